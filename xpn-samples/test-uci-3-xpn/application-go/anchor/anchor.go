@@ -0,0 +1,261 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package anchor writes documents to a storage backend and anchors their hash on the ledger, replacing
+// the write_data_xpn.py, xpn_bypass.so and peer chaincode invoke steps of the deploy scripts.
+//
+// A document is hashed while it is written to a temporary file, which replaces the file at its path
+// once its anchor is committed: a new version of an anchored path is anchored with
+// UpdateXpnTransaction, a new path with CreateXpnTransaction. Submissions that failed for transport
+// errors or timeouts are retried, after checking the ledger for an anchor committed despite the error;
+// when the anchor cannot be committed the temporary file is removed and the stored file is left as it
+// was, so that a file never stays in storage without its anchor.
+package anchor
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/chunk"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/attestation"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// Document is a file to write and anchor.
+type Document struct {
+	Path         string
+	Patient      string
+	DocumentType string
+	Content      io.Reader
+}
+
+// Receipt describes an anchored file.
+type Receipt struct {
+	ID            string `json:"id"`
+	Path          string `json:"path"`
+	Hash          string `json:"hash"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+	Size          int64  `json:"size"`
+	Partition     string `json:"partition"`
+	// ChunkHashes are the hashes of the chunks of the file when the Anchorer has a ChunkSize.
+	ChunkHashes []string `json:"chunkHashes,omitempty"`
+	// Signature and SignedAt are set when the Anchorer has a Signer.
+	Signature string `json:"signature,omitempty"`
+	SignedAt  string `json:"signedAt,omitempty"`
+	// Attempts is the number of submissions needed to anchor the file.
+	Attempts int `json:"attempts"`
+	// Reconciled is true when the anchor was found on the ledger after a failed submission.
+	Reconciled bool `json:"reconciled,omitempty"`
+}
+
+// Anchorer writes documents to Storage and anchors them on Ledger.
+type Anchorer struct {
+	Ledger    ledger.Client
+	Storage   storage.Writable
+	Partition string
+	// HashAlgorithm is the multihash function used to hash documents, multihash.Default when empty.
+	HashAlgorithm string
+	// ChunkSize enables chunk hashes of this many bytes, usually the XPN block size, see package chunk.
+	ChunkSize int64
+	// Layout is the layout of Partition recorded with every anchor, see package xpnconf.
+	Layout *ledger.XpnLayout
+	// Signer signs every anchor with the enrollment key of the submitting identity, see package
+	// attestation. The chaincode rejects signatures that do not match the submitter certificate.
+	Signer crypto.Signer
+	// Retries is the number of times a failed submission is retried.
+	Retries int
+	// Backoff is the wait before the first retry. It doubles with every retry.
+	Backoff time.Duration
+}
+
+// JSON returns v encoded the way write_data_xpn.py writes documents.
+func JSON(v interface{}) (io.Reader, error) {
+	content, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(content), nil
+}
+
+// Anchor writes doc to storage and anchors it on the ledger.
+func (a *Anchorer) Anchor(doc Document) (*Receipt, error) {
+	anchoredPath := path.Clean(doc.Path)
+	temp := tempPath(anchoredPath)
+	receipt, err := a.write(temp, doc)
+	if err != nil {
+		return nil, err
+	}
+	receipt.Path = anchoredPath
+
+	receipt, err = a.anchor(doc, receipt)
+	if err != nil {
+		if removeErr := a.Storage.Remove(temp); removeErr != nil {
+			return nil, fmt.Errorf("%v, and failed to remove %s: %v", err, temp, removeErr)
+		}
+		return nil, err
+	}
+
+	if err := a.Storage.Rename(temp, receipt.Path); err != nil {
+		return nil, fmt.Errorf("%s is anchored as %s but failed to move %s in place: %v", receipt.Path, receipt.ID, temp, err)
+	}
+
+	return receipt, nil
+}
+
+// anchor submits the anchor of the written file, retrying transport errors and timeouts.
+func (a *Anchorer) anchor(doc Document, receipt *Receipt) (*Receipt, error) {
+	// a path with a current anchor gets a new version of it
+	previous, err := ledger.ReadXpnTransactionByPath(a.Ledger, receipt.Path)
+	if ledger.NotAnchored(err) {
+		previous = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to anchor %s: %v", receipt.Path, err)
+	}
+	submit := ledger.CreateXpnTransaction
+	if previous != nil {
+		submit = ledger.UpdateXpnTransactionInput
+	}
+
+	input := ledger.XpnTransactionInput{
+		Hash:          receipt.Hash,
+		Path:          receipt.Path,
+		Patient:       doc.Patient,
+		DocumentType:  doc.DocumentType,
+		Size:          strconv.FormatInt(receipt.Size, 10),
+		HashAlgorithm: receipt.HashAlgorithm,
+		Partition:     receipt.Partition,
+		ChunkHashes:   receipt.ChunkHashes,
+		Layout:        a.Layout,
+	}
+	if a.ChunkSize > 0 {
+		input.ChunkSize = strconv.FormatInt(a.ChunkSize, 10)
+	}
+	if a.Signer != nil {
+		receipt.SignedAt = time.Now().UTC().Format(attestation.TimeLayout)
+		receipt.Signature, err = attestation.Sign(a.Signer, receipt.Path, receipt.Hash, receipt.SignedAt)
+		if err != nil {
+			return nil, err
+		}
+		input.Signature = receipt.Signature
+		input.SignedAt = receipt.SignedAt
+	}
+
+	backoff := a.Backoff
+	for {
+		receipt.Attempts++
+		receipt.ID, err = submit(a.Ledger, input)
+		if err == nil {
+			return receipt, nil
+		}
+
+		// the transaction may have been committed even though the submission failed
+		if id, found := a.committed(doc.Patient, receipt, previous); found {
+			receipt.ID = id
+			receipt.Reconciled = true
+			return receipt, nil
+		}
+
+		// the chaincode rejects the same proposal every time
+		if !ledger.Retryable(err) || receipt.Attempts > a.Retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return nil, fmt.Errorf("failed to anchor %s after %d attempts, file not stored: %v", receipt.Path, receipt.Attempts, err)
+}
+
+// tempPath returns the path a document is written to before it is anchored, next to anchoredPath.
+func tempPath(anchoredPath string) string {
+	return path.Join(path.Dir(anchoredPath), "."+path.Base(anchoredPath)+".tmp")
+}
+
+// write stores the document under name, hashing it on the way.
+func (a *Anchorer) write(name string, doc Document) (*Receipt, error) {
+	file, err := a.Storage.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", name, err)
+	}
+
+	algorithm := a.HashAlgorithm
+	if algorithm == "" {
+		algorithm = multihash.Default
+	}
+	algorithm, err = multihash.Canonical(algorithm)
+	if err != nil {
+		file.Close()
+		a.Storage.Remove(name)
+		return nil, err
+	}
+	chunkSize := a.ChunkSize
+	if chunkSize <= 0 {
+		// a single chunk as large as the file, only the whole file hash is used
+		chunkSize = math.MaxInt64
+	}
+	hasher, err := chunk.NewHasher(algorithm, chunkSize)
+	if err != nil {
+		file.Close()
+		a.Storage.Remove(name)
+		return nil, err
+	}
+
+	size, err := io.Copy(io.MultiWriter(file, hasher), doc.Content)
+	if syncer, ok := file.(interface{ Sync() error }); ok && err == nil {
+		// the file must be durable before it replaces the anchored one
+		err = syncer.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		a.Storage.Remove(name)
+		return nil, fmt.Errorf("failed to write %s: %v", name, err)
+	}
+
+	receipt := &Receipt{
+		Path:          name,
+		Hash:          hasher.Sum(),
+		HashAlgorithm: algorithm,
+		Size:          size,
+		Partition:     a.Partition,
+	}
+	if a.ChunkSize > 0 {
+		receipt.ChunkHashes = hasher.Chunks()
+	}
+
+	return receipt, nil
+}
+
+// committed looks for an anchor of the receipt's file on the ledger, newer than previous when the path
+// had an anchor, and returns its id.
+func (a *Anchorer) committed(patient string, receipt *Receipt, previous *ledger.XpnTransaction) (string, bool) {
+	xpntransactions, err := ledger.GetXpnTransactionsByPatient(a.Ledger, patient)
+	if err != nil {
+		return "", false
+	}
+
+	for _, xpntransaction := range xpntransactions {
+		if previous != nil && xpntransaction.Version <= previous.Version {
+			// an earlier version with the same content
+			continue
+		}
+		if path.Clean(xpntransaction.Path) == path.Clean(receipt.Path) && xpntransaction.Hash == receipt.Hash && !xpntransaction.Erased {
+			return xpntransaction.ID, true
+		}
+	}
+
+	return "", false
+}
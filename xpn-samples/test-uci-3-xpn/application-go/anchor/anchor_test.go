@@ -0,0 +1,290 @@
+package anchor_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/anchor"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/auditor"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/attestation"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLedger fails the first failures submissions with err, a timeout when nil. When commitOnFailure
+// is set, the failed submissions are committed anyway, as after a timeout waiting for the commit event.
+type fakeLedger struct {
+	failures        int
+	err             error
+	commitOnFailure bool
+	submissions     int
+	xpntransactions []*ledger.XpnTransaction
+}
+
+func (f *fakeLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	f.submissions++
+	id := strings.Repeat("a", f.submissions)
+
+	xpntransaction := &ledger.XpnTransaction{ID: id, Version: 1}
+	switch name {
+	case "CreateXpnTransaction", "CreateXpnTransactionTransient":
+		xpntransaction.Hash, xpntransaction.Path, xpntransaction.Patient = args[0], args[1], args[2]
+	case "UpdateXpnTransaction", "UpdateXpnTransactionTransient":
+		current := f.current(args[0])
+		if current == nil {
+			return nil, fmt.Errorf("Cannot update xpntransaction. Path %s is not anchored", args[0])
+		}
+		xpntransaction.Path, xpntransaction.Hash, xpntransaction.Patient = args[0], args[1], current.Patient
+		xpntransaction.Version, xpntransaction.PreviousID = current.Version+1, current.ID
+	default:
+		return nil, fmt.Errorf("unexpected transaction %s", name)
+	}
+
+	if f.submissions <= f.failures {
+		if f.commitOnFailure {
+			f.xpntransactions = append(f.xpntransactions, xpntransaction)
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+		return nil, errors.New("peer chaincode: exit status 1: Error: timed out waiting for txid on all peers")
+	}
+
+	f.xpntransactions = append(f.xpntransactions, xpntransaction)
+	return []byte(id), nil
+}
+
+func (f *fakeLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "ReadXpnTransactionByPath":
+		current := f.current(args[0])
+		if current == nil {
+			return nil, fmt.Errorf("Path %s is not anchored", args[0])
+		}
+		return json.Marshal(current)
+	case "GetXpnTransactionsByPatient":
+		return json.Marshal(f.xpntransactions)
+	}
+
+	return nil, fmt.Errorf("unexpected transaction %s", name)
+}
+
+// current returns the last anchor of path, like the chaincode currentXpnTransaction.
+func (f *fakeLedger) current(path string) *ledger.XpnTransaction {
+	var current *ledger.XpnTransaction
+	for _, xpntransaction := range f.xpntransactions {
+		if xpntransaction.Path == path {
+			current = xpntransaction
+		}
+	}
+	if current == nil || current.Erased {
+		return nil
+	}
+
+	return current
+}
+
+// transientLedger records the transient payloads it is given.
+type transientLedger struct {
+	fakeLedger
+	inputs []ledger.XpnTransactionInput
+}
+
+func (f *transientLedger) SubmitTransient(name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	var input ledger.XpnTransactionInput
+	if err := json.Unmarshal(transient["xpntransaction"], &input); err != nil {
+		return nil, err
+	}
+	f.inputs = append(f.inputs, input)
+	if name == "UpdateXpnTransactionTransient" {
+		return f.SubmitTransaction(name, input.Path, input.Hash, input.Size)
+	}
+	return f.SubmitTransaction(name, input.Hash, input.Path, input.Patient)
+}
+
+func document(t *testing.T) anchor.Document {
+	content, err := anchor.JSON(map[string]string{"id": "1", "patientId": "1"})
+	require.NoError(t, err)
+
+	return anchor.Document{Path: "/tmp/expand/xpn/1/patient.txt", Patient: "1", DocumentType: "patient", Content: content}
+}
+
+func TestAnchor(t *testing.T) {
+	fake := &fakeLedger{failures: 1}
+	store := storage.NewMemory()
+	a := &anchor.Anchorer{Ledger: fake, Storage: store, Partition: "xpn", Retries: 2}
+
+	receipt, err := a.Anchor(document(t))
+	require.NoError(t, err)
+	require.Equal(t, "aa", receipt.ID)
+	require.Equal(t, 2, receipt.Attempts)
+
+	file, err := store.Open(receipt.Path)
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), receipt.Size)
+	require.Equal(t, "{\n    \"id\": \"1\",\n    \"patientId\": \"1\"\n}", string(content))
+
+	hash, err := auditor.HashFile(strings.NewReader(string(content)), "sha256")
+	require.NoError(t, err)
+	require.Equal(t, hash, receipt.Hash)
+}
+
+func TestAnchorReconciles(t *testing.T) {
+	fake := &fakeLedger{failures: 1, commitOnFailure: true}
+	a := &anchor.Anchorer{Ledger: fake, Storage: storage.NewMemory(), Partition: "xpn", Retries: 2}
+
+	receipt, err := a.Anchor(document(t))
+	require.NoError(t, err)
+	require.Equal(t, "a", receipt.ID)
+	require.True(t, receipt.Reconciled)
+	require.Equal(t, 1, fake.submissions)
+}
+
+func TestAnchorRemovesUnanchoredFile(t *testing.T) {
+	fake := &fakeLedger{failures: 3}
+	store := storage.NewMemory()
+	a := &anchor.Anchorer{Ledger: fake, Storage: store, Partition: "xpn", Retries: 2}
+
+	_, err := a.Anchor(document(t))
+	require.Error(t, err)
+	require.Equal(t, 3, fake.submissions)
+
+	_, err = store.Open("/tmp/expand/xpn/1/patient.txt")
+	require.Error(t, err)
+}
+
+func TestAnchorDoesNotRetryRejections(t *testing.T) {
+	fake := &fakeLedger{failures: 1, err: errors.New("peer chaincode: exit status 1: Error: endorsement failure during invoke. response: status:500 message:\"Patient must be a pseudonym\"")}
+	store := storage.NewMemory()
+	a := &anchor.Anchorer{Ledger: fake, Storage: store, Partition: "xpn", Retries: 2}
+
+	_, err := a.Anchor(document(t))
+	require.Error(t, err)
+	require.Equal(t, 1, fake.submissions)
+
+	paths := walk(t, store)
+	require.Empty(t, paths)
+}
+
+func TestAnchorUpdatesAnchoredPath(t *testing.T) {
+	fake := &transientLedger{}
+	store := storage.NewMemory()
+	a := &anchor.Anchorer{Ledger: fake, Storage: store, Partition: "xpn", ChunkSize: 4}
+
+	first, err := a.Anchor(document(t))
+	require.NoError(t, err)
+
+	doc := document(t)
+	doc.Path = "/tmp/expand/xpn/1/./patient.txt"
+	doc.Content = strings.NewReader(`{"id":"1", "patientId":"1", "firstName":"Ana"}`)
+	second, err := a.Anchor(doc)
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/expand/xpn/1/patient.txt", second.Path)
+	require.NotEqual(t, first.Hash, second.Hash)
+
+	require.Len(t, fake.xpntransactions, 2)
+	require.Equal(t, 2, fake.xpntransactions[1].Version)
+	require.Equal(t, first.ID, fake.xpntransactions[1].PreviousID)
+	require.Equal(t, second.ChunkHashes, fake.inputs[1].ChunkHashes)
+
+	require.Equal(t, []string{"/tmp/expand/xpn/1/patient.txt"}, walk(t, store))
+	file, err := store.Open(second.Path)
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"1", "patientId":"1", "firstName":"Ana"}`, string(content))
+}
+
+func TestAnchorKeepsAnchoredFileOnFailure(t *testing.T) {
+	fake := &fakeLedger{}
+	store := storage.NewMemory()
+	a := &anchor.Anchorer{Ledger: fake, Storage: store, Partition: "xpn", Retries: 1}
+
+	first, err := a.Anchor(document(t))
+	require.NoError(t, err)
+
+	fake.failures = 3
+	doc := document(t)
+	doc.Content = strings.NewReader("modified")
+	_, err = a.Anchor(doc)
+	require.Error(t, err)
+	require.Equal(t, 3, fake.submissions)
+
+	// the anchored version is still stored, and only it
+	require.Equal(t, []string{first.Path}, walk(t, store))
+	file, err := store.Open(first.Path)
+	require.NoError(t, err)
+	hash, err := auditor.HashFile(file, "sha256")
+	require.NoError(t, err)
+	require.Equal(t, first.Hash, hash)
+}
+
+func TestAnchorReconcilesUpdate(t *testing.T) {
+	fake := &fakeLedger{}
+	a := &anchor.Anchorer{Ledger: fake, Storage: storage.NewMemory(), Partition: "xpn", Retries: 2}
+
+	_, err := a.Anchor(document(t))
+	require.NoError(t, err)
+
+	// the same content again, committed although the submission timed out
+	fake.failures, fake.commitOnFailure = 2, true
+	receipt, err := a.Anchor(document(t))
+	require.NoError(t, err)
+	require.True(t, receipt.Reconciled)
+	require.Equal(t, "aa", receipt.ID)
+	require.Equal(t, 2, fake.submissions)
+}
+
+func walk(t *testing.T, store storage.Storage) []string {
+	var paths []string
+	require.NoError(t, store.Walk(func(anchoredPath string) error {
+		paths = append(paths, anchoredPath)
+		return nil
+	}))
+
+	return paths
+}
+
+func TestAnchorSigned(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	fake := &transientLedger{}
+	a := &anchor.Anchorer{Ledger: fake, Storage: storage.NewMemory(), Partition: "xpn", Signer: key}
+	receipt, err := a.Anchor(document(t))
+	require.NoError(t, err)
+
+	require.Len(t, fake.inputs, 1)
+	require.Equal(t, receipt.Signature, fake.inputs[0].Signature)
+	require.Equal(t, receipt.SignedAt, fake.inputs[0].SignedAt)
+
+	digest := sha256.Sum256(attestation.Message(receipt.Path, receipt.Hash, receipt.SignedAt))
+	signature, err := base64.StdEncoding.DecodeString(receipt.Signature)
+	require.NoError(t, err)
+	require.True(t, ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature))
+}
+
+func TestAnchorSignedRequiresTransient(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	store := storage.NewMemory()
+	a := &anchor.Anchorer{Ledger: &fakeLedger{}, Storage: store, Partition: "xpn", Signer: key}
+	_, err = a.Anchor(document(t))
+	require.Error(t, err)
+
+	_, err = store.Open("/tmp/expand/xpn/1/patient.txt")
+	require.Error(t, err)
+}
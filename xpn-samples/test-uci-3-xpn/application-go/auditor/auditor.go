@@ -0,0 +1,297 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package auditor checks the files stored in XPN against the hashes anchored on the ledger.
+package auditor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/chunk"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/xpnconf"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// Finding describes a file that does not match the ledger.
+type Finding struct {
+	Path           string `json:"path"`
+	XpnTransaction string `json:"xpnTransaction,omitempty"`
+	AnchoredHash   string `json:"anchoredHash,omitempty"`
+	RecomputedHash string `json:"recomputedHash,omitempty"`
+	// Replica is the server URL of the replica the finding is about, empty for the primary copy.
+	Replica string `json:"replica,omitempty"`
+	// Ranges are the modified byte ranges, known when the anchor holds chunk hashes.
+	Ranges []chunk.Range `json:"ranges,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// Report is the result of one audit run.
+type Report struct {
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	Anchors    int       `json:"anchors"`
+	Intact     int       `json:"intact"`
+	Missing    []Finding `json:"missing"`
+	Modified   []Finding `json:"modified"`
+	Unanchored []Finding `json:"unanchored"`
+	// ReplicasChecked is the number of server files checked, Replicas the ones that are missing or
+	// hold blocks that do not match the anchored chunk hashes. ReplicasSkipped is the number of
+	// replicated anchors whose replicas could not be checked because they have no chunk hashes of
+	// the partition block size.
+	ReplicasChecked int       `json:"replicasChecked"`
+	Replicas        []Finding `json:"replicas"`
+	ReplicasSkipped int       `json:"replicasSkipped"`
+	// Submitted is the number of verification transactions submitted to the ledger.
+	Submitted int `json:"submitted"`
+}
+
+// Auditor compares the files in Storage with the anchors on the ledger.
+type Auditor struct {
+	Ledger  ledger.Client
+	Storage storage.Storage
+	// Submit records the result of every checked anchor on the ledger with VerifyXpnFile.
+	Submit bool
+	// Replicas gives access to the files held by each XPN server, keyed by the server URL of
+	// xpn/config.xml. XPN stripes a file over the servers of its partition block by block, so a
+	// server file holds some copies of some blocks behind a metadata header, see xpnconf.Placement.
+	// For anchors recorded with a replication level above zero, every block a server holds is
+	// checked against the anchored chunk hash of that block. Servers without an entry are not checked.
+	Replicas map[string]storage.Storage
+}
+
+// HashFile returns the multihash of the content of r computed with the named hash algorithm, or
+// with SHA-256 like write_data_xpn.py when algorithm is empty.
+func HashFile(r io.Reader, algorithm string) (string, error) {
+	if algorithm == "" {
+		algorithm = multihash.Default
+	}
+
+	return multihash.Sum(algorithm, r)
+}
+
+// Run audits every anchor once.
+func (a *Auditor) Run() (*Report, error) {
+	report := &Report{Started: time.Now().UTC()}
+
+	xpntransactions, err := ledger.GetAllXpnTransactions(a.Ledger)
+	if err != nil {
+		return nil, err
+	}
+
+	// the current version of every path
+	anchors := make(map[string]*ledger.XpnTransaction)
+	// paths whose files are waiting to be removed after an erasure
+	erased := make(map[string]bool)
+	for _, xpntransaction := range xpntransactions {
+		if xpntransaction.Erased {
+			erased[xpntransaction.Path] = true
+			continue
+		}
+		latest, ok := anchors[xpntransaction.Path]
+		if !ok || xpntransaction.Version > latest.Version {
+			anchors[xpntransaction.Path] = xpntransaction
+		}
+	}
+
+	paths := make([]string, 0, len(anchors))
+	for path := range anchors {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		xpntransaction := anchors[path]
+		report.Anchors++
+
+		a.checkReplicas(report, xpntransaction)
+
+		finding := Finding{Path: path, XpnTransaction: xpntransaction.ID, AnchoredHash: xpntransaction.Hash}
+		recomputedHash, err := a.hash(a.Storage, path, xpntransaction.HashAlgorithm)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			report.Missing = append(report.Missing, finding)
+			continue
+		case err != nil:
+			finding.Error = err.Error()
+			report.Missing = append(report.Missing, finding)
+			continue
+		}
+
+		finding.RecomputedHash = recomputedHash
+		if multihash.Equal(recomputedHash, xpntransaction.Hash, xpntransaction.HashAlgorithm) {
+			report.Intact++
+		} else {
+			if xpntransaction.ChunkSize > 0 {
+				finding.Ranges, err = a.diff(xpntransaction)
+				if err != nil {
+					finding.Error = err.Error()
+				}
+			}
+			report.Modified = append(report.Modified, finding)
+		}
+
+		if a.Submit {
+			if _, err := ledger.VerifyXpnFile(a.Ledger, xpntransaction.ID, recomputedHash); err != nil {
+				return nil, fmt.Errorf("failed to record verification of %s: %v", path, err)
+			}
+			report.Submitted++
+		}
+	}
+
+	err = a.Storage.Walk(func(path string) error {
+		if anchors[path] == nil && !erased[path] {
+			report.Unanchored = append(report.Unanchored, Finding{Path: path})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored files: %v", err)
+	}
+
+	report.Finished = time.Now().UTC()
+
+	return report, nil
+}
+
+// diff returns the byte ranges of the file of xpntransaction that differ from its chunk hashes.
+func (a *Auditor) diff(xpntransaction *ledger.XpnTransaction) ([]chunk.Range, error) {
+	file, err := a.Storage.Open(xpntransaction.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	algorithm := xpntransaction.HashAlgorithm
+	if algorithm == "" {
+		algorithm = multihash.Default
+	}
+
+	return chunk.Diff(file, algorithm, xpntransaction.ChunkSize, xpntransaction.ChunkHashes)
+}
+
+// replicaBlock is a block of a file expected in the file of a server.
+type replicaBlock struct {
+	index  int
+	offset int64
+}
+
+// checkReplicas checks the blocks of the file of xpntransaction held by the servers of its layout.
+func (a *Auditor) checkReplicas(report *Report, xpntransaction *ledger.XpnTransaction) {
+	layout := xpntransaction.Layout
+	if layout == nil || layout.ReplicationLevel == 0 || len(layout.Servers) == 0 || len(a.Replicas) == 0 {
+		return
+	}
+	// a server file is checked block by block, against the hashes of the blocks
+	if xpntransaction.ChunkSize != layout.BlockSize || len(xpntransaction.ChunkHashes) == 0 {
+		report.ReplicasSkipped++
+		return
+	}
+
+	// the blocks of every server, in the order of their offsets
+	blocks := make(map[int][]replicaBlock)
+	firstNode := xpnconf.FirstNode(partitionPath(xpntransaction), len(layout.Servers))
+	for i := range xpntransaction.ChunkHashes {
+		for _, c := range xpnconf.Placement(layout.BlockSize, layout.ReplicationLevel, len(layout.Servers), firstNode, int64(i)) {
+			blocks[c.Server] = append(blocks[c.Server], replicaBlock{index: i, offset: c.Offset})
+		}
+	}
+
+	for i, server := range layout.Servers {
+		replica, ok := a.Replicas[server]
+		if !ok || len(blocks[i]) == 0 {
+			continue
+		}
+		report.ReplicasChecked++
+
+		finding := Finding{Path: xpntransaction.Path, Replica: server, XpnTransaction: xpntransaction.ID, AnchoredHash: xpntransaction.Hash}
+		ranges, err := checkReplica(replica, xpntransaction, blocks[i])
+		if err != nil {
+			finding.Error = err.Error()
+			report.Replicas = append(report.Replicas, finding)
+			continue
+		}
+
+		if len(ranges) > 0 {
+			finding.Ranges = ranges
+			report.Replicas = append(report.Replicas, finding)
+		}
+	}
+}
+
+// checkReplica hashes the given blocks of the server file of xpntransaction and returns the byte
+// ranges of the file whose blocks differ from the anchored chunk hashes or are missing.
+func checkReplica(replica storage.Storage, xpntransaction *ledger.XpnTransaction, blocks []replicaBlock) ([]chunk.Range, error) {
+	file, err := replica.Open(xpntransaction.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	algorithm := xpntransaction.HashAlgorithm
+	if algorithm == "" {
+		algorithm = multihash.Default
+	}
+	blockSize := xpntransaction.Layout.BlockSize
+
+	var ranges []chunk.Range
+	var position int64
+	for n, block := range blocks {
+		r := chunk.Range{Offset: int64(block.index) * blockSize, Length: blockSize}
+		if rest := xpntransaction.Size - r.Offset; rest < r.Length {
+			r.Length = rest
+		}
+
+		if _, err := io.CopyN(io.Discard, file, block.offset-position); err == io.EOF {
+			// the server file ends before this block
+			for _, missing := range blocks[n:] {
+				ranges = append(ranges, chunk.Range{Offset: int64(missing.index) * blockSize, Length: blockSize})
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		hash, err := multihash.Sum(algorithm, io.LimitReader(file, r.Length))
+		if err != nil {
+			return nil, err
+		}
+		position = block.offset + r.Length
+		if !multihash.Equal(hash, xpntransaction.ChunkHashes[block.index], algorithm) {
+			ranges = append(ranges, r)
+		}
+	}
+
+	return ranges, nil
+}
+
+// partitionPath returns the path of the file of xpntransaction in its XPN partition, e.g.
+// /1/patient.txt for /tmp/expand/xpn/1/patient.txt.
+func partitionPath(xpntransaction *ledger.XpnTransaction) string {
+	partition := xpntransaction.Partition
+	if partition == "" {
+		partition = path.Base(storage.DefaultXpnPrefix)
+	}
+	root := path.Join(path.Dir(storage.DefaultXpnPrefix), partition)
+
+	return path.Join("/", strings.TrimPrefix(path.Clean(xpntransaction.Path), root))
+}
+
+func (a *Auditor) hash(store storage.Storage, path string, algorithm string) (string, error) {
+	file, err := store.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return HashFile(file, algorithm)
+}
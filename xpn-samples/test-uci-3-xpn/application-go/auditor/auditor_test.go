@@ -0,0 +1,156 @@
+package auditor_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/auditor"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/chunk"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/xpnconf"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLedger struct {
+	xpntransactions []*ledger.XpnTransaction
+	verified        map[string]string
+}
+
+func (f *fakeLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	f.verified[args[0]] = args[1]
+	return json.Marshal(ledger.XpnVerification{XpnTransaction: args[0], RecomputedHash: args[1]})
+}
+
+func (f *fakeLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return json.Marshal(f.xpntransactions)
+}
+
+func writeFile(t *testing.T, root string, name string, content string) string {
+	file := filepath.Join(root, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+
+	hash, err := auditor.HashFile(mustOpen(t, file), "")
+	require.NoError(t, err)
+	return hash
+}
+
+func mustOpen(t *testing.T, name string) *os.File {
+	file, err := os.Open(name)
+	require.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func chunkHashes(t *testing.T, content string, size int64) []string {
+	h, err := chunk.NewHasher("sha256", size)
+	require.NoError(t, err)
+	h.Write([]byte(content))
+	return h.Chunks()
+}
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	intactHash := writeFile(t, root, "1/patient.txt", "{\"id\": \"1\"}")
+	writeFile(t, root, "1/contract.txt", "modified")
+	writeFile(t, root, "1/vital_signs_2.txt", "0123456789abcdefXXXX")
+	writeFile(t, root, "1/diagnosis.txt", "not anchored")
+	writeFile(t, root, "2/patient.txt", "erased, waiting for deletion")
+
+	fake := &fakeLedger{
+		xpntransactions: []*ledger.XpnTransaction{
+			// anchored before multihashes, as a bare SHA-256 digest
+			{ID: "1", Path: "/tmp/expand/xpn/1/patient.txt", Hash: strings.TrimPrefix(intactHash, "1220"), HashAlgorithm: "sha256"},
+			{ID: "2", Path: "/tmp/expand/xpn/1/contract.txt", Hash: intactHash},
+			{ID: "3", Path: "/tmp/expand/xpn/1/vital_signs_1.txt", Hash: intactHash},
+			{ID: "4", Path: "/tmp/expand/xpn/2/patient.txt", Hash: intactHash, Erased: true},
+			{ID: "5", Path: "/tmp/expand/xpn/1/vital_signs_2.txt", Hash: intactHash, ChunkSize: 8, ChunkHashes: chunkHashes(t, "0123456789abcdef0123", 8)},
+		},
+		verified: make(map[string]string),
+	}
+
+	a := &auditor.Auditor{Ledger: fake, Storage: storage.NewLocalDir(root), Submit: true}
+	report, err := a.Run()
+	require.NoError(t, err)
+
+	require.Equal(t, 4, report.Anchors)
+	require.Equal(t, 1, report.Intact)
+	require.Len(t, report.Missing, 1)
+	require.Equal(t, "3", report.Missing[0].XpnTransaction)
+	require.Len(t, report.Modified, 2)
+	require.Equal(t, "2", report.Modified[0].XpnTransaction)
+	require.Empty(t, report.Modified[0].Ranges)
+	require.Equal(t, "5", report.Modified[1].XpnTransaction)
+	require.Equal(t, []chunk.Range{{Offset: 16, Length: 8}}, report.Modified[1].Ranges)
+	require.Len(t, report.Unanchored, 1)
+	require.Equal(t, "/tmp/expand/xpn/1/diagnosis.txt", report.Unanchored[0].Path)
+
+	require.Equal(t, 3, report.Submitted)
+	require.Equal(t, intactHash, fake.verified["1"])
+	require.NotEqual(t, intactHash, fake.verified["2"])
+}
+
+func TestRunReplicas(t *testing.T) {
+	primary, xpn1, xpn2, xpn3 := t.TempDir(), t.TempDir(), t.TempDir(), t.TempDir()
+	hash := writeFile(t, primary, "1/patient.txt", "aaaabbbbcc")
+	notReplicated := writeFile(t, primary, "2/patient.txt", "{\"id\": \"2\"}")
+	noChunks := writeFile(t, primary, "3/patient.txt", "{\"id\": \"3\"}")
+
+	// the blocks aaaa, bbbb and cc of /1/patient.txt, whose first node is server 2 ('/1/patient.txt'
+	// adds up to 1298), and their copies go round robin over the servers behind the header
+	header := strings.Repeat("\x00", xpnconf.HeaderSize)
+	writeFile(t, xpn1, "1/patient.txt", header+"aaaacc")
+	writeFile(t, xpn2, "1/patient.txt", header+"bbbbXX")
+	writeFile(t, xpn3, "1/patient.txt", header+"aaaa")
+
+	layout := &ledger.XpnLayout{
+		BlockSize:        4,
+		ReplicationLevel: 1,
+		Servers:          []string{"sck_server://xpn1/xpn", "sck_server://xpn2/xpn", "sck_server://xpn3/xpn"},
+	}
+	fake := &fakeLedger{
+		xpntransactions: []*ledger.XpnTransaction{
+			{ID: "1", Path: "/tmp/expand/xpn/1/patient.txt", Hash: hash, Partition: "xpn", Size: 10, Layout: layout,
+				ChunkSize: 4, ChunkHashes: chunkHashes(t, "aaaabbbbcc", 4)},
+			// not replicated, the replicas are not checked
+			{ID: "2", Path: "/tmp/expand/xpn/2/patient.txt", Hash: notReplicated, Partition: "xpn",
+				Layout: &ledger.XpnLayout{BlockSize: 4, Servers: layout.Servers}},
+			// without chunk hashes the blocks cannot be checked
+			{ID: "3", Path: "/tmp/expand/xpn/3/patient.txt", Hash: noChunks, Partition: "xpn", Layout: layout},
+		},
+	}
+
+	a := &auditor.Auditor{
+		Ledger:  fake,
+		Storage: storage.NewLocalDir(primary),
+		Replicas: map[string]storage.Storage{
+			"sck_server://xpn1/xpn": storage.NewLocalDir(xpn1),
+			"sck_server://xpn2/xpn": storage.NewLocalDir(xpn2),
+			"sck_server://xpn3/xpn": storage.NewLocalDir(xpn3),
+		},
+	}
+	report, err := a.Run()
+	require.NoError(t, err)
+
+	require.Equal(t, 3, report.Intact)
+	require.Equal(t, 3, report.ReplicasChecked)
+	require.Equal(t, 1, report.ReplicasSkipped)
+	require.Len(t, report.Replicas, 2)
+	require.Equal(t, "sck_server://xpn2/xpn", report.Replicas[0].Replica)
+	require.Equal(t, []chunk.Range{{Offset: 8, Length: 2}}, report.Replicas[0].Ranges)
+	require.Empty(t, report.Replicas[0].Error)
+	require.Equal(t, "sck_server://xpn3/xpn", report.Replicas[1].Replica)
+	require.Equal(t, []chunk.Range{{Offset: 4, Length: 4}}, report.Replicas[1].Ranges)
+
+	// a server without the file
+	a.Replicas["sck_server://xpn1/xpn"] = storage.NewLocalDir(t.TempDir())
+	report, err = a.Run()
+	require.NoError(t, err)
+	require.Len(t, report.Replicas, 3)
+	require.Equal(t, "sck_server://xpn1/xpn", report.Replicas[0].Replica)
+	require.NotEmpty(t, report.Replicas[0].Error)
+}
@@ -0,0 +1,40 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auditor
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteMetrics writes a report in the Prometheus text exposition format.
+func WriteMetrics(w io.Writer, report *Report) error {
+	metrics := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"xpn_audit_anchors", "Number of XPN anchors checked in the last audit.", float64(report.Anchors)},
+		{"xpn_audit_intact_files", "Number of anchored files whose hash matches the ledger.", float64(report.Intact)},
+		{"xpn_audit_missing_files", "Number of anchored files that could not be read.", float64(len(report.Missing))},
+		{"xpn_audit_modified_files", "Number of anchored files whose hash differs from the ledger.", float64(len(report.Modified))},
+		{"xpn_audit_unanchored_files", "Number of stored files without an anchor.", float64(len(report.Unanchored))},
+		{"xpn_audit_replicas_checked", "Number of server files checked in the last audit.", float64(report.ReplicasChecked)},
+		{"xpn_audit_replica_mismatches", "Number of server files that are missing or hold blocks whose hash differs from the ledger.", float64(len(report.Replicas))},
+		{"xpn_audit_replicas_skipped", "Number of replicated anchors without chunk hashes of the block size, whose replicas were not checked.", float64(report.ReplicasSkipped)},
+		{"xpn_audit_submitted_verifications", "Number of verification transactions submitted in the last audit.", float64(report.Submitted)},
+		{"xpn_audit_last_run_timestamp_seconds", "Time the last audit finished.", float64(report.Finished.UnixNano()) / 1e9},
+		{"xpn_audit_duration_seconds", "Duration of the last audit.", report.Finished.Sub(report.Started).Seconds()},
+	}
+
+	for _, metric := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", metric.name, metric.help, metric.name, metric.name, metric.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package batch anchors many XPN files with a single transaction. It hashes the files, anchors them
+// with CreateXpnBatch, which gives every file its own anchor, and returns an inclusion proof per file
+// against the Merkle root the chaincode builds over their (path, hash) pairs.
+package batch
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/merkle"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// Classifier returns the patient and document type of the file stored under an anchored path, like
+// watcher.Classify.
+type Classifier func(anchoredPath string) (patient string, documentType string, err error)
+
+// Result is an anchored batch together with the inclusion proof of every file.
+type Result struct {
+	ID     string                   `json:"id"`
+	Root   string                   `json:"root"`
+	Proofs map[string]*merkle.Proof `json:"proofs"`
+}
+
+// counter counts the bytes written to it.
+type counter int64
+
+func (c *counter) Write(p []byte) (int, error) {
+	*c += counter(len(p))
+	return len(p), nil
+}
+
+// Build hashes the files stored under paths with SHA-256 and returns them, classified by classify,
+// together with the tree over their multihashes, which is the tree the chaincode builds.
+func Build(store storage.Storage, paths []string, classify Classifier) ([]ledger.XpnBatchFile, *merkle.Tree, error) {
+	files := make([]ledger.XpnBatchFile, 0, len(paths))
+	entries := make([]merkle.Entry, 0, len(paths))
+	for _, anchoredPath := range paths {
+		anchoredPath = path.Clean(anchoredPath)
+		patient, documentType, err := classify(anchoredPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		file, err := store.Open(anchoredPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		var size counter
+		hash, err := multihash.Sum(multihash.Default, io.TeeReader(file, &size))
+		file.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to hash %s: %v", anchoredPath, err)
+		}
+
+		files = append(files, ledger.XpnBatchFile{
+			Hash:          hash,
+			Path:          anchoredPath,
+			Patient:       patient,
+			DocumentType:  documentType,
+			Size:          strconv.FormatInt(int64(size), 10),
+			HashAlgorithm: multihash.Default,
+		})
+		entries = append(entries, merkle.Entry{Path: anchoredPath, Hash: hash})
+	}
+
+	tree, err := merkle.New(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return files, tree, nil
+}
+
+// Proofs returns the inclusion proof of every file of tree.
+func Proofs(tree *merkle.Tree, paths []string) (map[string]*merkle.Proof, error) {
+	proofs := make(map[string]*merkle.Proof, len(paths))
+	for _, path := range paths {
+		proof, err := tree.Proof(path)
+		if err != nil {
+			return nil, err
+		}
+		proofs[path] = proof
+	}
+
+	return proofs, nil
+}
+
+// Anchor anchors the files stored under paths with a single CreateXpnBatch transaction and returns
+// the batch with the id assigned by the chaincode.
+func Anchor(c ledger.Client, store storage.Storage, partition string, paths []string, classify Classifier) (*Result, error) {
+	files, tree, err := Build(store, paths, classify)
+	if err != nil {
+		return nil, err
+	}
+
+	anchored := make([]string, 0, len(files))
+	for _, file := range files {
+		anchored = append(anchored, file.Path)
+	}
+	proofs, err := Proofs(tree, anchored)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := ledger.CreateXpnBatch(c, files, partition)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{ID: id, Root: tree.Root(), Proofs: proofs}, nil
+}
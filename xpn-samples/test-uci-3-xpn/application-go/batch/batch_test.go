@@ -0,0 +1,67 @@
+package batch_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/batch"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/watcher"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/merkle"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLedger struct {
+	args []string
+}
+
+func (f *fakeLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	f.args = append([]string{name}, args...)
+	return []byte("tx1"), nil
+}
+
+func (f *fakeLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return nil, nil
+}
+
+func TestAnchor(t *testing.T) {
+	root := t.TempDir()
+	paths := []string{"/tmp/expand/xpn/1/patient.txt", "/tmp/expand/xpn/1/contract.txt", "/tmp/expand/xpn/2/patient.txt"}
+	for _, path := range paths {
+		name := filepath.Join(root, path[len(storage.DefaultXpnPrefix):])
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(t, os.WriteFile(name, []byte(path), 0644))
+	}
+
+	fake := &fakeLedger{}
+	result, err := batch.Anchor(fake, storage.NewLocalDir(root), "xpn", paths, watcher.Classify)
+	require.NoError(t, err)
+	require.Equal(t, "tx1", result.ID)
+	require.Equal(t, "CreateXpnBatch", fake.args[0])
+	require.Equal(t, "xpn", fake.args[2])
+
+	var files []ledger.XpnBatchFile
+	require.NoError(t, json.Unmarshal([]byte(fake.args[1]), &files))
+	require.Len(t, files, 3)
+	require.Equal(t, ledger.XpnBatchFile{
+		Hash:          files[1].Hash,
+		Path:          "/tmp/expand/xpn/1/contract.txt",
+		Patient:       "1",
+		DocumentType:  "contract",
+		Size:          "30",
+		HashAlgorithm: multihash.Default,
+	}, files[1])
+
+	for _, path := range paths {
+		ok, err := merkle.Verify(result.Root, *result.Proofs[path])
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	_, err = batch.Anchor(fake, storage.NewLocalDir(root), "xpn", []string{"/tmp/expand/xpn/3/patient.txt"}, watcher.Classify)
+	require.Error(t, err)
+}
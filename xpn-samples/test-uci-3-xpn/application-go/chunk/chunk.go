@@ -0,0 +1,130 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package chunk hashes files in chunks aligned to the XPN block size, so that a modification can be
+// located to the byte ranges, and therefore the XPN servers, that hold it.
+package chunk
+
+import (
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// DefaultSize is the XPN block size of the sample partition (bsize = 512k in xpn/config.xml).
+const DefaultSize = 512 * 1024
+
+// Range is a byte range of a file.
+type Range struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// Hasher is an io.Writer that computes the hash of everything written to it together with the
+// hash of every chunk of Size bytes.
+type Hasher struct {
+	algorithm string
+	size      int64
+	whole     hash.Hash
+	chunk     hash.Hash
+	written   int64
+	chunks    []string
+}
+
+// NewHasher returns a Hasher using the named multihash function and chunks of size bytes.
+func NewHasher(algorithm string, size int64) (*Hasher, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", size)
+	}
+	algorithm, err := multihash.Canonical(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	whole, _ := multihash.New(algorithm)
+	chunk, _ := multihash.New(algorithm)
+
+	return &Hasher{algorithm: algorithm, size: size, whole: whole, chunk: chunk}, nil
+}
+
+// Write implements io.Writer.
+func (h *Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	h.whole.Write(p)
+	for len(p) > 0 {
+		room := h.size - h.written%h.size
+		part := p
+		if int64(len(part)) > room {
+			part = part[:room]
+		}
+		h.chunk.Write(part)
+		h.written += int64(len(part))
+		p = p[len(part):]
+
+		if h.written%h.size == 0 {
+			h.chunks = append(h.chunks, multihash.Encode(h.algorithm, h.chunk.Sum(nil)))
+			h.chunk.Reset()
+		}
+	}
+
+	return n, nil
+}
+
+// Sum returns the multihash of everything written.
+func (h *Hasher) Sum() string {
+	return multihash.Encode(h.algorithm, h.whole.Sum(nil))
+}
+
+// Chunks returns the multihashes of the chunks written, including a final partial chunk.
+func (h *Hasher) Chunks() []string {
+	chunks := append([]string(nil), h.chunks...)
+	if h.written%h.size != 0 {
+		chunks = append(chunks, multihash.Encode(h.algorithm, h.chunk.Sum(nil)))
+	}
+
+	return chunks
+}
+
+// Size returns the chunk size.
+func (h *Hasher) Size() int64 {
+	return h.size
+}
+
+// Written returns the number of bytes written.
+func (h *Hasher) Written() int64 {
+	return h.written
+}
+
+// Diff hashes r in chunks of size bytes and returns the byte ranges whose hashes differ from the
+// anchored chunk hashes. Chunks missing from r or from anchored are reported as well.
+func Diff(r io.Reader, algorithm string, size int64, anchored []string) ([]Range, error) {
+	h, err := NewHasher(algorithm, size)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	chunks := h.Chunks()
+
+	var ranges []Range
+	add := func(i int) {
+		offset := int64(i) * size
+		// merge adjacent chunks into one range
+		if n := len(ranges); n > 0 && ranges[n-1].Offset+ranges[n-1].Length == offset {
+			ranges[n-1].Length += size
+			return
+		}
+		ranges = append(ranges, Range{Offset: offset, Length: size})
+	}
+
+	for i := 0; i < len(chunks) || i < len(anchored); i++ {
+		if i >= len(chunks) || i >= len(anchored) || !multihash.Equal(chunks[i], anchored[i], algorithm) {
+			add(i)
+		}
+	}
+
+	return ranges, nil
+}
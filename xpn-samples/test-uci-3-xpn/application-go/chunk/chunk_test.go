@@ -0,0 +1,60 @@
+package chunk_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/chunk"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasher(t *testing.T) {
+	content := strings.Repeat("0123456789", 25)
+
+	h, err := chunk.NewHasher(multihash.SHA2_256, 100)
+	require.NoError(t, err)
+	// write in pieces that do not line up with the chunks
+	for i := 0; i < len(content); i += 33 {
+		end := i + 33
+		if end > len(content) {
+			end = len(content)
+		}
+		h.Write([]byte(content[i:end]))
+	}
+
+	whole, err := multihash.Sum(multihash.SHA2_256, strings.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, whole, h.Sum())
+
+	chunks := h.Chunks()
+	require.Len(t, chunks, 3)
+	last, err := multihash.Sum(multihash.SHA2_256, strings.NewReader(content[200:]))
+	require.NoError(t, err)
+	require.Equal(t, last, chunks[2])
+}
+
+func TestDiff(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 50))
+	h, err := chunk.NewHasher(multihash.SHA2_256, 100)
+	require.NoError(t, err)
+	h.Write(content)
+	anchored := h.Chunks()
+
+	ranges, err := chunk.Diff(bytes.NewReader(content), multihash.SHA2_256, 100, anchored)
+	require.NoError(t, err)
+	require.Empty(t, ranges)
+
+	modified := append([]byte(nil), content...)
+	modified[150] = 'x'
+	modified[250] = 'x'
+	modified[450] = 'x'
+	ranges, err = chunk.Diff(bytes.NewReader(modified), multihash.SHA2_256, 100, anchored)
+	require.NoError(t, err)
+	require.Equal(t, []chunk.Range{{Offset: 100, Length: 200}, {Offset: 400, Length: 100}}, ranges)
+
+	ranges, err = chunk.Diff(bytes.NewReader(content[:250]), multihash.SHA2_256, 100, anchored)
+	require.NoError(t, err)
+	require.Equal(t, []chunk.Range{{Offset: 200, Length: 300}}, ranges)
+}
@@ -0,0 +1,46 @@
+//go:build gateway
+
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"flag"
+	"io"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+)
+
+var (
+	gatewayEndpoint = flag.String("gateway", "", "address of the peer to submit through its Fabric Gateway instead of the peer CLI, e.g. localhost:7051")
+	gatewayHost     = flag.String("gateway-host", "", "TLS host name of the -gateway peer, e.g. peer0.org1.example.com")
+	gatewayTLSCert  = flag.String("gateway-tls-cert", "", "TLS CA certificate of the -gateway peer")
+	gatewayMSP      = flag.String("msp", "Org1MSP", "MSP ID of the -gateway identity")
+	gatewayCert     = flag.String("cert", "", "PEM enrollment certificate of the -gateway identity, msp/signcerts")
+)
+
+func init() {
+	dialGateway = func(channel string, chaincode string, key string) (ledger.Client, io.Closer, error) {
+		if *gatewayEndpoint == "" {
+			return nil, nil, nil
+		}
+
+		gateway, err := ledger.DialGateway(ledger.GatewayConfig{
+			Endpoint:     *gatewayEndpoint,
+			HostOverride: *gatewayHost,
+			TLSCertPath:  *gatewayTLSCert,
+			MSPID:        *gatewayMSP,
+			CertPath:     *gatewayCert,
+			KeyPath:      key,
+			Channel:      channel,
+			Chaincode:    chaincode,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return gateway, gateway, nil
+	}
+}
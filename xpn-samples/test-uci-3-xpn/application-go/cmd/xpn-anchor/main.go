@@ -0,0 +1,135 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// xpn-anchor writes the JSON document read from stdin to XPN, anchors it on the ledger and prints
+// the receipt. It replaces the write_data_xpn.py and peer chaincode invoke steps of the deploy scripts.
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/anchor"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/chunk"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/xpnconf"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/attestation"
+)
+
+// dialGateway returns a Fabric Gateway client when xpn-anchor is built with -tags gateway and -gateway
+// is set, and a nil client otherwise; see gateway.go.
+var dialGateway func(channel string, chaincode string, key string) (ledger.Client, io.Closer, error)
+
+func main() {
+	mountpoint := flag.String("mount", "", "directory where the XPN partition is mounted")
+	prefix := flag.String("prefix", storage.DefaultXpnPrefix, "XPN directory of the anchored paths")
+	partition := flag.String("partition", "xpn", "XPN partition of the file")
+	path := flag.String("path", "", "anchored path of the file, e.g. /tmp/expand/xpn/1/patient.txt")
+	patient := flag.String("patient", "", "patient the document belongs to")
+	documentType := flag.String("type", "", "document type: patient, contract, diagnosis or vital_signs")
+	chunkSize := flag.Int64("chunk-size", chunk.DefaultSize, "size of the hashed chunks, the XPN block size; 0 disables chunk hashes")
+	key := flag.String("key", "", "PEM encoded enrollment key of the submitter, e.g. msp/keystore/priv_sk; signs the anchor")
+	config := flag.String("config", "", "XPN configuration file; records the layout of the partition and sets the default chunk size to its block size")
+	retries := flag.Int("retries", 3, "number of times a failed submission is retried")
+	channel := flag.String("channel", "mychannel", "channel name")
+	chaincode := flag.String("chaincode", "basic", "chaincode name")
+	invokeFlags := flag.String("invoke-flags", "", "extra flags of \"peer chaincode invoke\", e.g. orderer and endorsing peers")
+	flag.Parse()
+
+	if *mountpoint == "" || *path == "" || *patient == "" || *documentType == "" {
+		log.Fatal("usage: xpn-anchor -mount <dir> -path <path> -patient <id> -type <document type> < document.json")
+	}
+	store := storage.NewXpnMount(*mountpoint)
+	store.Prefix = *prefix
+
+	var document interface{}
+	if err := json.NewDecoder(os.Stdin).Decode(&document); err != nil {
+		log.Fatalf("failed to read document: %v", err)
+	}
+	content, err := anchor.JSON(document)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var layout *ledger.XpnLayout
+	if *config != "" {
+		conf, err := xpnconf.Load(*config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p, err := conf.Partition(*partition)
+		if err != nil {
+			log.Fatal(err)
+		}
+		layout = &ledger.XpnLayout{BlockSize: p.BlockSize, ReplicationLevel: p.ReplicationLevel, Servers: p.Servers}
+		if !flagSet("chunk-size") {
+			*chunkSize = p.BlockSize
+		}
+	}
+
+	var signer crypto.Signer
+	if *key != "" {
+		keyPEM, err := os.ReadFile(*key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		signer, err = attestation.ParsePrivateKey(keyPEM)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var client ledger.Client = &ledger.PeerCLI{
+		Channel:     *channel,
+		Chaincode:   *chaincode,
+		InvokeFlags: strings.Fields(*invokeFlags),
+	}
+	if dialGateway != nil {
+		gateway, closer, err := dialGateway(*channel, *chaincode, *key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if gateway != nil {
+			defer closer.Close()
+			client = gateway
+		}
+	}
+
+	a := &anchor.Anchorer{
+		Ledger:    client,
+		Storage:   store,
+		Partition: *partition,
+		ChunkSize: *chunkSize,
+		Layout:    layout,
+		Signer:    signer,
+		Retries:   *retries,
+		Backoff:   time.Second,
+	}
+
+	receipt, err := a.Anchor(anchor.Document{Path: *path, Patient: *patient, DocumentType: *documentType, Content: content})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	json.NewEncoder(os.Stdout).Encode(receipt)
+}
+
+// flagSet reports whether the named flag was passed on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
@@ -0,0 +1,122 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// xpn-auditor checks the files stored in XPN against the hashes anchored on the ledger. It prints
+// a JSON report for every run and serves the result of the last run as Prometheus metrics.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/auditor"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+)
+
+func main() {
+	mountpoint := flag.String("mount", "", "directory where the XPN partition is mounted")
+	dir := flag.String("dir", "", "local copy of the XPN directory, used instead of -mount for tests")
+	prefix := flag.String("prefix", storage.DefaultXpnPrefix, "XPN directory of the anchored paths")
+	channel := flag.String("channel", "mychannel", "channel name")
+	chaincode := flag.String("chaincode", "basic", "chaincode name")
+	invokeFlags := flag.String("invoke-flags", "", "extra flags of \"peer chaincode invoke\", e.g. orderer and endorsing peers")
+	submit := flag.Bool("submit", false, "record every check on the ledger with VerifyXpnFile")
+	interval := flag.Duration("interval", 0, "run the audit on this interval instead of once")
+	listen := flag.String("listen", "", "address to serve Prometheus metrics on, e.g. :9446")
+	replicas := replicaFlag{}
+	flag.Var(replicas, "replica", "server URL and mount point of the files of an XPN server to check, e.g. sck_server://xpn2/xpn=/mnt/xpn2; may be repeated")
+	flag.Parse()
+
+	var store *storage.Dir
+	switch {
+	case *mountpoint != "":
+		store = storage.NewXpnMount(*mountpoint)
+	case *dir != "":
+		store = storage.NewLocalDir(*dir)
+	default:
+		log.Fatal("one of -mount or -dir is required")
+	}
+	store.Prefix = *prefix
+
+	a := &auditor.Auditor{
+		Ledger: &ledger.PeerCLI{
+			Channel:     *channel,
+			Chaincode:   *chaincode,
+			InvokeFlags: strings.Fields(*invokeFlags),
+		},
+		Storage:  store,
+		Submit:   *submit,
+		Replicas: make(map[string]storage.Storage),
+	}
+	for server, mountpoint := range replicas {
+		replica := storage.NewXpnMount(mountpoint)
+		replica.Prefix = *prefix
+		a.Replicas[server] = replica
+	}
+
+	var mu sync.Mutex
+	var last *auditor.Report
+	if *listen != "" {
+		http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if last == nil {
+				http.Error(w, "no audit has finished yet", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			auditor.WriteMetrics(w, last)
+		})
+		go func() {
+			log.Fatal(http.ListenAndServe(*listen, nil))
+		}()
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	for {
+		report, err := a.Run()
+		if err != nil {
+			log.Printf("audit failed: %v", err)
+		} else {
+			mu.Lock()
+			last = report
+			mu.Unlock()
+			encoder.Encode(report)
+		}
+
+		if *interval <= 0 {
+			if err != nil {
+				os.Exit(1)
+			}
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// replicaFlag collects -replica server=mountpoint flags.
+type replicaFlag map[string]string
+
+func (r replicaFlag) String() string {
+	return fmt.Sprint(map[string]string(r))
+}
+
+func (r replicaFlag) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return fmt.Errorf("expected <server url>=<mount point>, got %q", value)
+	}
+	r[value[:i]] = value[i+1:]
+
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// xpn-batch anchors the XPN files given as arguments with a single CreateXpnBatch transaction and
+// prints the batch id, its root and the inclusion proof of every file as JSON. The patient and
+// document type of every file are derived from its path like xpn-watcher does. With -verify it
+// checks a proof printed earlier against the root of batch -id instead.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/batch"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/watcher"
+)
+
+func main() {
+	mountpoint := flag.String("mount", "", "directory where the XPN partition is mounted")
+	prefix := flag.String("prefix", storage.DefaultXpnPrefix, "XPN directory of the anchored paths")
+	partition := flag.String("partition", "xpn", "XPN partition of the files")
+	id := flag.String("id", "", "batch id printed when the batch was anchored, for -verify")
+	channel := flag.String("channel", "mychannel", "channel name")
+	chaincode := flag.String("chaincode", "basic", "chaincode name")
+	invokeFlags := flag.String("invoke-flags", "", "extra flags of \"peer chaincode invoke\", e.g. orderer and endorsing peers")
+	verify := flag.String("verify", "", "JSON proof file to check against batch -id instead of anchoring")
+	flag.Parse()
+
+	client := &ledger.PeerCLI{
+		Channel:     *channel,
+		Chaincode:   *chaincode,
+		InvokeFlags: strings.Fields(*invokeFlags),
+	}
+	if *verify != "" {
+		if *id == "" {
+			log.Fatal("-verify requires -id")
+		}
+		proof, err := os.ReadFile(*verify)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ok, err := ledger.VerifyXpnBatchProof(client, *id, string(proof))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(ok)
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *mountpoint == "" || flag.NArg() == 0 {
+		log.Fatal("usage: xpn-batch -mount <dir> <anchored path>...")
+	}
+	store := storage.NewXpnMount(*mountpoint)
+	store.Prefix = *prefix
+
+	result, err := batch.Anchor(client, store, *partition, flag.Args(), watcher.Classify)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(result)
+}
@@ -0,0 +1,70 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// xpn-manifest anchors the manifest of XPN directories, such as the /tmp/expand/xpn/<id> directory of
+// a patient, with CreateXpnManifest. With -diff it prints the files added, removed and changed since
+// the last manifest of every directory, or since manifest -since, instead.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/snapshot"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+)
+
+func main() {
+	mountpoint := flag.String("mount", "", "directory where the XPN partition is mounted")
+	prefix := flag.String("prefix", storage.DefaultXpnPrefix, "XPN directory of the anchored paths")
+	channel := flag.String("channel", "mychannel", "channel name")
+	chaincode := flag.String("chaincode", "basic", "chaincode name")
+	invokeFlags := flag.String("invoke-flags", "", "extra flags of \"peer chaincode invoke\", e.g. orderer and endorsing peers")
+	diff := flag.Bool("diff", false, "print the changes since the last manifest instead of anchoring a new one")
+	since := flag.String("since", "", "manifest id to compare with, implies -diff; only with a single directory")
+	flag.Parse()
+
+	if *mountpoint == "" || flag.NArg() == 0 {
+		log.Fatal("usage: xpn-manifest -mount <dir> [-diff] [-since <manifest id>] <directory>...")
+	}
+	if *since != "" && flag.NArg() > 1 {
+		log.Fatal("-since takes a single directory")
+	}
+	client := &ledger.PeerCLI{
+		Channel:     *channel,
+		Chaincode:   *chaincode,
+		InvokeFlags: strings.Fields(*invokeFlags),
+	}
+	store := storage.NewXpnMount(*mountpoint)
+	store.Prefix = *prefix
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	changed := false
+	for _, directory := range flag.Args() {
+		if *diff || *since != "" {
+			report, err := snapshot.Diff(client, store, directory, *since)
+			if err != nil {
+				log.Fatal(err)
+			}
+			changed = changed || !report.Empty()
+			encoder.Encode(report)
+			continue
+		}
+
+		xpnmanifest, err := snapshot.Anchor(client, store, directory)
+		if err != nil {
+			log.Fatal(err)
+		}
+		encoder.Encode(xpnmanifest)
+	}
+
+	if changed {
+		os.Exit(1)
+	}
+}
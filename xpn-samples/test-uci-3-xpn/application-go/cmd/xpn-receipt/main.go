@@ -0,0 +1,128 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// xpn-receipt exports the anchoring receipt of a transaction as JSON. With -verify it checks a receipt
+// offline against the CA certificates of the organizations, without contacting a peer.
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/auditor"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/receipt"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+func main() {
+	txID := flag.String("tx", "", "id of the transaction that anchored the file, the anchor id for CreateXpnTransaction")
+	anchorID := flag.String("anchor", "", "anchor id, when the transaction wrote several anchors")
+	checkpoint := flag.Uint64("checkpoint", 0, "block the receipt is chained to, the last block when 0")
+	channel := flag.String("channel", "mychannel", "channel name")
+	chaincode := flag.String("chaincode", "basic", "chaincode name")
+	verify := flag.String("verify", "", "receipt file to check instead of exporting one")
+	cas := caFlag{}
+	flag.Var(cas, "ca", "MSP ID and CA certificate file, e.g. Org1MSP=organizations/peerOrganizations/org1.example.com/ca/ca.org1.example.com-cert.pem; may be repeated")
+	checkpointHash := flag.String("checkpoint-hash", "", "trusted hex header hash of the checkpoint block")
+	strict := flag.Bool("strict", true, "require -checkpoint-hash and a verified orderer signature of the checkpoint block, and the CA of every signing orderer; -strict=false only reports what was not checked")
+	file := flag.String("file", "", "local copy of the anchored file, checked against the anchored hash")
+	flag.Parse()
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+
+	if *verify != "" {
+		bundleJSON, err := os.ReadFile(*verify)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var bundle receipt.Bundle
+		if err := json.Unmarshal(bundleJSON, &bundle); err != nil {
+			log.Fatalf("failed to read receipt: %v", err)
+		}
+
+		result, err := receipt.Verify(&bundle, cas, *checkpointHash, *strict)
+		if err != nil {
+			log.Fatalf("receipt is not valid: %v", err)
+		}
+		if *file != "" {
+			checkFile(*file, result.Anchor)
+		}
+		if *checkpointHash == "" {
+			log.Printf("checkpoint hash not checked, compare %s with block %d of a trusted peer", result.CheckpointHash, result.Checkpoint)
+		}
+		if len(result.Orderers) == 0 {
+			log.Printf("no orderer signature of the checkpoint block checked, pass the orderer CA with -ca")
+		}
+		log.Printf("validity of transaction %s read from the unsigned block metadata, confirm it with a trusted peer", result.TxID)
+		encoder.Encode(result)
+		return
+	}
+
+	if *txID == "" {
+		log.Fatal("usage: xpn-receipt -tx <transaction id> > receipt.json | xpn-receipt -verify receipt.json -ca <msp>=<ca file>... -checkpoint-hash <hash>")
+	}
+	client := &ledger.PeerCLI{Channel: *channel, Chaincode: *chaincode}
+	bundle, err := receipt.Export(client, *channel, *chaincode, *txID, *anchorID, *checkpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoder.Encode(bundle)
+}
+
+// checkFile exits when the file does not have the anchored hash.
+func checkFile(name string, anchor *ledger.XpnTransaction) {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	hash, err := auditor.HashFile(f, anchor.HashAlgorithm)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !multihash.Equal(hash, anchor.Hash, anchor.HashAlgorithm) {
+		log.Fatalf("%s has hash %s, the receipt anchors %s", name, hash, anchor.Hash)
+	}
+}
+
+// caFlag collects -ca mspid=file flags into a certificate pool per MSP.
+type caFlag map[string]*x509.CertPool
+
+func (c caFlag) String() string {
+	msps := make([]string, 0, len(c))
+	for msp := range c {
+		msps = append(msps, msp)
+	}
+	return strings.Join(msps, ",")
+}
+
+func (c caFlag) Set(value string) error {
+	msp, name, ok := strings.Cut(value, "=")
+	if !ok || msp == "" || name == "" {
+		return fmt.Errorf("expected <msp id>=<ca certificate file>, got %q", value)
+	}
+	certPEM, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	pool := c[msp]
+	if pool == nil {
+		pool = x509.NewCertPool()
+		c[msp] = pool
+	}
+	if !pool.AppendCertsFromPEM(certPEM) {
+		return fmt.Errorf("no PEM encoded certificate in %s", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// xpn-reconcile compares the patient, contract, diagnosis and last vital signs documents stored in XPN
+// with the Patient, Contract, Diagnosis and RTData entities on the ledger, field by field, and prints
+// the drift as JSON. The exit status is 1 when there is drift.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/reconcile"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+)
+
+func main() {
+	mountpoint := flag.String("mount", "", "directory where the XPN partition is mounted")
+	dir := flag.String("dir", "", "local copy of the XPN directory, used instead of -mount for tests")
+	prefix := flag.String("prefix", storage.DefaultXpnPrefix, "XPN directory of the anchored paths")
+	channel := flag.String("channel", "mychannel", "channel name")
+	chaincode := flag.String("chaincode", "basic", "chaincode name")
+	invokeFlags := flag.String("invoke-flags", "", "extra flags of \"peer chaincode invoke\", e.g. orderer and endorsing peers")
+	flag.Parse()
+
+	var store *storage.Dir
+	switch {
+	case *mountpoint != "":
+		store = storage.NewXpnMount(*mountpoint)
+	case *dir != "":
+		store = storage.NewLocalDir(*dir)
+	default:
+		log.Fatal("usage: xpn-reconcile -mount <dir> [<patient id>...]")
+	}
+	store.Prefix = *prefix
+
+	r := &reconcile.Reconciler{
+		Ledger: &ledger.PeerCLI{
+			Channel:     *channel,
+			Chaincode:   *chaincode,
+			InvokeFlags: strings.Fields(*invokeFlags),
+		},
+		Storage: store,
+	}
+
+	report, err := r.Run(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(report)
+
+	if len(report.Drift) > 0 {
+		os.Exit(1)
+	}
+}
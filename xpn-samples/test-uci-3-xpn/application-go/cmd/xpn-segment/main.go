@@ -0,0 +1,107 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// xpn-segment appends vital signs readings, one JSON document per line of stdin, to the segmented log
+// of a patient and anchors every sealed segment, or prints the readings of the log with -read.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/chunk"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/segment"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/vitalsigns"
+)
+
+func main() {
+	mountpoint := flag.String("mount", "", "directory where the XPN partition is mounted")
+	dir := flag.String("dir", "", "local directory to use instead of -mount")
+	prefix := flag.String("prefix", storage.DefaultXpnPrefix, "XPN directory of the anchored paths")
+	patient := flag.String("patient", "", "patient id")
+	name := flag.String("name", "vital_signs", "name of the segments")
+	segmentSize := flag.Int64("segment-size", chunk.DefaultSize, "size of a segment, the XPN block size")
+	anchor := flag.Bool("anchor", true, "anchor every sealed segment")
+	partition := flag.String("partition", "xpn", "XPN partition of the segments")
+	read := flag.Bool("read", false, "print the readings of the log instead of appending to it")
+	verify := flag.Bool("verify", true, "with -read, check every segment against its anchor")
+	channel := flag.String("channel", "mychannel", "channel name")
+	chaincode := flag.String("chaincode", "basic", "chaincode name")
+	invokeFlags := flag.String("invoke-flags", "", "extra flags of \"peer chaincode invoke\", e.g. orderer and endorsing peers")
+	flag.Parse()
+
+	var store *storage.Dir
+	switch {
+	case *mountpoint != "":
+		store = storage.NewXpnMount(*mountpoint)
+	case *dir != "":
+		store = storage.NewLocalDir(*dir)
+	}
+	if store == nil || *patient == "" {
+		log.Fatal("usage: xpn-segment -mount <dir> -patient <id> [-read] < readings.jsonl")
+	}
+	store.Prefix = *prefix
+	directory := path.Join(*prefix, *patient)
+
+	client := &ledger.PeerCLI{
+		Channel:     *channel,
+		Chaincode:   *chaincode,
+		InvokeFlags: strings.Fields(*invokeFlags),
+	}
+
+	if *read {
+		r := &segment.Reader{Storage: store, Directory: directory, Name: *name}
+		if *verify {
+			r.Ledger = client
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		err := r.Readings(func(doc *vitalsigns.Document) error {
+			return encoder.Encode(doc)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	w, err := segment.OpenWriter(store, directory, *name, *segmentSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *anchor {
+		w.OnSeal = segment.Anchor(client, *patient, *partition)
+		// segments sealed by an earlier run whose anchoring failed
+		if err := w.Reanchor(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), int(*segmentSize))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		if _, err := vitalsigns.Decode(line); err != nil {
+			log.Fatalf("invalid reading: %v", err)
+		}
+		if err := w.Append(append([]byte(nil), line...)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// xpn-watcher polls XPN or local directories and anchors every new or modified file once its writes
+// have settled, so that data producers only write files. What is anchored is kept in a local state file.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/watcher"
+)
+
+func main() {
+	mountpoint := flag.String("mount", "", "directory where the XPN partition is mounted")
+	dir := flag.String("dir", "", "local directory to watch instead of -mount")
+	prefix := flag.String("prefix", storage.DefaultXpnPrefix, "XPN directory of the anchored paths")
+	partition := flag.String("partition", "xpn", "XPN partition of the files")
+	stateFile := flag.String("state", "xpn-watcher.json", "file keeping what is already anchored")
+	settle := flag.Duration("settle", watcher.DefaultSettle, "how long a file must stay unmodified before it is anchored")
+	batchSize := flag.Int("batch", 0, "anchor the files in batches of at most this many files with CreateXpnBatch; 0 anchors every file")
+	interval := flag.Duration("interval", 10*time.Second, "poll interval")
+	once := flag.Bool("once", false, "poll once and exit")
+	channel := flag.String("channel", "mychannel", "channel name")
+	chaincode := flag.String("chaincode", "basic", "chaincode name")
+	invokeFlags := flag.String("invoke-flags", "", "extra flags of \"peer chaincode invoke\", e.g. orderer and endorsing peers")
+	flag.Parse()
+
+	var store *storage.Dir
+	switch {
+	case *mountpoint != "":
+		store = storage.NewXpnMount(*mountpoint)
+	case *dir != "":
+		store = storage.NewLocalDir(*dir)
+	default:
+		log.Fatal("usage: xpn-watcher -mount <dir> [-state <file>] [<anchored directory>...]")
+	}
+	store.Prefix = *prefix
+
+	state, err := watcher.OpenState(*stateFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := &watcher.Watcher{
+		Ledger: &ledger.PeerCLI{
+			Channel:     *channel,
+			Chaincode:   *chaincode,
+			InvokeFlags: strings.Fields(*invokeFlags),
+		},
+		Storage:     store,
+		Directories: flag.Args(),
+		Partition:   *partition,
+		Settle:      *settle,
+		BatchSize:   *batchSize,
+		State:       state,
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for {
+		result, err := w.Poll()
+		if err != nil {
+			log.Printf("poll failed: %v", err)
+		} else if *once || result.Anchored+result.Reconciled+result.Failed > 0 {
+			encoder.Encode(result)
+		}
+
+		if *once {
+			if err != nil || result.Failed > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
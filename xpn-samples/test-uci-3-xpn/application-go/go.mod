@@ -0,0 +1,24 @@
+module github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go
+
+go 1.23.0
+
+require (
+	github.com/hyperledger/fabric-protos-go-apiv2 v0.3.4
+	github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go v0.0.0
+	github.com/stretchr/testify v1.10.0
+	google.golang.org/protobuf v1.36.4
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/crypto v0.36.0 // indirect
+	golang.org/x/net v0.38.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+	google.golang.org/grpc v1.71.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go => ../chaincode-go
@@ -0,0 +1,72 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// BlockSource reads the blocks of the channel, as marshaled common.Block protobuf messages.
+type BlockSource interface {
+	BlockByTxID(txID string) ([]byte, error)
+	BlockByNumber(number uint64) ([]byte, error)
+	// Height is the number of blocks of the channel.
+	Height() (uint64, error)
+}
+
+// BlockByTxID implements BlockSource with the GetBlockByTxID function of the qscc system chaincode.
+func (p *PeerCLI) BlockByTxID(txID string) ([]byte, error) {
+	return p.querySystem("qscc", "GetBlockByTxID", p.Channel, txID)
+}
+
+// BlockByNumber implements BlockSource with the GetBlockByNumber function of the qscc system chaincode.
+func (p *PeerCLI) BlockByNumber(number uint64) ([]byte, error) {
+	return p.querySystem("qscc", "GetBlockByNumber", p.Channel, strconv.FormatUint(number, 10))
+}
+
+// Height implements BlockSource with "peer channel getinfo".
+func (p *PeerCLI) Height() (uint64, error) {
+	stdout, _, err := p.run([]string{"channel", "getinfo", "-c", p.Channel})
+	if err != nil {
+		return 0, err
+	}
+
+	// Blockchain info: {"height":5,"currentBlockHash":"...","previousBlockHash":"..."}
+	i := bytes.IndexByte(stdout, '{')
+	if i < 0 {
+		return 0, fmt.Errorf("failed to read channel height from %q", stdout)
+	}
+	var info struct {
+		Height uint64 `json:"height"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(stdout[i:]), &info); err != nil {
+		return 0, fmt.Errorf("failed to read channel height: %v", err)
+	}
+
+	return info.Height, nil
+}
+
+// querySystem queries a system chaincode. Its binary result is printed hex encoded.
+func (p *PeerCLI) querySystem(chaincode string, name string, args ...string) ([]byte, error) {
+	input, _ := json.Marshal(struct {
+		Args []string `json:"Args"`
+	}{append([]string{name}, args...)})
+
+	stdout, _, err := p.run([]string{"chaincode", "query", "-C", p.Channel, "-n", chaincode, "--hex", "-c", string(input)})
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := hex.DecodeString(string(bytes.TrimSpace(stdout)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s result: %v", name, err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,55 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledger
+
+import "strings"
+
+// retryableErrors are parts of the messages of failures that say nothing about the transaction
+// itself: the peer or orderer could not be reached, did not answer in time, or the transaction was
+// invalidated by a concurrent one. The messages of PeerCLI and of the Fabric Gateway client (gRPC
+// status codes and commit status) both contain them.
+var retryableErrors = []string{
+	"timed out",
+	"timeout",
+	"deadline exceeded",
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"failed to connect",
+	"error while dialing",
+	"code = Unavailable",
+	"code = DeadlineExceeded",
+	"code = ResourceExhausted",
+	"MVCC_READ_CONFLICT",
+	"PHANTOM_READ_CONFLICT",
+}
+
+// Retryable reports whether a failed submission may succeed when it is submitted again. Transport
+// errors, timeouts and read conflicts are retryable; a transaction rejected by the chaincode is not,
+// since every endorsement of the same proposal fails the same way.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	message := err.Error()
+	for _, retryable := range retryableErrors {
+		if strings.Contains(message, retryable) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NotAnchored reports whether err is the chaincode error for a path or hash that has no anchor.
+func NotAnchored(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "is not anchored") || strings.Contains(err.Error(), "was never anchored"))
+}
+
+// NotFound reports whether err is the chaincode error for an entity that does not exist.
+func NotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "does not exist")
+}
@@ -0,0 +1,141 @@
+//go:build gateway
+
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledger
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/hash"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// GatewayConfig describes the peer a Gateway connects to and the identity it submits with, the same
+// settings as the CORE_PEER_* variables of the deploy scripts.
+type GatewayConfig struct {
+	// Endpoint is the address of the peer, e.g. localhost:7051.
+	Endpoint string
+	// HostOverride is the TLS host name of the peer, e.g. peer0.org1.example.com.
+	HostOverride string
+	// TLSCertPath is the TLS CA certificate of the peer.
+	TLSCertPath string
+	MSPID       string
+	// CertPath and KeyPath are the PEM enrollment certificate and key, msp/signcerts and msp/keystore.
+	CertPath  string
+	KeyPath   string
+	Channel   string
+	Chaincode string
+}
+
+// Gateway is a Client and TransientSubmitter submitting through the Fabric Gateway of a peer. It is
+// built with -tags gateway, which needs github.com/hyperledger/fabric-gateway in go.mod.
+//
+// Its errors keep the gRPC status and the commit status of the gateway client, which Retryable tells
+// apart from chaincode rejections.
+type Gateway struct {
+	Contract *client.Contract
+
+	gateway    *client.Gateway
+	connection *grpc.ClientConn
+}
+
+// DialGateway connects to the Fabric Gateway of the peer in config.
+func DialGateway(config GatewayConfig) (*Gateway, error) {
+	tlsCert, err := readCertificate(config.TLSCertPath)
+	if err != nil {
+		return nil, err
+	}
+	certPool := x509.NewCertPool()
+	certPool.AddCert(tlsCert)
+	connection, err := grpc.NewClient(config.Endpoint, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(certPool, config.HostOverride)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", config.Endpoint, err)
+	}
+
+	gateway, err := connectGateway(config, connection)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	return &Gateway{
+		Contract:   gateway.GetNetwork(config.Channel).GetContract(config.Chaincode),
+		gateway:    gateway,
+		connection: connection,
+	}, nil
+}
+
+func connectGateway(config GatewayConfig, connection *grpc.ClientConn) (*client.Gateway, error) {
+	cert, err := readCertificate(config.CertPath)
+	if err != nil {
+		return nil, err
+	}
+	id, err := identity.NewX509Identity(config.MSPID, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := os.ReadFile(config.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+	key, err := identity.PrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := identity.NewPrivateKeySign(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Connect(
+		id,
+		client.WithSign(sign),
+		client.WithHash(hash.SHA256),
+		client.WithClientConnection(connection),
+		client.WithEvaluateTimeout(5*time.Second),
+		client.WithEndorseTimeout(15*time.Second),
+		client.WithSubmitTimeout(5*time.Second),
+		client.WithCommitStatusTimeout(time.Minute),
+	)
+}
+
+func readCertificate(name string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+
+	return identity.CertificateFromPEM(certPEM)
+}
+
+// SubmitTransaction implements Client. It returns once the transaction is committed.
+func (g *Gateway) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return g.Contract.SubmitTransaction(name, args...)
+}
+
+// EvaluateTransaction implements Client.
+func (g *Gateway) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return g.Contract.EvaluateTransaction(name, args...)
+}
+
+// SubmitTransient implements TransientSubmitter.
+func (g *Gateway) SubmitTransient(name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	return g.Contract.Submit(name, client.WithArguments(args...), client.WithTransient(transient))
+}
+
+// Close closes the gateway and its connection.
+func (g *Gateway) Close() error {
+	g.gateway.Close()
+
+	return g.connection.Close()
+}
@@ -0,0 +1,650 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package ledger gives the off-chain tools access to the XPN anchors stored by the chaincode.
+package ledger
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/manifest"
+)
+
+// Client submits and evaluates chaincode transactions. A Fabric Gateway *client.Contract
+// satisfies it, as does PeerCLI.
+type Client interface {
+	SubmitTransaction(name string, args ...string) ([]byte, error)
+	EvaluateTransaction(name string, args ...string) ([]byte, error)
+}
+
+// TransientSubmitter is implemented by clients that can pass a transient map with a submitted
+// transaction. With Fabric Gateway it wraps contract.Submit(name, client.WithTransient(transient)).
+type TransientSubmitter interface {
+	SubmitTransient(name string, transient map[string][]byte, args ...string) ([]byte, error)
+}
+
+// XpnTransaction mirrors the chaincode XpnTransaction. Patient is only returned to identities with
+// the xpn.reidentify attribute.
+type XpnTransaction struct {
+	ID            string     `json:"ID"`
+	Hash          string     `json:"Hash"`
+	Path          string     `json:"Path"`
+	Patient       string     `json:"Patient,omitempty"`
+	DocumentType  string     `json:"DocumentType"`
+	Size          int64      `json:"Size"`
+	HashAlgorithm string     `json:"HashAlgorithm"`
+	Partition     string     `json:"Partition"`
+	Layout        *XpnLayout `json:"Layout,omitempty"`
+	Timestamp     string     `json:"Timestamp"`
+	ChunkSize     int64      `json:"ChunkSize,omitempty"`
+	ChunkHashes   []string   `json:"ChunkHashes,omitempty"`
+	Version       int        `json:"Version"`
+	PreviousID    string     `json:"PreviousID,omitempty"`
+	PreviousHash  string     `json:"PreviousHash,omitempty"`
+	Creator       string     `json:"Creator"`
+	CreatorMSP    string     `json:"CreatorMSP"`
+	Signature     string     `json:"Signature,omitempty"`
+	SignedAt      string     `json:"SignedAt,omitempty"`
+	Signer        string     `json:"Signer,omitempty"`
+	Batch         string     `json:"Batch,omitempty"`
+	Erased        bool       `json:"Erased,omitempty"`
+	FileDeleted   bool       `json:"FileDeleted,omitempty"`
+}
+
+// XpnLayout mirrors the chaincode XpnLayout, the partition layout a file was written with.
+type XpnLayout struct {
+	BlockSize        int64    `json:"BlockSize"`
+	ReplicationLevel int      `json:"ReplicationLevel"`
+	Servers          []string `json:"Servers"`
+}
+
+// XpnSigner mirrors the chaincode XpnSigner.
+type XpnSigner struct {
+	Fingerprint string `json:"Fingerprint"`
+	ID          string `json:"ID"`
+	MSP         string `json:"MSP"`
+	Certificate string `json:"Certificate"`
+}
+
+// XpnAttestation mirrors the chaincode XpnAttestation.
+type XpnAttestation struct {
+	XpnTransaction string     `json:"XpnTransaction"`
+	Path           string     `json:"Path"`
+	Hash           string     `json:"Hash"`
+	Version        int        `json:"Version"`
+	Signed         bool       `json:"Signed"`
+	SignedAt       string     `json:"SignedAt,omitempty"`
+	Signature      string     `json:"Signature,omitempty"`
+	Message        string     `json:"Message,omitempty"`
+	Signer         *XpnSigner `json:"Signer,omitempty"`
+	Valid          bool       `json:"Valid"`
+	Error          string     `json:"Error,omitempty"`
+}
+
+// XpnVerification mirrors the chaincode XpnVerification.
+type XpnVerification struct {
+	TxID           string `json:"TxID"`
+	XpnTransaction string `json:"XpnTransaction"`
+	Path           string `json:"Path"`
+	AnchoredHash   string `json:"AnchoredHash"`
+	RecomputedHash string `json:"RecomputedHash"`
+	Intact         bool   `json:"Intact"`
+	Verifier       string `json:"Verifier"`
+	MSP            string `json:"MSP"`
+	Timestamp      string `json:"Timestamp"`
+}
+
+// GetAllXpnTransactions returns all anchors stored on the ledger.
+func GetAllXpnTransactions(c Client) ([]*XpnTransaction, error) {
+	result, err := c.EvaluateTransaction("GetAllXpnTransactions")
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate GetAllXpnTransactions: %v", err)
+	}
+
+	var xpntransactions []*XpnTransaction
+	if err := unmarshalResult(result, &xpntransactions); err != nil {
+		return nil, err
+	}
+
+	return xpntransactions, nil
+}
+
+// VerifyXpnFile submits a VerifyXpnFile transaction for the anchor with given id.
+func VerifyXpnFile(c Client, id string, recomputedHash string) (*XpnVerification, error) {
+	result, err := c.SubmitTransaction("VerifyXpnFile", id, recomputedHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit VerifyXpnFile: %v", err)
+	}
+
+	var verification XpnVerification
+	if err := unmarshalResult(result, &verification); err != nil {
+		return nil, err
+	}
+
+	return &verification, nil
+}
+
+// unmarshalResult decodes a JSON transaction result. The contract API returns an empty
+// result for nil slices.
+func unmarshalResult(result []byte, v interface{}) error {
+	if len(result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(result, v); err != nil {
+		return fmt.Errorf("failed to unmarshal transaction result: %v", err)
+	}
+
+	return nil
+}
+
+// XpnBatchFile is a file anchored by CreateXpnBatch.
+type XpnBatchFile struct {
+	Hash          string `json:"hash"`
+	Path          string `json:"path"`
+	Patient       string `json:"patient"`
+	DocumentType  string `json:"documentType"`
+	Size          string `json:"size"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+}
+
+// CreateXpnBatch anchors files with a single transaction and returns the batch id assigned by the
+// chaincode. Every file gets its own anchor, see XpnTransaction.Batch. The files are passed in the
+// transient map when c supports it.
+func CreateXpnBatch(c Client, files []XpnBatchFile, partition string) (string, error) {
+	var result []byte
+	var err error
+	if submitter, ok := c.(TransientSubmitter); ok {
+		var inputJSON []byte
+		inputJSON, err = json.Marshal(struct {
+			Files     []XpnBatchFile `json:"files"`
+			Partition string         `json:"partition"`
+		}{files, partition})
+		if err != nil {
+			return "", err
+		}
+		result, err = submitter.SubmitTransient("CreateXpnBatchTransient", map[string][]byte{"xpnbatch": inputJSON})
+	} else {
+		var filesJSON []byte
+		filesJSON, err = json.Marshal(files)
+		if err != nil {
+			return "", err
+		}
+		result, err = c.SubmitTransaction("CreateXpnBatch", string(filesJSON), partition)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to submit CreateXpnBatch: %v", err)
+	}
+
+	return string(result), nil
+}
+
+// VerifyXpnBatchProof asks the chaincode whether proof, a JSON encoded merkle.Proof, is covered
+// by the root of the batch with given id.
+func VerifyXpnBatchProof(c Client, id string, proof string) (bool, error) {
+	result, err := c.EvaluateTransaction("VerifyXpnBatchProof", id, proof)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate VerifyXpnBatchProof: %v", err)
+	}
+
+	return strconv.ParseBool(string(result))
+}
+
+// XpnTransactionInput is the transient payload of CreateXpnTransactionTransient.
+type XpnTransactionInput struct {
+	ID            string `json:"id,omitempty"`
+	Hash          string `json:"hash"`
+	Path          string `json:"path"`
+	Patient       string `json:"patient"`
+	DocumentType  string `json:"documentType"`
+	Size          string `json:"size"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+	Partition     string `json:"partition"`
+	// ChunkSize, ChunkHashes, Layout and the signature are only passed through the transient map.
+	ChunkSize   string     `json:"chunkSize,omitempty"`
+	ChunkHashes []string   `json:"chunkHashes,omitempty"`
+	Layout      *XpnLayout `json:"layout,omitempty"`
+	// Signature and SignedAt are produced by attestation.Sign with the enrollment key of the submitter.
+	Signature string `json:"signature,omitempty"`
+	SignedAt  string `json:"signedAt,omitempty"`
+}
+
+// CreateXpnTransaction anchors a file and returns the id assigned by the chaincode. The details
+// are passed in the transient map when c supports it.
+func CreateXpnTransaction(c Client, input XpnTransactionInput) (string, error) {
+	var result []byte
+	var err error
+	if submitter, ok := c.(TransientSubmitter); ok {
+		var inputJSON []byte
+		inputJSON, err = json.Marshal(input)
+		if err != nil {
+			return "", err
+		}
+		result, err = submitter.SubmitTransient("CreateXpnTransactionTransient", map[string][]byte{"xpntransaction": inputJSON})
+	} else if input.ChunkSize != "" || input.Layout != nil || input.Signature != "" {
+		return "", fmt.Errorf("chunk hashes, layouts and signatures can only be passed by clients implementing TransientSubmitter")
+	} else {
+		result, err = c.SubmitTransaction("CreateXpnTransaction", input.Hash, input.Path, input.Patient, input.DocumentType,
+			input.Size, input.HashAlgorithm, input.Partition)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to submit CreateXpnTransaction: %v", err)
+	}
+
+	return string(result), nil
+}
+
+// UpdateXpnTransactionInput anchors a new version of the file at input.Path, with the chunk hashes,
+// layout and signature of input, and returns its id. The details are passed in the transient map when
+// c supports it. The patient, document type and partition of the previous version are kept.
+func UpdateXpnTransactionInput(c Client, input XpnTransactionInput) (string, error) {
+	var result []byte
+	var err error
+	if submitter, ok := c.(TransientSubmitter); ok {
+		var inputJSON []byte
+		inputJSON, err = json.Marshal(input)
+		if err != nil {
+			return "", err
+		}
+		result, err = submitter.SubmitTransient("UpdateXpnTransactionTransient", map[string][]byte{"xpntransaction": inputJSON})
+	} else if input.ChunkSize != "" || input.Layout != nil || input.Signature != "" {
+		return "", fmt.Errorf("chunk hashes, layouts and signatures can only be passed by clients implementing TransientSubmitter")
+	} else {
+		result, err = c.SubmitTransaction("UpdateXpnTransaction", input.Path, input.Hash, input.Size)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to submit UpdateXpnTransaction: %v", err)
+	}
+
+	return string(result), nil
+}
+
+// GetXpnTransactionsByPatient returns the anchors of the files of a patient.
+func GetXpnTransactionsByPatient(c Client, patient string) ([]*XpnTransaction, error) {
+	result, err := c.EvaluateTransaction("GetXpnTransactionsByPatient", patient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate GetXpnTransactionsByPatient: %v", err)
+	}
+
+	var xpntransactions []*XpnTransaction
+	if err := unmarshalResult(result, &xpntransactions); err != nil {
+		return nil, err
+	}
+
+	return xpntransactions, nil
+}
+
+// ReadXpnTransactionByPath returns the current anchor of path. The error satisfies NotAnchored when
+// path has none.
+func ReadXpnTransactionByPath(c Client, path string) (*XpnTransaction, error) {
+	result, err := c.EvaluateTransaction("ReadXpnTransactionByPath", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate ReadXpnTransactionByPath: %v", err)
+	}
+
+	var xpntransaction XpnTransaction
+	if err := unmarshalResult(result, &xpntransaction); err != nil {
+		return nil, err
+	}
+
+	return &xpntransaction, nil
+}
+
+// ReadXpnTransactionByHash returns every anchor of the content with given hash, none when the
+// chaincode reports that the hash was never anchored.
+func ReadXpnTransactionByHash(c Client, hash string) ([]*XpnTransaction, error) {
+	result, err := c.EvaluateTransaction("ReadXpnTransactionByHash", hash)
+	if NotAnchored(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate ReadXpnTransactionByHash: %v", err)
+	}
+
+	var xpntransactions []*XpnTransaction
+	if err := unmarshalResult(result, &xpntransactions); err != nil {
+		return nil, err
+	}
+
+	return xpntransactions, nil
+}
+
+// GetXpnAttestation returns the attestation of the anchor with given id.
+func GetXpnAttestation(c Client, id string) (*XpnAttestation, error) {
+	result, err := c.EvaluateTransaction("GetXpnAttestation", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate GetXpnAttestation: %v", err)
+	}
+
+	var attestation XpnAttestation
+	if err := unmarshalResult(result, &attestation); err != nil {
+		return nil, err
+	}
+
+	return &attestation, nil
+}
+
+// GetXpnAttestationsByPath returns the attestations of every version of the file at path.
+func GetXpnAttestationsByPath(c Client, path string) ([]*XpnAttestation, error) {
+	result, err := c.EvaluateTransaction("GetXpnAttestationsByPath", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate GetXpnAttestationsByPath: %v", err)
+	}
+
+	var attestations []*XpnAttestation
+	if err := unmarshalResult(result, &attestations); err != nil {
+		return nil, err
+	}
+
+	return attestations, nil
+}
+
+// RTData mirrors the chaincode RTData. Pointer records point to an anchored vital signs document
+// instead of holding the measurements, see package rtdata. Their Path and Hash are only returned to
+// identities with the xpn.reidentify attribute.
+type RTData struct {
+	ID                     string  `json:"ID"`
+	Patient                string  `json:"Patient"`
+	OxygenSaturation       float64 `json:"OxygenSaturation"`
+	PulseRate              float64 `json:"PulseRate"`
+	Temperature            float64 `json:"Temperature"`
+	BloodPressureSystolic  float64 `json:"BloodPressureSystolic"`
+	BloodPressureDiastolic float64 `json:"BloodPressureDiastolic"`
+	Path                   string  `json:"Path,omitempty"`
+	Hash                   string  `json:"Hash,omitempty"`
+	Pointer                bool    `json:"Pointer,omitempty"`
+	Erased                 bool    `json:"Erased,omitempty"`
+}
+
+// GetPatientPseudonym returns the pseudonym the records of a patient are stored under.
+func GetPatientPseudonym(c Client, patient string) (string, error) {
+	result, err := c.EvaluateTransaction("GetPatientPseudonym", patient)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate GetPatientPseudonym: %v", err)
+	}
+
+	return string(result), nil
+}
+
+// ReadRTData returns the real time measurements with given id, "RTD" followed by the patient pseudonym.
+func ReadRTData(c Client, id string) (*RTData, error) {
+	result, err := c.EvaluateTransaction("ReadRTData", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate ReadRTData: %v", err)
+	}
+
+	var rtData RTData
+	if err := unmarshalResult(result, &rtData); err != nil {
+		return nil, err
+	}
+
+	return &rtData, nil
+}
+
+// CreateRTDataPointer submits a CreateRTDataPointer transaction pointing the measurements of a patient
+// to the vital signs document anchored at path with hash.
+func CreateRTDataPointer(c Client, patient string, path string, hash string) error {
+	_, err := c.SubmitTransaction("CreateRTDataPointer", patient, path, hash)
+	if err != nil {
+		return fmt.Errorf("failed to submit CreateRTDataPointer: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateRTDataPointer submits an UpdateRTDataPointer transaction.
+func UpdateRTDataPointer(c Client, patient string, path string, hash string) error {
+	_, err := c.SubmitTransaction("UpdateRTDataPointer", patient, path, hash)
+	if err != nil {
+		return fmt.Errorf("failed to submit UpdateRTDataPointer: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateDiagnosis submits an UpdateDiagnosis transaction for a patient. document is the vital signs
+// document behind a pointer record, passed in the transient map, or nil when the measurements are
+// stored on the ledger.
+func UpdateDiagnosis(c Client, patient string, document []byte) error {
+	var err error
+	if document == nil {
+		_, err = c.SubmitTransaction("UpdateDiagnosis", patient)
+	} else if submitter, ok := c.(TransientSubmitter); ok {
+		_, err = submitter.SubmitTransient("UpdateDiagnosis", map[string][]byte{"rtdata": document}, patient)
+	} else {
+		return fmt.Errorf("vital signs documents can only be passed by clients implementing TransientSubmitter")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to submit UpdateDiagnosis: %v", err)
+	}
+
+	return nil
+}
+
+// XpnManifest mirrors the chaincode XpnManifest.
+type XpnManifest struct {
+	ID            string           `json:"ID"`
+	Directory     string           `json:"Directory"`
+	Hash          string           `json:"Hash"`
+	HashAlgorithm string           `json:"HashAlgorithm"`
+	Entries       []manifest.Entry `json:"Entries"`
+	Size          int64            `json:"Size"`
+	Version       int              `json:"Version"`
+	PreviousID    string           `json:"PreviousID,omitempty"`
+	Creator       string           `json:"Creator"`
+	MSP           string           `json:"MSP"`
+	Timestamp     string           `json:"Timestamp"`
+	Erased        bool             `json:"Erased,omitempty"`
+}
+
+// CreateXpnManifest submits a CreateXpnManifest transaction anchoring the manifest of directory.
+func CreateXpnManifest(c Client, directory string, entries []manifest.Entry) (*XpnManifest, error) {
+	if entries == nil {
+		entries = []manifest.Entry{}
+	}
+	entriesJSON, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.SubmitTransaction("CreateXpnManifest", directory, string(entriesJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit CreateXpnManifest: %v", err)
+	}
+
+	var xpnmanifest XpnManifest
+	if err := unmarshalResult(result, &xpnmanifest); err != nil {
+		return nil, err
+	}
+
+	return &xpnmanifest, nil
+}
+
+// ReadXpnManifest returns the manifest with given id.
+func ReadXpnManifest(c Client, id string) (*XpnManifest, error) {
+	result, err := c.EvaluateTransaction("ReadXpnManifest", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate ReadXpnManifest: %v", err)
+	}
+
+	var xpnmanifest XpnManifest
+	if err := unmarshalResult(result, &xpnmanifest); err != nil {
+		return nil, err
+	}
+
+	return &xpnmanifest, nil
+}
+
+// GetLatestXpnManifest returns the last manifest of directory, or nil when none was anchored.
+func GetLatestXpnManifest(c Client, directory string) (*XpnManifest, error) {
+	result, err := c.EvaluateTransaction("GetLatestXpnManifest", directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate GetLatestXpnManifest: %v", err)
+	}
+
+	var xpnmanifest *XpnManifest
+	if err := unmarshalResult(result, &xpnmanifest); err != nil {
+		return nil, err
+	}
+
+	return xpnmanifest, nil
+}
+
+// GetXpnManifestHistory returns the manifests of directory, oldest first.
+func GetXpnManifestHistory(c Client, directory string) ([]*XpnManifest, error) {
+	result, err := c.EvaluateTransaction("GetXpnManifestHistory", directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate GetXpnManifestHistory: %v", err)
+	}
+
+	var xpnmanifests []*XpnManifest
+	if err := unmarshalResult(result, &xpnmanifests); err != nil {
+		return nil, err
+	}
+
+	return xpnmanifests, nil
+}
+
+// Patient mirrors the chaincode Patient. The identifiable fields are empty unless the caller is a
+// member of the patient details collection.
+type Patient struct {
+	ID          string  `json:"ID"`
+	FirstName   string  `json:"FirstName,omitempty"`
+	MiddleName  string  `json:"MiddleName,omitempty"`
+	LastName    string  `json:"LastName,omitempty"`
+	BirthDate   string  `json:"BirthDate,omitempty"`
+	BirthPlace  string  `json:"BirthPlace,omitempty"`
+	Weight      float64 `json:"Weight"`
+	Height      float64 `json:"Height"`
+	DetailsHash string  `json:"DetailsHash"`
+	Shredded    bool    `json:"Shredded,omitempty"`
+}
+
+// Contract mirrors the chaincode Contract.
+type Contract struct {
+	ID                        string  `json:"ID"`
+	Patient                   string  `json:"Patient"`
+	MinOxygenSaturation       float64 `json:"MinOxygenSaturation"`
+	MaxOxygenSaturation       float64 `json:"MaxOxygenSaturation"`
+	MinPulseRate              float64 `json:"MinPulseRate"`
+	MaxPulseRate              float64 `json:"MaxPulseRate"`
+	MinTemperature            float64 `json:"MinTemperature"`
+	MaxTemperature            float64 `json:"MaxTemperature"`
+	MinBloodPressureSystolic  float64 `json:"MinBloodPressureSystolic"`
+	MaxBloodPressureSystolic  float64 `json:"MaxBloodPressureSystolic"`
+	MinBloodPressureDiastolic float64 `json:"MinBloodPressureDiastolic"`
+	MaxBloodPressureDiastolic float64 `json:"MaxBloodPressureDiastolic"`
+	Erased                    bool    `json:"Erased,omitempty"`
+}
+
+// Diagnosis mirrors the chaincode Diagnosis.
+type Diagnosis struct {
+	ID                        string `json:"ID"`
+	Patient                   string `json:"Patient"`
+	OxygenSaturationDiagnosis string `json:"OxygenSaturationDiagnosis"`
+	PulseRateDiagnosis        string `json:"PulseRateDiagnosis"`
+	TemperatureDiagnosis      string `json:"TemperatureDiagnosis"`
+	BloodPressureDiagnosis    string `json:"BloodPressureDiagnosis"`
+	Erased                    bool   `json:"Erased,omitempty"`
+}
+
+// AuditedReadPatient submits an AuditedReadPatient transaction for the patient with given id and
+// returns the id of the access record. ReadPatient only returns the identifiable fields to callers
+// with a recent access record.
+func AuditedReadPatient(c Client, id string, purpose string) (string, error) {
+	result, err := c.SubmitTransaction("AuditedReadPatient", id, purpose)
+	if err != nil {
+		return "", fmt.Errorf("failed to submit AuditedReadPatient: %v", err)
+	}
+
+	return string(result), nil
+}
+
+// ReadPatient returns the patient with given id.
+func ReadPatient(c Client, id string) (*Patient, error) {
+	result, err := c.EvaluateTransaction("ReadPatient", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate ReadPatient: %v", err)
+	}
+
+	var patient Patient
+	if err := unmarshalResult(result, &patient); err != nil {
+		return nil, err
+	}
+
+	return &patient, nil
+}
+
+// PatientInput is the transient payload of CreatePatientTransient and UpdatePatientTransient.
+type PatientInput struct {
+	ID         string `json:"id"`
+	FirstName  string `json:"firstName"`
+	MiddleName string `json:"middleName"`
+	LastName   string `json:"lastName"`
+	BirthDate  string `json:"birthDate"`
+	BirthPlace string `json:"birthPlace"`
+	Weight     string `json:"weight"`
+	Height     string `json:"height"`
+}
+
+// CreatePatient submits a CreatePatientTransient transaction, so that the identifiable fields are not
+// part of the proposal. A new random salt for the details hash is passed along.
+func CreatePatient(c TransientSubmitter, input PatientInput) error {
+	return submitPatient(c, "CreatePatientTransient", input)
+}
+
+// UpdatePatient submits an UpdatePatientTransient transaction with a new random salt.
+func UpdatePatient(c TransientSubmitter, input PatientInput) error {
+	return submitPatient(c, "UpdatePatientTransient", input)
+}
+
+func submitPatient(c TransientSubmitter, name string, input PatientInput) error {
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	_, err = c.SubmitTransient(name, map[string][]byte{"patient": inputJSON, "salt": salt})
+	if err != nil {
+		return fmt.Errorf("failed to submit %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// ReadContract returns the contract with given id, "C" followed by the patient pseudonym.
+func ReadContract(c Client, id string) (*Contract, error) {
+	result, err := c.EvaluateTransaction("ReadContract", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate ReadContract: %v", err)
+	}
+
+	var contract Contract
+	if err := unmarshalResult(result, &contract); err != nil {
+		return nil, err
+	}
+
+	return &contract, nil
+}
+
+// ReadDiagnosis returns the diagnosis with given id, "D" followed by the patient pseudonym.
+func ReadDiagnosis(c Client, id string) (*Diagnosis, error) {
+	result, err := c.EvaluateTransaction("ReadDiagnosis", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate ReadDiagnosis: %v", err)
+	}
+
+	var diagnosis Diagnosis
+	if err := unmarshalResult(result, &diagnosis); err != nil {
+		return nil, err
+	}
+
+	return &diagnosis, nil
+}
@@ -0,0 +1,108 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// PeerCLI is a Client that runs the peer binary, configured through the CORE_PEER_*
+// environment variables the same way as the deploy scripts.
+type PeerCLI struct {
+	// Binary is the peer executable, "peer" when empty.
+	Binary    string
+	Channel   string
+	Chaincode string
+	// InvokeFlags are passed to "peer chaincode invoke" only, e.g. the orderer address,
+	// its TLS CA file and the --peerAddresses/--tlsRootCertFiles of the endorsing peers.
+	InvokeFlags []string
+}
+
+// invokePayload matches the payload printed by "peer chaincode invoke".
+var invokePayload = regexp.MustCompile(`payload:("(?:[^"\\]|\\.)*")`)
+
+// SubmitTransaction invokes the chaincode and waits for the transaction to be committed.
+func (p *PeerCLI) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return p.submit(name, args)
+}
+
+func (p *PeerCLI) submit(name string, args []string, extraFlags ...string) ([]byte, error) {
+	cmdArgs := append([]string{"chaincode", "invoke", "-C", p.Channel, "-n", p.Chaincode, "--waitForEvent"}, p.InvokeFlags...)
+	cmdArgs = append(cmdArgs, extraFlags...)
+	cmdArgs = append(cmdArgs, "-c", chaincodeInput(name, args))
+
+	// the invoke result is printed on stderr
+	_, stderr, err := p.run(cmdArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	match := invokePayload.FindSubmatch(stderr)
+	if match == nil {
+		return nil, nil
+	}
+	payload, err := strconv.Unquote(string(match[1]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode invoke payload: %v", err)
+	}
+
+	return []byte(payload), nil
+}
+
+// SubmitTransient implements TransientSubmitter.
+func (p *PeerCLI) SubmitTransient(name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	// encoding/json encodes []byte values as base64, as expected by --transient
+	transientJSON, err := json.Marshal(transient)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.submit(name, args, "--transient", string(transientJSON))
+}
+
+// EvaluateTransaction queries the chaincode on the peer set in the environment.
+func (p *PeerCLI) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	stdout, _, err := p.run([]string{"chaincode", "query", "-C", p.Channel, "-n", p.Chaincode, "-c", chaincodeInput(name, args)})
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSpace(stdout), nil
+}
+
+func (p *PeerCLI) run(args []string) ([]byte, []byte, error) {
+	binary := p.Binary
+	if binary == "" {
+		binary = "peer"
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, nil, fmt.Errorf("%s %s: %v: %s", binary, args[1], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// chaincodeInput builds the -c argument of the peer CLI.
+func chaincodeInput(name string, args []string) string {
+	if args == nil {
+		args = []string{}
+	}
+	input, _ := json.Marshal(struct {
+		Function string   `json:"function"`
+		Args     []string `json:"Args"`
+	}{name, args})
+
+	return string(input)
+}
@@ -0,0 +1,186 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package receipt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// xpnTransactionKeyPrefix starts the composite keys of the chaincode XpnTransaction records.
+const xpnTransactionKeyPrefix = "\x00XpnTransaction\x00"
+
+// transaction is an endorser transaction parsed from a block.
+type transaction struct {
+	index         int
+	channelHeader *common.ChannelHeader
+	envelope      *common.Envelope
+	creator       []byte
+	// proposalResponsePayload is the payload signed by every endorser.
+	proposalResponsePayload []byte
+	endorsements            []*peer.Endorsement
+	// writes are the keys written by the transaction in the chaincode namespace.
+	writes []*kvrwset.KVWrite
+}
+
+// headerHash returns the hash of a block header, the PreviousHash of the next block.
+func headerHash(header *common.BlockHeader) []byte {
+	sum := sha256.Sum256(headerBytes(header))
+	return sum[:]
+}
+
+// headerBytes returns the ASN.1 encoding of a block header hashed and signed by Fabric.
+func headerBytes(header *common.BlockHeader) []byte {
+	encoded, _ := asn1.Marshal(struct {
+		Number       *big.Int
+		PreviousHash []byte
+		DataHash     []byte
+	}{new(big.Int).SetUint64(header.Number), header.PreviousHash, header.DataHash})
+
+	return encoded
+}
+
+// dataHash returns the hash of the block data stored in the block header.
+func dataHash(data *common.BlockData) []byte {
+	sum := sha256.Sum256(bytes.Join(data.Data, nil))
+	return sum[:]
+}
+
+// findTransaction returns the transaction with given id from block, reading the writes of namespace.
+func findTransaction(block *common.Block, txID string, namespace string) (*transaction, error) {
+	if block.Header == nil || block.Data == nil {
+		return nil, fmt.Errorf("block has no header or data")
+	}
+
+	for i, data := range block.Data.Data {
+		envelope := &common.Envelope{}
+		if err := proto.Unmarshal(data, envelope); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal envelope %d: %v", i, err)
+		}
+		payload := &common.Payload{}
+		if err := proto.Unmarshal(envelope.Payload, payload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal payload %d: %v", i, err)
+		}
+		if payload.Header == nil {
+			continue
+		}
+		channelHeader := &common.ChannelHeader{}
+		if err := proto.Unmarshal(payload.Header.ChannelHeader, channelHeader); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal channel header %d: %v", i, err)
+		}
+		if channelHeader.TxId != txID {
+			continue
+		}
+		if channelHeader.Type != int32(common.HeaderType_ENDORSER_TRANSACTION) {
+			return nil, fmt.Errorf("transaction %s is not an endorser transaction", txID)
+		}
+
+		signatureHeader := &common.SignatureHeader{}
+		if err := proto.Unmarshal(payload.Header.SignatureHeader, signatureHeader); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal signature header: %v", err)
+		}
+
+		tx := &transaction{index: i, channelHeader: channelHeader, envelope: envelope, creator: signatureHeader.Creator}
+		if err := tx.readAction(payload.Data, namespace); err != nil {
+			return nil, err
+		}
+
+		return tx, nil
+	}
+
+	return nil, fmt.Errorf("transaction %s is not in block %d", txID, block.Header.Number)
+}
+
+// readAction reads the endorsements and the writes of the single action of an endorser transaction.
+func (tx *transaction) readAction(data []byte, namespace string) error {
+	peerTx := &peer.Transaction{}
+	if err := proto.Unmarshal(data, peerTx); err != nil {
+		return fmt.Errorf("failed to unmarshal transaction: %v", err)
+	}
+	if len(peerTx.Actions) != 1 {
+		return fmt.Errorf("transaction has %d actions, expected 1", len(peerTx.Actions))
+	}
+
+	actionPayload := &peer.ChaincodeActionPayload{}
+	if err := proto.Unmarshal(peerTx.Actions[0].Payload, actionPayload); err != nil {
+		return fmt.Errorf("failed to unmarshal chaincode action payload: %v", err)
+	}
+	if actionPayload.Action == nil {
+		return fmt.Errorf("transaction has no endorsed action")
+	}
+	tx.proposalResponsePayload = actionPayload.Action.ProposalResponsePayload
+	tx.endorsements = actionPayload.Action.Endorsements
+
+	responsePayload := &peer.ProposalResponsePayload{}
+	if err := proto.Unmarshal(tx.proposalResponsePayload, responsePayload); err != nil {
+		return fmt.Errorf("failed to unmarshal proposal response payload: %v", err)
+	}
+	action := &peer.ChaincodeAction{}
+	if err := proto.Unmarshal(responsePayload.Extension, action); err != nil {
+		return fmt.Errorf("failed to unmarshal chaincode action: %v", err)
+	}
+	results := &rwset.TxReadWriteSet{}
+	if err := proto.Unmarshal(action.Results, results); err != nil {
+		return fmt.Errorf("failed to unmarshal read-write set: %v", err)
+	}
+
+	for _, nsRwset := range results.NsRwset {
+		if nsRwset.Namespace != namespace {
+			continue
+		}
+		kvRwset := &kvrwset.KVRWSet{}
+		if err := proto.Unmarshal(nsRwset.Rwset, kvRwset); err != nil {
+			return fmt.Errorf("failed to unmarshal read-write set of %s: %v", namespace, err)
+		}
+		tx.writes = append(tx.writes, kvRwset.Writes...)
+	}
+
+	return nil
+}
+
+// anchorWrite returns the XpnTransaction record written by the transaction. id selects the record
+// when the transaction writes several, it may be empty otherwise.
+func (tx *transaction) anchorWrite(id string) (*kvrwset.KVWrite, error) {
+	var found []*kvrwset.KVWrite
+	for _, write := range tx.writes {
+		if write.IsDelete || !strings.HasPrefix(write.Key, xpnTransactionKeyPrefix) {
+			continue
+		}
+		if id == "" || write.Key == xpnTransactionKeyPrefix+id+"\x00" {
+			found = append(found, write)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("transaction %s does not write an XpnTransaction", tx.channelHeader.TxId)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("transaction %s writes %d XpnTransactions, select one by id", tx.channelHeader.TxId, len(found))
+	}
+}
+
+// valid reports whether the transaction at index is marked valid in the block metadata. The
+// transactions filter is not covered by the block hash or the orderer signatures, so the result is
+// only as trustworthy as the source of the block.
+func valid(block *common.Block, index int) bool {
+	if block.Metadata == nil || len(block.Metadata.Metadata) <= int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		return false
+	}
+	filter := block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+
+	return index < len(filter) && filter[index] == byte(peer.TxValidationCode_VALID)
+}
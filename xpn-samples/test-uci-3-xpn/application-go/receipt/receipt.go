@@ -0,0 +1,159 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package receipt exports self-contained proofs that a file was anchored with a hash at a point in
+// time, and verifies them offline against the certificates of the organization CAs.
+//
+// A Bundle holds the block with the anchoring transaction and the headers of the following blocks up
+// to a checkpoint block. The verifier checks the block data hash, the validation flag, the creator and
+// endorsement signatures of the transaction, the anchor record in its write set, the header hash
+// chain and, when the orderer CA is given, the orderer signatures of the checkpoint block.
+package receipt
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"google.golang.org/protobuf/proto"
+)
+
+// Bundle is a portable anchoring receipt.
+type Bundle struct {
+	Channel     string `json:"channel"`
+	Chaincode   string `json:"chaincode"`
+	TxID        string `json:"txId"`
+	BlockNumber uint64 `json:"blockNumber"`
+	// Timestamp is the time the client created the transaction, RFC3339.
+	Timestamp string                 `json:"timestamp"`
+	Anchor    *ledger.XpnTransaction `json:"anchor"`
+	// Creator and Endorsements describe the identities that signed the transaction. They are
+	// informational, the verifier reads them from Block.
+	Creator      Identity   `json:"creator"`
+	Endorsements []Identity `json:"endorsements"`
+	// Block is the marshaled common.Block holding the transaction.
+	Block []byte `json:"block"`
+	// Headers are the headers of the blocks after Block up to the checkpoint, the last one.
+	Headers []Header `json:"headers"`
+	// CheckpointSignatures is the marshaled SIGNATURES metadata of the checkpoint block.
+	CheckpointSignatures []byte `json:"checkpointSignatures,omitempty"`
+}
+
+// Header is a block header. Hashes are hex encoded.
+type Header struct {
+	Number       uint64 `json:"number"`
+	PreviousHash string `json:"previousHash"`
+	DataHash     string `json:"dataHash"`
+}
+
+// Identity is a signing identity of a transaction or block.
+type Identity struct {
+	MSP         string `json:"msp"`
+	Subject     string `json:"subject,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// Export builds the receipt of the transaction with given id, which wrote an anchor of chaincode.
+// anchorID selects the anchor when the transaction wrote several, such as a batch. The headers chain
+// the block to the checkpoint block, the last block of the channel when checkpoint is zero.
+func Export(blocks ledger.BlockSource, channel string, chaincode string, txID string, anchorID string, checkpoint uint64) (*Bundle, error) {
+	blockBytes, err := blocks.BlockByTxID(txID)
+	if err != nil {
+		return nil, err
+	}
+	block := &common.Block{}
+	if err := proto.Unmarshal(blockBytes, block); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal block: %v", err)
+	}
+
+	tx, err := findTransaction(block, txID, chaincode)
+	if err != nil {
+		return nil, err
+	}
+	if !valid(block, tx.index) {
+		return nil, fmt.Errorf("transaction %s is not valid", txID)
+	}
+	write, err := tx.anchorWrite(anchorID)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{
+		Channel:     channel,
+		Chaincode:   chaincode,
+		TxID:        txID,
+		BlockNumber: block.Header.Number,
+		Timestamp:   tx.channelHeader.Timestamp.AsTime().UTC().Format(time.RFC3339Nano),
+		Block:       blockBytes,
+	}
+	if err := json.Unmarshal(write.Value, &bundle.Anchor); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal anchor: %v", err)
+	}
+	bundle.Creator, _ = identity(tx.creator)
+	for _, endorsement := range tx.endorsements {
+		endorser, _ := identity(endorsement.Endorser)
+		bundle.Endorsements = append(bundle.Endorsements, endorser)
+	}
+
+	if checkpoint == 0 {
+		height, err := blocks.Height()
+		if err != nil {
+			return nil, err
+		}
+		checkpoint = height - 1
+	}
+	if checkpoint < block.Header.Number {
+		return nil, fmt.Errorf("checkpoint %d is before block %d", checkpoint, block.Header.Number)
+	}
+
+	last := block
+	for number := block.Header.Number + 1; number <= checkpoint; number++ {
+		nextBytes, err := blocks.BlockByNumber(number)
+		if err != nil {
+			return nil, err
+		}
+		next := &common.Block{}
+		if err := proto.Unmarshal(nextBytes, next); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal block %d: %v", number, err)
+		}
+		bundle.Headers = append(bundle.Headers, Header{
+			Number:       next.Header.Number,
+			PreviousHash: hex.EncodeToString(next.Header.PreviousHash),
+			DataHash:     hex.EncodeToString(next.Header.DataHash),
+		})
+		last = next
+	}
+	if last.Metadata != nil && len(last.Metadata.Metadata) > int(common.BlockMetadataIndex_SIGNATURES) {
+		bundle.CheckpointSignatures = last.Metadata.Metadata[common.BlockMetadataIndex_SIGNATURES]
+	}
+
+	return bundle, nil
+}
+
+// identity decodes a serialized MSP identity and its certificate.
+func identity(serialized []byte) (Identity, *x509.Certificate) {
+	id := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(serialized, id); err != nil {
+		return Identity{}, nil
+	}
+
+	result := Identity{MSP: id.Mspid, Certificate: string(id.IdBytes)}
+	block, _ := pem.Decode(id.IdBytes)
+	if block == nil {
+		return result, nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return result, nil
+	}
+	result.Subject = cert.Subject.String()
+
+	return result, cert
+}
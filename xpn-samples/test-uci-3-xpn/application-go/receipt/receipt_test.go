@@ -0,0 +1,339 @@
+package receipt_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/receipt"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// txTime is the time the anchoring transaction is created, within the validity of the certificates.
+var txTime = time.Now().UTC().Truncate(time.Second)
+
+type signer struct {
+	key        *ecdsa.PrivateKey
+	serialized []byte
+}
+
+func (s *signer) sign(t *testing.T, message []byte) []byte {
+	digest := sha256.Sum256(message)
+	signature, err := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+	require.NoError(t, err)
+	return signature
+}
+
+type ca struct {
+	msp  string
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newCA(t *testing.T, mspID string) *ca {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca." + mspID},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &ca{msp: mspID, key: key, cert: cert}
+}
+
+func (c *ca) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+func (c *ca) issue(t *testing.T, name string) *signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.cert, &key.PublicKey, c.key)
+	require.NoError(t, err)
+	serialized, err := proto.Marshal(&msp.SerializedIdentity{Mspid: c.msp, IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})})
+	require.NoError(t, err)
+	return &signer{key: key, serialized: serialized}
+}
+
+func marshal(t *testing.T, m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return b
+}
+
+func headerHash(header *common.BlockHeader) []byte {
+	encoded, _ := asn1.Marshal(struct {
+		Number       *big.Int
+		PreviousHash []byte
+		DataHash     []byte
+	}{new(big.Int).SetUint64(header.Number), header.PreviousHash, header.DataHash})
+	sum := sha256.Sum256(encoded)
+	return sum[:]
+}
+
+func newBlock(number uint64, previous *common.Block, data [][]byte) *common.Block {
+	sum := sha256.Sum256(bytes.Join(data, nil))
+	block := &common.Block{
+		Header:   &common.BlockHeader{Number: number, DataHash: sum[:]},
+		Data:     &common.BlockData{Data: data},
+		Metadata: &common.BlockMetadata{Metadata: [][]byte{{}, {}, make([]byte, len(data))}},
+	}
+	if previous != nil {
+		block.Header.PreviousHash = headerHash(previous.Header)
+	}
+	return block
+}
+
+// anchorTransaction returns an envelope writing anchor, created by client and endorsed by endorsers.
+func anchorTransaction(t *testing.T, txID string, anchor *ledger.XpnTransaction, client *signer, endorsers ...*signer) []byte {
+	anchorJSON, err := json.Marshal(anchor)
+	require.NoError(t, err)
+	results := marshal(t, &rwset.TxReadWriteSet{
+		DataModel: rwset.TxReadWriteSet_KV,
+		NsRwset: []*rwset.NsReadWriteSet{{
+			Namespace: "basic",
+			Rwset: marshal(t, &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{
+				{Key: "\x00XpnTransaction\x00" + anchor.ID + "\x00", Value: anchorJSON},
+				{Key: "\x00XpnTransactionPath\x00" + anchor.Path + "\x00" + anchor.ID + "\x00", Value: []byte{0}},
+			}}),
+		}},
+	})
+	prp := marshal(t, &peer.ProposalResponsePayload{
+		ProposalHash: []byte("proposal"),
+		Extension:    marshal(t, &peer.ChaincodeAction{Results: results}),
+	})
+	action := &peer.ChaincodeEndorsedAction{ProposalResponsePayload: prp}
+	for _, endorser := range endorsers {
+		action.Endorsements = append(action.Endorsements, &peer.Endorsement{
+			Endorser:  endorser.serialized,
+			Signature: endorser.sign(t, append(append([]byte{}, prp...), endorser.serialized...)),
+		})
+	}
+
+	payload := marshal(t, &common.Payload{
+		Header: &common.Header{
+			ChannelHeader: marshal(t, &common.ChannelHeader{
+				Type:      int32(common.HeaderType_ENDORSER_TRANSACTION),
+				ChannelId: "mychannel",
+				TxId:      txID,
+				Timestamp: timestamppb.New(txTime),
+			}),
+			SignatureHeader: marshal(t, &common.SignatureHeader{Creator: client.serialized, Nonce: []byte("nonce")}),
+		},
+		Data: marshal(t, &peer.Transaction{Actions: []*peer.TransactionAction{{
+			Payload: marshal(t, &peer.ChaincodeActionPayload{Action: action}),
+		}}}),
+	})
+
+	return marshal(t, &common.Envelope{Payload: payload, Signature: client.sign(t, payload)})
+}
+
+// signBlock adds the signature of orderer to the SIGNATURES metadata of block.
+func signBlock(t *testing.T, block *common.Block, orderer *signer) {
+	value := []byte("orderer block metadata")
+	signatureHeader := marshal(t, &common.SignatureHeader{Creator: orderer.serialized, Nonce: []byte("nonce")})
+	encoded, _ := asn1.Marshal(struct {
+		Number       *big.Int
+		PreviousHash []byte
+		DataHash     []byte
+	}{new(big.Int).SetUint64(block.Header.Number), block.Header.PreviousHash, block.Header.DataHash})
+	block.Metadata.Metadata[common.BlockMetadataIndex_SIGNATURES] = marshal(t, &common.Metadata{
+		Value: value,
+		Signatures: []*common.MetadataSignature{{
+			SignatureHeader: signatureHeader,
+			Signature:       orderer.sign(t, bytes.Join([][]byte{value, signatureHeader, encoded}, nil)),
+		}},
+	})
+}
+
+type fakeBlocks struct {
+	blocks []*common.Block
+	txs    map[string]uint64
+}
+
+func (f *fakeBlocks) BlockByTxID(txID string) ([]byte, error) {
+	return proto.Marshal(f.blocks[f.txs[txID]])
+}
+
+func (f *fakeBlocks) BlockByNumber(number uint64) ([]byte, error) {
+	return proto.Marshal(f.blocks[number])
+}
+
+func (f *fakeBlocks) Height() (uint64, error) {
+	return uint64(len(f.blocks)), nil
+}
+
+type fixture struct {
+	blocks                *fakeBlocks
+	org1, org2, ordererCA *ca
+	anchor                *ledger.XpnTransaction
+}
+
+func newFixture(t *testing.T) *fixture {
+	f := &fixture{org1: newCA(t, "Org1MSP"), org2: newCA(t, "Org2MSP"), ordererCA: newCA(t, "OrdererMSP")}
+	f.anchor = &ledger.XpnTransaction{ID: "tx1", Hash: "1220" + "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		Path: "/tmp/expand/xpn/1/patient.txt", Patient: "1", DocumentType: "patient", Size: 42, HashAlgorithm: "sha2-256",
+		Partition: "xpn", Timestamp: "2024-05-01T10:00:00.000000000Z", Version: 1, CreatorMSP: "Org1MSP"}
+
+	genesis := newBlock(0, nil, [][]byte{[]byte("config")})
+	tx := anchorTransaction(t, "tx1", f.anchor, f.org1.issue(t, "user1"), f.org1.issue(t, "peer0.org1"), f.org2.issue(t, "peer0.org2"))
+	other := marshal(t, &common.Envelope{Payload: marshal(t, &common.Payload{Header: &common.Header{
+		ChannelHeader: marshal(t, &common.ChannelHeader{Type: int32(common.HeaderType_ENDORSER_TRANSACTION), ChannelId: "mychannel", TxId: "tx0"}),
+	}})})
+	block1 := newBlock(1, genesis, [][]byte{other, tx})
+	block1.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = []byte{byte(peer.TxValidationCode_MVCC_READ_CONFLICT), byte(peer.TxValidationCode_VALID)}
+	block2 := newBlock(2, block1, [][]byte{other})
+	block3 := newBlock(3, block2, [][]byte{other})
+	signBlock(t, block3, f.ordererCA.issue(t, "orderer"))
+
+	f.blocks = &fakeBlocks{blocks: []*common.Block{genesis, block1, block2, block3}, txs: map[string]uint64{"tx1": 1}}
+	return f
+}
+
+func (f *fixture) roots() map[string]*x509.CertPool {
+	return map[string]*x509.CertPool{"Org1MSP": f.org1.pool(), "Org2MSP": f.org2.pool(), "OrdererMSP": f.ordererCA.pool()}
+}
+
+func TestExportVerify(t *testing.T) {
+	f := newFixture(t)
+
+	bundle, err := receipt.Export(f.blocks, "mychannel", "basic", "tx1", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), bundle.BlockNumber)
+	require.Equal(t, f.anchor, bundle.Anchor)
+	require.Len(t, bundle.Headers, 2)
+	require.Equal(t, "Org1MSP", bundle.Creator.MSP)
+	require.Len(t, bundle.Endorsements, 2)
+
+	// the bundle survives a JSON round trip
+	bundleJSON, err := json.Marshal(bundle)
+	require.NoError(t, err)
+	var decoded receipt.Bundle
+	require.NoError(t, json.Unmarshal(bundleJSON, &decoded))
+
+	result, err := receipt.Verify(&decoded, f.roots(), "", false)
+	require.NoError(t, err)
+	require.Equal(t, f.anchor, result.Anchor)
+	require.Equal(t, txTime.Format(time.RFC3339Nano), result.Timestamp)
+	require.Equal(t, "CN=user1", result.Creator.Subject)
+	require.Len(t, result.Endorsers, 2)
+	require.Equal(t, uint64(3), result.Checkpoint)
+	require.Len(t, result.Orderers, 1)
+
+	_, err = receipt.Verify(&decoded, f.roots(), result.CheckpointHash, true)
+	require.NoError(t, err)
+}
+
+func TestVerifyStrict(t *testing.T) {
+	f := newFixture(t)
+	bundle, err := receipt.Export(f.blocks, "mychannel", "basic", "tx1", "", 3)
+	require.NoError(t, err)
+	result, err := receipt.Verify(bundle, f.roots(), "", false)
+	require.NoError(t, err)
+
+	_, err = receipt.Verify(bundle, f.roots(), "", true)
+	require.ErrorContains(t, err, "checkpoint hash is required")
+
+	// the orderer MSP has no CA certificate
+	roots := map[string]*x509.CertPool{"Org1MSP": f.org1.pool(), "Org2MSP": f.org2.pool()}
+	result, err = receipt.Verify(bundle, roots, result.CheckpointHash, false)
+	require.NoError(t, err)
+	require.Empty(t, result.Orderers)
+	_, err = receipt.Verify(bundle, roots, result.CheckpointHash, true)
+	require.ErrorContains(t, err, "no CA certificate for MSP OrdererMSP")
+
+	bundle.CheckpointSignatures = nil
+	_, err = receipt.Verify(bundle, f.roots(), result.CheckpointHash, false)
+	require.NoError(t, err)
+	_, err = receipt.Verify(bundle, f.roots(), result.CheckpointHash, true)
+	require.ErrorContains(t, err, "no orderer signature of checkpoint block 3")
+}
+
+func TestVerifyTampered(t *testing.T) {
+	f := newFixture(t)
+	export := func() *receipt.Bundle {
+		bundle, err := receipt.Export(f.blocks, "mychannel", "basic", "tx1", "", 3)
+		require.NoError(t, err)
+		return bundle
+	}
+
+	bundle := export()
+	bundle.Anchor.Hash = "1220" + "0000000000000000000000000000000000000000000000000000000000000000"
+	_, err := receipt.Verify(bundle, f.roots(), "", false)
+	require.ErrorContains(t, err, "differs")
+
+	bundle = export()
+	bundle.Headers[0].DataHash = "00"
+	_, err = receipt.Verify(bundle, f.roots(), "", false)
+	require.ErrorContains(t, err, "does not chain")
+
+	bundle = export()
+	_, err = receipt.Verify(bundle, f.roots(), "00", false)
+	require.ErrorContains(t, err, "checkpoint")
+
+	bundle = export()
+	_, err = receipt.Verify(bundle, map[string]*x509.CertPool{"Org1MSP": f.org1.pool()}, "", false)
+	require.ErrorContains(t, err, "no CA certificate for MSP Org2MSP")
+
+	bundle = export()
+	_, err = receipt.Verify(bundle, map[string]*x509.CertPool{"Org1MSP": f.org2.pool(), "Org2MSP": f.org2.pool()}, "", false)
+	require.ErrorContains(t, err, "not issued")
+
+	// the transactions filter is not authenticated: a receipt exported before the filter changed still
+	// verifies, strictly, against the checkpoint hash of the chain
+	bundle = export()
+	result, err := receipt.Verify(bundle, f.roots(), "", false)
+	require.NoError(t, err)
+	require.False(t, result.ValidityAuthenticated)
+	f.blocks.blocks[1].Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER][1] = byte(peer.TxValidationCode_MVCC_READ_CONFLICT)
+	_, err = receipt.Verify(bundle, f.roots(), result.CheckpointHash, true)
+	require.NoError(t, err)
+
+	// a filter marking the transaction invalid is rejected
+	block := &common.Block{}
+	require.NoError(t, proto.Unmarshal(bundle.Block, block))
+	block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER][1] = byte(peer.TxValidationCode_MVCC_READ_CONFLICT)
+	bundle.Block = marshal(t, block)
+	_, err = receipt.Verify(bundle, f.roots(), result.CheckpointHash, true)
+	require.EqualError(t, err, "transaction tx1 is not valid")
+
+	// a receipt cannot be exported for an invalid transaction
+	f.blocks.blocks[1].Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER][1] = byte(peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE)
+	_, err = receipt.Export(f.blocks, "mychannel", "basic", "tx1", "", 3)
+	require.ErrorContains(t, err, "not valid")
+}
@@ -0,0 +1,225 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package receipt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"google.golang.org/protobuf/proto"
+)
+
+// Result is what a verified Bundle proves: Anchor was written by transaction TxID in block
+// BlockNumber, created at Timestamp by Creator and endorsed by Endorsers, and the block is an
+// ancestor of the checkpoint block with header hash CheckpointHash. That the transaction was valid,
+// and so that the anchor was committed, is not proven, see ValidityAuthenticated.
+type Result struct {
+	TxID           string                 `json:"txId"`
+	BlockNumber    uint64                 `json:"blockNumber"`
+	Timestamp      string                 `json:"timestamp"`
+	Anchor         *ledger.XpnTransaction `json:"anchor"`
+	Creator        Identity               `json:"creator"`
+	Endorsers      []Identity             `json:"endorsers"`
+	Checkpoint     uint64                 `json:"checkpoint"`
+	CheckpointHash string                 `json:"checkpointHash"`
+	// Orderers are the orderers whose signature of the checkpoint block was verified. Unless Verify
+	// is strict, signatures of orderers whose MSP has no CA certificate are not checked.
+	Orderers []Identity `json:"orderers"`
+	// ValidityAuthenticated is always false. The validation code of the transaction is read from the
+	// TRANSACTIONS_FILTER of the block metadata, which each peer writes when it commits the block and
+	// which neither the header hash nor the orderer signatures cover. A bundle whose filter was
+	// changed marks a transaction rejected at commit, e.g. on an MVCC read conflict, as valid.
+	// Confirm the validity with a trusted peer when it matters.
+	ValidityAuthenticated bool `json:"validityAuthenticated"`
+}
+
+// Verify checks a bundle offline. roots holds the CA certificates of each MSP ID. checkpointHash is
+// the hex encoded header hash of the checkpoint block obtained from a trusted source.
+//
+// A strict verification requires checkpointHash and at least one verified orderer signature of the
+// checkpoint block, and fails on an orderer signature whose MSP has no CA certificate in roots.
+// Otherwise an empty checkpointHash is not compared, Result.CheckpointHash and Result.Orderers
+// should then be checked by the caller. In both modes the validity of the transaction is taken from
+// the unauthenticated block metadata, see Result.ValidityAuthenticated.
+func Verify(bundle *Bundle, roots map[string]*x509.CertPool, checkpointHash string, strict bool) (*Result, error) {
+	if strict && checkpointHash == "" {
+		return nil, fmt.Errorf("a trusted checkpoint hash is required")
+	}
+
+	block := &common.Block{}
+	if err := proto.Unmarshal(bundle.Block, block); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal block: %v", err)
+	}
+	if block.Header == nil || block.Data == nil {
+		return nil, fmt.Errorf("block has no header or data")
+	}
+	if block.Header.Number != bundle.BlockNumber {
+		return nil, fmt.Errorf("block number is %d, not %d", block.Header.Number, bundle.BlockNumber)
+	}
+	if !bytes.Equal(dataHash(block.Data), block.Header.DataHash) {
+		return nil, fmt.Errorf("block data does not match the data hash of the block header")
+	}
+
+	tx, err := findTransaction(block, bundle.TxID, bundle.Chaincode)
+	if err != nil {
+		return nil, err
+	}
+	if tx.channelHeader.ChannelId != bundle.Channel {
+		return nil, fmt.Errorf("transaction %s belongs to channel %s, not %s", bundle.TxID, tx.channelHeader.ChannelId, bundle.Channel)
+	}
+	if !valid(block, tx.index) {
+		return nil, fmt.Errorf("transaction %s is not valid", bundle.TxID)
+	}
+	at := tx.channelHeader.Timestamp.AsTime()
+
+	result := &Result{
+		TxID:        bundle.TxID,
+		BlockNumber: block.Header.Number,
+		Timestamp:   at.UTC().Format(time.RFC3339Nano),
+	}
+
+	// the client signed the envelope, the endorsers the proposal response payload
+	result.Creator, err = verifySignature(tx.creator, tx.envelope.Payload, tx.envelope.Signature, roots, at)
+	if err != nil {
+		return nil, fmt.Errorf("creator: %v", err)
+	}
+	if len(tx.endorsements) == 0 {
+		return nil, fmt.Errorf("transaction %s has no endorsements", bundle.TxID)
+	}
+	for i, endorsement := range tx.endorsements {
+		message := append(append([]byte{}, tx.proposalResponsePayload...), endorsement.Endorser...)
+		endorser, err := verifySignature(endorsement.Endorser, message, endorsement.Signature, roots, at)
+		if err != nil {
+			return nil, fmt.Errorf("endorsement %d: %v", i, err)
+		}
+		result.Endorsers = append(result.Endorsers, endorser)
+	}
+
+	if bundle.Anchor == nil {
+		return nil, fmt.Errorf("bundle has no anchor")
+	}
+	write, err := tx.anchorWrite(bundle.Anchor.ID)
+	if err != nil {
+		return nil, err
+	}
+	var anchor ledger.XpnTransaction
+	if err := json.Unmarshal(write.Value, &anchor); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal anchor: %v", err)
+	}
+	if !reflect.DeepEqual(&anchor, bundle.Anchor) {
+		return nil, fmt.Errorf("the anchor of the bundle differs from the anchor written by transaction %s", bundle.TxID)
+	}
+	result.Anchor = &anchor
+
+	// every header holds the hash of the previous one
+	last := block.Header
+	for _, header := range bundle.Headers {
+		if header.Number != last.Number+1 {
+			return nil, fmt.Errorf("header %d does not follow block %d", header.Number, last.Number)
+		}
+		if header.PreviousHash != hex.EncodeToString(headerHash(last)) {
+			return nil, fmt.Errorf("header %d does not chain to block %d", header.Number, last.Number)
+		}
+		next := &common.BlockHeader{Number: header.Number}
+		if next.PreviousHash, err = hex.DecodeString(header.PreviousHash); err != nil {
+			return nil, fmt.Errorf("header %d: invalid previous hash: %v", header.Number, err)
+		}
+		if next.DataHash, err = hex.DecodeString(header.DataHash); err != nil {
+			return nil, fmt.Errorf("header %d: invalid data hash: %v", header.Number, err)
+		}
+		last = next
+	}
+	result.Checkpoint = last.Number
+	result.CheckpointHash = hex.EncodeToString(headerHash(last))
+	if checkpointHash != "" && checkpointHash != result.CheckpointHash {
+		return nil, fmt.Errorf("checkpoint block %d has hash %s, not %s", last.Number, result.CheckpointHash, checkpointHash)
+	}
+
+	if len(bundle.CheckpointSignatures) > 0 {
+		result.Orderers, err = verifyBlockSignatures(bundle.CheckpointSignatures, last, roots, strict)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strict && len(result.Orderers) == 0 {
+		return nil, fmt.Errorf("no orderer signature of checkpoint block %d could be verified", last.Number)
+	}
+
+	return result, nil
+}
+
+// verifyBlockSignatures checks the orderer signatures of a block whose MSP has a CA certificate in
+// roots. When strict, a signature whose MSP has none is an error.
+func verifyBlockSignatures(signatures []byte, header *common.BlockHeader, roots map[string]*x509.CertPool, strict bool) ([]Identity, error) {
+	metadata := &common.Metadata{}
+	if err := proto.Unmarshal(signatures, metadata); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal checkpoint signatures: %v", err)
+	}
+
+	var orderers []Identity
+	for i, signature := range metadata.Signatures {
+		// signatures identified by an IdentifierHeader (BFT orderers) carry no certificate
+		if len(signature.SignatureHeader) == 0 {
+			continue
+		}
+		signatureHeader := &common.SignatureHeader{}
+		if err := proto.Unmarshal(signature.SignatureHeader, signatureHeader); err != nil {
+			return nil, fmt.Errorf("checkpoint signature %d: %v", i, err)
+		}
+		if orderer, _ := identity(signatureHeader.Creator); roots[orderer.MSP] == nil {
+			if strict {
+				return nil, fmt.Errorf("checkpoint signature %d: no CA certificate for MSP %s", i, orderer.MSP)
+			}
+			continue
+		}
+
+		message := bytes.Join([][]byte{metadata.Value, signature.SignatureHeader, headerBytes(header)}, nil)
+		orderer, err := verifySignature(signatureHeader.Creator, message, signature.Signature, roots, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint signature %d: %v", i, err)
+		}
+		orderers = append(orderers, orderer)
+	}
+
+	return orderers, nil
+}
+
+// verifySignature checks that signature is an ECDSA signature of message by the serialized identity,
+// whose certificate must chain to the CA certificates of its MSP at time at, or now when at is zero.
+func verifySignature(serialized []byte, message []byte, signature []byte, roots map[string]*x509.CertPool, at time.Time) (Identity, error) {
+	id, cert := identity(serialized)
+	if cert == nil {
+		return id, fmt.Errorf("identity of %s has no certificate", id.MSP)
+	}
+	pool := roots[id.MSP]
+	if pool == nil {
+		return id, fmt.Errorf("no CA certificate for MSP %s", id.MSP)
+	}
+
+	_, err := cert.Verify(x509.VerifyOptions{Roots: pool, CurrentTime: at, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return id, fmt.Errorf("certificate of %s is not issued by the %s CA: %v", id.Subject, id.MSP, err)
+	}
+
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return id, fmt.Errorf("certificate of %s does not hold an ECDSA public key", id.Subject)
+	}
+	digest := sha256.Sum256(message)
+	if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+		return id, fmt.Errorf("signature of %s does not match", id.Subject)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,411 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package reconcile compares the documents written to XPN by the deploy scripts with the ledger
+// entities they describe, field by field. A file can match its anchored hash and still disagree with
+// the ledger, e.g. a diagnosis.txt still holding "None" after UpdateDiagnosis, or a contract updated
+// on the ledger only; these differences are reported as drift.
+//
+// The documents use camelCase keys and numbers written as strings, and hard-code "id": "1"; the
+// patient is given by "patientId". Every document type has a fixed mapping to the fields of the
+// Patient, Contract, Diagnosis and RTData entities.
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/rtdata"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/watcher"
+)
+
+// Kinds of drift.
+const (
+	// Mismatch is a field whose document and ledger values differ.
+	Mismatch = "mismatch"
+	// MissingInDocument is a mapped field the document does not hold.
+	MissingInDocument = "missing in document"
+	// MissingOnLedger is a document whose entity does not exist on the ledger. Other failures to read
+	// the entity, e.g. an unreachable peer or a denied access, stop the run instead.
+	MissingOnLedger = "missing on ledger"
+	// Unmapped is a document field without a ledger counterpart.
+	Unmapped = "unmapped"
+	// Unreadable is a document that is not valid JSON.
+	Unreadable = "unreadable"
+)
+
+// field maps a document key to the field of the ledger entity.
+type field struct {
+	document string
+	ledger   string
+	number   bool
+}
+
+// mapping is the mapping of a document type to its ledger entity.
+type mapping struct {
+	entity string
+	fields []field
+}
+
+var mappings = map[string]mapping{
+	"patient": {"Patient", []field{
+		{"firstName", "FirstName", false},
+		{"middleName", "MiddleName", false},
+		{"lastName", "LastName", false},
+		{"birthDate", "BirthDate", false},
+		{"birthPlace", "BirthPlace", false},
+		{"weight", "Weight", true},
+		{"height", "Height", true},
+	}},
+	"contract": {"Contract", []field{
+		{"minOxygenSaturation", "MinOxygenSaturation", true},
+		{"maxOxygenSaturation", "MaxOxygenSaturation", true},
+		{"minPulseRate", "MinPulseRate", true},
+		{"maxPulseRate", "MaxPulseRate", true},
+		{"minTemperature", "MinTemperature", true},
+		{"maxTemperature", "MaxTemperature", true},
+		{"minBloodPressureSystolic", "MinBloodPressureSystolic", true},
+		{"maxBloodPressureSystolic", "MaxBloodPressureSystolic", true},
+		{"minBloodPressureDiastolic", "MinBloodPressureDiastolic", true},
+		{"maxBloodPressureDiastolic", "MaxBloodPressureDiastolic", true},
+	}},
+	"diagnosis": {"Diagnosis", []field{
+		{"oxygenSaturationDiagnosis", "OxygenSaturationDiagnosis", false},
+		{"pulseRateDiagnosis", "PulseRateDiagnosis", false},
+		{"temperatureDiagnosis", "TemperatureDiagnosis", false},
+		{"bloodPressureDiagnosis", "BloodPressureDiagnosis", false},
+	}},
+	"vital_signs": {"RTData", []field{
+		{"oxygenSaturation", "OxygenSaturation", true},
+		{"pulseRate", "PulseRate", true},
+		{"temperature", "Temperature", true},
+		{"bloodPressureSystolic", "BloodPressureSystolic", true},
+		{"bloodPressureDiastolic", "BloodPressureDiastolic", true},
+	}},
+}
+
+// identifiable are the patient fields only returned to members of the patient details collection.
+var identifiable = map[string]bool{"FirstName": true, "MiddleName": true, "LastName": true, "BirthDate": true, "BirthPlace": true}
+
+// vitalSignsNumber extracts n from vital_signs_<n>.txt.
+var vitalSignsNumber = regexp.MustCompile(`^vital_signs_([0-9]+)\.txt$`)
+
+// Drift is a difference between a document and the ledger.
+type Drift struct {
+	Path     string `json:"path"`
+	Patient  string `json:"patient"`
+	Entity   string `json:"entity"`
+	EntityID string `json:"entityId,omitempty"`
+	Kind     string `json:"kind"`
+	// Field is the ledger field and DocumentField the document key, both empty for whole documents.
+	Field         string `json:"field,omitempty"`
+	DocumentField string `json:"documentField,omitempty"`
+	DocumentValue string `json:"documentValue,omitempty"`
+	LedgerValue   string `json:"ledgerValue,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Report is the result of a reconciliation.
+type Report struct {
+	Patients  int `json:"patients"`
+	Documents int `json:"documents"`
+	// Fields is the number of fields compared, Withheld the number of identifiable patient fields
+	// that could not be compared because the caller may not read them.
+	Fields   int     `json:"fields"`
+	Withheld int     `json:"withheld"`
+	Drift    []Drift `json:"drift"`
+}
+
+// Reconciler compares the documents in Storage with the entities on Ledger.
+type Reconciler struct {
+	Ledger  ledger.Client
+	Storage storage.Storage
+}
+
+// document is a stored document of a patient.
+type document struct {
+	path         string
+	documentType string
+}
+
+// Run reconciles the documents of the given patients, or of every patient with documents in
+// storage when patients is empty. Only the last vital signs document of a patient is compared, with
+// the current RTData.
+func (r *Reconciler) Run(patients []string) (*Report, error) {
+	documents, err := r.documents()
+	if err != nil {
+		return nil, err
+	}
+	if len(patients) == 0 {
+		for patient := range documents {
+			patients = append(patients, patient)
+		}
+		sort.Strings(patients)
+	}
+
+	report := &Report{}
+	for _, patient := range patients {
+		report.Patients++
+		for _, doc := range documents[patient] {
+			report.Documents++
+			if err := r.reconcile(report, patient, doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// documents returns the documents of every patient found in storage, sorted by path.
+func (r *Reconciler) documents() (map[string][]document, error) {
+	documents := make(map[string][]document)
+	// the highest n of vital_signs_<n>.txt of every patient
+	vitalSigns := make(map[string]int)
+	latest := make(map[string]string)
+
+	err := r.Storage.Walk(func(anchoredPath string) error {
+		patient, documentType, err := watcher.Classify(anchoredPath)
+		if err != nil {
+			return nil
+		}
+		if _, ok := mappings[documentType]; !ok {
+			return nil
+		}
+		if documentType == "vital_signs" {
+			match := vitalSignsNumber.FindStringSubmatch(path.Base(anchoredPath))
+			if match == nil {
+				return nil
+			}
+			n, _ := strconv.Atoi(match[1])
+			if previous, ok := vitalSigns[patient]; ok && previous >= n {
+				return nil
+			}
+			vitalSigns[patient] = n
+			latest[patient] = anchoredPath
+			return nil
+		}
+
+		documents[patient] = append(documents[patient], document{path: anchoredPath, documentType: documentType})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored documents: %v", err)
+	}
+
+	for patient, anchoredPath := range latest {
+		documents[patient] = append(documents[patient], document{path: anchoredPath, documentType: "vital_signs"})
+	}
+	for patient := range documents {
+		sort.Slice(documents[patient], func(i, j int) bool { return documents[patient][i].path < documents[patient][j].path })
+	}
+
+	return documents, nil
+}
+
+// reconcile compares one document with its ledger entity.
+func (r *Reconciler) reconcile(report *Report, patient string, doc document) error {
+	m := mappings[doc.documentType]
+	drift := Drift{Path: doc.path, Patient: patient, Entity: m.entity}
+
+	values, err := r.read(doc.path)
+	if err != nil {
+		drift.Kind = Unreadable
+		drift.Error = err.Error()
+		report.Drift = append(report.Drift, drift)
+		return nil
+	}
+
+	// the patient of the document, which is also the directory name
+	if documentPatient, ok := values["patientId"]; ok && documentPatient != patient {
+		d := drift
+		d.Kind = Mismatch
+		d.Field = "Patient"
+		d.DocumentField = "patientId"
+		d.DocumentValue = documentPatient
+		d.LedgerValue = patient
+		report.Drift = append(report.Drift, d)
+	}
+
+	entity, err := r.entity(patient, m.entity)
+	if err != nil {
+		if !ledger.NotFound(err) {
+			return fmt.Errorf("failed to read %s of patient %s: %v", m.entity, patient, err)
+		}
+		drift.Kind = MissingOnLedger
+		drift.Error = err.Error()
+		report.Drift = append(report.Drift, drift)
+		return nil
+	}
+	if id, ok := entity["ID"].(string); ok {
+		drift.EntityID = id
+	}
+
+	mapped := map[string]bool{"id": true, "patientId": true}
+	for _, f := range m.fields {
+		mapped[f.document] = true
+
+		documentValue, inDocument := values[f.document]
+		ledgerValue := format(entity[f.ledger])
+		if m.entity == "Patient" && identifiable[f.ledger] && ledgerValue == "" {
+			if inDocument {
+				report.Withheld++
+			}
+			continue
+		}
+		if !inDocument {
+			if f.ledger == "MiddleName" && ledgerValue == "" {
+				// the deploy scripts do not write a middle name
+				continue
+			}
+			d := drift
+			d.Kind = MissingInDocument
+			d.Field = f.ledger
+			d.DocumentField = f.document
+			d.LedgerValue = ledgerValue
+			report.Drift = append(report.Drift, d)
+			continue
+		}
+
+		report.Fields++
+		if !equal(documentValue, ledgerValue, f.number) {
+			d := drift
+			d.Kind = Mismatch
+			d.Field = f.ledger
+			d.DocumentField = f.document
+			d.DocumentValue = documentValue
+			d.LedgerValue = ledgerValue
+			report.Drift = append(report.Drift, d)
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if !mapped[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		d := drift
+		d.Kind = Unmapped
+		d.DocumentField = key
+		d.DocumentValue = values[key]
+		report.Drift = append(report.Drift, d)
+	}
+
+	return nil
+}
+
+// read returns the values of the document stored under anchoredPath, formatted as strings.
+func (r *Reconciler) read(anchoredPath string) (map[string]string, error) {
+	file, err := r.Storage.Open(anchoredPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", anchoredPath, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		values[key] = format(value)
+	}
+
+	return values, nil
+}
+
+// entity returns the fields of the ledger entity of a patient.
+func (r *Reconciler) entity(patient string, entity string) (map[string]interface{}, error) {
+	var v interface{}
+	if entity == "Patient" {
+		// the identifiable fields are only returned after the access is recorded
+		_, err := ledger.AuditedReadPatient(r.Ledger, patient, "reconcile")
+		if err != nil {
+			return nil, err
+		}
+		p, err := ledger.ReadPatient(r.Ledger, patient)
+		if err != nil {
+			return nil, err
+		}
+		v = p
+	} else {
+		pseudonym, err := ledger.GetPatientPseudonym(r.Ledger, patient)
+		if err != nil {
+			return nil, err
+		}
+		switch entity {
+		case "Contract":
+			v, err = ledger.ReadContract(r.Ledger, "C"+pseudonym)
+		case "Diagnosis":
+			v, err = ledger.ReadDiagnosis(r.Ledger, "D"+pseudonym)
+		case "RTData":
+			// pointer records are resolved to the measurements of their document
+			resolver := &rtdata.Resolver{Ledger: r.Ledger, Storage: r.Storage}
+			v, err = resolver.Read(patient)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// format returns a JSON value as a string, numbers without a trailing zero fraction.
+func format(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		content, _ := json.Marshal(v)
+		return string(content)
+	}
+}
+
+// equal compares a document value with a ledger value, numerically for number fields.
+func equal(documentValue string, ledgerValue string, number bool) bool {
+	if !number {
+		return documentValue == ledgerValue
+	}
+
+	d, err := strconv.ParseFloat(documentValue, 64)
+	if err != nil {
+		return false
+	}
+	l, err := strconv.ParseFloat(ledgerValue, 64)
+	if err != nil {
+		return false
+	}
+
+	return math.Abs(d-l) <= 1e-9*math.Max(1, math.Abs(l))
+}
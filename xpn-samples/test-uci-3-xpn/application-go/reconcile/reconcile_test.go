@@ -0,0 +1,130 @@
+package reconcile_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/reconcile"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLedger struct {
+	entities map[string]interface{}
+	failures map[string]error
+	audited  []string
+}
+
+func (f *fakeLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	if name == "AuditedReadPatient" {
+		if _, ok := f.entities[args[0]]; !ok {
+			return nil, fmt.Errorf("Cannot read patient. Patient with id %s does not exist", args[0])
+		}
+		f.audited = append(f.audited, args[0])
+		return []byte("tx"), nil
+	}
+	return nil, fmt.Errorf("unexpected %s", name)
+}
+
+func (f *fakeLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	if name == "GetPatientPseudonym" {
+		return []byte("P" + args[0]), nil
+	}
+	if err, ok := f.failures[args[0]]; ok {
+		return nil, err
+	}
+	entity, ok := f.entities[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("Cannot read entity. Entity with id %s does not exist", args[0])
+	}
+	return json.Marshal(entity)
+}
+
+func write(t *testing.T, store storage.Writable, path string, content string) {
+	w, err := store.Create(path)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+func TestRun(t *testing.T) {
+	store := storage.NewMemory()
+	write(t, store, "/tmp/expand/xpn/1/patient.txt", `{"id":"1", "patientId":"1", "firstName":"Ana", "lastName":"Gil", "birthDate":"01-02-1990", "birthPlace":"Madrid", "weight":"70", "height":"1.80"}`)
+	write(t, store, "/tmp/expand/xpn/1/contract.txt", `{"id":"1", "patientId":"1", "minOxygenSaturation":"95", "maxOxygenSaturation":"100", "minPulseRate":"60", "maxPulseRate":"100", "minTemperature":"35.5", "maxTemperature":"38", "minBloodPressureSystolic":"120", "maxBloodPressureSystolic":"180", "minBloodPressureDiastolic":"80", "maxBloodPressureDiastolic":"120"}`)
+	write(t, store, "/tmp/expand/xpn/1/diagnosis.txt", `{"id":"1", "patientId":"1", "oxygenSaturationDiagnosis":"None", "pulseRateDiagnosis":"None", "temperatureDiagnosis":"None", "bloodPressureDiagnosis":"None", "notes":"x"}`)
+	write(t, store, "/tmp/expand/xpn/1/vital_signs_2.txt", `{"id":"1", "patientId":"1", "oxygenSaturation":"90", "pulseRate":"70", "temperature":"36.6", "bloodPressureSystolic":"120", "bloodPressureDiastolic":"80"}`)
+	write(t, store, "/tmp/expand/xpn/1/vital_signs_10.txt", `{"id":"1", "patientId":"1", "oxygenSaturation":"97", "pulseRate":"70", "temperature":"36.6", "bloodPressureSystolic":"120", "bloodPressureDiastolic":"80"}`)
+	write(t, store, "/tmp/expand/xpn/2/patient.txt", `{"id":"1", "patientId":"3", "weight":"70", "height":"1.7"}`)
+	write(t, store, "/tmp/expand/xpn/2/contract.txt", `not json`)
+
+	fake := &fakeLedger{entities: map[string]interface{}{
+		// the identifiable fields are withheld from the caller
+		"1": ledger.Patient{ID: "1", Weight: 72, Height: 1.8},
+		"CP1": ledger.Contract{ID: "CP1", Patient: "P1", MinOxygenSaturation: 95, MaxOxygenSaturation: 100, MinPulseRate: 60, MaxPulseRate: 100,
+			MinTemperature: 35.5, MaxTemperature: 38, MinBloodPressureSystolic: 120, MaxBloodPressureSystolic: 180,
+			MinBloodPressureDiastolic: 80, MaxBloodPressureDiastolic: 120},
+		"DP1":   ledger.Diagnosis{ID: "DP1", Patient: "P1", OxygenSaturationDiagnosis: "Normal", PulseRateDiagnosis: "None", TemperatureDiagnosis: "None", BloodPressureDiagnosis: "None"},
+		"RTDP1": ledger.RTData{ID: "RTDP1", Patient: "P1", OxygenSaturation: 97, PulseRate: 70, Temperature: 36.6, BloodPressureSystolic: 120, BloodPressureDiastolic: 80},
+		"2":     ledger.Patient{ID: "2", Weight: 70, Height: 1.7},
+	}}
+
+	r := &reconcile.Reconciler{Ledger: fake, Storage: store}
+	report, err := r.Run(nil)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, report.Patients)
+	require.Equal(t, 6, report.Documents)
+	require.Equal(t, 4, report.Withheld)
+	require.Equal(t, 2+10+4+5+2, report.Fields)
+
+	var kinds []string
+	for _, drift := range report.Drift {
+		kinds = append(kinds, fmt.Sprintf("%s %s %s %s/%s", drift.Patient, drift.Kind, drift.Entity, drift.Field, drift.DocumentField))
+	}
+	require.Equal(t, []string{
+		"1 mismatch Diagnosis OxygenSaturationDiagnosis/oxygenSaturationDiagnosis",
+		"1 unmapped Diagnosis /notes",
+		"1 mismatch Patient Weight/weight",
+		"2 unreadable Contract /",
+		"2 mismatch Patient Patient/patientId",
+	}, kinds)
+
+	require.Equal(t, []string{"1", "2"}, fake.audited)
+
+	weight := report.Drift[2]
+	require.Equal(t, "70", weight.DocumentValue)
+	require.Equal(t, "72", weight.LedgerValue)
+	require.Equal(t, "1", weight.EntityID)
+
+	report, err = r.Run([]string{"3"})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Patients)
+	require.Empty(t, report.Drift)
+}
+
+func TestRunLedgerErrors(t *testing.T) {
+	store := storage.NewMemory()
+	write(t, store, "/tmp/expand/xpn/1/diagnosis.txt", `{"id":"1", "patientId":"1", "oxygenSaturationDiagnosis":"None"}`)
+	write(t, store, "/tmp/expand/xpn/1/contract.txt", `{"id":"1", "patientId":"1", "minOxygenSaturation":"95"}`)
+
+	// only an entity that does not exist is drift
+	fake := &fakeLedger{entities: map[string]interface{}{}}
+	r := &reconcile.Reconciler{Ledger: fake, Storage: store}
+	report, err := r.Run(nil)
+	require.NoError(t, err)
+	require.Len(t, report.Drift, 2)
+	for _, drift := range report.Drift {
+		require.Equal(t, reconcile.MissingOnLedger, drift.Kind)
+	}
+
+	fake.failures = map[string]error{"DP1": fmt.Errorf("failed to connect to peer0.org1.example.com:7051")}
+	_, err = r.Run(nil)
+	require.EqualError(t, err, "failed to read Diagnosis of patient 1: failed to evaluate ReadDiagnosis: failed to connect to peer0.org1.example.com:7051")
+
+	fake.failures = map[string]error{"CP1": fmt.Errorf("Cannot read contract. Caller of MSP Org3MSP is not allowed to read contracts")}
+	_, err = r.Run(nil)
+	require.ErrorContains(t, err, "failed to read Contract of patient 1: ")
+}
@@ -0,0 +1,106 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package rtdata reads the real time measurements of a patient whether they are stored on the ledger
+// or in XPN. An RTData pointer record holds the path and hash of an anchored vital signs document;
+// the Resolver fetches the document from storage, checks it against the hash and decodes it.
+package rtdata
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/vitalsigns"
+)
+
+// Resolver reads measurements from Ledger and the documents of pointer records from Storage.
+type Resolver struct {
+	Ledger  ledger.Client
+	Storage storage.Storage
+}
+
+// Read returns the measurements of a patient. For pointer records the measurements are read from the
+// vital signs document, which must match the pointer hash.
+func (r *Resolver) Read(patient string) (*ledger.RTData, error) {
+	rtData, _, err := r.read(patient)
+	return rtData, err
+}
+
+// UpdateDiagnosis submits UpdateDiagnosis for a patient, passing the vital signs document when the
+// measurements are a pointer record.
+func (r *Resolver) UpdateDiagnosis(patient string) error {
+	_, document, err := r.read(patient)
+	if err != nil {
+		return err
+	}
+
+	return ledger.UpdateDiagnosis(r.Ledger, patient, document)
+}
+
+// read returns the resolved measurements of a patient and, for pointer records, the raw document.
+func (r *Resolver) read(patient string) (*ledger.RTData, []byte, error) {
+	pseudonym, err := ledger.GetPatientPseudonym(r.Ledger, patient)
+	if err != nil {
+		return nil, nil, err
+	}
+	rtData, err := ledger.ReadRTData(r.Ledger, "RTD"+pseudonym)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rtData.Pointer {
+		return rtData, nil, nil
+	}
+
+	document, err := r.Fetch(rtData)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := vitalsigns.Decode(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc.PatientID != patient {
+		return nil, nil, fmt.Errorf("the document of %s belongs to patient %s", rtData.Path, doc.PatientID)
+	}
+
+	rtData.OxygenSaturation = float64(doc.OxygenSaturation)
+	rtData.PulseRate = float64(doc.PulseRate)
+	rtData.Temperature = float64(doc.Temperature)
+	rtData.BloodPressureSystolic = float64(doc.BloodPressureSystolic)
+	rtData.BloodPressureDiastolic = float64(doc.BloodPressureDiastolic)
+
+	return rtData, document, nil
+}
+
+// Fetch returns the vital signs document of a pointer record after checking it against the pointer hash.
+func (r *Resolver) Fetch(rtData *ledger.RTData) ([]byte, error) {
+	m, err := multihash.Decode(rtData.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := r.Storage.Open(rtData.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", rtData.Path, err)
+	}
+	defer file.Close()
+	document, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", rtData.Path, err)
+	}
+
+	hash, err := multihash.Sum(m.Name, bytes.NewReader(document))
+	if err != nil {
+		return nil, err
+	}
+	if hash != rtData.Hash {
+		return nil, fmt.Errorf("%s has hash %s, the measurements point to hash %s", rtData.Path, hash, rtData.Hash)
+	}
+
+	return document, nil
+}
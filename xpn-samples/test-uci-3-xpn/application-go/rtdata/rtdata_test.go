@@ -0,0 +1,99 @@
+package rtdata_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/rtdata"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+	"github.com/stretchr/testify/require"
+)
+
+const document = `{
+    "id": "1",
+    "patientId": "1",
+    "oxygenSaturation": "95",
+    "pulseRate": "72",
+    "temperature": "36.6",
+    "bloodPressureSystolic": "120",
+    "bloodPressureDiastolic": "80"
+}`
+
+type fakeLedger struct {
+	rtData    ledger.RTData
+	submitted []string
+	transient map[string][]byte
+}
+
+func (f *fakeLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	f.submitted = append(f.submitted, name)
+	return nil, nil
+}
+
+func (f *fakeLedger) SubmitTransient(name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	f.transient = transient
+	return f.SubmitTransaction(name, args...)
+}
+
+func (f *fakeLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "GetPatientPseudonym":
+		return []byte("p1"), nil
+	default:
+		return json.Marshal(f.rtData)
+	}
+}
+
+func pointer(t *testing.T, content string) (*fakeLedger, *storage.Memory) {
+	store := storage.NewMemory()
+	file, err := store.Create("/tmp/expand/xpn/1/vital_signs_1.txt")
+	require.NoError(t, err)
+	_, err = file.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	hash, err := multihash.Sum(multihash.Default, strings.NewReader(document))
+	require.NoError(t, err)
+
+	return &fakeLedger{rtData: ledger.RTData{ID: "RTDp1", Patient: "p1", Path: "/tmp/expand/xpn/1/vital_signs_1.txt", Hash: hash, Pointer: true}}, store
+}
+
+func TestReadPointer(t *testing.T) {
+	fake, store := pointer(t, document)
+	r := &rtdata.Resolver{Ledger: fake, Storage: store}
+
+	rtData, err := r.Read("1")
+	require.NoError(t, err)
+	require.Equal(t, 95.0, rtData.OxygenSaturation)
+	require.Equal(t, 36.6, rtData.Temperature)
+	require.Equal(t, 80.0, rtData.BloodPressureDiastolic)
+
+	require.NoError(t, r.UpdateDiagnosis("1"))
+	require.Equal(t, []string{"UpdateDiagnosis"}, fake.submitted)
+	require.Equal(t, document, string(fake.transient["rtdata"]))
+}
+
+func TestReadPointerModified(t *testing.T) {
+	fake, store := pointer(t, strings.Replace(document, "72", "40", 1))
+	r := &rtdata.Resolver{Ledger: fake, Storage: store}
+
+	_, err := r.Read("1")
+	require.Error(t, err)
+	require.Error(t, r.UpdateDiagnosis("1"))
+	require.Empty(t, fake.submitted)
+}
+
+func TestReadValues(t *testing.T) {
+	fake := &fakeLedger{rtData: ledger.RTData{ID: "RTDp1", Patient: "p1", OxygenSaturation: 97, PulseRate: 60, Temperature: 36, BloodPressureSystolic: 110, BloodPressureDiastolic: 70}}
+	r := &rtdata.Resolver{Ledger: fake, Storage: storage.NewMemory()}
+
+	rtData, err := r.Read("1")
+	require.NoError(t, err)
+	require.Equal(t, 97.0, rtData.OxygenSaturation)
+
+	require.NoError(t, r.UpdateDiagnosis("1"))
+	require.Nil(t, fake.transient)
+}
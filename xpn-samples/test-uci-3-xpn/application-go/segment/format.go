@@ -0,0 +1,95 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package segment writes and reads high-rate vital signs as an append-only log of segments instead
+// of one vital_signs_<n>.txt file per reading. Records are appended to the open segment until the next
+// one would not fit in SegmentSize, usually the XPN block size, so that a segment is stored in a single
+// block. The segment is then sealed under its final name and its hash can be anchored like any other
+// file; a reader checks every sealed segment against its anchor before returning its records.
+//
+// A segment is a 16 byte header, the magic "XPNSEG01" and the big-endian uint64 sequence number of
+// the segment, followed by the records. Every record is the big-endian uint32 length of its payload,
+// the big-endian uint32 CRC-32C (Castagnoli) of the payload, and the payload.
+package segment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Magic starts every segment.
+const Magic = "XPNSEG01"
+
+const (
+	headerSize       = len(Magic) + 8
+	recordHeaderSize = 8
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned for segments that are truncated or whose records do not match their checksum.
+var ErrCorrupt = errors.New("corrupt segment")
+
+// header returns the header of segment seq.
+func header(seq uint64) []byte {
+	b := make([]byte, headerSize)
+	copy(b, Magic)
+	binary.BigEndian.PutUint64(b[len(Magic):], seq)
+
+	return b
+}
+
+// appendRecord appends the framing and payload of a record to b.
+func appendRecord(b *bytes.Buffer, payload []byte) {
+	var h [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(h[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(h[4:], crc32.Checksum(payload, castagnoli))
+	b.Write(h[:])
+	b.Write(payload)
+}
+
+// Decode returns the sequence number and records of a sealed segment. Every record must match its
+// checksum.
+func Decode(content []byte) (uint64, [][]byte, error) {
+	seq, records, valid, err := decode(content)
+	if err != nil {
+		return 0, nil, err
+	}
+	if valid != len(content) {
+		return 0, nil, fmt.Errorf("%w: invalid record at offset %d", ErrCorrupt, valid)
+	}
+
+	return seq, records, nil
+}
+
+// decode returns the records of content up to the first truncated or corrupt one and the length of
+// the valid prefix. Only a missing or invalid header is an error.
+func decode(content []byte) (uint64, [][]byte, int, error) {
+	if len(content) < headerSize || string(content[:len(Magic)]) != Magic {
+		return 0, nil, 0, fmt.Errorf("%w: missing %s header", ErrCorrupt, Magic)
+	}
+	seq := binary.BigEndian.Uint64(content[len(Magic):headerSize])
+
+	var records [][]byte
+	offset := headerSize
+	for offset+recordHeaderSize <= len(content) {
+		length := int(binary.BigEndian.Uint32(content[offset:]))
+		checksum := binary.BigEndian.Uint32(content[offset+4:])
+		start := offset + recordHeaderSize
+		if length > len(content)-start {
+			break
+		}
+		payload := content[start : start+length]
+		if crc32.Checksum(payload, castagnoli) != checksum {
+			break
+		}
+		records = append(records, payload)
+		offset = start + length
+	}
+
+	return seq, records, offset, nil
+}
@@ -0,0 +1,120 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package segment
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/vitalsigns"
+)
+
+// Reader reads the sealed segments of Name in Directory.
+type Reader struct {
+	Storage   storage.Storage
+	Directory string
+	Name      string
+	// Ledger, when set, is used to check every segment against the hash anchored for its path
+	// before its records are returned.
+	Ledger ledger.Client
+}
+
+// Segments returns the paths of the sealed segments in order.
+func (r *Reader) Segments() ([]string, error) {
+	directory := path.Clean(r.Directory)
+	sealed, _, _, err := list(r.Storage, directory, r.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(sealed))
+	for _, seq := range sealed {
+		paths = append(paths, path.Join(directory, r.Name+"_"+strconv.FormatUint(seq, 10)+".seg"))
+	}
+
+	return paths, nil
+}
+
+// Iterate calls fn with every record of the sealed segments in order. It stops at the first segment
+// that is corrupt, does not follow the previous one, or segment 1 for the first one, or does not
+// match its anchor.
+func (r *Reader) Iterate(fn func(seq uint64, record []byte) error) error {
+	paths, err := r.Segments()
+	if err != nil {
+		return err
+	}
+
+	var previous uint64
+	for _, name := range paths {
+		content, err := readAll(r.Storage, name)
+		if err != nil {
+			return err
+		}
+		if r.Ledger != nil {
+			if err := r.verify(name, content); err != nil {
+				return err
+			}
+		}
+
+		seq, records, err := Decode(content)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !strings.HasSuffix(name, "_"+strconv.FormatUint(seq, 10)+".seg") {
+			return fmt.Errorf("%s: %w: header holds segment %d", name, ErrCorrupt, seq)
+		}
+		if seq != previous+1 {
+			return fmt.Errorf("segments %d to %d of %s are missing", previous+1, seq-1, r.Name)
+		}
+		previous = seq
+
+		for _, record := range records {
+			if err := fn(seq, record); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Readings calls fn with every vital signs document of the sealed segments in order.
+func (r *Reader) Readings(fn func(*vitalsigns.Document) error) error {
+	return r.Iterate(func(seq uint64, record []byte) error {
+		doc, err := vitalsigns.Decode(record)
+		if err != nil {
+			return fmt.Errorf("segment %d: %v", seq, err)
+		}
+		return fn(doc)
+	})
+}
+
+// verify checks the content of the segment stored under name against its anchor.
+func (r *Reader) verify(name string, content []byte) error {
+	anchor, err := ledger.ReadXpnTransactionByPath(r.Ledger, name)
+	if err != nil {
+		return fmt.Errorf("segment %s is not anchored: %v", name, err)
+	}
+	hash, err := multihash.Sum(multihash.Default, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if !multihash.Equal(hash, anchor.Hash, anchor.HashAlgorithm) {
+		return fmt.Errorf("segment %s does not match its anchor %s", name, anchor.ID)
+	}
+
+	return nil
+}
+
+func sortSeqs(seqs []uint64) {
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+}
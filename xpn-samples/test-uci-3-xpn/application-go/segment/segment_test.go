@@ -0,0 +1,297 @@
+package segment_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/segment"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/vitalsigns"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLedger anchors segments by path. With fail set, the next submission fails with it. With lose
+// set, it is committed but reported as failed.
+type fakeLedger struct {
+	anchors   map[string]*ledger.XpnTransaction
+	submitted int
+	fail      error
+	lose      bool
+}
+
+func (f *fakeLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	f.submitted++
+	if f.fail != nil {
+		err := f.fail
+		f.fail = nil
+		return nil, err
+	}
+	id := fmt.Sprint(len(f.anchors) + 1)
+	f.anchors[args[1]] = &ledger.XpnTransaction{ID: id, Hash: args[0], Path: args[1], Patient: args[2], DocumentType: args[3], HashAlgorithm: args[5]}
+	if f.lose {
+		f.lose = false
+		return nil, errors.New("timed out waiting for txid on all peers")
+	}
+	return []byte(id), nil
+}
+
+func (f *fakeLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	anchor, ok := f.anchors[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("Path %s is not anchored", args[0])
+	}
+	return json.Marshal(anchor)
+}
+
+func reading(i int) []byte {
+	return []byte(fmt.Sprintf(`{"id":"1", "patientId":"1", "oxygenSaturation":"%d", "pulseRate":"72", "temperature":"36.6", "bloodPressureSystolic":"120", "bloodPressureDiastolic":"80"}`, 90+i))
+}
+
+func read(t *testing.T, store storage.Storage, path string) []byte {
+	file, err := store.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	return content
+}
+
+func rewrite(t *testing.T, store storage.Writable, path string, content []byte) {
+	w, err := store.Create(path)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+func TestWriteRead(t *testing.T) {
+	store := storage.NewMemory()
+	fake := &fakeLedger{anchors: make(map[string]*ledger.XpnTransaction)}
+
+	// room for three readings per segment
+	size := int64(16 + 3*(8+len(reading(0))))
+	w, err := segment.OpenWriter(store, "/tmp/expand/xpn/1", "vital_signs", size)
+	require.NoError(t, err)
+	w.OnSeal = segment.Anchor(fake, "1", "xpn")
+
+	for i := 0; i < 7; i++ {
+		require.NoError(t, w.Append(reading(i)))
+	}
+	require.Len(t, fake.anchors, 2)
+	require.Equal(t, int64(size), int64(len(read(t, store, "/tmp/expand/xpn/1/vital_signs_1.seg"))))
+	require.NotNil(t, read(t, store, "/tmp/expand/xpn/1/vital_signs_3.seg.open"))
+
+	sealed, err := w.Seal()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), sealed.Seq)
+	require.Equal(t, 1, sealed.Records)
+	require.Equal(t, "vital_signs", fake.anchors[sealed.Path].DocumentType)
+	_, err = store.Open("/tmp/expand/xpn/1/vital_signs_3.seg.open")
+	require.Error(t, err)
+
+	require.Error(t, w.Append(make([]byte, size)))
+
+	r := &segment.Reader{Storage: store, Directory: "/tmp/expand/xpn/1", Name: "vital_signs", Ledger: fake}
+	segments, err := r.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+
+	var saturations []float64
+	require.NoError(t, r.Readings(func(doc *vitalsigns.Document) error {
+		saturations = append(saturations, float64(doc.OxygenSaturation))
+		return nil
+	}))
+	require.Equal(t, []float64{90, 91, 92, 93, 94, 95, 96}, saturations)
+
+	// a segment that no longer matches its anchor
+	content := read(t, store, "/tmp/expand/xpn/1/vital_signs_2.seg")
+	content[len(content)-2] ^= 1
+	rewrite(t, store, "/tmp/expand/xpn/1/vital_signs_2.seg", content)
+	err = r.Iterate(func(uint64, []byte) error { return nil })
+	require.ErrorContains(t, err, "does not match its anchor")
+
+	// without the ledger the checksum catches it
+	r.Ledger = nil
+	err = r.Iterate(func(uint64, []byte) error { return nil })
+	require.True(t, errors.Is(err, segment.ErrCorrupt))
+}
+
+func TestReanchor(t *testing.T) {
+	store := storage.NewMemory()
+	fake := &fakeLedger{anchors: make(map[string]*ledger.XpnTransaction)}
+	size := int64(16 + 3*(8+len(reading(0))))
+	w, err := segment.OpenWriter(store, "/tmp/expand/xpn/1", "vital_signs", size)
+	require.NoError(t, err)
+	w.OnSeal = segment.Anchor(fake, "1", "xpn")
+
+	// the segment is sealed but not anchored, and the writer stops
+	for i := 0; i < 3; i++ {
+		require.NoError(t, w.Append(reading(i)))
+	}
+	fake.fail = errors.New("failed to connect to peer0.org1.example.com:7051")
+	sealed, err := w.Seal()
+	require.ErrorContains(t, err, "segment /tmp/expand/xpn/1/vital_signs_1.seg is sealed but: failed to anchor segment /tmp/expand/xpn/1/vital_signs_1.seg: ")
+	require.Equal(t, uint64(1), sealed.Seq)
+	require.Empty(t, fake.anchors)
+	require.NotNil(t, read(t, store, "/tmp/expand/xpn/1/vital_signs_1.seg.unanchored"))
+
+	// the next writer anchors it before the segment it seals
+	w, err = segment.OpenWriter(store, "/tmp/expand/xpn/1", "vital_signs", size)
+	require.NoError(t, err)
+	w.OnSeal = segment.Anchor(fake, "1", "xpn")
+	for i := 3; i < 6; i++ {
+		require.NoError(t, w.Append(reading(i)))
+	}
+	_, err = w.Seal()
+	require.NoError(t, err)
+	require.Len(t, fake.anchors, 2)
+	require.Equal(t, sealed.Hash, fake.anchors["/tmp/expand/xpn/1/vital_signs_1.seg"].Hash)
+	_, err = store.Open("/tmp/expand/xpn/1/vital_signs_1.seg.unanchored")
+	require.Error(t, err)
+
+	// a segment anchored by a submission reported as failed is not anchored again
+	require.NoError(t, w.Append(reading(6)))
+	fake.lose = true
+	_, err = w.Seal()
+	require.ErrorContains(t, err, "timed out")
+	w, err = segment.OpenWriter(store, "/tmp/expand/xpn/1", "vital_signs", size)
+	require.NoError(t, err)
+	w.OnSeal = segment.Anchor(fake, "1", "xpn")
+	submitted := fake.submitted
+	require.NoError(t, w.Reanchor())
+	require.Equal(t, submitted, fake.submitted)
+	require.Len(t, fake.anchors, 3)
+	_, err = store.Open("/tmp/expand/xpn/1/vital_signs_3.seg.unanchored")
+	require.Error(t, err)
+
+	r := &segment.Reader{Storage: store, Directory: "/tmp/expand/xpn/1", Name: "vital_signs", Ledger: fake}
+	var records int
+	require.NoError(t, r.Iterate(func(uint64, []byte) error { records++; return nil }))
+	require.Equal(t, 7, records)
+
+	// the first segments are missing
+	require.NoError(t, store.Remove("/tmp/expand/xpn/1/vital_signs_1.seg"))
+	require.NoError(t, store.Remove("/tmp/expand/xpn/1/vital_signs_2.seg"))
+	err = r.Iterate(func(uint64, []byte) error { return nil })
+	require.EqualError(t, err, "segments 1 to 2 of vital_signs are missing")
+}
+
+func TestRecover(t *testing.T) {
+	store := storage.NewMemory()
+	w, err := segment.OpenWriter(store, "/tmp/expand/xpn/1", "vital_signs", 4096)
+	require.NoError(t, err)
+	require.NoError(t, w.Append(reading(0)))
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Append(reading(1)))
+	require.NoError(t, w.Append(reading(2)))
+
+	// the writer stopped while writing the last record
+	open := "/tmp/expand/xpn/1/vital_signs_2.seg.open"
+	content := read(t, store, open)
+	rewrite(t, store, open, content[:len(content)-5])
+
+	w, err = segment.OpenWriter(store, "/tmp/expand/xpn/1", "vital_signs", 4096)
+	require.NoError(t, err)
+	require.NoError(t, w.Append(reading(3)))
+	sealed, err := w.Seal()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), sealed.Seq)
+	require.Equal(t, 2, sealed.Records)
+
+	seq, records, err := segment.Decode(read(t, store, sealed.Path))
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), seq)
+	require.Equal(t, [][]byte{reading(1), reading(3)}, records)
+
+	// a missing segment breaks the sequence
+	require.NoError(t, w.Append(reading(4)))
+	require.NoError(t, w.Close())
+	require.NoError(t, store.Remove("/tmp/expand/xpn/1/vital_signs_2.seg"))
+
+	r := &segment.Reader{Storage: store, Directory: "/tmp/expand/xpn/1", Name: "vital_signs"}
+	err = r.Iterate(func(uint64, []byte) error { return nil })
+	require.ErrorContains(t, err, "segments 2 to 2 of vital_signs are missing")
+}
+
+// failingStorage fails every append after writing half of it while fail is set.
+type failingStorage struct {
+	*storage.Memory
+	fail bool
+}
+
+func (f *failingStorage) Append(path string) (io.WriteCloser, error) {
+	file, err := f.Memory.Append(path)
+	if err != nil || !f.fail {
+		return file, err
+	}
+	return &failingFile{file}, nil
+}
+
+type failingFile struct {
+	io.WriteCloser
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	n, _ := f.WriteCloser.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestAppendFailure(t *testing.T) {
+	store := &failingStorage{Memory: storage.NewMemory()}
+	w, err := segment.OpenWriter(store, "/tmp/expand/xpn/1", "vital_signs", 4096)
+	require.NoError(t, err)
+	require.NoError(t, w.Append(reading(0)))
+
+	// the failed record is not acknowledged and part of it is left in the open segment
+	store.fail = true
+	require.ErrorContains(t, w.Append(reading(1)), "no space left on device")
+	store.fail = false
+	require.NoError(t, w.Append(reading(2)))
+
+	_, records, err := segment.Decode(read(t, store, "/tmp/expand/xpn/1/vital_signs_1.seg.open"))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{reading(0), reading(2)}, records)
+
+	sealed, err := w.Seal()
+	require.NoError(t, err)
+	require.Equal(t, 2, sealed.Records)
+	_, records, err = segment.Decode(read(t, store, sealed.Path))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{reading(0), reading(2)}, records)
+}
+
+func TestWriteDir(t *testing.T) {
+	store := storage.NewLocalDir(t.TempDir())
+	w, err := segment.OpenWriter(store, "/tmp/expand/xpn/1", "vital_signs", 4096)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, w.Append(reading(i)))
+	}
+
+	// the records are stored before the segment is sealed
+	_, records, err := segment.Decode(read(t, store, "/tmp/expand/xpn/1/vital_signs_1.seg.open"))
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	// a new writer continues the open segment
+	w, err = segment.OpenWriter(store, "/tmp/expand/xpn/1", "vital_signs", 4096)
+	require.NoError(t, err)
+	require.NoError(t, w.Append(reading(3)))
+	require.NoError(t, w.Close())
+
+	_, err = store.Open("/tmp/expand/xpn/1/vital_signs_1.seg.open")
+	require.Error(t, err)
+	seq, records, err := segment.Decode(read(t, store, "/tmp/expand/xpn/1/vital_signs_1.seg"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), seq)
+	require.Equal(t, [][]byte{reading(0), reading(1), reading(2), reading(3)}, records)
+}
+
+func TestDecode(t *testing.T) {
+	_, _, err := segment.Decode([]byte("not a segment"))
+	require.True(t, errors.Is(err, segment.ErrCorrupt))
+}
@@ -0,0 +1,361 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package segment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// openSuffix is appended to the name of the open segment.
+const openSuffix = ".open"
+
+// unanchoredSuffix is appended to the name of a sealed segment to mark it until OnSeal succeeds.
+const unanchoredSuffix = ".unanchored"
+
+// Sealed describes a sealed segment.
+type Sealed struct {
+	Path    string `json:"path"`
+	Seq     uint64 `json:"seq"`
+	Records int    `json:"records"`
+	Size    int64  `json:"size"`
+	Hash    string `json:"hash"`
+}
+
+// Writer appends records to the segments <Directory>/<Name>_<seq>.seg, e.g.
+// /tmp/expand/xpn/1/vital_signs_1.seg. The open segment is kept in <Name>_<seq>.seg.open, every record
+// is appended to it and synced, so that a record is stored once Append returns; it is at most one
+// block. The open segment is renamed to its final name when it is sealed.
+type Writer struct {
+	Storage     storage.Writable
+	Directory   string
+	Name        string
+	SegmentSize int64
+	// OnSeal is called with every sealed segment, e.g. to anchor it, see Anchor. The segment stays
+	// sealed when it fails and is marked <Name>_<seq>.seg.unanchored, so that it is passed to OnSeal
+	// again, also by a later writer, before the next sealed segment or by Reanchor.
+	OnSeal func(Sealed) error
+
+	seq     uint64
+	buf     bytes.Buffer
+	records int
+	// written is the length of buf stored in the open segment. When torn is set, the open segment may
+	// hold more, e.g. part of a record whose append failed, and is replaced before the next append.
+	written int
+	torn    bool
+	// unanchored are the sealed segments whose OnSeal failed, in order.
+	unanchored []uint64
+}
+
+// OpenWriter returns a writer appending to the segments of name in directory. It continues the open
+// segment left by a previous writer, dropping a last record that was not completely written, or starts
+// the segment following the last sealed one.
+func OpenWriter(store storage.Writable, directory string, name string, segmentSize int64) (*Writer, error) {
+	if segmentSize < int64(headerSize+recordHeaderSize+1) {
+		return nil, fmt.Errorf("segment size %d is too small", segmentSize)
+	}
+	w := &Writer{Storage: store, Directory: path.Clean(directory), Name: name, SegmentSize: segmentSize}
+
+	sealed, open, unanchored, err := list(store, w.Directory, name)
+	if err != nil {
+		return nil, err
+	}
+	w.seq = 1
+	if len(sealed) > 0 {
+		w.seq = sealed[len(sealed)-1] + 1
+	}
+	for _, seq := range unanchored {
+		// the mark of a segment that was not sealed yet is written again when it is
+		if seq < w.seq {
+			w.unanchored = append(w.unanchored, seq)
+		}
+	}
+	w.buf.Write(header(w.seq))
+
+	if open > 0 && open < w.seq {
+		// left behind after its segment was sealed
+		if err := store.Remove(w.path(open) + openSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	} else if open > 0 {
+		content, err := readAll(store, w.path(open)+openSuffix)
+		if err != nil {
+			return nil, err
+		}
+		seq, records, valid, err := decode(content)
+		if err != nil || seq != open {
+			return nil, fmt.Errorf("failed to recover %s: %v", w.path(open)+openSuffix, err)
+		}
+		w.seq = seq
+		w.buf.Reset()
+		w.buf.Write(content[:valid])
+		w.records = len(records)
+		w.written = valid
+		w.torn = valid < len(content)
+	}
+
+	return w, nil
+}
+
+// Append appends a record, sealing the open segment first when the record does not fit.
+func (w *Writer) Append(record []byte) error {
+	if int64(headerSize+recordHeaderSize+len(record)) > w.SegmentSize {
+		return fmt.Errorf("record of %d bytes does not fit in a segment of %d bytes", len(record), w.SegmentSize)
+	}
+	if int64(w.buf.Len()+recordHeaderSize+len(record)) > w.SegmentSize {
+		if _, err := w.seal(); err != nil {
+			return err
+		}
+	}
+
+	appendRecord(&w.buf, record)
+	if err := w.flush(); err != nil {
+		w.buf.Truncate(w.written)
+		return err
+	}
+	w.records++
+
+	return nil
+}
+
+// Seal seals the open segment, unless it holds no records, and returns it.
+func (w *Writer) Seal() (*Sealed, error) {
+	if w.records == 0 {
+		return nil, nil
+	}
+
+	return w.seal()
+}
+
+// Close seals the open segment. A writer opened again later starts a new segment.
+func (w *Writer) Close() error {
+	_, err := w.Seal()
+	return err
+}
+
+// Reanchor passes the sealed segments whose OnSeal failed, also in an earlier writer, to OnSeal again
+// in order and removes their marks. It stops at the first failure.
+func (w *Writer) Reanchor() error {
+	if w.OnSeal == nil {
+		return nil
+	}
+
+	for len(w.unanchored) > 0 {
+		name := w.path(w.unanchored[0])
+		content, err := readAll(w.Storage, name)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		seq, records, err := Decode(content)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		hash, err := multihash.Sum(multihash.Default, bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+
+		sealed := Sealed{Path: name, Seq: seq, Records: len(records), Size: int64(len(content)), Hash: hash}
+		if err := w.OnSeal(sealed); err != nil {
+			return fmt.Errorf("failed to anchor segment %s: %v", name, err)
+		}
+		if err := w.Storage.Remove(name + unanchoredSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		w.unanchored = w.unanchored[1:]
+	}
+
+	return nil
+}
+
+func (w *Writer) seal() (*Sealed, error) {
+	name := w.path(w.seq)
+	content := w.buf.Bytes()
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	if w.OnSeal != nil {
+		// marked before it is sealed, in case the writer stops before OnSeal
+		file, err := w.Storage.Create(name + unanchoredSuffix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %v", name+unanchoredSuffix, err)
+		}
+		if err := write(file, nil); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", name+unanchoredSuffix, err)
+		}
+	}
+	if err := w.Storage.Rename(name+openSuffix, name); err != nil {
+		return nil, fmt.Errorf("failed to seal %s: %v", name, err)
+	}
+
+	hash, err := multihash.Sum(multihash.Default, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	sealed := &Sealed{Path: name, Seq: w.seq, Records: w.records, Size: int64(len(content)), Hash: hash}
+
+	w.seq++
+	w.records = 0
+	w.written = 0
+	w.buf.Reset()
+	w.buf.Write(header(w.seq))
+
+	if w.OnSeal != nil {
+		w.unanchored = append(w.unanchored, sealed.Seq)
+		if err := w.Reanchor(); err != nil {
+			return sealed, fmt.Errorf("segment %s is sealed but: %v", name, err)
+		}
+	}
+
+	return sealed, nil
+}
+
+func (w *Writer) path(seq uint64) string {
+	return path.Join(w.Directory, w.Name+"_"+strconv.FormatUint(seq, 10)+".seg")
+}
+
+// flush appends the part of buf that is not yet stored to the open segment and syncs it.
+func (w *Writer) flush() error {
+	name := w.path(w.seq) + openSuffix
+	if w.torn {
+		if err := w.replace(name, w.buf.Bytes()[:w.written]); err != nil {
+			return err
+		}
+		w.torn = false
+	}
+	if w.written == w.buf.Len() {
+		return nil
+	}
+
+	file, err := w.Storage.Append(name)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	if err := write(file, w.buf.Bytes()[w.written:]); err != nil {
+		// the file may end with part of the record now
+		w.torn = true
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	w.written = w.buf.Len()
+
+	return nil
+}
+
+// replace replaces the file stored under name with content in a single step.
+func (w *Writer) replace(name string, content []byte) error {
+	temp := name + ".tmp"
+	file, err := w.Storage.Create(temp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", temp, err)
+	}
+	if err := write(file, content); err != nil {
+		w.Storage.Remove(temp)
+		return fmt.Errorf("failed to write %s: %v", temp, err)
+	}
+
+	return w.Storage.Rename(temp, name)
+}
+
+// write writes content to file, syncs it when the storage can and closes it.
+func write(file io.WriteCloser, content []byte) error {
+	_, err := file.Write(content)
+	if syncer, ok := file.(interface{ Sync() error }); ok && err == nil {
+		err = syncer.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Anchor returns an OnSeal function anchoring every sealed segment as a vital_signs document of
+// patient stored in partition. A segment already anchored with its hash, e.g. by a submission
+// reported as failed, is not anchored again.
+func Anchor(c ledger.Client, patient string, partition string) func(Sealed) error {
+	return func(sealed Sealed) error {
+		current, err := ledger.ReadXpnTransactionByPath(c, sealed.Path)
+		if err == nil && multihash.Equal(current.Hash, sealed.Hash, current.HashAlgorithm) {
+			return nil
+		}
+		if err != nil && !ledger.NotAnchored(err) {
+			return err
+		}
+
+		_, err = ledger.CreateXpnTransaction(c, ledger.XpnTransactionInput{
+			Hash:          sealed.Hash,
+			Path:          sealed.Path,
+			Patient:       patient,
+			DocumentType:  "vital_signs",
+			Size:          strconv.FormatInt(sealed.Size, 10),
+			HashAlgorithm: multihash.Default,
+			Partition:     partition,
+		})
+		return err
+	}
+}
+
+// list returns the sequence numbers of the sealed segments of name in directory in order, the
+// sequence number of the open segment, 0 when there is none, and the sequence numbers of the marked
+// unanchored segments in order.
+func list(store storage.Storage, directory string, name string) ([]uint64, uint64, []uint64, error) {
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `_([0-9]+)\.seg(` + regexp.QuoteMeta(openSuffix) + `|` + regexp.QuoteMeta(unanchoredSuffix) + `)?$`)
+
+	var sealed, unanchored []uint64
+	var open uint64
+	err := store.Walk(func(anchoredPath string) error {
+		if path.Dir(anchoredPath) != directory {
+			return nil
+		}
+		match := pattern.FindStringSubmatch(path.Base(anchoredPath))
+		if match == nil {
+			return nil
+		}
+		seq, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil
+		}
+		if match[2] == unanchoredSuffix {
+			unanchored = append(unanchored, seq)
+			return nil
+		}
+		if match[2] != "" {
+			if seq > open {
+				open = seq
+			}
+			return nil
+		}
+		sealed = append(sealed, seq)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to list segments of %s: %v", directory, err)
+	}
+	sortSeqs(sealed)
+	sortSeqs(unanchored)
+
+	return sealed, open, unanchored, nil
+}
+
+func readAll(store storage.Storage, name string) ([]byte, error) {
+	file, err := store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
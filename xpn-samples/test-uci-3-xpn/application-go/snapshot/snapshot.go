@@ -0,0 +1,120 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package snapshot builds the manifest of an XPN directory, e.g. the /tmp/expand/xpn/<id> directory
+// of a patient, anchors it and reports the files added, removed and changed since an anchored manifest.
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/manifest"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// Report lists the differences between the stored files of a directory and one of its manifests.
+type Report struct {
+	Directory string `json:"directory"`
+	// Manifest is the ID of the manifest compared with, empty when the directory has none.
+	Manifest  string `json:"manifest,omitempty"`
+	Version   int    `json:"version,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	manifest.Changes
+}
+
+// Take returns the manifest entries of the files stored below directory, sorted by relative path
+// and hashed with SHA-256.
+func Take(store storage.Storage, directory string) ([]manifest.Entry, error) {
+	prefix := path.Clean(directory) + "/"
+
+	var entries []manifest.Entry
+	err := store.Walk(func(anchoredPath string) error {
+		if !strings.HasPrefix(anchoredPath, prefix) {
+			return nil
+		}
+
+		file, err := store.Open(anchoredPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		counter := &countingReader{r: file}
+		hash, err := multihash.Sum(multihash.Default, counter)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %v", anchoredPath, err)
+		}
+		entries = append(entries, manifest.Entry{Path: strings.TrimPrefix(anchoredPath, prefix), Size: counter.n, Hash: hash})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files of %s: %v", directory, err)
+	}
+	manifest.Sort(entries)
+
+	return entries, nil
+}
+
+// Anchor takes the manifest of directory and anchors it as the next version of the directory.
+func Anchor(c ledger.Client, store storage.Storage, directory string) (*ledger.XpnManifest, error) {
+	entries, err := Take(store, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	return ledger.CreateXpnManifest(c, path.Clean(directory), entries)
+}
+
+// Diff compares the files stored below directory with the manifest with given id, or with the last
+// manifest of the directory when id is empty. Without any manifest every file is reported as added.
+func Diff(c ledger.Client, store storage.Storage, directory string, id string) (*Report, error) {
+	var previous *ledger.XpnManifest
+	var err error
+	if id == "" {
+		previous, err = ledger.GetLatestXpnManifest(c, directory)
+	} else {
+		previous, err = ledger.ReadXpnManifest(c, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Directory: path.Clean(directory)}
+	var entries []manifest.Entry
+	if previous != nil {
+		if previous.Directory != report.Directory {
+			return nil, fmt.Errorf("manifest %s is the manifest of %s, not of %s", previous.ID, previous.Directory, report.Directory)
+		}
+		report.Manifest = previous.ID
+		report.Version = previous.Version
+		report.Timestamp = previous.Timestamp
+		entries = previous.Entries
+	}
+
+	current, err := Take(store, directory)
+	if err != nil {
+		return nil, err
+	}
+	report.Changes = manifest.Diff(entries, current)
+
+	return report, nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,112 @@
+package snapshot_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/snapshot"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/manifest"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLedger stores manifests like the chaincode, without checking them.
+type fakeLedger struct {
+	manifests []*ledger.XpnManifest
+}
+
+func (f *fakeLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	xpnmanifest := &ledger.XpnManifest{ID: fmt.Sprint(len(f.manifests) + 1), Directory: args[0], Version: len(f.manifests) + 1}
+	if err := json.Unmarshal([]byte(args[1]), &xpnmanifest.Entries); err != nil {
+		return nil, err
+	}
+	f.manifests = append(f.manifests, xpnmanifest)
+	return json.Marshal(xpnmanifest)
+}
+
+func (f *fakeLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "GetLatestXpnManifest":
+		if len(f.manifests) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(f.manifests[len(f.manifests)-1])
+	case "ReadXpnManifest":
+		for _, xpnmanifest := range f.manifests {
+			if xpnmanifest.ID == args[0] {
+				return json.Marshal(xpnmanifest)
+			}
+		}
+	}
+	return nil, fmt.Errorf("the manifest %s does not exist", args[0])
+}
+
+func write(t *testing.T, store storage.Writable, path string, content string) {
+	w, err := store.Create(path)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+func TestTake(t *testing.T) {
+	store := storage.NewMemory()
+	write(t, store, "/tmp/expand/xpn/1/patient.txt", "{\"id\": \"1\"}")
+	write(t, store, "/tmp/expand/xpn/1/contract.txt", "{}")
+	write(t, store, "/tmp/expand/xpn/10/patient.txt", "{\"id\": \"10\"}")
+
+	entries, err := snapshot.Take(store, "/tmp/expand/xpn/1/")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "contract.txt", entries[0].Path)
+	require.Equal(t, int64(2), entries[0].Size)
+	require.Equal(t, "patient.txt", entries[1].Path)
+	require.Equal(t, int64(11), entries[1].Size)
+	require.NoError(t, manifest.Validate(entries))
+
+	_, err = manifest.Hash(entries, multihash.Default)
+	require.NoError(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	store := storage.NewMemory()
+	write(t, store, "/tmp/expand/xpn/1/patient.txt", "{\"id\": \"1\"}")
+	write(t, store, "/tmp/expand/xpn/1/diagnosis.txt", "{}")
+	fake := &fakeLedger{}
+
+	report, err := snapshot.Diff(fake, store, "/tmp/expand/xpn/1", "")
+	require.NoError(t, err)
+	require.Empty(t, report.Manifest)
+	require.Len(t, report.Added, 2)
+
+	first, err := snapshot.Anchor(fake, store, "/tmp/expand/xpn/1")
+	require.NoError(t, err)
+	require.Len(t, first.Entries, 2)
+
+	report, err = snapshot.Diff(fake, store, "/tmp/expand/xpn/1", "")
+	require.NoError(t, err)
+	require.Equal(t, first.ID, report.Manifest)
+	require.True(t, report.Empty())
+
+	write(t, store, "/tmp/expand/xpn/1/diagnosis.txt", "{\"diagnosis\": \"hypoxemia\"}")
+	write(t, store, "/tmp/expand/xpn/1/vital_signs_1.txt", "{}")
+	require.NoError(t, store.Remove("/tmp/expand/xpn/1/patient.txt"))
+	_, err = snapshot.Anchor(fake, store, "/tmp/expand/xpn/1")
+	require.NoError(t, err)
+
+	report, err = snapshot.Diff(fake, store, "/tmp/expand/xpn/1", first.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Version)
+	require.Len(t, report.Added, 1)
+	require.Equal(t, "vital_signs_1.txt", report.Added[0].Path)
+	require.Len(t, report.Removed, 1)
+	require.Equal(t, "patient.txt", report.Removed[0].Path)
+	require.Len(t, report.Changed, 1)
+	require.Equal(t, "diagnosis.txt", report.Changed[0].Path)
+
+	_, err = snapshot.Diff(fake, store, "/tmp/expand/xpn/2", first.ID)
+	require.Error(t, err)
+}
@@ -0,0 +1,115 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// Memory is a Writable keeping the files in memory, used for tests.
+type Memory struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemory returns an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string][]byte)}
+}
+
+// Open implements Storage.
+func (m *Memory) Open(anchoredPath string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	content, ok := m.files[anchoredPath]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: anchoredPath, Err: fs.ErrNotExist}
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// Walk implements Storage.
+func (m *Memory) Walk(fn func(anchoredPath string) error) error {
+	m.mu.Lock()
+	paths := make([]string, 0, len(m.files))
+	for path := range m.files {
+		paths = append(paths, path)
+	}
+	m.mu.Unlock()
+
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := fn(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create implements Writable. The file becomes visible when the writer is closed.
+func (m *Memory) Create(anchoredPath string) (io.WriteCloser, error) {
+	return &memoryFile{memory: m, path: anchoredPath}, nil
+}
+
+// Append implements Writable. The appended content becomes visible when the writer is closed.
+func (m *Memory) Append(anchoredPath string) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file := &memoryFile{memory: m, path: anchoredPath}
+	file.Write(m.files[anchoredPath])
+
+	return file, nil
+}
+
+// Remove implements Writable.
+func (m *Memory) Remove(anchoredPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[anchoredPath]; !ok {
+		return &fs.PathError{Op: "remove", Path: anchoredPath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, anchoredPath)
+
+	return nil
+}
+
+// Rename implements Writable.
+func (m *Memory) Rename(from string, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	content, ok := m.files[from]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrNotExist}
+	}
+	delete(m.files, from)
+	m.files[to] = content
+
+	return nil
+}
+
+type memoryFile struct {
+	bytes.Buffer
+	memory *Memory
+	path   string
+}
+
+func (f *memoryFile) Close() error {
+	f.memory.mu.Lock()
+	defer f.memory.mu.Unlock()
+
+	f.memory.files[f.path] = f.Bytes()
+
+	return nil
+}
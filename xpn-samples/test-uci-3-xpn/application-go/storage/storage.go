@@ -0,0 +1,168 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package storage reads the files anchored on the ledger from where they are stored.
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultXpnPrefix is the XPN directory the deploy scripts write to.
+const DefaultXpnPrefix = "/tmp/expand/xpn"
+
+// Storage gives access to the files behind the anchored paths.
+type Storage interface {
+	// Open opens the file stored under an anchored path. The error satisfies
+	// errors.Is(err, fs.ErrNotExist) when the file is missing.
+	Open(anchoredPath string) (io.ReadCloser, error)
+	// Walk calls fn with the anchored path of every stored file.
+	Walk(fn func(anchoredPath string) error) error
+}
+
+// Dir is a Storage backed by a directory. Anchored paths below Prefix are mapped
+// to the same relative path below Root.
+type Dir struct {
+	Root   string
+	Prefix string
+}
+
+// NewLocalDir returns a Storage holding a copy of the XPN directory in root, used for tests.
+func NewLocalDir(root string) *Dir {
+	return &Dir{Root: root, Prefix: DefaultXpnPrefix}
+}
+
+// NewXpnMount returns a Storage reading the XPN partition mounted at mountpoint.
+func NewXpnMount(mountpoint string) *Dir {
+	return &Dir{Root: mountpoint, Prefix: DefaultXpnPrefix}
+}
+
+// Open implements Storage.
+func (d *Dir) Open(anchoredPath string) (io.ReadCloser, error) {
+	name, err := d.localPath(anchoredPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(name)
+}
+
+// Walk implements Storage.
+func (d *Dir) Walk(fn func(anchoredPath string) error) error {
+	return filepath.WalkDir(d.Root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(d.Root, name)
+		if err != nil {
+			return err
+		}
+
+		return fn(path.Join(d.Prefix, filepath.ToSlash(rel)))
+	})
+}
+
+func (d *Dir) localPath(anchoredPath string) (string, error) {
+	clean := path.Clean(anchoredPath)
+	prefix := path.Clean(d.Prefix)
+	if clean != prefix && !strings.HasPrefix(clean, prefix+"/") {
+		return "", &fs.PathError{Op: "open", Path: anchoredPath, Err: fs.ErrNotExist}
+	}
+
+	return filepath.Join(d.Root, filepath.FromSlash(strings.TrimPrefix(clean, prefix))), nil
+}
+
+// Writable is a Storage new files can be written to.
+type Writable interface {
+	Storage
+	// Create creates or truncates the file stored under an anchored path, creating missing directories.
+	Create(anchoredPath string) (io.WriteCloser, error)
+	// Append opens the file stored under an anchored path for appending, creating it and missing
+	// directories when it does not exist. The writer has a Sync method when the storage has one.
+	Append(anchoredPath string) (io.WriteCloser, error)
+	// Remove removes the file stored under an anchored path.
+	Remove(anchoredPath string) error
+	// Rename replaces the file stored under anchored path to with the one stored under from, in a
+	// single step, so that readers see either the old or the new file.
+	Rename(from string, to string) error
+}
+
+// Create implements Writable.
+func (d *Dir) Create(anchoredPath string) (io.WriteCloser, error) {
+	name, err := d.localPath(anchoredPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+
+	return os.Create(name)
+}
+
+// Append implements Writable.
+func (d *Dir) Append(anchoredPath string) (io.WriteCloser, error) {
+	name, err := d.localPath(anchoredPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// Remove implements Writable.
+func (d *Dir) Remove(anchoredPath string) error {
+	name, err := d.localPath(anchoredPath)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+// Rename implements Writable.
+func (d *Dir) Rename(from string, to string) error {
+	fromName, err := d.localPath(from)
+	if err != nil {
+		return err
+	}
+	toName, err := d.localPath(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(toName), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(fromName, toName)
+}
+
+// Stater is a Storage that reports the size and modification time of stored files.
+type Stater interface {
+	Storage
+	// Stat returns the file info of the file stored under an anchored path.
+	Stat(anchoredPath string) (fs.FileInfo, error)
+}
+
+// Stat implements Stater.
+func (d *Dir) Stat(anchoredPath string) (fs.FileInfo, error) {
+	name, err := d.localPath(anchoredPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Stat(name)
+}
@@ -0,0 +1,121 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package watcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/merkle"
+)
+
+// File is what the state knows of a watched file.
+type File struct {
+	// Hash, Size and ModTime describe the content last anchored, empty until the file is anchored.
+	Hash    string    `json:"hash,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"modTime,omitempty"`
+	// ID is the id of the xpntransaction anchoring the file, Batch and Proof the batch holding it
+	// and its inclusion proof when the file was anchored in a batch.
+	ID         string        `json:"id,omitempty"`
+	Batch      string        `json:"batch,omitempty"`
+	Proof      *merkle.Proof `json:"proof,omitempty"`
+	AnchoredAt time.Time     `json:"anchoredAt,omitempty"`
+	// Reconciled is true when the anchor was found on the ledger instead of being submitted.
+	Reconciled bool `json:"reconciled,omitempty"`
+	// Error and Attempts describe the failed submissions of the current content.
+	Error    string `json:"error,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+// Batch is a batch being submitted. It is saved before the submission, so that after a failure or a
+// restart its files are looked up on the ledger before it is submitted again. ID is the id assigned
+// by the chaincode, empty until the batch is committed.
+type Batch struct {
+	ID      string   `json:"id,omitempty"`
+	Changes []Change `json:"changes"`
+}
+
+// State is the local database of the watcher, a JSON file rewritten after every change.
+type State struct {
+	Files   map[string]*File `json:"files"`
+	Pending *Batch           `json:"pending,omitempty"`
+
+	name string
+}
+
+// OpenState reads the state stored in the named file, or returns an empty state when the file does
+// not exist yet. Save writes it back to the same file.
+func OpenState(name string) (*State, error) {
+	state := &State{Files: make(map[string]*File), name: name}
+	if name == "" {
+		return state, nil
+	}
+
+	content, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state %s: %v", name, err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*File)
+	}
+
+	return state, nil
+}
+
+// Save writes the state, replacing the previous file atomically. States without a file name are
+// kept in memory only.
+func (s *State) Save() error {
+	if s.name == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.name), filepath.Base(s.name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.name)
+}
+
+// file returns the state of the file stored under path, adding it when missing.
+func (s *State) file(path string) *File {
+	f, ok := s.Files[path]
+	if !ok {
+		f = &File{}
+		s.Files[path] = f
+	}
+
+	return f
+}
@@ -0,0 +1,418 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package watcher anchors the files written to XPN or local directories without the producers calling
+// the ledger themselves. Every poll lists the watched directories, hashes the files that are new or
+// modified and whose writes have settled, and anchors them one by one or in batches.
+//
+// Polling is used rather than inotify, which does not see the writes of other nodes to an XPN
+// partition. Delivery is at least once: a file whose anchor could not be submitted is retried on the
+// next poll. The current anchor of the path is compared with the file before every submission, and
+// batches are saved before they are submitted and looked up by the current anchors of their files
+// before they are submitted again, so that a retry never anchors a file twice. A batch rejected by
+// the chaincode is not retried, its files are anchored one by one instead.
+package watcher
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/auditor"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/merkle"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// DefaultSettle is how long a file must stay unmodified before it is anchored.
+const DefaultSettle = 5 * time.Second
+
+// documentName matches the names of the documents written by the deploy scripts, e.g. vital_signs_3.txt,
+// and of the sealed segments of package segment, e.g. vital_signs_3.seg.
+var documentName = regexp.MustCompile(`^([a-z_]+?)(_[0-9]+)?\.(txt|seg)$`)
+
+// Change is the settled content of a file that is not anchored yet.
+type Change struct {
+	Path    string    `json:"path"`
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Result counts what one poll did.
+type Result struct {
+	// Anchored files were anchored by a transaction submitted during the poll, Reconciled files
+	// were found anchored on the ledger already.
+	Anchored   int `json:"anchored"`
+	Reconciled int `json:"reconciled"`
+	Batches    int `json:"batches"`
+	// Unsettled files were modified less than Settle ago and are checked again on the next poll.
+	Unsettled int `json:"unsettled"`
+	// Failed files could not be anchored and are retried on the next poll. The error of a file
+	// anchored alone is recorded in its state too.
+	Failed int      `json:"failed"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Watcher anchors the files stored in Storage below Directories on Ledger.
+type Watcher struct {
+	Ledger  ledger.Client
+	Storage storage.Stater
+	// Directories are the anchored directories watched, e.g. /tmp/expand/xpn/1. Every stored file
+	// is watched when empty.
+	Directories []string
+	Partition   string
+	// Settle is how long a file must stay unmodified before it is anchored, DefaultSettle when zero.
+	Settle time.Duration
+	// BatchSize anchors the settled files in batches of at most this many files with CreateXpnBatch,
+	// which still gives every file its own xpntransaction. Every file is submitted alone when zero.
+	BatchSize int
+	// Classify returns the patient and document type of a file, Classify of this package when nil.
+	Classify func(path string) (patient string, documentType string, err error)
+	State    *State
+	// Now returns the current time, time.Now when nil.
+	Now func() time.Time
+}
+
+// Classify derives the patient and document type of a file from the layout of the deploy scripts,
+// <patient id>/<document type>[_<n>].txt, e.g. /tmp/expand/xpn/1/vital_signs_3.txt. Sealed segments
+// are classified like documents, open ones are not anchored.
+func Classify(anchoredPath string) (string, string, error) {
+	patient := path.Base(path.Dir(anchoredPath))
+	match := documentName.FindStringSubmatch(path.Base(anchoredPath))
+	if match == nil || patient == "." || patient == "/" {
+		return "", "", fmt.Errorf("cannot derive the patient and document type of %s", anchoredPath)
+	}
+
+	return patient, match[1], nil
+}
+
+// Poll anchors every settled file that is new or modified since it was last anchored, and saves the state.
+func (w *Watcher) Poll() (*Result, error) {
+	result := &Result{}
+
+	if pending := w.State.Pending; pending != nil {
+		if err := w.submitBatch(result, pending); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			if ledger.Retryable(err) {
+				result.Failed += len(pending.Changes)
+				// the pending batch must be committed before the next one is built
+				return result, w.State.Save()
+			}
+			// resubmitting it would fail the same way, its files are scanned again below
+			w.State.Pending = nil
+		}
+	}
+
+	changes, err := w.scan(result)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.BatchSize > 0 {
+		for start := 0; start < len(changes); start += w.BatchSize {
+			end := start + w.BatchSize
+			if end > len(changes) {
+				end = len(changes)
+			}
+
+			batch := &Batch{Changes: changes[start:end]}
+			w.State.Pending = batch
+			if err := w.State.Save(); err != nil {
+				return nil, fmt.Errorf("failed to save state: %v", err)
+			}
+			if err := w.submitBatch(result, batch); err != nil {
+				result.Errors = append(result.Errors, err.Error())
+				if ledger.Retryable(err) {
+					result.Failed += len(batch.Changes)
+					break
+				}
+				// a file the chaincode rejects must not hold back the others of the batch
+				w.State.Pending = nil
+				w.anchorEach(result, batch.Changes)
+			}
+		}
+	} else {
+		w.anchorEach(result, changes)
+	}
+
+	if err := w.State.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save state: %v", err)
+	}
+
+	return result, nil
+}
+
+// scan returns the settled files whose content differs from the anchored one.
+func (w *Watcher) scan(result *Result) ([]Change, error) {
+	settle := w.Settle
+	if settle == 0 {
+		settle = DefaultSettle
+	}
+
+	var changes []Change
+	err := w.Storage.Walk(func(anchoredPath string) error {
+		if !w.watched(anchoredPath) {
+			return nil
+		}
+
+		info, err := w.Storage.Stat(anchoredPath)
+		if err != nil {
+			return err
+		}
+		f := w.State.Files[anchoredPath]
+		if f != nil && f.Hash != "" && f.Size == info.Size() && f.ModTime.Equal(info.ModTime()) {
+			return nil
+		}
+		if w.now().Sub(info.ModTime()) < settle {
+			result.Unsettled++
+			return nil
+		}
+
+		file, err := w.Storage.Open(anchoredPath)
+		if err != nil {
+			return err
+		}
+		hash, err := auditor.HashFile(file, multihash.Default)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %v", anchoredPath, err)
+		}
+
+		// the file was written to while it was hashed
+		after, err := w.Storage.Stat(anchoredPath)
+		if err != nil {
+			return err
+		}
+		if after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+			result.Unsettled++
+			return nil
+		}
+
+		if f != nil && f.Hash == hash {
+			// touched without changing the content
+			f.Size = info.Size()
+			f.ModTime = info.ModTime()
+			return nil
+		}
+
+		changes = append(changes, Change{Path: anchoredPath, Hash: hash, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list watched files: %v", err)
+	}
+
+	return changes, nil
+}
+
+// watched reports whether anchoredPath is below one of the watched directories.
+func (w *Watcher) watched(anchoredPath string) bool {
+	if len(w.Directories) == 0 {
+		return true
+	}
+	for _, directory := range w.Directories {
+		if strings.HasPrefix(anchoredPath, path.Clean(directory)+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// anchorEach anchors every change with its own xpntransaction and records the error of the changes
+// that could not be anchored.
+func (w *Watcher) anchorEach(result *Result, changes []Change) {
+	for _, change := range changes {
+		if err := w.anchor(result, change); err != nil {
+			f := w.State.file(change.Path)
+			f.Error = err.Error()
+			f.Attempts++
+			result.Failed++
+			result.Errors = append(result.Errors, err.Error())
+		}
+	}
+}
+
+// anchor anchors a change with its own xpntransaction, unless the current anchor of the path already
+// has the same hash. An older version with the same hash does not count: a file reverted to earlier
+// content is anchored again.
+func (w *Watcher) anchor(result *Result, change Change) error {
+	current, err := ledger.ReadXpnTransactionByPath(w.Ledger, change.Path)
+	if err != nil && !ledger.NotAnchored(err) {
+		return err
+	}
+	anchored := err == nil && current.ID != "" && !current.Erased
+	if anchored && multihash.Equal(current.Hash, change.Hash, multihash.Default) {
+		w.anchored(change, current.ID, true)
+		result.Reconciled++
+		return nil
+	}
+
+	var id string
+	// a path that is anchored already gets a new version
+	if anchored {
+		id, err = ledger.UpdateXpnTransactionInput(w.Ledger, ledger.XpnTransactionInput{
+			Hash: change.Hash,
+			Path: change.Path,
+			Size: strconv.FormatInt(change.Size, 10),
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		patient, documentType, err := w.classify(change.Path)
+		if err != nil {
+			return err
+		}
+
+		id, err = ledger.CreateXpnTransaction(w.Ledger, ledger.XpnTransactionInput{
+			Hash:          change.Hash,
+			Path:          change.Path,
+			Patient:       patient,
+			DocumentType:  documentType,
+			Size:          strconv.FormatInt(change.Size, 10),
+			HashAlgorithm: multihash.Default,
+			Partition:     w.Partition,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	w.anchored(change, id, false)
+	result.Anchored++
+
+	return nil
+}
+
+// submitBatch anchors the files of batch with CreateXpnBatch, unless the ledger already holds an
+// anchor of every file from one batch, and records the inclusion proof of every file.
+func (w *Watcher) submitBatch(result *Result, batch *Batch) error {
+	entries := make([]merkle.Entry, 0, len(batch.Changes))
+	for _, change := range batch.Changes {
+		entries = append(entries, merkle.Entry{Path: change.Path, Hash: change.Hash})
+	}
+	tree, err := merkle.New(entries)
+	if err != nil {
+		return err
+	}
+	proofs := make([]*merkle.Proof, 0, len(entries))
+	for _, entry := range entries {
+		proof, err := tree.Proof(entry.Path)
+		if err != nil {
+			return err
+		}
+		proofs = append(proofs, proof)
+	}
+
+	// the batch may have been committed by an earlier submission
+	ids, err := w.committedBatch(batch)
+	if err != nil {
+		return err
+	}
+	reconciled := ids != nil
+	if !reconciled {
+		files := make([]ledger.XpnBatchFile, 0, len(batch.Changes))
+		for _, change := range batch.Changes {
+			patient, documentType, err := w.classify(change.Path)
+			if err != nil {
+				return err
+			}
+			files = append(files, ledger.XpnBatchFile{
+				Hash:          change.Hash,
+				Path:          change.Path,
+				Patient:       patient,
+				DocumentType:  documentType,
+				Size:          strconv.FormatInt(change.Size, 10),
+				HashAlgorithm: multihash.Default,
+			})
+		}
+
+		batch.ID, err = ledger.CreateXpnBatch(w.Ledger, files, w.Partition)
+		if err != nil {
+			return fmt.Errorf("failed to anchor batch: %v", err)
+		}
+		ids = make([]string, len(batch.Changes))
+		for i := range batch.Changes {
+			ids[i] = fmt.Sprintf("%s.%d", batch.ID, i)
+		}
+	}
+
+	for i, change := range batch.Changes {
+		w.anchored(change, ids[i], reconciled)
+		f := w.State.Files[change.Path]
+		f.Batch = batch.ID
+		f.Proof = proofs[i]
+	}
+	if reconciled {
+		result.Reconciled += len(batch.Changes)
+	} else {
+		result.Anchored += len(batch.Changes)
+	}
+	result.Batches++
+	w.State.Pending = nil
+
+	return nil
+}
+
+// committedBatch looks up the current anchors of the files of batch. When every file is currently
+// anchored with its hash by the same batch, it sets the batch id and returns the ids of the anchors;
+// otherwise nil.
+func (w *Watcher) committedBatch(batch *Batch) ([]string, error) {
+	ids := make([]string, 0, len(batch.Changes))
+	batchID := ""
+	for _, change := range batch.Changes {
+		current, err := ledger.ReadXpnTransactionByPath(w.Ledger, change.Path)
+		if ledger.NotAnchored(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if current.Batch == "" || current.Erased || !multihash.Equal(current.Hash, change.Hash, multihash.Default) ||
+			(batchID != "" && current.Batch != batchID) {
+			return nil, nil
+		}
+		batchID = current.Batch
+		ids = append(ids, current.ID)
+	}
+
+	batch.ID = batchID
+	return ids, nil
+}
+
+// anchored records the anchor of change in the state.
+func (w *Watcher) anchored(change Change, id string, reconciled bool) {
+	w.State.Files[change.Path] = &File{
+		Hash:       change.Hash,
+		Size:       change.Size,
+		ModTime:    change.ModTime,
+		ID:         id,
+		AnchoredAt: w.now().UTC(),
+		Reconciled: reconciled,
+	}
+}
+
+// classify returns the patient and document type of the file stored under anchoredPath.
+func (w *Watcher) classify(anchoredPath string) (string, string, error) {
+	if w.Classify == nil {
+		return Classify(anchoredPath)
+	}
+
+	return w.Classify(anchoredPath)
+}
+
+func (w *Watcher) now() time.Time {
+	if w.Now == nil {
+		return time.Now()
+	}
+
+	return w.Now()
+}
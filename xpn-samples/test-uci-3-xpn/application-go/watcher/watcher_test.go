@@ -0,0 +1,318 @@
+package watcher_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/ledger"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/storage"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/watcher"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/merkle"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLedger keeps anchors and batch roots like the chaincode, which anchors every file of a batch. With fail set, the next submission
+// fails with it. With lose set, it is committed but reported as failed, like a timed out peer chaincode invoke.
+type fakeLedger struct {
+	xpntransactions []*ledger.XpnTransaction
+	batches         map[string]string
+	submitted       []string
+	fail            error
+	lose            bool
+}
+
+func (f *fakeLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	f.submitted = append(f.submitted, name)
+	if f.fail != nil {
+		err := f.fail
+		f.fail = nil
+		return nil, err
+	}
+	var result []byte
+	switch name {
+	case "CreateXpnTransaction":
+		id := fmt.Sprint(len(f.xpntransactions) + 1)
+		f.xpntransactions = append(f.xpntransactions, &ledger.XpnTransaction{ID: id, Hash: args[0], Path: args[1], Patient: args[2], DocumentType: args[3], Version: 1})
+		result = []byte(id)
+	case "UpdateXpnTransaction":
+		id := fmt.Sprint(len(f.xpntransactions) + 1)
+		f.xpntransactions = append(f.xpntransactions, &ledger.XpnTransaction{ID: id, Path: args[0], Hash: args[1], Version: 2})
+		result = []byte(id)
+	case "CreateXpnBatch":
+		var files []ledger.XpnBatchFile
+		if err := json.Unmarshal([]byte(args[0]), &files); err != nil {
+			return nil, err
+		}
+		id := fmt.Sprintf("batch%d", len(f.batches)+1)
+		entries := make([]merkle.Entry, 0, len(files))
+		for i, file := range files {
+			f.xpntransactions = append(f.xpntransactions, &ledger.XpnTransaction{ID: fmt.Sprintf("%s.%d", id, i), Hash: file.Hash,
+				Path: file.Path, Patient: file.Patient, DocumentType: file.DocumentType, Version: 1, Batch: id})
+			entries = append(entries, merkle.Entry{Path: file.Path, Hash: file.Hash})
+		}
+		tree, err := merkle.New(entries)
+		if err != nil {
+			return nil, err
+		}
+		f.batches[id] = tree.Root()
+		result = []byte(id)
+	}
+
+	if f.lose {
+		f.lose = false
+		return nil, errors.New("timed out waiting for txid on all peers")
+	}
+
+	return result, nil
+}
+
+func (f *fakeLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "ReadXpnTransactionByPath":
+		for i := len(f.xpntransactions) - 1; i >= 0; i-- {
+			if f.xpntransactions[i].Path == args[0] {
+				if f.xpntransactions[i].Erased {
+					break
+				}
+				return json.Marshal(f.xpntransactions[i])
+			}
+		}
+		// like the chaincode, which fails instead of returning no anchor
+		return nil, fmt.Errorf("peer chaincode: exit status 1: Error: endorsement failure during query. response: status:500 message:\"Path %s is not anchored\"", args[0])
+	case "VerifyXpnBatchProof":
+		var proof merkle.Proof
+		if err := json.Unmarshal([]byte(args[1]), &proof); err != nil {
+			return nil, err
+		}
+		ok, err := merkle.Verify(f.batches[args[0]], proof)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(fmt.Sprint(ok)), nil
+	}
+
+	return nil, fmt.Errorf("unexpected %s", name)
+}
+
+func write(t *testing.T, root string, name string, content string, modTime time.Time) {
+	file := filepath.Join(root, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+}
+
+func TestClassify(t *testing.T) {
+	patient, documentType, err := watcher.Classify("/tmp/expand/xpn/1/vital_signs_12.txt")
+	require.NoError(t, err)
+	require.Equal(t, "1", patient)
+	require.Equal(t, "vital_signs", documentType)
+
+	_, documentType, err = watcher.Classify("/tmp/expand/xpn/1/patient.txt")
+	require.NoError(t, err)
+	require.Equal(t, "patient", documentType)
+
+	_, documentType, err = watcher.Classify("/tmp/expand/xpn/1/vital_signs_3.seg")
+	require.NoError(t, err)
+	require.Equal(t, "vital_signs", documentType)
+
+	_, _, err = watcher.Classify("/tmp/expand/xpn/1/notes.json")
+	require.Error(t, err)
+	_, _, err = watcher.Classify("/tmp/expand/xpn/1/vital_signs_4.seg.open")
+	require.Error(t, err)
+}
+
+func TestPoll(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	settled := now.Add(-time.Minute)
+	write(t, root, "1/patient.txt", "{\"id\": \"1\"}", settled)
+	write(t, root, "1/contract.txt", "{}", settled)
+	write(t, root, "1/vital_signs_1.txt", "{}", now)
+	write(t, root, "2/patient.txt", "{\"id\": \"2\"}", settled)
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	state, err := watcher.OpenState(stateFile)
+	require.NoError(t, err)
+
+	fake := &fakeLedger{batches: make(map[string]string), lose: true}
+	w := &watcher.Watcher{
+		Ledger:      fake,
+		Storage:     storage.NewLocalDir(root),
+		Directories: []string{"/tmp/expand/xpn/1"},
+		Partition:   "xpn",
+		Settle:      5 * time.Second,
+		State:       state,
+		Now:         func() time.Time { return now },
+	}
+
+	// the first submission is committed but reported as failed
+	result, err := w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Anchored)
+	require.Equal(t, 1, result.Failed)
+	require.Equal(t, 1, result.Unsettled)
+	require.Len(t, fake.xpntransactions, 2)
+
+	// the retry finds the committed anchor instead of submitting it again
+	result, err = w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Reconciled)
+	require.Equal(t, 0, result.Anchored)
+	require.Len(t, fake.xpntransactions, 2)
+
+	// the state survives a restart
+	state, err = watcher.OpenState(stateFile)
+	require.NoError(t, err)
+	require.Len(t, state.Files, 2)
+	require.Equal(t, "1", state.Files["/tmp/expand/xpn/1/contract.txt"].ID)
+	require.True(t, state.Files["/tmp/expand/xpn/1/contract.txt"].Reconciled)
+	w.State = state
+
+	now = now.Add(time.Minute)
+	write(t, root, "1/patient.txt", "{\"id\": \"1\", \"weight\": 80}", settled.Add(time.Second))
+	result, err = w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Anchored)
+	require.Equal(t, []string{"CreateXpnTransaction", "CreateXpnTransaction", "UpdateXpnTransaction", "CreateXpnTransaction"}, fake.submitted)
+
+	result, err = w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, watcher.Result{}, *result)
+}
+
+func TestPollRevertedFile(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	state, err := watcher.OpenState("")
+	require.NoError(t, err)
+	fake := &fakeLedger{batches: make(map[string]string)}
+	w := &watcher.Watcher{
+		Ledger:  fake,
+		Storage: storage.NewLocalDir(root),
+		State:   state,
+		Now:     func() time.Time { return now },
+	}
+
+	for i, content := range []string{"{\"id\": \"1\"}", "{\"id\": \"1\", \"weight\": 80}", "{\"id\": \"1\"}"} {
+		write(t, root, "1/patient.txt", content, now.Add(time.Duration(i-10)*time.Minute))
+		result, err := w.Poll()
+		require.NoError(t, err)
+		require.Equal(t, 1, result.Anchored, i)
+		require.Equal(t, 0, result.Reconciled, i)
+	}
+
+	// the reverted content is anchored as a new version although an older version has its hash
+	require.Equal(t, []string{"CreateXpnTransaction", "UpdateXpnTransaction", "UpdateXpnTransaction"}, fake.submitted)
+	require.Equal(t, fake.xpntransactions[0].Hash, fake.xpntransactions[2].Hash)
+	require.Equal(t, "3", state.Files["/tmp/expand/xpn/1/patient.txt"].ID)
+}
+
+func TestPollBatch(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		write(t, root, fmt.Sprintf("1/vital_signs_%d.txt", i), fmt.Sprint(i), now.Add(-time.Minute))
+	}
+
+	state, err := watcher.OpenState("")
+	require.NoError(t, err)
+	fake := &fakeLedger{batches: make(map[string]string), fail: errors.New("failed to connect to peer0.org1.example.com:7051")}
+	w := &watcher.Watcher{
+		Ledger:    fake,
+		Storage:   storage.NewLocalDir(root),
+		Partition: "xpn",
+		BatchSize: 2,
+		State:     state,
+		Now:       func() time.Time { return now },
+	}
+
+	// the first batch cannot be submitted, the next ones wait for it
+	result, err := w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Failed)
+	require.NotNil(t, state.Pending)
+	require.Empty(t, fake.batches)
+
+	// submitted again, committed but reported as failed
+	fake.lose = true
+	result, err = w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Failed)
+	require.NotNil(t, state.Pending)
+	require.Len(t, fake.batches, 1)
+
+	// the pending batch is found by the hashes of its files instead of being submitted again
+	result, err = w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Reconciled)
+	require.Equal(t, 3, result.Anchored)
+	require.Equal(t, 3, result.Batches)
+	require.Nil(t, state.Pending)
+	require.Len(t, fake.batches, 3)
+	require.Len(t, fake.xpntransactions, 5)
+
+	for name, f := range state.Files {
+		require.NotEmpty(t, f.Batch, name)
+		require.Equal(t, f.Batch, f.ID[:len(f.Batch)], name)
+		ok, err := merkle.Verify(fake.batches[f.Batch], *f.Proof)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+}
+
+func TestPollBatchRejected(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		write(t, root, fmt.Sprintf("1/vital_signs_%d.txt", i), fmt.Sprint(i), now.Add(-time.Minute))
+	}
+
+	state, err := watcher.OpenState("")
+	require.NoError(t, err)
+	fake := &fakeLedger{batches: make(map[string]string), fail: errors.New("connection refused")}
+	w := &watcher.Watcher{
+		Ledger:    fake,
+		Storage:   storage.NewLocalDir(root),
+		Partition: "xpn",
+		BatchSize: 10,
+		State:     state,
+		Now:       func() time.Time { return now },
+	}
+	result, err := w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Failed)
+	require.NotNil(t, state.Pending)
+
+	// the chaincode rejects the pending batch, it is dropped and its files are batched again
+	fake.fail = errors.New("endorsement failure during invoke. response: status:500 message:\"invalid size\"")
+	result, err = w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 0, result.Failed)
+	require.Equal(t, 3, result.Anchored)
+	require.Equal(t, 1, result.Batches)
+	require.Len(t, result.Errors, 1)
+	require.Nil(t, state.Pending)
+
+	// a file that cannot be anchored makes the batch fail, the other files are anchored one by one
+	write(t, root, "1/notes.json", "{}", now.Add(-time.Minute))
+	write(t, root, "1/vital_signs_4.txt", "4", now.Add(-time.Minute))
+	write(t, root, "1/vital_signs_5.txt", "5", now.Add(-time.Minute))
+	result, err = w.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Anchored)
+	require.Equal(t, 1, result.Failed)
+	require.Equal(t, 0, result.Batches)
+	require.Nil(t, state.Pending)
+	require.Equal(t, []string{"CreateXpnBatch", "CreateXpnBatch", "CreateXpnBatch", "CreateXpnTransaction", "CreateXpnTransaction"}, fake.submitted)
+
+	notes := state.Files["/tmp/expand/xpn/1/notes.json"]
+	require.Equal(t, "cannot derive the patient and document type of /tmp/expand/xpn/1/notes.json", notes.Error)
+	require.Equal(t, 1, notes.Attempts)
+	require.Empty(t, notes.ID)
+	require.NotEmpty(t, state.Files["/tmp/expand/xpn/1/vital_signs_4.txt"].ID)
+}
@@ -0,0 +1,45 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package xpnconf
+
+// HeaderSize is the size of the metadata header XPN writes at the start of the file every server
+// stores for a file of the partition.
+const HeaderSize = 8192
+
+// Copy is where a copy of a block of a file is stored: the index of the server in the partition
+// server list and the offset of the block in the file of that server.
+type Copy struct {
+	Server int
+	Offset int64
+}
+
+// FirstNode returns the index of the server holding the first block of the file at name, the path
+// of the file in the partition, e.g. /1/patient.txt, like the hash function of XPN: the sum of the
+// bytes of name modulo the number of servers.
+func FirstNode(name string, servers int) int {
+	var sum uint32
+	for i := 0; i < len(name); i++ {
+		sum += uint32(name[i])
+	}
+
+	return int(sum % uint32(servers))
+}
+
+// Placement returns the replicationLevel+1 copies of block of a file striped over servers servers,
+// following the XPN read/write policy: the copies of every block are the next replicationLevel+1
+// blocks of the round robin over the servers that starts at firstNode, and every server stores the
+// blocks it is given one after the other behind a header of HeaderSize bytes.
+func Placement(blockSize int64, replicationLevel int, servers int, firstNode int, block int64) []Copy {
+	copies := make([]Copy, 0, replicationLevel+1)
+	for replica := 0; replica <= replicationLevel; replica++ {
+		n := block*int64(replicationLevel+1) + int64(replica)
+		copies = append(copies, Copy{
+			Server: int((n + int64(firstNode)) % int64(servers)),
+			Offset: HeaderSize + n/int64(servers)*blockSize,
+		})
+	}
+
+	return copies
+}
@@ -0,0 +1,158 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package xpnconf parses the XPN configuration file (xpn/config.xml), which despite its name is an
+// INI-like list of [partition] sections:
+//
+//	[partition]
+//	bsize = 512k
+//	replication_level = 0
+//	partition_name = xpn
+//	server_url = sck_server://localhost/xpn
+//
+// server_url may be repeated, once per server of the partition.
+package xpnconf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultBlockSize is the XPN block size used when a partition sets no bsize.
+const DefaultBlockSize = 512 * 1024
+
+// Partition is a [partition] section.
+type Partition struct {
+	Name             string   `json:"name"`
+	BlockSize        int64    `json:"blockSize"`
+	ReplicationLevel int      `json:"replicationLevel"`
+	Servers          []string `json:"servers"`
+}
+
+// Config is a parsed XPN configuration file.
+type Config struct {
+	Partitions []Partition `json:"partitions"`
+}
+
+// Load parses the XPN configuration file at name.
+func Load(name string) (*Config, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse parses an XPN configuration.
+func Parse(r io.Reader) (*Config, error) {
+	config := &Config{}
+	var current *Partition
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section := strings.TrimSpace(line[1 : len(line)-1])
+			if section != "partition" {
+				return nil, fmt.Errorf("line %d: unknown section [%s]", lineNumber, section)
+			}
+			config.Partitions = append(config.Partitions, Partition{BlockSize: DefaultBlockSize})
+			current = &config.Partitions[len(config.Partitions)-1]
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: %s outside of a [partition] section", lineNumber, strings.TrimSpace(key))
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "partition_name":
+			current.Name = value
+		case "bsize":
+			current.BlockSize, err = ParseSize(value)
+		case "replication_level":
+			current.ReplicationLevel, err = strconv.Atoi(value)
+			if err == nil && current.ReplicationLevel < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		case "server_url":
+			current.Servers = append(current.Servers, value)
+		default:
+			// keys this package does not use, e.g. of newer XPN versions
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s %q: %v", lineNumber, key, value, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, partition := range config.Partitions {
+		if partition.Name == "" {
+			return nil, fmt.Errorf("a partition has no partition_name")
+		}
+		if len(partition.Servers) == 0 {
+			return nil, fmt.Errorf("partition %s has no server_url", partition.Name)
+		}
+		if partition.ReplicationLevel >= len(partition.Servers) {
+			return nil, fmt.Errorf("partition %s has replication_level %d but only %d servers", partition.Name, partition.ReplicationLevel, len(partition.Servers))
+		}
+	}
+
+	return config, nil
+}
+
+// Partition returns the partition with given name.
+func (c *Config) Partition(name string) (*Partition, error) {
+	for i := range c.Partitions {
+		if c.Partitions[i].Name == name {
+			return &c.Partitions[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("partition %s is not configured", name)
+}
+
+// ParseSize parses a size such as 512k, 1m or 4096. The suffixes k, m and g are powers of 1024.
+func ParseSize(s string) (int64, error) {
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("must be a positive number of bytes")
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,81 @@
+package xpnconf_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/application-go/xpnconf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSampleConfig(t *testing.T) {
+	config, err := xpnconf.Load("../../xpn/config.xml")
+	require.NoError(t, err)
+
+	partition, err := config.Partition("xpn")
+	require.NoError(t, err)
+	require.Equal(t, xpnconf.Partition{
+		Name:             "xpn",
+		BlockSize:        512 * 1024,
+		ReplicationLevel: 0,
+		Servers:          []string{"sck_server://localhost/xpn"},
+	}, *partition)
+}
+
+func TestParseReplicatedPartitions(t *testing.T) {
+	config, err := xpnconf.Parse(strings.NewReader(`
+# two partitions
+[partition]
+partition_name = a
+bsize = 1m
+replication_level = 1
+server_url = sck_server://node1/xpn
+server_url = sck_server://node2/xpn
+
+[partition]
+partition_name = b
+server_url = mpi_server://node3/xpn
+`))
+	require.NoError(t, err)
+	require.Len(t, config.Partitions, 2)
+	require.Equal(t, int64(1<<20), config.Partitions[0].BlockSize)
+	require.Equal(t, 1, config.Partitions[0].ReplicationLevel)
+	require.Len(t, config.Partitions[0].Servers, 2)
+	require.Equal(t, int64(xpnconf.DefaultBlockSize), config.Partitions[1].BlockSize)
+
+	_, err = config.Partition("c")
+	require.Error(t, err)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, config := range []string{
+		"bsize = 512k\n",
+		"[partition]\npartition_name = a\n",
+		"[partition]\npartition_name = a\nserver_url = s\nreplication_level = 1\n",
+		"[partition]\npartition_name = a\nserver_url = s\nbsize = 0\n",
+		"[partition]\npartition_name = a\nserver_url = s\nbsize\n",
+		"[servers]\n",
+	} {
+		_, err := xpnconf.Parse(strings.NewReader(config))
+		require.Error(t, err, config)
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	require.Equal(t, 2, xpnconf.FirstNode("/1/patient.txt", 3))
+
+	// three blocks of 4 bytes with one more copy each on three servers, from server 2
+	var placement [][]xpnconf.Copy
+	for block := int64(0); block < 3; block++ {
+		placement = append(placement, xpnconf.Placement(4, 1, 3, 2, block))
+	}
+	header := int64(xpnconf.HeaderSize)
+	require.Equal(t, [][]xpnconf.Copy{
+		{{Server: 2, Offset: header}, {Server: 0, Offset: header}},
+		{{Server: 1, Offset: header}, {Server: 2, Offset: header + 4}},
+		{{Server: 0, Offset: header + 4}, {Server: 1, Offset: header + 4}},
+	}, placement)
+
+	// without replication the blocks are striped
+	require.Equal(t, []xpnconf.Copy{{Server: 1, Offset: header + 4}}, xpnconf.Placement(4, 0, 2, 0, 3))
+}
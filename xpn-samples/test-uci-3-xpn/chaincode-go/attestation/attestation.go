@@ -0,0 +1,113 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package attestation signs and verifies the detached signature a data producer attaches to an XPN
+// anchor. It is shared by the chaincode and the off-chain tools.
+//
+// The signed message is the path, the multihash and the signing time, separated by newlines:
+//
+//	/tmp/expand/xpn/1/patient.txt
+//	1220<sha-256 digest>
+//	2024-05-01T10:00:00Z
+//
+// Its SHA-256 digest is signed with the ECDSA enrollment key of the producer, and the ASN.1 DER
+// signature is passed base64 encoded.
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+// TimeLayout is the layout of the signing time.
+const TimeLayout = time.RFC3339
+
+// Message returns the message signed for the file at path with given multihash, signed at signedAt.
+// The path is cleaned like the chaincode does before anchoring it.
+func Message(p string, hash string, signedAt string) []byte {
+	return []byte(path.Clean(p) + "\n" + hash + "\n" + signedAt)
+}
+
+// Sign signs the message of the file at path with key, which must hold an ECDSA private key, and
+// returns the base64 encoded signature.
+func Sign(key crypto.Signer, p string, hash string, signedAt string) (string, error) {
+	if _, ok := key.Public().(*ecdsa.PublicKey); !ok {
+		return "", errors.New("attestation keys must be ECDSA keys")
+	}
+
+	digest := sha256.Sum256(Message(p, hash, signedAt))
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign %s: %v", p, err)
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks that signature is a signature of the message of the file at path made with the
+// private key of cert.
+func Verify(cert *x509.Certificate, p string, hash string, signedAt string, signature string) error {
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("the certificate does not hold an ECDSA public key")
+	}
+	der, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("the signature is not valid base64: %v", err)
+	}
+
+	digest := sha256.Sum256(Message(p, hash, signedAt))
+	if !ecdsa.VerifyASN1(publicKey, digest[:], der) {
+		return errors.New("the signature does not match the certificate")
+	}
+
+	return nil
+}
+
+// Fingerprint returns the hex SHA-256 digest of the DER encoding of cert.
+func Fingerprint(cert *x509.Certificate) string {
+	return fmt.Sprintf("%x", sha256.Sum256(cert.Raw))
+}
+
+// ParseCertificate parses a PEM encoded certificate.
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// ParsePrivateKey parses a PEM encoded ECDSA private key, such as the enrollment key in
+// msp/keystore of a Fabric user, in PKCS #8 or SEC 1 form.
+func ParsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("attestation keys must be ECDSA keys")
+	}
+
+	return ecdsaKey, nil
+}
@@ -0,0 +1,71 @@
+package attestation_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/attestation"
+	"github.com/stretchr/testify/require"
+)
+
+const hash = "1220" + "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func certificate(t *testing.T, key *ecdsa.PrivateKey) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "producer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := attestation.ParseCertificate(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	require.NoError(t, err)
+	return cert
+}
+
+func TestSignVerify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	cert := certificate(t, key)
+
+	signature, err := attestation.Sign(key, "/tmp/expand/xpn/1/../1/patient.txt", hash, "2024-05-01T10:00:00Z")
+	require.NoError(t, err)
+
+	require.NoError(t, attestation.Verify(cert, "/tmp/expand/xpn/1/patient.txt", hash, "2024-05-01T10:00:00Z", signature))
+	require.Error(t, attestation.Verify(cert, "/tmp/expand/xpn/2/patient.txt", hash, "2024-05-01T10:00:00Z", signature))
+	require.Error(t, attestation.Verify(cert, "/tmp/expand/xpn/1/patient.txt", hash, "2024-05-01T10:00:01Z", signature))
+	require.Error(t, attestation.Verify(cert, "/tmp/expand/xpn/1/patient.txt", hash, "2024-05-01T10:00:00Z", "not base64"))
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	require.Error(t, attestation.Verify(certificate(t, other), "/tmp/expand/xpn/1/patient.txt", hash, "2024-05-01T10:00:00Z", signature))
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	parsed, err := attestation.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	require.NoError(t, err)
+	require.True(t, key.PublicKey.Equal(parsed.Public()))
+
+	sec1, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	parsed, err = attestation.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}))
+	require.NoError(t, err)
+	require.True(t, key.PublicKey.Equal(parsed.Public()))
+
+	_, err = attestation.ParsePrivateKey([]byte("not a key"))
+	require.Error(t, err)
+}
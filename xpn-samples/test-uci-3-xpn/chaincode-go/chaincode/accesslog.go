@@ -0,0 +1,236 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// accessRecordType is the composite key object type used for access records.
+// Composite keys are not returned by the open-ended range queries used by the
+// GetAll* functions, so access records never show up as patients or contracts.
+const accessRecordType = "AccessRecord"
+
+// timestampLayout is a fixed width RFC3339 layout so that timestamps used in
+// keys sort lexicographically in chronological order.
+const timestampLayout = "2006-01-02T15:04:05.000000000Z"
+
+// auditedReadWindow is how long after an audited read the caller can read the identifiable fields
+// of the patient with ReadPatient.
+const auditedReadWindow = 5 * time.Minute
+
+// AccessRecord stores who read a sensitive record, when, and why.
+type AccessRecord struct {
+	TxID      string `json:"TxID"`
+	Patient   string `json:"Patient"`
+	Key       string `json:"Key"`
+	Caller    string `json:"Caller"`
+	MSP       string `json:"MSP"`
+	Purpose   string `json:"Purpose"`
+	Timestamp string `json:"Timestamp"`
+}
+
+// ---------------------------------------------------- ACCESS LOG -------------------------------------------------------------- //
+// txTimestamp returns the transaction timestamp set by the client in UTC.
+func txTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+
+	return ts.AsTime().UTC(), nil
+}
+
+// parseTimeBound parses an optional RFC3339 time range bound.
+func parseTimeBound(name string, value string) (time.Time, error) {
+	if strings.TrimSpace(value) == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s, required: RFC3339", name, value)
+	}
+
+	return t.UTC(), nil
+}
+
+// recordAccess appends an access record for the given patient and key to the world state.
+func (s *SmartContract) recordAccess(ctx contractapi.TransactionContextInterface, patient string, key string, purpose string) error {
+	if strings.TrimSpace(purpose) == "" {
+		return fmt.Errorf("purpose must be non-empty")
+	}
+
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller identity: %v", err)
+	}
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+	ts, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	record := AccessRecord{
+		TxID:      ctx.GetStub().GetTxID(),
+		Patient:   patient,
+		Key:       key,
+		Caller:    caller,
+		MSP:       msp,
+		Purpose:   purpose,
+		Timestamp: ts.Format(timestampLayout),
+	}
+
+	recordKey, err := ctx.GetStub().CreateCompositeKey(accessRecordType, []string{patient, record.Timestamp, record.TxID})
+	if err != nil {
+		return err
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(recordKey, recordJSON)
+}
+
+// accessRecorded reports whether the caller has an access record for key of patient committed in the
+// auditedReadWindow before the transaction time.
+func (s *SmartContract) accessRecorded(ctx contractapi.TransactionContextInterface, patient string, key string) (bool, error) {
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return false, fmt.Errorf("failed to read caller identity: %v", err)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	records, err := s.accessRecordsOf(ctx, patient, now.Add(-auditedReadWindow), now)
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		if record.Caller == caller && record.Key == key {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// AuditedReadPatient appends an access record for the patient with given id to the ledger and returns
+// the id of the record, the transaction ID. It must be submitted, not evaluated, for the access record
+// to be committed. The patient itself is not returned, because the result of a submitted transaction
+// is stored in the block on every organization; evaluate ReadPatient once the record is committed.
+// ReadPatient only returns the identifiable fields to callers with such a record, see auditedReadWindow.
+func (s *SmartContract) AuditedReadPatient(ctx contractapi.TransactionContextInterface, id string, purpose string) (string, error) {
+	exists, err := s.PatientExists(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("Cannot read patient. Patient with id %s does not exist", id)
+	}
+
+	err = s.recordAccess(ctx, id, id, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	return ctx.GetStub().GetTxID(), nil
+}
+
+// AuditedReadDiagnosis returns the diagnosis with given id and appends an access record to the ledger.
+// It must be submitted, not evaluated, for the access record to be committed.
+func (s *SmartContract) AuditedReadDiagnosis(ctx contractapi.TransactionContextInterface, id string, purpose string) (*Diagnosis, error) {
+	diagnosis, err := s.ReadDiagnosis(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordAccess(ctx, diagnosis.Patient, id, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	return diagnosis, nil
+}
+
+// GetPatientAccessLog returns the access records of a patient between from and to (RFC3339, both optional).
+// Diagnosis reads are logged under the patient pseudonym and are only included for collection members
+// holding the xpn.reidentify=true attribute, because they disclose the pseudonym.
+func (s *SmartContract) GetPatientAccessLog(ctx contractapi.TransactionContextInterface, patient string, from string, to string) ([]*AccessRecord, error) {
+	fromTime, err := parseTimeBound("from", from)
+	if err != nil {
+		return nil, err
+	}
+	toTime, err := parseTimeBound("to", to)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := s.accessRecordsOf(ctx, patient, fromTime, toTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// diagnoses are logged under the patient pseudonym, which only collection members can resolve
+	pseudonym, err := s.pseudonymFor(ctx, patient)
+	if err == nil {
+		pseudonymRecords, err := s.accessRecordsOf(ctx, pseudonym, fromTime, toTime)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, pseudonymRecords...)
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].Timestamp < records[j].Timestamp
+		})
+	}
+
+	return records, nil
+}
+
+// accessRecordsOf returns the access records logged under patient between fromTime and toTime.
+func (s *SmartContract) accessRecordsOf(ctx contractapi.TransactionContextInterface, patient string, fromTime time.Time, toTime time.Time) ([]*AccessRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(accessRecordType, []string{patient})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var records []*AccessRecord
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record AccessRecord
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+
+		accessed, err := time.Parse(timestampLayout, record.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if !fromTime.IsZero() && accessed.Before(fromTime) {
+			continue
+		}
+		if !toTime.IsZero() && accessed.After(toTime) {
+			continue
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,30 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// Client identity attributes granting the transactions that only some identities of a member
+// organization may submit, and the value they must have.
+const (
+	// adminAttribute is held by the administrators of an organization, who set its keys and the
+	// configuration of the chaincode
+	adminAttribute = "xpn.admin"
+	// dpoAttribute is held by the data protection officers, who erase patients
+	dpoAttribute = "xpn.dpo"
+	grantedValue = "true"
+)
+
+// ---------------------------------------------------- AUTHORIZATION -------------------------------------------------------------- //
+// checkAttribute returns an error unless the caller holds attribute=true. action names the
+// transaction in the error, as in "Cannot erase patient".
+func checkAttribute(ctx contractapi.TransactionContextInterface, action string, attribute string) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(attribute, grantedValue)
+	if err != nil {
+		return fmt.Errorf("Cannot %s. Caller does not have the %s=%s attribute", action, attribute, grantedValue)
+	}
+
+	return nil
+}
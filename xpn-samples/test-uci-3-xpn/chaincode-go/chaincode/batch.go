@@ -0,0 +1,204 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/merkle"
+)
+
+// xpnBatchType is the composite key object type of xpn batches.
+const xpnBatchType = "XpnBatch"
+
+// xpnBatchTransientKey is the transient map key read by CreateXpnBatchTransient.
+const xpnBatchTransientKey = "xpnbatch"
+
+// XpnBatch anchors many XPN files with one transaction. Every file gets its own xpntransaction, listed
+// in Files, and the batch keeps the Merkle root over their (path, hash) pairs, built with the merkle
+// package, so single files can also be checked with inclusion proofs. The id is the transaction ID.
+type XpnBatch struct {
+	ID        string   `json:"ID"`
+	Root      string   `json:"Root"`
+	Leaves    int      `json:"Leaves"`
+	Partition string   `json:"Partition"`
+	Files     []string `json:"Files"`
+	Timestamp string   `json:"Timestamp"`
+}
+
+// xpnBatchFile is a file of a batch, anchored like with CreateXpnTransaction.
+type xpnBatchFile struct {
+	Hash          string `json:"hash"`
+	Path          string `json:"path"`
+	Patient       string `json:"patient"`
+	DocumentType  string `json:"documentType"`
+	Size          string `json:"size"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+}
+
+// xpnBatchInput is the transient payload of CreateXpnBatchTransient.
+type xpnBatchInput struct {
+	Files     []xpnBatchFile `json:"files"`
+	Partition string         `json:"partition"`
+}
+
+// ---------------------------------------------------- XpnBatch -------------------------------------------------------------- //
+// xpnBatchKey returns the world state key of the xpn batch with given id.
+func xpnBatchKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(xpnBatchType, []string{id})
+}
+
+// CreateXpnBatch anchors a batch of XPN files, given as a JSON array of objects with the hash, path,
+// patient, documentType, size and hashAlgorithm of each file, and returns the batch id. A file whose
+// path is anchored already gets a new version, the others a first one. The xpntransaction of the i-th
+// file has the id <batch id>.<i>.
+func (s *SmartContract) CreateXpnBatch(ctx contractapi.TransactionContextInterface, files string, partition string) (string, error) {
+	var batchFiles []xpnBatchFile
+	err := json.Unmarshal([]byte(files), &batchFiles)
+	if err != nil {
+		return "", fmt.Errorf("invalid files: %v", err)
+	}
+
+	return s.createXpnBatch(ctx, batchFiles, partition)
+}
+
+// CreateXpnBatchTransient anchors the batch passed in the transient map under the "xpnbatch" key, an
+// object with the files, as in CreateXpnBatch, and the partition, and returns the batch id.
+func (s *SmartContract) CreateXpnBatchTransient(ctx contractapi.TransactionContextInterface) (string, error) {
+	var input xpnBatchInput
+	err := readTransient(ctx, xpnBatchTransientKey, &input)
+	if err != nil {
+		return "", err
+	}
+
+	return s.createXpnBatch(ctx, input.Files, input.Partition)
+}
+
+// createXpnBatch anchors files as the batch with the transaction ID as id.
+func (s *SmartContract) createXpnBatch(ctx contractapi.TransactionContextInterface, files []xpnBatchFile, partition string) (string, error) {
+	if len(files) == 0 {
+		return "", fmt.Errorf("Files must be non-empty")
+	}
+	if strings.TrimSpace(partition) == "" {
+		return "", fmt.Errorf("Partition must be non-empty")
+	}
+
+	id := ctx.GetStub().GetTxID()
+	batch := XpnBatch{
+		ID:        id,
+		Leaves:    len(files),
+		Partition: partition,
+	}
+
+	// the world state does not show the writes of this transaction, so a path can only be anchored once
+	entries := make([]merkle.Entry, 0, len(files))
+	paths := make(map[string]bool, len(files))
+	for i, file := range files {
+		fileID := fmt.Sprintf("%s.%d", id, i)
+
+		path := canonicalXpnPath(file.Path)
+		if paths[path] {
+			return "", fmt.Errorf("Cannot create xpnbatch. Path %s is part of the batch more than once", path)
+		}
+		paths[path] = true
+
+		current, err := s.currentXpnTransaction(ctx, path)
+		if err != nil {
+			return "", err
+		}
+		hashAlgorithm := file.HashAlgorithm
+		if current != nil {
+			hashAlgorithm = current.HashAlgorithm
+		}
+		m, err := parseXpnHash(file.Hash, hashAlgorithm)
+		if err != nil {
+			return "", fmt.Errorf("failed to anchor %s: %v", path, err)
+		}
+
+		if current != nil {
+			_, err = s.updateXpnTransaction(ctx, fileID, path, file.Hash, file.Size, xpnChunks{}, nil, xpnSignature{}, id)
+		} else {
+			err = s.createXpnTransaction(ctx, fileID, file.Hash, path, file.Patient, file.DocumentType, file.Size,
+				file.HashAlgorithm, partition, xpnChunks{}, nil, xpnSignature{}, id)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to anchor %s: %v", path, err)
+		}
+
+		entries = append(entries, merkle.Entry{Path: path, Hash: m.String()})
+		batch.Files = append(batch.Files, fileID)
+	}
+
+	tree, err := merkle.New(entries)
+	if err != nil {
+		return "", fmt.Errorf("invalid files: %v", err)
+	}
+	batch.Root = tree.Root()
+
+	key, err := xpnBatchKey(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	ts, err := txTimestamp(ctx)
+	if err != nil {
+		return "", err
+	}
+	batch.Timestamp = ts.Format(timestampLayout)
+
+	batchJSON, err := json.Marshal(batch)
+	if err != nil {
+		return "", err
+	}
+	err = ctx.GetStub().PutState(key, batchJSON)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// ReadXpnBatch returns the xpn batch stored in the world state with given id.
+func (s *SmartContract) ReadXpnBatch(ctx contractapi.TransactionContextInterface, id string) (*XpnBatch, error) {
+	key, err := xpnBatchKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	batchJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xpnbatch from world state: %v", err)
+	}
+	if batchJSON == nil {
+		return nil, fmt.Errorf("the xpnbatch %s does not exist", id)
+	}
+
+	var batch XpnBatch
+	err = json.Unmarshal(batchJSON, &batch)
+	if err != nil {
+		return nil, err
+	}
+
+	return &batch, nil
+}
+
+// VerifyXpnBatchProof reports whether proof, a JSON encoded merkle.Proof, shows that a file is part
+// of the xpn batch with given id.
+func (s *SmartContract) VerifyXpnBatchProof(ctx contractapi.TransactionContextInterface, id string, proof string) (bool, error) {
+	batch, err := s.ReadXpnBatch(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	var merkleProof merkle.Proof
+	err = json.Unmarshal([]byte(proof), &merkleProof)
+	if err != nil {
+		return false, fmt.Errorf("invalid proof: %v", err)
+	}
+	if len(merkleProof.Steps) > 64 {
+		return false, fmt.Errorf("invalid proof: too many steps")
+	}
+
+	return merkle.Verify(batch.Root, merkleProof)
+}
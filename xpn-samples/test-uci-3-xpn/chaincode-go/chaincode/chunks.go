@@ -0,0 +1,82 @@
+package chaincode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// maxXpnChunks bounds the number of chunk hashes stored in an xpntransaction.
+const maxXpnChunks = 4096
+
+// xpnChunks are the optional chunk hashes passed when a file is anchored. Size is empty
+// when no chunk hashes are given.
+type xpnChunks struct {
+	Size   string   `json:"chunkSize"`
+	Hashes []string `json:"chunkHashes"`
+}
+
+// ---------------------------------------------------- XpnTransaction CHUNKS -------------------------------------------------------------- //
+// setXpnChunks stores the chunk hashes in xpntransaction, converting bare digests to multihashes of
+// the xpntransaction hash algorithm.
+func setXpnChunks(xpntransaction *XpnTransaction, chunks xpnChunks) error {
+	xpntransaction.ChunkSize = 0
+	xpntransaction.ChunkHashes = nil
+	if strings.TrimSpace(chunks.Size) == "" {
+		if len(chunks.Hashes) > 0 {
+			return fmt.Errorf("chunkSize must be set with chunkHashes")
+		}
+		return nil
+	}
+
+	chunkSize, err := strconv.ParseInt(chunks.Size, 10, 64)
+	if err != nil || chunkSize <= 0 {
+		return fmt.Errorf("invalid chunkSize: %s, must be a positive number", chunks.Size)
+	}
+
+	xpntransaction.ChunkSize = chunkSize
+	for i, hash := range chunks.Hashes {
+		m, err := multihash.Parse(hash, xpntransaction.HashAlgorithm)
+		if err != nil {
+			return fmt.Errorf("invalid hash of chunk %d: %v", i, err)
+		}
+		xpntransaction.ChunkHashes = append(xpntransaction.ChunkHashes, m.String())
+	}
+
+	return nil
+}
+
+// validateXpnChunks checks that the chunk hashes of xpntransaction cover its file.
+func validateXpnChunks(xpntransaction XpnTransaction) error {
+	if xpntransaction.ChunkSize == 0 {
+		if len(xpntransaction.ChunkHashes) > 0 {
+			return fmt.Errorf("ChunkSize must be set with ChunkHashes")
+		}
+		return nil
+	}
+	if xpntransaction.ChunkSize < 0 {
+		return fmt.Errorf("ChunkSize must be a positive number")
+	}
+
+	expected := (xpntransaction.Size + xpntransaction.ChunkSize - 1) / xpntransaction.ChunkSize
+	if expected > maxXpnChunks {
+		return fmt.Errorf("a file of %d bytes has more than %d chunks of %d bytes", xpntransaction.Size, maxXpnChunks, xpntransaction.ChunkSize)
+	}
+	if int64(len(xpntransaction.ChunkHashes)) != expected {
+		return fmt.Errorf("a file of %d bytes has %d chunks of %d bytes, got %d chunk hashes", xpntransaction.Size, expected, xpntransaction.ChunkSize, len(xpntransaction.ChunkHashes))
+	}
+
+	for i, hash := range xpntransaction.ChunkHashes {
+		m, err := multihash.Decode(hash)
+		if err != nil {
+			return fmt.Errorf("hash of chunk %d must be a multihash: %v", i, err)
+		}
+		if m.Name != xpntransaction.HashAlgorithm {
+			return fmt.Errorf("hash of chunk %d is a %s multihash, not %s", i, m.Name, xpntransaction.HashAlgorithm)
+		}
+	}
+
+	return nil
+}
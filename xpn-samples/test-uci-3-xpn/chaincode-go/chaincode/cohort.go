@@ -0,0 +1,477 @@
+package chaincode
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// cohortConfigType is the composite key object type used for the cohort query configuration.
+const cohortConfigType = "CohortConfig"
+
+// cohortCollection is the private data collection holding the generalized quasi-identifiers of the
+// patients under their pseudonym. Unlike the patient details collection it includes Org3, so that every
+// organization can group cohort statistics by birthPlace and ageBand. It is defined in
+// collections_config.json.
+const cohortCollection = "cohortCollection"
+
+// cohortAttributesType is the composite key object type of the cohort attributes of a patient.
+const cohortAttributesType = "CohortAttributes"
+
+// cohortNoiseCollection is the private data collection holding the secret the noise of the cohort
+// statistics is derived from. Its members do not restrict reads to members, so clients of every
+// organization can evaluate noisy queries on their peers; the chaincode never returns the secret.
+const cohortNoiseCollection = "cohortNoiseCollection"
+
+// noiseSecretKey is the key of the noise secret in the cohort noise collection, and the transient map
+// key SetCohortNoiseSecret reads it from.
+const noiseSecretKey = "noiseSecret"
+
+// defaultCohortMinimumSize is the k used while no minimum group size has been configured.
+const defaultCohortMinimumSize = 5
+
+// ageBandWidth is the width in years of the age bands used to group patients.
+const ageBandWidth = 10
+
+// CohortStatistic holds aggregate values for one group of patients. Excluded is the number of
+// patients of all groups left out of the query because their measurements are kept off the ledger
+// in pointer records; it is the same in every group.
+type CohortStatistic struct {
+	Group    string  `json:"Group"`
+	Count    float64 `json:"Count"`
+	Sum      float64 `json:"Sum"`
+	Mean     float64 `json:"Mean"`
+	P50      float64 `json:"P50"`
+	P90      float64 `json:"P90"`
+	Excluded float64 `json:"Excluded"`
+}
+
+// CohortAttributes are the quasi-identifiers of a patient, generalized to what cohort queries group by:
+// the birth place and the year of birth.
+type CohortAttributes struct {
+	BirthPlace string `json:"BirthPlace"`
+	BirthYear  int    `json:"BirthYear"`
+}
+
+// ---------------------------------------------------- COHORT ATTRIBUTES -------------------------------------------------------------- //
+// putCohortAttributes stores the cohort attributes of a patient in the cohort collection, under the
+// pseudonym of the patient.
+func (s *SmartContract) putCohortAttributes(ctx contractapi.TransactionContextInterface, patient Patient) error {
+	born, err := time.Parse("02-01-2006", patient.BirthDate)
+	if err != nil {
+		return fmt.Errorf("invalid birth date: %s", patient.BirthDate)
+	}
+	pseudonym, err := s.storedPseudonym(ctx, patient.ID)
+	if err != nil {
+		return err
+	}
+
+	key, err := privateCompositeKey(ctx, cohortAttributesType, pseudonym)
+	if err != nil {
+		return err
+	}
+	attributesJSON, err := json.Marshal(CohortAttributes{BirthPlace: patient.BirthPlace, BirthYear: born.Year()})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(cohortCollection, key, attributesJSON)
+}
+
+// cohortAttributesOf returns the cohort attributes stored under a pseudonym, or nil.
+func cohortAttributesOf(ctx contractapi.TransactionContextInterface, pseudonym string) (*CohortAttributes, error) {
+	key, err := privateCompositeKey(ctx, cohortAttributesType, pseudonym)
+	if err != nil {
+		return nil, err
+	}
+	attributesJSON, err := ctx.GetStub().GetPrivateData(cohortCollection, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cohort attributes: %v", err)
+	}
+	if attributesJSON == nil {
+		return nil, nil
+	}
+
+	var attributes CohortAttributes
+	err = json.Unmarshal(attributesJSON, &attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attributes, nil
+}
+
+// purgeCohortAttributes removes the cohort attributes stored under a pseudonym.
+func purgeCohortAttributes(ctx contractapi.TransactionContextInterface, pseudonym string) error {
+	key, err := privateCompositeKey(ctx, cohortAttributesType, pseudonym)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PurgePrivateData(cohortCollection, key)
+}
+
+// ---------------------------------------------------- COHORT STATISTICS -------------------------------------------------------------- //
+// SetCohortMinimumSize sets k, the smallest group size GetCohortStatistics returns. Only administrators,
+// identities with the xpn.admin=true attribute, can set it.
+func (s *SmartContract) SetCohortMinimumSize(ctx contractapi.TransactionContextInterface, k string) error {
+	err := checkAttribute(ctx, "set cohort minimum size", adminAttribute)
+	if err != nil {
+		return err
+	}
+
+	kInt, err := strconv.Atoi(k)
+	if err != nil || kInt < 2 {
+		return fmt.Errorf("invalid k: %s, must be an integer of at least 2", k)
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(cohortConfigType, []string{"minimumSize"})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte(strconv.Itoa(kInt)))
+}
+
+// GetCohortMinimumSize returns k, the smallest group size GetCohortStatistics returns.
+func (s *SmartContract) GetCohortMinimumSize(ctx contractapi.TransactionContextInterface) (int, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(cohortConfigType, []string{"minimumSize"})
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cohort configuration from world state: %v", err)
+	}
+	if value == nil {
+		return defaultCohortMinimumSize, nil
+	}
+
+	return strconv.Atoi(string(value))
+}
+
+// SetCohortNoiseSecret stores the secret the noise of GetCohortStatistics is derived from, passed in the
+// transient map under "noiseSecret", in the cohort noise collection. Only administrators can set it, and
+// it cannot be replaced once set, because fresh noise for the same queries could be averaged out.
+func (s *SmartContract) SetCohortNoiseSecret(ctx contractapi.TransactionContextInterface) error {
+	err := checkAttribute(ctx, "set cohort noise secret", adminAttribute)
+	if err != nil {
+		return err
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient map: %v", err)
+	}
+	secret, ok := transientMap[noiseSecretKey]
+	if !ok || len(secret) < 32 {
+		return fmt.Errorf("%s must be a secret of at least 32 bytes in the transient map", noiseSecretKey)
+	}
+
+	existing, err := ctx.GetStub().GetPrivateDataHash(cohortNoiseCollection, noiseSecretKey)
+	if err != nil {
+		return fmt.Errorf("failed to read cohort noise secret: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("Cannot set cohort noise secret. The cohort noise secret already exists")
+	}
+
+	return ctx.GetStub().PutPrivateData(cohortNoiseCollection, noiseSecretKey, secret)
+}
+
+// vitalValue returns the named measurement of rtData.
+func vitalValue(rtData *RTData, metric string) (float64, error) {
+	switch metric {
+	case "OxygenSaturation":
+		return rtData.OxygenSaturation, nil
+	case "PulseRate":
+		return rtData.PulseRate, nil
+	case "Temperature":
+		return rtData.Temperature, nil
+	case "BloodPressureSystolic":
+		return rtData.BloodPressureSystolic, nil
+	case "BloodPressureDiastolic":
+		return rtData.BloodPressureDiastolic, nil
+	}
+
+	return 0, fmt.Errorf("invalid metric: %s", metric)
+}
+
+// alertCount returns the number of alerts raised in a diagnosis.
+func alertCount(diagnosis *Diagnosis) float64 {
+	count := 0.0
+	for _, d := range []string{diagnosis.OxygenSaturationDiagnosis, diagnosis.PulseRateDiagnosis,
+		diagnosis.TemperatureDiagnosis, diagnosis.BloodPressureDiagnosis} {
+		if strings.HasPrefix(d, "Alert") {
+			count++
+		}
+	}
+
+	return count
+}
+
+// ageBand returns the age band, e.g. "40-49", of a patient born in birthYear at time now. Only the
+// year of birth is known, so the age is the one the patient reaches in the year of now.
+func ageBand(birthYear int, now time.Time) string {
+	low := (now.Year() - birthYear) / ageBandWidth * ageBandWidth
+
+	return fmt.Sprintf("%d-%d", low, low+ageBandWidth-1)
+}
+
+// contractTemplate returns a label identifying the limits of a contract, so that patients
+// sharing the same limits fall in the same group.
+func contractTemplate(contract *Contract) string {
+	limit := func(min float64, max float64) string {
+		return strconv.FormatFloat(min, 'f', -1, 64) + "-" + strconv.FormatFloat(max, 'f', -1, 64)
+	}
+
+	return strings.Join([]string{
+		limit(contract.MinOxygenSaturation, contract.MaxOxygenSaturation),
+		limit(contract.MinPulseRate, contract.MaxPulseRate),
+		limit(contract.MinTemperature, contract.MaxTemperature),
+		limit(contract.MinBloodPressureSystolic, contract.MaxBloodPressureSystolic),
+		limit(contract.MinBloodPressureDiastolic, contract.MaxBloodPressureDiastolic),
+	}, "/")
+}
+
+// cohortGroup returns the group a pseudonymised patient belongs to.
+func (s *SmartContract) cohortGroup(ctx contractapi.TransactionContextInterface, pseudonym string, groupBy string, now time.Time) (string, error) {
+	switch groupBy {
+	case "":
+		return "all", nil
+	case "contractTemplate":
+		contract, err := s.ReadContract(ctx, "C"+pseudonym)
+		if err != nil {
+			return "", err
+		}
+		return contractTemplate(contract), nil
+	case "birthPlace", "ageBand":
+		attributes, err := cohortAttributesOf(ctx, pseudonym)
+		if err != nil {
+			return "", err
+		}
+		if attributes == nil {
+			return "", fmt.Errorf("no cohort attributes available for pseudonym %s", pseudonym)
+		}
+		if groupBy == "birthPlace" {
+			return attributes.BirthPlace, nil
+		}
+		return ageBand(attributes.BirthYear, now), nil
+	}
+
+	return "", fmt.Errorf("invalid groupBy: %s, required: birthPlace, ageBand, contractTemplate or empty", groupBy)
+}
+
+// percentile returns the nearest-rank percentile p of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// cohortNoise derives the noise of a query from the cohort noise secret, the query and the values the
+// noisy value is computed from. Repeating a query over the same state returns the same noise, so
+// averaging the answers does not remove it, and the caller cannot recompute it without the secret.
+type cohortNoise struct {
+	secret []byte
+	query  string
+}
+
+// laplace returns Laplace distributed noise with the given scale for the value named label.
+func (n *cohortNoise) laplace(scale float64, label string, values []float64) float64 {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write([]byte(n.query + "\x00" + label))
+	for _, value := range values {
+		mac.Write([]byte("\x00" + strconv.FormatFloat(value, 'g', -1, 64)))
+	}
+	// uniform in [0, 1) with 53 bits of precision
+	u := float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11)/(1<<53) - 0.5
+
+	sign := 1.0
+	if u < 0 {
+		sign = -1.0
+	}
+
+	return -scale * sign * math.Log(1-2*math.Abs(u))
+}
+
+// newCohortNoise returns the noise source of a query, or an error when no noise secret is set.
+func newCohortNoise(ctx contractapi.TransactionContextInterface, query ...string) (*cohortNoise, error) {
+	secret, err := ctx.GetStub().GetPrivateData(cohortNoiseCollection, noiseSecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cohort noise secret: %v", err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("Cannot add noise. No cohort noise secret is set")
+	}
+
+	return &cohortNoise{secret: secret, query: strings.Join(query, "\x00")}, nil
+}
+
+// releasedGroups returns the names of the groups that can be returned, in order. Groups smaller than
+// k are suppressed, and so are the next smallest groups until the suppressed groups hold at least k
+// patients together, because their count and sum could otherwise be derived by subtracting the
+// returned groups from the total of a query without groupBy.
+func releasedGroups(groups map[string][]float64, k int) []string {
+	var names []string
+	suppressed := 0
+	for name, groupValues := range groups {
+		if len(groupValues) >= k {
+			names = append(names, name)
+		} else {
+			suppressed += len(groupValues)
+		}
+	}
+
+	// smallest first, so the complementary suppression removes as little as possible
+	sort.Slice(names, func(i, j int) bool {
+		if len(groups[names[i]]) != len(groups[names[j]]) {
+			return len(groups[names[i]]) < len(groups[names[j]])
+		}
+		return names[i] < names[j]
+	})
+	for suppressed > 0 && suppressed < k && len(names) > 0 {
+		suppressed += len(groups[names[0]])
+		names = names[1:]
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// GetCohortStatistics returns the count, sum, mean and 50th and 90th percentiles of a metric per
+// group of patients. metric is a measurement name of RTData, e.g. "PulseRate", or "Alerts" for the
+// number of alerts per diagnosis. groupBy is "birthPlace", "ageBand", "contractTemplate" or empty for
+// a single group. Groups smaller than the configured minimum size k are left out, together with
+// as many other groups as needed to keep them from being derived from the total, see releasedGroups.
+// Grouping by birthPlace or ageBand reads the cohort collection, see CohortAttributes. The measurements
+// of pointer records (CreateRTDataPointer) are stored off the ledger, so their patients are left out
+// of vital sign metrics and counted in Excluded.
+//
+// When noiseScale is positive, Laplace noise of that scale is added to every returned value. The noise
+// is derived from the secret set with SetCohortNoiseSecret, the query and the values of the group, see
+// cohortNoise, so the query must be evaluated on a peer of a member of the cohort noise collection.
+func (s *SmartContract) GetCohortStatistics(ctx contractapi.TransactionContextInterface, metric string, groupBy string, noiseScale string) ([]*CohortStatistic, error) {
+	scale := 0.0
+	if strings.TrimSpace(noiseScale) != "" {
+		var err error
+		scale, err = strconv.ParseFloat(noiseScale, 64)
+		if err != nil || scale < 0 {
+			return nil, fmt.Errorf("invalid noiseScale: %s", noiseScale)
+		}
+	}
+
+	k, err := s.GetCohortMinimumSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var noise *cohortNoise
+	if scale > 0 {
+		noise, err = newCohortNoise(ctx, metric, groupBy, strconv.FormatFloat(scale, 'g', -1, 64))
+		if err != nil {
+			return nil, err
+		}
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// pseudonym -> value of the metric
+	values := make(map[string]float64)
+	excluded := 0
+	if metric == "Alerts" {
+		allDiagnosis, err := s.GetAllDiagnosis(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, diagnosis := range allDiagnosis {
+			if !diagnosis.Erased {
+				values[diagnosis.Patient] = alertCount(diagnosis)
+			}
+		}
+	} else {
+		measurements, err := s.GetAllRTData(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, rtData := range measurements {
+			if rtData.Erased {
+				continue
+			}
+			// the measurements of pointer records are kept off the ledger
+			if rtData.Pointer {
+				excluded++
+				continue
+			}
+			value, err := vitalValue(rtData, metric)
+			if err != nil {
+				return nil, err
+			}
+			values[rtData.Patient] = value
+		}
+	}
+
+	groups := make(map[string][]float64)
+	for pseudonym, value := range values {
+		group, err := s.cohortGroup(ctx, pseudonym, groupBy, now)
+		if err != nil {
+			return nil, err
+		}
+		groups[group] = append(groups[group], value)
+	}
+
+	names := releasedGroups(groups, k)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("Cannot return cohort statistics. No group has at least %d patients", k)
+	}
+
+	excludedValue := float64(excluded)
+	if noise != nil {
+		excludedValue += noise.laplace(scale, "Excluded", []float64{excludedValue})
+	}
+
+	var statistics []*CohortStatistic
+	for _, name := range names {
+		groupValues := groups[name]
+		sort.Float64s(groupValues)
+
+		sum := 0.0
+		for _, v := range groupValues {
+			sum += v
+		}
+		statistic := CohortStatistic{
+			Group:    name,
+			Count:    float64(len(groupValues)),
+			Sum:      sum,
+			Mean:     sum / float64(len(groupValues)),
+			P50:      percentile(groupValues, 50),
+			P90:      percentile(groupValues, 90),
+			Excluded: excludedValue,
+		}
+
+		if noise != nil {
+			fields := map[string]*float64{"Count": &statistic.Count, "Sum": &statistic.Sum, "Mean": &statistic.Mean,
+				"P50": &statistic.P50, "P90": &statistic.P90}
+			for field, value := range fields {
+				*value += noise.laplace(scale, name+"\x00"+field, groupValues)
+			}
+		}
+		statistics = append(statistics, &statistic)
+	}
+
+	return statistics, nil
+}
@@ -0,0 +1,473 @@
+package chaincode
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// Keys of the transient map read by the encrypted patient functions.
+const (
+	dataKeyTransientKey     = "dataKey"
+	wrappingKeyTransientKey = "wrappingKey"
+)
+
+// orgWrappingKeyType is the composite key object type of the X25519 public keys the organizations
+// registered to receive the data keys of encrypted patients.
+const orgWrappingKeyType = "OrgWrappingKey"
+
+// OrgWrappingKey is the X25519 public key of an organization, base64 encoded. The private key never
+// leaves the organization.
+type OrgWrappingKey struct {
+	MSP       string `json:"MSP"`
+	PublicKey string `json:"PublicKey"`
+}
+
+// ---------------------------------------------------- CRYPTO-SHREDDING -------------------------------------------------------------- //
+// implicitOrgCollection returns the name of the implicit private data collection of an organization.
+func implicitOrgCollection(msp string) string {
+	return "_implicit_org_" + msp
+}
+
+// deterministicNonce derives an AES-GCM nonce from the transaction ID and a label, so that every
+// endorsing peer produces the same ciphertext. A data key is only used once per transaction and label.
+func deterministicNonce(ctx contractapi.TransactionContextInterface, label string) []byte {
+	sum := sha256.Sum256([]byte(label + ctx.GetStub().GetTxID()))
+	return sum[:12]
+}
+
+// seal encrypts plaintext with AES-GCM and returns the nonce followed by the ciphertext.
+func seal(key []byte, nonce []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(append([]byte{}, nonce...), nonce, plaintext, nil), nil
+}
+
+// open decrypts the output of seal.
+func open(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// keyEncryptionKey derives the key that wraps a data key from an X25519 shared secret and the two
+// public keys of the exchange.
+func keyEncryptionKey(shared []byte, ephemeral *ecdh.PublicKey, recipient *ecdh.PublicKey) []byte {
+	sum := sha256.Sum256(append(append(append([]byte{}, shared...), ephemeral.Bytes()...), recipient.Bytes()...))
+	return sum[:]
+}
+
+// wrapDataKey encrypts a data key to the X25519 public key of msp and returns the ephemeral public
+// key followed by the sealed data key. The ephemeral private key is derived from the data key and the
+// transaction ID, so that every endorsing peer produces the same wrapped key without the chaincode
+// holding a secret of its own.
+func wrapDataKey(ctx contractapi.TransactionContextInterface, dataKey []byte, msp string, recipient *ecdh.PublicKey) ([]byte, error) {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("wrap" + msp + ctx.GetStub().GetTxID()))
+	ephemeral, err := ecdh.X25519().NewPrivateKey(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(keyEncryptionKey(shared, ephemeral.PublicKey(), recipient), deterministicNonce(ctx, "wrap"+msp), dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ephemeral.PublicKey().Bytes(), sealed...), nil
+}
+
+// unwrapDataKey decrypts the output of wrapDataKey with the X25519 private key of the recipient.
+func unwrapDataKey(recipient *ecdh.PrivateKey, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 32 {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(wrapped[:32])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(keyEncryptionKey(shared, ephemeral, recipient.PublicKey()), wrapped[32:])
+}
+
+// SetOrgWrappingKey registers the base64 encoded X25519 public key of the caller's organization, to
+// which the data keys of patients encrypted from then on are wrapped. Only administrators, identities
+// with the xpn.admin=true attribute, can set the key of their organization.
+func (s *SmartContract) SetOrgWrappingKey(ctx contractapi.TransactionContextInterface, publicKey string) error {
+	err := checkAttribute(ctx, "set wrapping key", adminAttribute)
+	if err != nil {
+		return err
+	}
+
+	keyBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return fmt.Errorf("wrapping key must be base64 encoded: %v", err)
+	}
+	_, err = ecdh.X25519().NewPublicKey(keyBytes)
+	if err != nil {
+		return fmt.Errorf("wrapping key must be an X25519 public key: %v", err)
+	}
+
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(orgWrappingKeyType, []string{msp})
+	if err != nil {
+		return err
+	}
+	keyJSON, err := json.Marshal(OrgWrappingKey{MSP: msp, PublicKey: publicKey})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, keyJSON)
+}
+
+// orgWrappingKeys returns the registered wrapping keys, sorted by MSP ID.
+func orgWrappingKeys(ctx contractapi.TransactionContextInterface) ([]OrgWrappingKey, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orgWrappingKeyType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var keys []OrgWrappingKey
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var key OrgWrappingKey
+		err = json.Unmarshal(queryResponse.Value, &key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// CreateEncryptedPatient creates a new patient whose identifiable fields are encrypted in the world
+// state with a per-patient data key, instead of being stored in the patient details collection.
+// The transient map holds the patient under "patient", the 32 byte data key under "dataKey" and the
+// random salt of the details hash under "salt". The data key is wrapped to the public key of every
+// organization registered with SetOrgWrappingKey, the caller's included, and stored in the implicit
+// collection of that organization.
+func (s *SmartContract) CreateEncryptedPatient(ctx contractapi.TransactionContextInterface) error {
+	var input patientInput
+	err := readTransient(ctx, patientTransientKey, &input)
+	if err != nil {
+		return err
+	}
+
+	wrappingKeys, err := orgWrappingKeys(ctx)
+	if err != nil {
+		return err
+	}
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+	registered := false
+	for _, wrappingKey := range wrappingKeys {
+		registered = registered || wrappingKey.MSP == msp
+	}
+	if !registered {
+		return fmt.Errorf("Cannot create encrypted patient. %s has not set a wrapping key", msp)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient map: %v", err)
+	}
+	dataKey, ok := transientMap[dataKeyTransientKey]
+	if !ok || len(dataKey) != 32 {
+		return fmt.Errorf("%s must be a 32 byte key in the transient map", dataKeyTransientKey)
+	}
+
+	weightFloat, err := strconv.ParseFloat(input.Weight, 64)
+	if err != nil {
+		return fmt.Errorf("invalid weight: %s", input.Weight)
+	}
+	heightFloat, err := strconv.ParseFloat(input.Height, 64)
+	if err != nil {
+		return fmt.Errorf("invalid height: %s", input.Height)
+	}
+
+	exists, err := s.PatientExists(ctx, input.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Cannot create patient. Patient with id %s already exists", input.ID)
+	}
+
+	patient := Patient{
+		ID:         input.ID,
+		FirstName:  input.FirstName,
+		MiddleName: input.MiddleName,
+		LastName:   input.LastName,
+		BirthDate:  input.BirthDate,
+		BirthPlace: input.BirthPlace,
+		Weight:     weightFloat,
+		Height:     heightFloat,
+	}
+
+	// validate the patient
+	err = s.validatePatient(patient)
+	if err != nil {
+		return err
+	}
+
+	err = s.assignPseudonym(ctx, patient.ID)
+	if err != nil {
+		return err
+	}
+	err = s.putCohortAttributes(ctx, patient)
+	if err != nil {
+		return err
+	}
+
+	salt, err := newSalt(ctx)
+	if err != nil {
+		return err
+	}
+
+	details := PatientDetails{
+		ID:         patient.ID,
+		FirstName:  patient.FirstName,
+		MiddleName: patient.MiddleName,
+		LastName:   patient.LastName,
+		BirthDate:  patient.BirthDate,
+		BirthPlace: patient.BirthPlace,
+		Salt:       salt,
+	}
+	detailsHash, err := hashPatientDetails(details)
+	if err != nil {
+		return err
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	encryptedDetails, err := seal(dataKey, deterministicNonce(ctx, "details"), detailsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt patient details: %v", err)
+	}
+
+	var keyHolders []string
+	for _, wrappingKey := range wrappingKeys {
+		publicKey, err := base64.StdEncoding.DecodeString(wrappingKey.PublicKey)
+		if err != nil {
+			return err
+		}
+		recipient, err := ecdh.X25519().NewPublicKey(publicKey)
+		if err != nil {
+			return err
+		}
+
+		wrappedKey, err := wrapDataKey(ctx, dataKey, wrappingKey.MSP, recipient)
+		if err != nil {
+			return fmt.Errorf("failed to wrap data key for %s: %v", wrappingKey.MSP, err)
+		}
+		err = ctx.GetStub().PutPrivateData(implicitOrgCollection(wrappingKey.MSP), input.ID, wrappedKey)
+		if err != nil {
+			return fmt.Errorf("failed to put wrapped data key for %s: %v", wrappingKey.MSP, err)
+		}
+		keyHolders = append(keyHolders, wrappingKey.MSP)
+	}
+
+	public := Patient{
+		ID:               patient.ID,
+		Weight:           patient.Weight,
+		Height:           patient.Height,
+		DetailsHash:      detailsHash,
+		EncryptedDetails: base64.StdEncoding.EncodeToString(encryptedDetails),
+		KeyHolders:       keyHolders,
+	}
+
+	publicJSON, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(patient.ID, publicJSON)
+}
+
+// ReadPatientDecrypted returns an encrypted patient with its identifiable fields decrypted. The caller
+// passes the X25519 private key of its organization in the transient map under "wrappingKey"; the data
+// key is read from the implicit collection of the caller's organization, so this must be evaluated on
+// a peer of that organization. Like ReadPatient it requires an access record of the caller, see
+// AuditedReadPatient.
+func (s *SmartContract) ReadPatientDecrypted(ctx contractapi.TransactionContextInterface, id string) (*Patient, error) {
+	patientJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patient from world state: %v", err)
+	}
+	if patientJSON == nil {
+		return nil, fmt.Errorf("Cannot read patient. Patient with id %s does not exist", id)
+	}
+
+	var patient Patient
+	err = json.Unmarshal(patientJSON, &patient)
+	if err != nil {
+		return nil, err
+	}
+	if patient.EncryptedDetails == "" {
+		return nil, fmt.Errorf("Cannot decrypt patient. Patient with id %s is not encrypted", id)
+	}
+	if patient.Shredded {
+		return nil, fmt.Errorf("Cannot decrypt patient. The data key of patient %s has been destroyed", id)
+	}
+	recorded, err := s.accessRecorded(ctx, id, id)
+	if err != nil {
+		return nil, err
+	}
+	if !recorded {
+		return nil, fmt.Errorf("Cannot decrypt patient. No access to patient %s is recorded, submit AuditedReadPatient first", id)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient map: %v", err)
+	}
+	privateKey, ok := transientMap[wrappingKeyTransientKey]
+	if !ok {
+		return nil, fmt.Errorf("%s must be a key in the transient map", wrappingKeyTransientKey)
+	}
+	wrappingKey, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an X25519 private key: %v", wrappingKeyTransientKey, err)
+	}
+
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+	wrappedKey, err := ctx.GetStub().GetPrivateData(implicitOrgCollection(msp), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wrapped data key: %v", err)
+	}
+	if wrappedKey == nil {
+		return nil, fmt.Errorf("Cannot decrypt patient. No data key for patient %s is held by %s", id, msp)
+	}
+
+	dataKey, err := unwrapDataKey(wrappingKey, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	encryptedDetails, err := base64.StdEncoding.DecodeString(patient.EncryptedDetails)
+	if err != nil {
+		return nil, err
+	}
+	detailsJSON, err := open(dataKey, encryptedDetails)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt patient details: %v", err)
+	}
+
+	var details PatientDetails
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		return nil, err
+	}
+
+	patient.FirstName = details.FirstName
+	patient.MiddleName = details.MiddleName
+	patient.LastName = details.LastName
+	patient.BirthDate = details.BirthDate
+	patient.BirthPlace = details.BirthPlace
+
+	return &patient, nil
+}
+
+// shredPatientKeys purges the wrapped data keys of a patient from the implicit collections
+// of all key holders.
+func (s *SmartContract) shredPatientKeys(ctx contractapi.TransactionContextInterface, patient *Patient) error {
+	for _, msp := range patient.KeyHolders {
+		err := ctx.GetStub().PurgePrivateData(implicitOrgCollection(msp), patient.ID)
+		if err != nil {
+			return fmt.Errorf("failed to purge data key held by %s: %v", msp, err)
+		}
+	}
+
+	return nil
+}
+
+// ShredPatientKey destroys the data key of an encrypted patient, which makes every version of its
+// identifiable fields, including those in the ledger history, unreadable. The details hash is
+// cleared as well. Like ErasePatient it requires the xpn.dpo=true attribute.
+func (s *SmartContract) ShredPatientKey(ctx contractapi.TransactionContextInterface, id string) error {
+	err := checkAttribute(ctx, "shred patient key", dpoAttribute)
+	if err != nil {
+		return err
+	}
+
+	patientJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return fmt.Errorf("failed to read patient from world state: %v", err)
+	}
+	if patientJSON == nil {
+		return fmt.Errorf("Cannot shred patient key. Patient with id %s does not exist", id)
+	}
+
+	var patient Patient
+	err = json.Unmarshal(patientJSON, &patient)
+	if err != nil {
+		return err
+	}
+	if patient.EncryptedDetails == "" {
+		return fmt.Errorf("Cannot shred patient key. Patient with id %s is not encrypted", id)
+	}
+
+	err = s.shredPatientKeys(ctx, &patient)
+	if err != nil {
+		return err
+	}
+
+	// the salted hash would still confirm guesses of the details
+	patient.KeyHolders = nil
+	patient.DetailsHash = ""
+	patient.Shredded = true
+	patientJSON, err = json.Marshal(patient)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(id, patientJSON)
+}
@@ -0,0 +1,298 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/manifest"
+)
+
+// erasureCertificateType is the composite key object type used for erasure certificates.
+const erasureCertificateType = "ErasureCertificate"
+
+// ErasureCertificate records what was erased for a patient and when. XpnManifests are the ids of the
+// manifests the entries of the erased files were removed from.
+type ErasureCertificate struct {
+	TxID         string   `json:"TxID"`
+	Patient      string   `json:"Patient"`
+	Keys         []string `json:"Keys"`
+	XpnFiles     []string `json:"XpnFiles"`
+	XpnManifests []string `json:"XpnManifests,omitempty"`
+	Timestamp    string   `json:"Timestamp"`
+}
+
+// ---------------------------------------------------- ERASURE -------------------------------------------------------------- //
+// eraseRecord replaces the record stored under key with the given tombstone.
+// It returns false when there is no record to erase.
+func eraseRecord(ctx contractapi.TransactionContextInterface, key string, tombstone interface{}) (bool, error) {
+	recordJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s from world state: %v", key, err)
+	}
+	if recordJSON == nil {
+		return false, nil
+	}
+
+	tombstoneJSON, err := json.Marshal(tombstone)
+	if err != nil {
+		return false, err
+	}
+
+	return true, ctx.GetStub().PutState(key, tombstoneJSON)
+}
+
+// eraseXpnTransactions flags the xpntransactions stored under a patient pseudonym as erased, so
+// that the off-chain deletion job removes the files, and purges their links to the patient. It
+// returns the paths of the flagged files.
+func (s *SmartContract) eraseXpnTransactions(ctx contractapi.TransactionContextInterface, pseudonym string) ([]string, error) {
+	xpntransactions, err := s.xpnTransactionsOfPatient(ctx, pseudonym)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, xpntransaction := range xpntransactions {
+		err = purgeXpnPatient(ctx, xpntransaction.ID, pseudonym)
+		if err != nil {
+			return nil, err
+		}
+		if xpntransaction.Erased {
+			continue
+		}
+
+		xpntransaction.Erased = true
+		err = s.putXpnTransaction(ctx, *xpntransaction)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, xpntransaction.Path)
+	}
+
+	return paths, nil
+}
+
+// eraseXpnManifests removes the entries of the erased files at paths from the manifests of the
+// directories holding them and of their parent directories, and flags those manifests as erased. It
+// returns the ids of the changed manifests.
+func (s *SmartContract) eraseXpnManifests(ctx contractapi.TransactionContextInterface, paths []string) ([]string, error) {
+	erased := make(map[string]bool)
+	directories := make(map[string]bool)
+	for _, file := range paths {
+		erased[file] = true
+		for directory := path.Dir(file); !directories[directory]; directory = path.Dir(directory) {
+			directories[directory] = true
+		}
+	}
+	sortedDirectories := make([]string, 0, len(directories))
+	for directory := range directories {
+		sortedDirectories = append(sortedDirectories, directory)
+	}
+	sort.Strings(sortedDirectories)
+
+	var ids []string
+	for _, directory := range sortedDirectories {
+		xpnmanifests, err := s.GetXpnManifestHistory(ctx, directory)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, xpnmanifest := range xpnmanifests {
+			var entries []manifest.Entry
+			for _, entry := range xpnmanifest.Entries {
+				if erased[path.Join(directory, entry.Path)] {
+					xpnmanifest.Size -= entry.Size
+					continue
+				}
+				entries = append(entries, entry)
+			}
+			if len(entries) == len(xpnmanifest.Entries) {
+				continue
+			}
+
+			xpnmanifest.Entries = entries
+			xpnmanifest.Erased = true
+			err = putXpnManifest(ctx, xpnmanifest)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, xpnmanifest.ID)
+		}
+	}
+
+	return ids, nil
+}
+
+// ErasePatient erases a patient: the public record is deleted, the private details and the pseudonym
+// mapping are purged, the contract, measurements and diagnosis are replaced by erased tombstones, the
+// XPN files are flagged for deletion and their entries are removed from the directory manifests. It
+// returns the erasure certificate, which is also stored on the ledger. Only data protection officers,
+// identities with the xpn.dpo=true attribute, can erase patients.
+func (s *SmartContract) ErasePatient(ctx contractapi.TransactionContextInterface, id string) (*ErasureCertificate, error) {
+	err := checkAttribute(ctx, "erase patient", dpoAttribute)
+	if err != nil {
+		return nil, err
+	}
+
+	patientJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patient from world state: %v", err)
+	}
+	if patientJSON == nil {
+		return nil, fmt.Errorf("Cannot erase patient. Patient with id %s does not exist", id)
+	}
+
+	// encrypted patients are erased by destroying their data key
+	var patient Patient
+	err = json.Unmarshal(patientJSON, &patient)
+	if err != nil {
+		return nil, err
+	}
+	err = s.shredPatientKeys(ctx, &patient)
+	if err != nil {
+		return nil, err
+	}
+	pseudonym, err := s.pseudonymFor(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PurgePrivateData(patientDetailsCollection, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge patient details from private data collection: %v", err)
+	}
+	err = ctx.GetStub().DelState(id)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{id}
+
+	tombstones := map[string]interface{}{
+		"C" + pseudonym:   Contract{ID: "C" + pseudonym, Patient: pseudonym, Erased: true},
+		"RTD" + pseudonym: RTData{ID: "RTD" + pseudonym, Patient: pseudonym, Erased: true},
+		"D" + pseudonym:   Diagnosis{ID: "D" + pseudonym, Patient: pseudonym, Erased: true},
+	}
+	for _, key := range []string{"C" + pseudonym, "RTD" + pseudonym, "D" + pseudonym} {
+		erased, err := eraseRecord(ctx, key, tombstones[key])
+		if err != nil {
+			return nil, err
+		}
+		if erased {
+			keys = append(keys, key)
+		}
+	}
+
+	err = purgeRTDataPointer(ctx, "RTD"+pseudonym)
+	if err != nil {
+		return nil, err
+	}
+	err = purgeCohortAttributes(ctx, pseudonym)
+	if err != nil {
+		return nil, err
+	}
+	// the pseudonym mapping goes last, nothing can be linked back to the patient afterwards
+	err = s.purgePseudonym(ctx, id, pseudonym)
+	if err != nil {
+		return nil, err
+	}
+
+	xpnFiles, err := s.eraseXpnTransactions(ctx, pseudonym)
+	if err != nil {
+		return nil, err
+	}
+	xpnManifests, err := s.eraseXpnManifests(ctx, xpnFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate := ErasureCertificate{
+		TxID:         ctx.GetStub().GetTxID(),
+		Patient:      id,
+		Keys:         keys,
+		XpnFiles:     xpnFiles,
+		XpnManifests: xpnManifests,
+		Timestamp:    ts.Format(timestampLayout),
+	}
+
+	certificateKey, err := ctx.GetStub().CreateCompositeKey(erasureCertificateType, []string{id, certificate.TxID})
+	if err != nil {
+		return nil, err
+	}
+	certificateJSON, err := json.Marshal(certificate)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(certificateKey, certificateJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificate, nil
+}
+
+// GetErasureCertificates returns the erasure certificates issued for a patient.
+func (s *SmartContract) GetErasureCertificates(ctx contractapi.TransactionContextInterface, patient string) ([]*ErasureCertificate, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(erasureCertificateType, []string{patient})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var certificates []*ErasureCertificate
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var certificate ErasureCertificate
+		err = json.Unmarshal(queryResponse.Value, &certificate)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, &certificate)
+	}
+
+	return certificates, nil
+}
+
+// GetErasedXpnTransactions returns the xpntransactions whose files are still waiting to be
+// deleted by the off-chain deletion job.
+func (s *SmartContract) GetErasedXpnTransactions(ctx contractapi.TransactionContextInterface) ([]*XpnTransaction, error) {
+	xpntransactions, err := s.GetAllXpnTransactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var erased []*XpnTransaction
+	for _, xpntransaction := range xpntransactions {
+		if xpntransaction.Erased && !xpntransaction.FileDeleted {
+			erased = append(erased, xpntransaction)
+		}
+	}
+
+	return erased, nil
+}
+
+// MarkXpnFileDeleted is called by the off-chain deletion job once the file of an erased
+// xpntransaction has been removed from XPN.
+func (s *SmartContract) MarkXpnFileDeleted(ctx contractapi.TransactionContextInterface, id string) error {
+	xpntransaction, err := s.ReadXpnTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !xpntransaction.Erased {
+		return fmt.Errorf("Cannot mark file as deleted. XpnTransaction with id %s is not erased", id)
+	}
+
+	xpntransaction.FileDeleted = true
+
+	return s.putXpnTransaction(ctx, *xpntransaction)
+}
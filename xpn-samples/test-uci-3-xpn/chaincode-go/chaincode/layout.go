@@ -0,0 +1,35 @@
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// XpnLayout is the layout of the XPN partition a file was written to, as configured in
+// xpn/config.xml when the file was anchored.
+type XpnLayout struct {
+	BlockSize        int64    `json:"BlockSize"`
+	ReplicationLevel int      `json:"ReplicationLevel"`
+	Servers          []string `json:"Servers"`
+}
+
+// ---------------------------------------------------- XpnTransaction LAYOUT -------------------------------------------------------------- //
+// validateXpnLayout checks that a partition layout is consistent.
+func validateXpnLayout(layout XpnLayout) error {
+	if layout.BlockSize <= 0 {
+		return fmt.Errorf("Layout BlockSize must be a positive number")
+	}
+	if len(layout.Servers) == 0 {
+		return fmt.Errorf("Layout Servers must be non-empty")
+	}
+	for _, server := range layout.Servers {
+		if strings.TrimSpace(server) == "" {
+			return fmt.Errorf("Layout Servers must be non-empty URLs")
+		}
+	}
+	if layout.ReplicationLevel < 0 || layout.ReplicationLevel >= len(layout.Servers) {
+		return fmt.Errorf("Layout ReplicationLevel must be between 0 and the number of servers minus one")
+	}
+
+	return nil
+}
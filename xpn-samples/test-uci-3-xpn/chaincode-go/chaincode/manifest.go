@@ -0,0 +1,203 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/manifest"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// Composite key object types of directory manifests and of their directory index.
+const (
+	xpnManifestType           = "XpnManifest"
+	xpnManifestDirectoryIndex = "XpnManifestDirectory"
+)
+
+// maxXpnManifestEntries bounds the number of files of a manifest, which is stored in full.
+const maxXpnManifestEntries = 10000
+
+// XpnManifest commits to the sorted list of (relative path, size, hash) of the files of an XPN
+// directory, e.g. /tmp/expand/xpn/<patient id>, at a point in time. Hash is the multihash of the
+// encoding of Entries, see package manifest. A manifest is Erased when ErasePatient removed the
+// entries of the erased files from it; Hash then commits to the entries it had when it was anchored.
+type XpnManifest struct {
+	ID            string           `json:"ID"`
+	Directory     string           `json:"Directory"`
+	Hash          string           `json:"Hash"`
+	HashAlgorithm string           `json:"HashAlgorithm"`
+	Entries       []manifest.Entry `json:"Entries"`
+	Size          int64            `json:"Size"`
+	Version       int              `json:"Version"`
+	PreviousID    string           `json:"PreviousID,omitempty"`
+	Creator       string           `json:"Creator"`
+	MSP           string           `json:"MSP"`
+	Timestamp     string           `json:"Timestamp"`
+	Erased        bool             `json:"Erased,omitempty"`
+}
+
+// ---------------------------------------------------- XPN DIRECTORY MANIFESTS -------------------------------------------------------------- //
+// CreateXpnManifest anchors the manifest of directory. entries is the JSON array of its files, sorted by
+// relative path, as built by package manifest. The manifest hash is computed here with SHA-256 and the
+// manifest becomes the next version of the directory. Its ID is the transaction ID.
+func (s *SmartContract) CreateXpnManifest(ctx contractapi.TransactionContextInterface, directory string, entries string) (*XpnManifest, error) {
+	if strings.TrimSpace(directory) == "" {
+		return nil, fmt.Errorf("Directory must be non-empty")
+	}
+	directory = canonicalXpnPath(directory)
+
+	var files []manifest.Entry
+	err := json.Unmarshal([]byte(entries), &files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest entries: %v", err)
+	}
+	if len(files) > maxXpnManifestEntries {
+		return nil, fmt.Errorf("Cannot create manifest. %d entries exceed the maximum of %d", len(files), maxXpnManifestEntries)
+	}
+	hash, err := manifest.Hash(files, multihash.Default)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create manifest of %s: %v", directory, err)
+	}
+
+	previous, err := s.GetLatestXpnManifest(ctx, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	creator, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read caller identity: %v", err)
+	}
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+	ts, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	xpnmanifest := XpnManifest{
+		ID:            ctx.GetStub().GetTxID(),
+		Directory:     directory,
+		Hash:          hash,
+		HashAlgorithm: multihash.Default,
+		Entries:       files,
+		Version:       1,
+		Creator:       creator,
+		MSP:           msp,
+		Timestamp:     ts.Format(timestampLayout),
+	}
+	for _, file := range files {
+		xpnmanifest.Size += file.Size
+	}
+	if previous != nil {
+		xpnmanifest.Version = previous.Version + 1
+		xpnmanifest.PreviousID = previous.ID
+	}
+
+	err = putXpnManifest(ctx, &xpnmanifest)
+	if err != nil {
+		return nil, err
+	}
+
+	directoryKey, err := ctx.GetStub().CreateCompositeKey(xpnManifestDirectoryIndex, []string{directory, xpnmanifest.ID})
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(directoryKey, []byte{0x00})
+	if err != nil {
+		return nil, err
+	}
+
+	return &xpnmanifest, nil
+}
+
+// putXpnManifest stores a manifest under its ID.
+func putXpnManifest(ctx contractapi.TransactionContextInterface, xpnmanifest *XpnManifest) error {
+	xpnmanifestJSON, err := json.Marshal(xpnmanifest)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(xpnManifestType, []string{xpnmanifest.ID})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, xpnmanifestJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put manifest: %v", err)
+	}
+
+	return nil
+}
+
+// ReadXpnManifest returns the manifest stored in the world state with given id.
+func (s *SmartContract) ReadXpnManifest(ctx contractapi.TransactionContextInterface, id string) (*XpnManifest, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(xpnManifestType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	xpnmanifestJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if xpnmanifestJSON == nil {
+		return nil, fmt.Errorf("the manifest %s does not exist", id)
+	}
+
+	var xpnmanifest XpnManifest
+	err = json.Unmarshal(xpnmanifestJSON, &xpnmanifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xpnmanifest, nil
+}
+
+// GetXpnManifestHistory returns the manifests of directory, oldest first.
+func (s *SmartContract) GetXpnManifestHistory(ctx contractapi.TransactionContextInterface, directory string) ([]*XpnManifest, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(xpnManifestDirectoryIndex, []string{canonicalXpnPath(directory)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	// index keys are ordered by manifest ID, not by version
+	var xpnmanifests []*XpnManifest
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		xpnmanifest, err := s.ReadXpnManifest(ctx, keyParts[len(keyParts)-1])
+		if err != nil {
+			return nil, err
+		}
+		xpnmanifests = append(xpnmanifests, xpnmanifest)
+	}
+
+	sort.Slice(xpnmanifests, func(i, j int) bool { return xpnmanifests[i].Version < xpnmanifests[j].Version })
+
+	return xpnmanifests, nil
+}
+
+// GetLatestXpnManifest returns the last manifest of directory, or nil when none was anchored.
+func (s *SmartContract) GetLatestXpnManifest(ctx contractapi.TransactionContextInterface, directory string) (*XpnManifest, error) {
+	xpnmanifests, err := s.GetXpnManifestHistory(ctx, directory)
+	if err != nil {
+		return nil, err
+	}
+	if len(xpnmanifests) == 0 {
+		return nil, nil
+	}
+
+	return xpnmanifests[len(xpnmanifests)-1], nil
+}
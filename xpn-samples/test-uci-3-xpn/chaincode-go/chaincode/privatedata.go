@@ -0,0 +1,190 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// patientDetailsCollection is the private data collection holding identifiable
+// patient data. It is defined in collections_config.json.
+const patientDetailsCollection = "patientDetailsCollection"
+
+// patientDetailsMembers are the organizations of the patient details collection policy in
+// collections_config.json. The collection only lets members read it, and the chaincode
+// checks the caller as well before returning identifiable data.
+var patientDetailsMembers = map[string]bool{
+	"Org1MSP": true,
+	"Org2MSP": true,
+}
+
+// saltTransientKey is the transient map key holding the random salt of the patient details hash.
+const saltTransientKey = "salt"
+
+// minSaltSize is the smallest salt accepted, in bytes.
+const minSaltSize = 16
+
+// PatientDetails holds the identifiable part of a patient, stored only in the
+// private data collection.
+type PatientDetails struct {
+	ID         string `json:"ID"`
+	FirstName  string `json:"FirstName"`
+	MiddleName string `json:"MiddleName"`
+	LastName   string `json:"LastName"`
+	BirthDate  string `json:"BirthDate"`
+	BirthPlace string `json:"BirthPlace"`
+	Salt       string `json:"Salt"`
+}
+
+// ---------------------------------------------------- PATIENT PRIVATE DATA -------------------------------------------------------------- //
+// hashPatientDetails returns the salted hash of the identifiable patient fields.
+func hashPatientDetails(details PatientDetails) (string, error) {
+	salt := details.Salt
+	details.Salt = ""
+
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(append([]byte(salt), detailsJSON...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newSalt returns the salt for a patient, passed by the client in the transient map under "salt",
+// so that every endorsing peer uses the same value without it being derivable from the public
+// transaction. The salt is only stored in the patient details collection.
+func newSalt(ctx contractapi.TransactionContextInterface) (string, error) {
+	salt, err := transientSalt(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(salt), nil
+}
+
+// transientSalt returns the raw salt passed in the transient map.
+func transientSalt(ctx contractapi.TransactionContextInterface) ([]byte, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient map: %v", err)
+	}
+	salt, ok := transientMap[saltTransientKey]
+	if !ok || len(salt) < minSaltSize {
+		return nil, fmt.Errorf("%s must be at least %d random bytes in the transient map", saltTransientKey, minSaltSize)
+	}
+
+	return salt, nil
+}
+
+// putPatient stores the identifiable fields of a patient in the private data collection
+// and the remaining fields, together with the salted hash, in the world state. The salt is
+// read from the transient map, see newSalt.
+func (s *SmartContract) putPatient(ctx contractapi.TransactionContextInterface, patient Patient) error {
+	salt, err := newSalt(ctx)
+	if err != nil {
+		return err
+	}
+
+	details := PatientDetails{
+		ID:         patient.ID,
+		FirstName:  patient.FirstName,
+		MiddleName: patient.MiddleName,
+		LastName:   patient.LastName,
+		BirthDate:  patient.BirthDate,
+		BirthPlace: patient.BirthPlace,
+		Salt:       salt,
+	}
+
+	detailsHash, err := hashPatientDetails(details)
+	if err != nil {
+		return err
+	}
+
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutPrivateData(patientDetailsCollection, patient.ID, detailsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put patient details to private data collection: %v", err)
+	}
+	err = s.putCohortAttributes(ctx, patient)
+	if err != nil {
+		return err
+	}
+
+	public := Patient{
+		ID:          patient.ID,
+		Weight:      patient.Weight,
+		Height:      patient.Height,
+		DetailsHash: detailsHash,
+	}
+
+	publicJSON, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(patient.ID, publicJSON)
+}
+
+// callerIsDetailsMember reports whether the caller belongs to an organization of the
+// patient details collection.
+func callerIsDetailsMember(ctx contractapi.TransactionContextInterface) bool {
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return false
+	}
+
+	return patientDetailsMembers[msp]
+}
+
+// readPatientDetails returns the identifiable fields of a patient. It returns nil
+// when the caller's organization is not a member of the collection or the peer
+// holds no private data for the patient.
+func (s *SmartContract) readPatientDetails(ctx contractapi.TransactionContextInterface, id string) *PatientDetails {
+	if !callerIsDetailsMember(ctx) {
+		return nil
+	}
+
+	return s.lookupPatientDetails(ctx, id)
+}
+
+// lookupPatientDetails returns the identifiable fields of a patient without checking
+// the caller. It must only be used for results that do not disclose them.
+func (s *SmartContract) lookupPatientDetails(ctx contractapi.TransactionContextInterface, id string) *PatientDetails {
+	detailsJSON, err := ctx.GetStub().GetPrivateData(patientDetailsCollection, id)
+	if err != nil || detailsJSON == nil {
+		return nil
+	}
+
+	var details PatientDetails
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		return nil
+	}
+
+	return &details
+}
+
+// withPatientDetails fills the identifiable fields of a public patient record when
+// the caller is allowed to read them.
+func (s *SmartContract) withPatientDetails(ctx contractapi.TransactionContextInterface, patient *Patient) *Patient {
+	details := s.readPatientDetails(ctx, patient.ID)
+	if details == nil {
+		return patient
+	}
+
+	patient.FirstName = details.FirstName
+	patient.MiddleName = details.MiddleName
+	patient.LastName = details.LastName
+	patient.BirthDate = details.BirthDate
+	patient.BirthPlace = details.BirthPlace
+
+	return patient
+}
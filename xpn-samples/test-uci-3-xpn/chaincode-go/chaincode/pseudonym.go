@@ -0,0 +1,251 @@
+package chaincode
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// Composite key object types used for pseudonyms. The pseudonymisation keys of the organizations and
+// the mappings are kept in the patient details collection.
+const (
+	pseudonymKeyType     = "PseudonymKey"
+	patientPseudonymType = "PatientPseudonym"
+	pseudonymPatientType = "PseudonymPatient"
+)
+
+// pseudonymKeyTransientKey is the transient map key holding a new pseudonymisation key.
+const pseudonymKeyTransientKey = "pseudonymKey"
+
+// Client identity attribute, and its value, required to look up the pseudonym of a patient.
+const (
+	reidentifyAttribute = "xpn.reidentify"
+	reidentifyValue     = "true"
+)
+
+var pseudonymPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// ---------------------------------------------------- PSEUDONYMS -------------------------------------------------------------- //
+// isPseudonym reports whether s has the format of a patient pseudonym.
+func isPseudonym(s string) bool {
+	return pseudonymPattern.MatchString(s)
+}
+
+// privateCompositeKey builds a composite key for a private data collection.
+func privateCompositeKey(ctx contractapi.TransactionContextInterface, objectType string, attribute string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(objectType, []string{attribute})
+}
+
+// keyedPseudonym returns the pseudonym of value under key.
+func keyedPseudonym(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// checkReidentify returns an error unless the caller holds the xpn.reidentify=true attribute, which
+// grants access to the mapping between patients and their pseudonyms.
+func checkReidentify(ctx contractapi.TransactionContextInterface) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(reidentifyAttribute, reidentifyValue)
+	if err != nil {
+		return fmt.Errorf("Cannot resolve pseudonym. Caller does not have the %s=%s attribute", reidentifyAttribute, reidentifyValue)
+	}
+
+	return nil
+}
+
+// SetPseudonymKey stores the pseudonymisation key of the caller's organization, passed in the transient
+// map under "pseudonymKey", in the patient details collection, where the peers of every member endorsing
+// a new patient can read it. The pseudonyms of the patients the organization creates are derived from
+// it. Only administrators, identities with the xpn.admin=true attribute, can set the key, and it cannot
+// be replaced once set.
+func (s *SmartContract) SetPseudonymKey(ctx contractapi.TransactionContextInterface) error {
+	err := checkAttribute(ctx, "set pseudonym key", adminAttribute)
+	if err != nil {
+		return err
+	}
+	if !callerIsDetailsMember(ctx) {
+		return fmt.Errorf("Cannot set pseudonym key. Caller is not a member of %s", patientDetailsCollection)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient map: %v", err)
+	}
+	pseudonymKey, ok := transientMap[pseudonymKeyTransientKey]
+	if !ok || len(pseudonymKey) < 32 {
+		return fmt.Errorf("%s must be a key of at least 32 bytes in the transient map", pseudonymKeyTransientKey)
+	}
+
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+	key, err := privateCompositeKey(ctx, pseudonymKeyType, msp)
+	if err != nil {
+		return err
+	}
+
+	existing, err := ctx.GetStub().GetPrivateDataHash(patientDetailsCollection, key)
+	if err != nil {
+		return fmt.Errorf("failed to read pseudonym key: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("Cannot set pseudonym key. A pseudonym key for %s already exists", msp)
+	}
+
+	return ctx.GetStub().PutPrivateData(patientDetailsCollection, key, pseudonymKey)
+}
+
+// assignPseudonym derives the pseudonym the clinical records of a new patient are stored under with the
+// pseudonym key of the caller's organization, from the patient id and the random salt passed in the
+// transient map, see newSalt, and stores the mapping in both directions in the patient details
+// collection. The salt is purged with the details when the patient is erased, so the pseudonym cannot
+// be derived again, and a patient created again with the same id gets a new one.
+func (s *SmartContract) assignPseudonym(ctx contractapi.TransactionContextInterface, patient string) error {
+	salt, err := transientSalt(ctx)
+	if err != nil {
+		return err
+	}
+
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+	key, err := privateCompositeKey(ctx, pseudonymKeyType, msp)
+	if err != nil {
+		return err
+	}
+	pseudonymKey, err := ctx.GetStub().GetPrivateData(patientDetailsCollection, key)
+	if err != nil {
+		return fmt.Errorf("failed to read pseudonym key: %v", err)
+	}
+	if pseudonymKey == nil {
+		return fmt.Errorf("Cannot assign pseudonym. No pseudonym key is set for %s", msp)
+	}
+	pseudonym := keyedPseudonym(pseudonymKey, patient+"#"+hex.EncodeToString(salt))
+
+	patientKey, err := privateCompositeKey(ctx, patientPseudonymType, patient)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(patientDetailsCollection, patientKey, []byte(pseudonym))
+	if err != nil {
+		return err
+	}
+
+	pseudonymKeyKey, err := privateCompositeKey(ctx, pseudonymPatientType, pseudonym)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(patientDetailsCollection, pseudonymKeyKey, []byte(patient))
+}
+
+// pseudonymFor returns the pseudonym of a patient. Only members of the patient details collection
+// holding the xpn.reidentify=true attribute can resolve pseudonyms, also when the pseudonym is only
+// used to store or look up records: the proposal names the patient and the records are keyed by the
+// pseudonym, which links the two.
+func (s *SmartContract) pseudonymFor(ctx contractapi.TransactionContextInterface, patient string) (string, error) {
+	if !callerIsDetailsMember(ctx) {
+		return "", fmt.Errorf("Cannot resolve pseudonym. Caller is not a member of %s", patientDetailsCollection)
+	}
+	err := checkReidentify(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return s.storedPseudonym(ctx, patient)
+}
+
+// anchorPseudonym returns the pseudonym xpntransactions of patient are stored under. patient is
+// either the id of a patient, resolved like pseudonymFor, or a pseudonym, which must be known. Both
+// require the xpn.reidentify=true attribute, because the paths of the anchors hold the patient id.
+func (s *SmartContract) anchorPseudonym(ctx contractapi.TransactionContextInterface, patient string) (string, error) {
+	if !isPseudonym(patient) {
+		return s.pseudonymFor(ctx, patient)
+	}
+
+	if !callerIsDetailsMember(ctx) {
+		return "", fmt.Errorf("Cannot resolve pseudonym. Caller is not a member of %s", patientDetailsCollection)
+	}
+	err := checkReidentify(ctx)
+	if err != nil {
+		return "", err
+	}
+	_, err = s.patientForPseudonym(ctx, patient)
+	if err != nil {
+		return "", err
+	}
+
+	return patient, nil
+}
+
+// storedPseudonym returns the pseudonym of a patient without checking the caller. It must only be
+// used for results that do not disclose the pseudonym.
+func (s *SmartContract) storedPseudonym(ctx contractapi.TransactionContextInterface, patient string) (string, error) {
+	key, err := privateCompositeKey(ctx, patientPseudonymType, patient)
+	if err != nil {
+		return "", err
+	}
+
+	pseudonym, err := ctx.GetStub().GetPrivateData(patientDetailsCollection, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pseudonym of patient %s: %v", patient, err)
+	}
+	if pseudonym == nil {
+		return "", fmt.Errorf("Cannot resolve pseudonym. Patient with id %s has no pseudonym", patient)
+	}
+
+	return string(pseudonym), nil
+}
+
+// patientForPseudonym returns the patient behind a pseudonym without checking the caller.
+// It must only be used for results that do not disclose the patient.
+func (s *SmartContract) patientForPseudonym(ctx contractapi.TransactionContextInterface, pseudonym string) (string, error) {
+	key, err := privateCompositeKey(ctx, pseudonymPatientType, pseudonym)
+	if err != nil {
+		return "", err
+	}
+
+	patient, err := ctx.GetStub().GetPrivateData(patientDetailsCollection, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve pseudonym %s: %v", pseudonym, err)
+	}
+	if patient == nil {
+		return "", fmt.Errorf("Cannot resolve pseudonym. Pseudonym %s is unknown", pseudonym)
+	}
+
+	return string(patient), nil
+}
+
+// purgePseudonym removes the pseudonym mapping of a patient from the patient details collection.
+func (s *SmartContract) purgePseudonym(ctx contractapi.TransactionContextInterface, patient string, pseudonym string) error {
+	patientKey, err := privateCompositeKey(ctx, patientPseudonymType, patient)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PurgePrivateData(patientDetailsCollection, patientKey)
+	if err != nil {
+		return err
+	}
+
+	pseudonymKey, err := privateCompositeKey(ctx, pseudonymPatientType, pseudonym)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PurgePrivateData(patientDetailsCollection, pseudonymKey)
+}
+
+// GetPatientPseudonym returns the pseudonym under which the contract, measurements and diagnosis of
+// a patient are stored. It is only available to members of the patient details collection holding
+// the xpn.reidentify=true attribute.
+func (s *SmartContract) GetPatientPseudonym(ctx contractapi.TransactionContextInterface, patient string) (string, error) {
+	return s.pseudonymFor(ctx, patient)
+}
@@ -0,0 +1,250 @@
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/vitalsigns"
+)
+
+// rtDataPointerType is the composite key object type of the private part of pointer records. The
+// path of the document names the patient id, so it is kept in the patient details collection.
+const rtDataPointerType = "RTDataPointer"
+
+// rtDataPointer is the private part of a pointer record.
+type rtDataPointer struct {
+	Path string `json:"Path"`
+	Hash string `json:"Hash"`
+}
+
+// ------------------------------------------------ REAL TIME DATA POINTERS --------------------------------------------------------- //
+// CreateRTDataPointer creates real time measurements of a patient that point to a vital signs document
+// in XPN instead of holding the values. The document must be anchored at path with hash.
+func (s *SmartContract) CreateRTDataPointer(ctx contractapi.TransactionContextInterface, patient string, path string, hash string) error {
+	rtData, err := s.rtDataPointer(ctx, patient, path, hash)
+	if err != nil {
+		return err
+	}
+
+	rtDataExists, err := s.RTDataExists(ctx, rtData.ID)
+	if err != nil {
+		return err
+	}
+	if rtDataExists {
+		return fmt.Errorf("Cannot create real time measurements. Measurements with id %s already exists", rtData.ID)
+	}
+
+	return s.putRTData(ctx, rtData)
+}
+
+// UpdateRTDataPointer replaces the real time measurements of a patient, values or pointer, with a
+// pointer to the vital signs document anchored at path with hash.
+func (s *SmartContract) UpdateRTDataPointer(ctx contractapi.TransactionContextInterface, patient string, path string, hash string) error {
+	rtData, err := s.rtDataPointer(ctx, patient, path, hash)
+	if err != nil {
+		return err
+	}
+
+	rtDataExists, err := s.RTDataExists(ctx, rtData.ID)
+	if err != nil {
+		return err
+	}
+	if !rtDataExists {
+		return fmt.Errorf("Cannot update measurements. Measurements with id %s do not exist", rtData.ID)
+	}
+
+	return s.putRTData(ctx, rtData)
+}
+
+// rtDataPointer builds the pointer record of patient, checking that the current anchor of path
+// belongs to the patient and has the given hash.
+func (s *SmartContract) rtDataPointer(ctx contractapi.TransactionContextInterface, patient string, path string, hash string) (*RTData, error) {
+	patientExists, err := s.PatientExists(ctx, patient)
+	if err != nil {
+		return nil, err
+	}
+	if !patientExists {
+		return nil, fmt.Errorf("Cannot create measurements. Patient with id %s does not exist", patient)
+	}
+
+	anchor, err := s.currentXpnTransaction(ctx, canonicalXpnPath(path))
+	if err != nil {
+		return nil, err
+	}
+	if anchor == nil {
+		return nil, fmt.Errorf("Cannot point to measurements. No xpntransaction anchors path %s", path)
+	}
+	pseudonym, err := s.pseudonymFor(ctx, patient)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := xpnPatientOf(ctx, anchor.ID)
+	if err != nil {
+		return nil, err
+	}
+	if owner != pseudonym {
+		return nil, fmt.Errorf("Cannot point to measurements. Path %s belongs to another patient", path)
+	}
+	if !multihash.Equal(hash, anchor.Hash, anchor.HashAlgorithm) {
+		return nil, fmt.Errorf("Cannot point to measurements. Path %s is anchored with hash %s, not %s", path, anchor.Hash, hash)
+	}
+
+	rtData := RTData{
+		ID:      "RTD" + pseudonym,
+		Patient: pseudonym,
+		Path:    anchor.Path,
+		Hash:    anchor.Hash,
+		Pointer: true,
+	}
+
+	err = s.validateRTData(rtData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rtData, nil
+}
+
+// putRTData stores a pointer record. The path and hash go to the patient details collection, the
+// world state only tells that the measurements are kept in XPN.
+func (s *SmartContract) putRTData(ctx contractapi.TransactionContextInterface, rtData *RTData) error {
+	err := putRTDataPointer(ctx, rtData.ID, rtDataPointer{Path: rtData.Path, Hash: rtData.Hash})
+	if err != nil {
+		return err
+	}
+
+	public := *rtData
+	public.Path = ""
+	public.Hash = ""
+	rtDataJSON, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(rtData.ID, rtDataJSON)
+}
+
+// resolveRTData returns rtData itself when it holds the measurements. For pointer records it reads the
+// vital signs document from the "rtdata" transient key, checks it against the pointer hash and returns
+// a copy of rtData with the measurements of the document.
+func (s *SmartContract) resolveRTData(ctx contractapi.TransactionContextInterface, patient string, rtData *RTData) (*RTData, error) {
+	if !rtData.Pointer {
+		return rtData, nil
+	}
+	pointer, err := rtDataPointerOf(ctx, rtData.ID)
+	if err != nil {
+		return nil, err
+	}
+	withPointer := *rtData
+	withPointer.Path = pointer.Path
+	withPointer.Hash = pointer.Hash
+	rtData = &withPointer
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient map: %v", err)
+	}
+	content, ok := transientMap[rtDataTransientKey]
+	if !ok {
+		return nil, fmt.Errorf("Cannot read measurements. Measurements with id %s are stored in %s, pass the document under the %s transient key",
+			rtData.ID, rtData.Path, rtDataTransientKey)
+	}
+
+	m, err := multihash.Decode(rtData.Hash)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := multihash.Sum(m.Name, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	if hash != rtData.Hash {
+		return nil, fmt.Errorf("Cannot read measurements. The document passed has hash %s, %s is anchored with hash %s", hash, rtData.Path, rtData.Hash)
+	}
+
+	doc, err := vitalsigns.Decode(content)
+	if err != nil {
+		return nil, err
+	}
+	if doc.PatientID != patient {
+		return nil, fmt.Errorf("Cannot read measurements. The document of %s belongs to patient %s", rtData.Path, doc.PatientID)
+	}
+
+	resolved := *rtData
+	resolved.OxygenSaturation = float64(doc.OxygenSaturation)
+	resolved.PulseRate = float64(doc.PulseRate)
+	resolved.Temperature = float64(doc.Temperature)
+	resolved.BloodPressureSystolic = float64(doc.BloodPressureSystolic)
+	resolved.BloodPressureDiastolic = float64(doc.BloodPressureDiastolic)
+
+	return &resolved, nil
+}
+
+// putRTDataPointer stores the path and hash of the pointer record with given id.
+func putRTDataPointer(ctx contractapi.TransactionContextInterface, id string, pointer rtDataPointer) error {
+	key, err := privateCompositeKey(ctx, rtDataPointerType, id)
+	if err != nil {
+		return err
+	}
+
+	pointerJSON, err := json.Marshal(pointer)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(patientDetailsCollection, key, pointerJSON)
+}
+
+// rtDataPointerOf returns the path and hash of the pointer record with given id without checking
+// the caller. It must only be used for results that do not disclose them.
+func rtDataPointerOf(ctx contractapi.TransactionContextInterface, id string) (*rtDataPointer, error) {
+	key, err := privateCompositeKey(ctx, rtDataPointerType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	pointerJSON, err := ctx.GetStub().GetPrivateData(patientDetailsCollection, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pointer of measurements %s: %v", id, err)
+	}
+	if pointerJSON == nil {
+		return nil, fmt.Errorf("Cannot read measurements. Measurements with id %s have no pointer", id)
+	}
+
+	var pointer rtDataPointer
+	err = json.Unmarshal(pointerJSON, &pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pointer, nil
+}
+
+// withRTDataPointer fills the path and hash of a pointer record when the caller is allowed to see
+// them.
+func withRTDataPointer(ctx contractapi.TransactionContextInterface, rtData *RTData) *RTData {
+	if !rtData.Pointer || !canReidentify(ctx) {
+		return rtData
+	}
+
+	pointer, err := rtDataPointerOf(ctx, rtData.ID)
+	if err == nil {
+		rtData.Path = pointer.Path
+		rtData.Hash = pointer.Hash
+	}
+
+	return rtData
+}
+
+// purgeRTDataPointer removes the path and hash of the pointer record with given id.
+func purgeRTDataPointer(ctx contractapi.TransactionContextInterface, id string) error {
+	key, err := privateCompositeKey(ctx, rtDataPointerType, id)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PurgePrivateData(patientDetailsCollection, key)
+}
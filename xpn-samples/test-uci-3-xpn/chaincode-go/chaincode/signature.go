@@ -0,0 +1,211 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/attestation"
+)
+
+// xpnSignerType is the composite key object type of the certificates of the identities that signed
+// xpntransactions, keyed by certificate fingerprint.
+const xpnSignerType = "XpnSigner"
+
+// maxXpnSignatureSkew is how far the signing time may be ahead of the transaction timestamp.
+const maxXpnSignatureSkew = 5 * time.Minute
+
+// xpnSignature is the optional detached signature passed when a file is anchored, see package
+// attestation. Signature is empty when the file is not signed.
+type xpnSignature struct {
+	Signature string `json:"signature"`
+	SignedAt  string `json:"signedAt"`
+}
+
+// XpnSigner is the identity that signed one or more xpntransactions.
+type XpnSigner struct {
+	Fingerprint string `json:"Fingerprint"`
+	ID          string `json:"ID"`
+	MSP         string `json:"MSP"`
+	Certificate string `json:"Certificate"`
+}
+
+// XpnAttestation tells whether an xpntransaction is signed and by which identity. The message, the
+// signature and the certificate are enough to check the signature off the ledger.
+type XpnAttestation struct {
+	XpnTransaction string     `json:"XpnTransaction"`
+	Path           string     `json:"Path"`
+	Hash           string     `json:"Hash"`
+	Version        int        `json:"Version"`
+	Signed         bool       `json:"Signed"`
+	SignedAt       string     `json:"SignedAt,omitempty"`
+	Signature      string     `json:"Signature,omitempty"`
+	Message        string     `json:"Message,omitempty"`
+	Signer         *XpnSigner `json:"Signer,omitempty"`
+	Valid          bool       `json:"Valid"`
+	Error          string     `json:"Error,omitempty"`
+}
+
+// ---------------------------------------------------- XpnTransaction SIGNATURE -------------------------------------------------------------- //
+// setXpnSignature verifies the signature of xpntransaction against the certificate of the submitter
+// and stores it, together with the certificate, in the world state.
+func (s *SmartContract) setXpnSignature(ctx contractapi.TransactionContextInterface, xpntransaction *XpnTransaction, signature xpnSignature) error {
+	xpntransaction.Signature = ""
+	xpntransaction.SignedAt = ""
+	xpntransaction.Signer = ""
+	if strings.TrimSpace(signature.Signature) == "" {
+		if signature.SignedAt != "" {
+			return fmt.Errorf("signature must be set with signedAt")
+		}
+		return nil
+	}
+
+	signedAt, err := time.Parse(attestation.TimeLayout, signature.SignedAt)
+	if err != nil {
+		return fmt.Errorf("invalid signedAt: %s, must be an RFC3339 time", signature.SignedAt)
+	}
+	ts, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if signedAt.After(ts.Add(maxXpnSignatureSkew)) {
+		return fmt.Errorf("invalid signedAt: %s is later than the transaction timestamp", signature.SignedAt)
+	}
+
+	// the submitter certificate, read from the creator of the proposal
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to read caller certificate: %v", err)
+	}
+	if cert == nil {
+		return fmt.Errorf("Cannot verify signature. The caller identity has no X.509 certificate")
+	}
+
+	err = attestation.Verify(cert, xpntransaction.Path, xpntransaction.Hash, signature.SignedAt, signature.Signature)
+	if err != nil {
+		return fmt.Errorf("Cannot verify signature of %s: %v", xpntransaction.Path, err)
+	}
+
+	signer := XpnSigner{
+		Fingerprint: attestation.Fingerprint(cert),
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+	}
+	signer.ID, err = ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller identity: %v", err)
+	}
+	signer.MSP, err = ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+
+	signerJSON, err := json.Marshal(signer)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(xpnSignerType, []string{signer.Fingerprint})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, signerJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put signer certificate: %v", err)
+	}
+
+	xpntransaction.Signature = signature.Signature
+	xpntransaction.SignedAt = signature.SignedAt
+	xpntransaction.Signer = signer.Fingerprint
+
+	return nil
+}
+
+// readXpnSigner returns the signer with given certificate fingerprint.
+func (s *SmartContract) readXpnSigner(ctx contractapi.TransactionContextInterface, fingerprint string) (*XpnSigner, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(xpnSignerType, []string{fingerprint})
+	if err != nil {
+		return nil, err
+	}
+	signerJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if signerJSON == nil {
+		return nil, fmt.Errorf("the signer %s does not exist", fingerprint)
+	}
+
+	var signer XpnSigner
+	err = json.Unmarshal(signerJSON, &signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signer, nil
+}
+
+// GetXpnAttestation returns the attestation of the xpntransaction with given id. The signature is
+// checked again against the stored certificate of the signer.
+func (s *SmartContract) GetXpnAttestation(ctx contractapi.TransactionContextInterface, id string) (*XpnAttestation, error) {
+	xpntransaction, err := s.ReadXpnTransaction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.xpnAttestation(ctx, xpntransaction)
+}
+
+// GetXpnAttestationsByPath returns the attestations of every version of the file at path, oldest first.
+func (s *SmartContract) GetXpnAttestationsByPath(ctx contractapi.TransactionContextInterface, path string) ([]*XpnAttestation, error) {
+	history, err := s.GetXpnTransactionHistory(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var attestations []*XpnAttestation
+	for _, xpntransaction := range history {
+		a, err := s.xpnAttestation(ctx, xpntransaction)
+		if err != nil {
+			return nil, err
+		}
+		attestations = append(attestations, a)
+	}
+
+	return attestations, nil
+}
+
+func (s *SmartContract) xpnAttestation(ctx contractapi.TransactionContextInterface, xpntransaction *XpnTransaction) (*XpnAttestation, error) {
+	a := XpnAttestation{
+		XpnTransaction: xpntransaction.ID,
+		Path:           xpntransaction.Path,
+		Hash:           xpntransaction.Hash,
+		Version:        xpntransaction.Version,
+		Signed:         xpntransaction.Signature != "",
+	}
+	if !a.Signed {
+		return &a, nil
+	}
+
+	a.SignedAt = xpntransaction.SignedAt
+	a.Signature = xpntransaction.Signature
+	a.Message = string(attestation.Message(xpntransaction.Path, xpntransaction.Hash, xpntransaction.SignedAt))
+
+	signer, err := s.readXpnSigner(ctx, xpntransaction.Signer)
+	if err != nil {
+		return nil, err
+	}
+	a.Signer = signer
+
+	cert, err := attestation.ParseCertificate([]byte(signer.Certificate))
+	if err == nil {
+		err = attestation.Verify(cert, xpntransaction.Path, xpntransaction.Hash, xpntransaction.SignedAt, xpntransaction.Signature)
+	}
+	if err != nil {
+		a.Error = err.Error()
+	} else {
+		a.Valid = true
+	}
+
+	return &a, nil
+}
@@ -3,12 +3,14 @@ package chaincode
 import (
 	"encoding/json"
 	"fmt"
+	"path"
 	"strings"
 	"regexp"
 	"strconv"
 	"unicode"
 
 	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
 )
 
 // SmartContract provides functions for managing a Patient
@@ -16,23 +18,64 @@ type SmartContract struct {
 	contractapi.Contract
 }
 
+// XpnTransaction anchors the hash of a file stored in XPN. Timestamp is the time of the
+// transaction that anchored it. ChunkHashes optionally hold the hashes of consecutive ChunkSize
+// byte chunks of the file, aligned to the XPN block size, and Layout the partition layout the file
+// was written with. When a file is rewritten, or anchored again after its anchors were erased, a
+// new version is anchored that links to the previous one through PreviousID and PreviousHash. Batch
+// is the id of the XpnBatch the file was anchored with, if any. A signed xpntransaction holds the
+// detached signature of the submitter over its path, hash and SignedAt time, and the certificate
+// fingerprint of the Signer. Patient is the pseudonym of the patient. As the path names the patient
+// id, Patient is kept in the patient details collection and only returned to callers allowed to
+// resolve pseudonyms.
 type XpnTransaction struct {
-	ID          string  `json:"ID"`
-	Hash        string  `json:"Hash"`
-	Path        string  `json:"Path"`
+	ID            string     `json:"ID"`
+	Hash          string     `json:"Hash"`
+	Path          string     `json:"Path"`
+	Patient       string     `json:"Patient,omitempty"`
+	DocumentType  string     `json:"DocumentType"`
+	Size          int64      `json:"Size"`
+	HashAlgorithm string     `json:"HashAlgorithm"`
+	Partition     string     `json:"Partition"`
+	Layout        *XpnLayout `json:"Layout,omitempty"`
+	Timestamp     string     `json:"Timestamp"`
+	ChunkSize     int64      `json:"ChunkSize,omitempty"`
+	ChunkHashes   []string   `json:"ChunkHashes,omitempty"`
+	Version       int        `json:"Version"`
+	PreviousID    string     `json:"PreviousID,omitempty"`
+	PreviousHash  string     `json:"PreviousHash,omitempty"`
+	Creator       string     `json:"Creator"`
+	CreatorMSP    string     `json:"CreatorMSP"`
+	Signature     string     `json:"Signature,omitempty"`
+	SignedAt      string     `json:"SignedAt,omitempty"`
+	Signer        string     `json:"Signer,omitempty"`
+	Batch         string     `json:"Batch,omitempty"`
+	Erased        bool       `json:"Erased,omitempty"`
+	FileDeleted   bool       `json:"FileDeleted,omitempty"`
 }
 
+// Patient is returned with its identifiable fields only to members of the
+// patient details collection. The world state keeps the ID, Weight, Height and
+// the salted hash of the identifiable fields.
 type Patient struct {
 	ID          string  `json:"ID"`
-	FirstName   string  `json:"FirstName"`
-	MiddleName  string  `json:"MiddleName"`
-	LastName    string  `json:"LastName"`
-	BirthDate   string  `json:"BirthDate"`
-	BirthPlace  string  `json:"BirthPlace"`
+	FirstName   string  `json:"FirstName,omitempty"`
+	MiddleName  string  `json:"MiddleName,omitempty"`
+	LastName    string  `json:"LastName,omitempty"`
+	BirthDate   string  `json:"BirthDate,omitempty"`
+	BirthPlace  string  `json:"BirthPlace,omitempty"`
 	Weight 		float64 `json:"Weight"`
 	Height 		float64 `json:"Height"`
+	DetailsHash string  `json:"DetailsHash"`
+	EncryptedDetails string   `json:"EncryptedDetails,omitempty"`
+	KeyHolders       []string `json:"KeyHolders,omitempty"`
+	Shredded         bool     `json:"Shredded,omitempty"`
 }
 
+// RTData holds the last real time measurements of a patient. A pointer record points to an anchored
+// vital signs document in XPN instead of holding the measurements, see CreateRTDataPointer. Its Path
+// and Hash are kept in the patient details collection and only returned to callers allowed to
+// resolve pseudonyms.
 type RTData struct {
 	ID               		string   `json:"ID"`
 	Patient					string 	 `json:"Patient"`
@@ -41,7 +84,10 @@ type RTData struct {
 	Temperature      		float64  `json:"Temperature"`
 	BloodPressureSystolic   float64  `json:"BloodPressureSystolic"`
 	BloodPressureDiastolic  float64  `json:"BloodPressureDiastolic"`
-
+	Path					string	 `json:"Path,omitempty"`
+	Hash					string	 `json:"Hash,omitempty"`
+	Pointer					bool	 `json:"Pointer,omitempty"`
+	Erased					bool	 `json:"Erased,omitempty"`
 }
 
 type Diagnosis struct {
@@ -51,6 +97,7 @@ type Diagnosis struct {
 	PulseRateDiagnosis        	string `json:"PulseRateDiagnosis"`
 	TemperatureDiagnosis      	string `json:"TemperatureDiagnosis"`
 	BloodPressureDiagnosis  	string `json:"BloodPressureDiagnosis"`
+	Erased						bool   `json:"Erased,omitempty"`
 }
 
 
@@ -67,9 +114,39 @@ type Contract struct {
 	MaxBloodPressureSystolic	float64  `json:"MaxBloodPressureSystolic"`
 	MinBloodPressureDiastolic	float64  `json:"MinBloodPressureDiastolic"`
 	MaxBloodPressureDiastolic	float64  `json:"MaxBloodPressureDiastolic"`	
+	Erased						bool	 `json:"Erased,omitempty"`
 }
 
 // ---------------------------------------------------- XpnTransaction -------------------------------------------------------------- //
+// xpnTransactionType is the composite key object type of xpntransactions. Using composite keys keeps
+// xpntransactions apart from patients, which are also stored under numeric keys.
+const xpnTransactionType = "XpnTransaction"
+
+// Composite key object types of the public xpntransaction indexes. The patient index is private, see
+// xpnPatientIndex.
+const (
+	xpnDocumentTypeIndex = "XpnTransactionDocumentType"
+	xpnPathIndex         = "XpnTransactionPath"
+	xpnHashIndex         = "XpnTransactionHash"
+)
+
+// xpnDocumentTypes are the kinds of documents written to XPN by the deploy scripts.
+var xpnDocumentTypes = map[string]bool{
+	"patient":     true,
+	"contract":    true,
+	"diagnosis":   true,
+	"vital_signs": true,
+}
+
+// xpnTransactionIDPattern restricts the ids of xpntransactions, which are either derived from the
+// transaction ID or chosen by the client in compatibility mode.
+var xpnTransactionIDPattern = regexp.MustCompile(`^[0-9A-Za-z._-]{1,128}$`)
+
+// xpnTransactionKey returns the world state key of the xpntransaction with given id.
+func xpnTransactionKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(xpnTransactionType, []string{id})
+}
+
 // Validations for the XpnTransaction parameters
 func (s *SmartContract) validateXpnTransaction(xpntransaction XpnTransaction) error {
 	// Check if ID is empty
@@ -77,14 +154,9 @@ func (s *SmartContract) validateXpnTransaction(xpntransaction XpnTransaction) er
 		return fmt.Errorf("ID must be non-empty")
 	}
 
-	//check ID is a number
-	if _, err := strconv.Atoi(xpntransaction.ID); err != nil {
-		return fmt.Errorf("ID must be a number")
-	}
-
-	// Check if Hash is empty
-	if strings.TrimSpace(xpntransaction.Hash) == "" {
-		return fmt.Errorf("Hash must be non-empty")
+	//check ID only uses characters allowed in keys
+	if !xpnTransactionIDPattern.MatchString(xpntransaction.ID) {
+		return fmt.Errorf("ID must be at most 128 letters, digits, '.', '_' or '-'")
 	}
 
 	// Check if Path is empty
@@ -92,26 +164,267 @@ func (s *SmartContract) validateXpnTransaction(xpntransaction XpnTransaction) er
 		return fmt.Errorf("Path must be non-empty")
 	}
 
+	//check Path is absolute
+	if !strings.HasPrefix(xpntransaction.Path, "/") {
+		return fmt.Errorf("Path must be absolute")
+	}
+
+	//check Patient is a pseudonym
+	if !isPseudonym(xpntransaction.Patient) {
+		return fmt.Errorf("Patient must be a pseudonym")
+	}
+
+	// Check if DocumentType is known
+	if !xpnDocumentTypes[xpntransaction.DocumentType] {
+		return fmt.Errorf("DocumentType must be one of patient, contract, diagnosis or vital_signs")
+	}
+
+	// Check if Size is not negative
+	if xpntransaction.Size < 0 {
+		return fmt.Errorf("Size must be a non-negative number")
+	}
+
+	// Check if Hash is a multihash of HashAlgorithm
+	m, err := multihash.Decode(xpntransaction.Hash)
+	if err != nil {
+		return fmt.Errorf("Hash must be a multihash: %v", err)
+	}
+	if m.Name != xpntransaction.HashAlgorithm {
+		return fmt.Errorf("Hash is a %s multihash, not %s", m.Name, xpntransaction.HashAlgorithm)
+	}
+
+	// Check if Partition is empty
+	if strings.TrimSpace(xpntransaction.Partition) == "" {
+		return fmt.Errorf("Partition must be non-empty")
+	}
+
+	// Check if the chunk hashes cover the file
+	err = validateXpnChunks(xpntransaction)
+	if err != nil {
+		return err
+	}
+
+	// Check if the partition layout is consistent
+	if xpntransaction.Layout != nil {
+		err = validateXpnLayout(*xpntransaction.Layout)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 
-// CreateXpnTransaction creates a new xpntransaction with given details.
-func (s *SmartContract) CreateXpnTransaction(ctx contractapi.TransactionContextInterface, id string, hash string, path string) error {
-	
-	exists, err := s.XpnTransactionExists(ctx, id)
+// canonicalXpnPath returns path in the form it is anchored and indexed with: cleaned of
+// duplicate slashes and "." and ".." elements.
+func canonicalXpnPath(p string) string {
+	if strings.TrimSpace(p) == "" {
+		return p
+	}
+
+	return path.Clean(p)
+}
+
+
+// parseXpnHash returns the multihash of hash, which is either a multihash or a bare hex digest
+// of hashAlgorithm, as printed by write_data_xpn.py.
+func parseXpnHash(hash string, hashAlgorithm string) (*multihash.Multihash, error) {
+	if strings.TrimSpace(hashAlgorithm) == "" {
+		return nil, fmt.Errorf("HashAlgorithm must be non-empty")
+	}
+
+	m, err := multihash.Parse(hash, hashAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hash: %v", err)
+	}
+
+	return m, nil
+}
+
+
+// CreateXpnTransaction creates a new xpntransaction with given details and returns its id, which is
+// the ID of the transaction, so clients do not have to allocate ids themselves.
+func (s *SmartContract) CreateXpnTransaction(ctx contractapi.TransactionContextInterface, hash string, path string,
+	patient string, documentType string, size string, hashAlgorithm string, partition string) (string, error) {
+
+	id := ctx.GetStub().GetTxID()
+	err := s.createXpnTransaction(ctx, id, hash, path, patient, documentType, size, hashAlgorithm, partition, xpnChunks{}, nil, xpnSignature{}, "")
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+
+// CreateXpnTransactionWithID creates a new xpntransaction with an id chosen by the client. It is kept
+// for clients that still allocate their own ids.
+func (s *SmartContract) CreateXpnTransactionWithID(ctx contractapi.TransactionContextInterface, id string, hash string, path string,
+	patient string, documentType string, size string, hashAlgorithm string, partition string) error {
+
+	return s.createXpnTransaction(ctx, id, hash, path, patient, documentType, size, hashAlgorithm, partition, xpnChunks{}, nil, xpnSignature{}, "")
+}
+
+
+// createXpnTransaction creates the first version of an xpntransaction. patient is the id or the
+// pseudonym of a patient, the xpntransaction is stored and indexed under the pseudonym. batch is the
+// id of the XpnBatch the file is anchored with, empty for single files.
+func (s *SmartContract) createXpnTransaction(ctx contractapi.TransactionContextInterface, id string, hash string, path string,
+	patient string, documentType string, size string, hashAlgorithm string, partition string, chunks xpnChunks, layout *XpnLayout, signature xpnSignature,
+	batch string) error {
+
+	sizeInt, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size: %s", size)
+	}
+
+	m, err := parseXpnHash(hash, hashAlgorithm)
 	if err != nil {
 		return err
 	}
-	if exists {
-		return fmt.Errorf("Cannot create xpntransaction. XpnTransaction with id %s already exists", id)
+
+	pseudonym, err := s.anchorPseudonym(ctx, patient)
+	if err != nil {
+		return err
+	}
+
+	path = canonicalXpnPath(path)
+	history, err := s.GetXpnTransactionHistory(ctx, path)
+	if err != nil {
+		return err
+	}
+	var last *XpnTransaction
+	if len(history) > 0 {
+		last = history[len(history)-1]
+	}
+	if last != nil && !last.Erased {
+		return fmt.Errorf("Cannot create xpntransaction. Path %s is already anchored by xpntransaction %s, use UpdateXpnTransaction", path, last.ID)
 	}
 
+	ts, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
 
 	xpntransaction := XpnTransaction{
-		ID:   id,
-		Hash: hash,
-		Path: path,
+		ID:            id,
+		Hash:          m.String(),
+		Path:          path,
+		Patient:       pseudonym,
+		DocumentType:  documentType,
+		Size:          sizeInt,
+		HashAlgorithm: m.Name,
+		Partition:     partition,
+		Timestamp:     ts.Format(timestampLayout),
+		Layout:        layout,
+		Version:       1,
+		Batch:         batch,
+	}
+	if last != nil {
+		// the path was anchored before its anchors were erased, the chain of versions continues so
+		// that the new anchor is the current one
+		xpntransaction.Version = last.Version + 1
+		xpntransaction.PreviousID = last.ID
+		xpntransaction.PreviousHash = last.Hash
+	}
+
+	err = setXpnChunks(&xpntransaction, chunks)
+	if err != nil {
+		return err
+	}
+
+	err = s.setXpnSignature(ctx, &xpntransaction, signature)
+	if err != nil {
+		return err
+	}
+
+	return s.storeXpnTransaction(ctx, xpntransaction)
+}
+
+
+// UpdateXpnTransaction anchors a new version of the file at path, linked to the current version,
+// and returns its id. The patient, document type, hash algorithm and partition are kept.
+func (s *SmartContract) UpdateXpnTransaction(ctx contractapi.TransactionContextInterface, path string, hash string, size string) (string, error) {
+	return s.updateXpnTransaction(ctx, ctx.GetStub().GetTxID(), path, hash, size, xpnChunks{}, nil, xpnSignature{}, "")
+}
+
+
+// updateXpnTransaction anchors a new version of the file at path with given id. The layout of the
+// previous version is kept when layout is nil. batch is the id of the XpnBatch the new version is
+// anchored with, empty for single files.
+func (s *SmartContract) updateXpnTransaction(ctx contractapi.TransactionContextInterface, id string, path string, hash string, size string,
+	chunks xpnChunks, layout *XpnLayout, signature xpnSignature, batch string) (string, error) {
+	sizeInt, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid size: %s", size)
+	}
+
+	previous, err := s.currentXpnTransaction(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if previous == nil {
+		return "", fmt.Errorf("Cannot update xpntransaction. Path %s is not anchored", path)
+	}
+	m, err := parseXpnHash(hash, previous.HashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+	if multihash.Equal(previous.Hash, m.String(), previous.HashAlgorithm) {
+		return "", fmt.Errorf("Cannot update xpntransaction. Path %s is already anchored with hash %s", path, hash)
+	}
+
+	ts, err := txTimestamp(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	xpntransaction := *previous
+	xpntransaction.ID = id
+	xpntransaction.Patient, err = xpnPatientOf(ctx, previous.ID)
+	if err != nil {
+		return "", err
+	}
+	xpntransaction.Hash = m.String()
+	xpntransaction.HashAlgorithm = m.Name
+	xpntransaction.Size = sizeInt
+	xpntransaction.Timestamp = ts.Format(timestampLayout)
+	xpntransaction.Version = previous.Version + 1
+	xpntransaction.PreviousID = previous.ID
+	xpntransaction.PreviousHash = previous.Hash
+	xpntransaction.Batch = batch
+	if layout != nil {
+		xpntransaction.Layout = layout
+	}
+
+	err = setXpnChunks(&xpntransaction, chunks)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.setXpnSignature(ctx, &xpntransaction, signature)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.storeXpnTransaction(ctx, xpntransaction)
+	if err != nil {
+		return "", err
+	}
+
+	return xpntransaction.ID, nil
+}
+
+
+// storeXpnTransaction validates a new xpntransaction and stores it together with its index entries.
+func (s *SmartContract) storeXpnTransaction(ctx contractapi.TransactionContextInterface, xpntransaction XpnTransaction) error {
+	exists, err := s.XpnTransactionExists(ctx, xpntransaction.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Cannot create xpntransaction. XpnTransaction with id %s already exists", xpntransaction.ID)
 	}
 
 	// validate the xpntransaction
@@ -119,19 +432,87 @@ func (s *SmartContract) CreateXpnTransaction(ctx contractapi.TransactionContextI
 	if err != nil {
 		return err
 	}
-	
+
+	xpntransaction.Creator, err = ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller identity: %v", err)
+	}
+	xpntransaction.CreatorMSP, err = ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+
+	err = s.putXpnTransaction(ctx, xpntransaction)
+	if err != nil {
+		return err
+	}
+
+	return s.indexXpnTransaction(ctx, xpntransaction)
+}
+
+
+// putXpnTransaction stores an xpntransaction in the world state, without its patient.
+func (s *SmartContract) putXpnTransaction(ctx contractapi.TransactionContextInterface, xpntransaction XpnTransaction) error {
+	xpntransaction.Patient = ""
+
+	key, err := xpnTransactionKey(ctx, xpntransaction.ID)
+	if err != nil {
+		return err
+	}
+
 	xpntransactionJSON, err := json.Marshal(xpntransaction)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(id, xpntransactionJSON)
+	return ctx.GetStub().PutState(key, xpntransactionJSON)
+}
+
+
+// indexXpnTransaction adds an xpntransaction to the private patient index and to the public
+// document type, path and hash indexes.
+func (s *SmartContract) indexXpnTransaction(ctx contractapi.TransactionContextInterface, xpntransaction XpnTransaction) error {
+	err := putXpnPatient(ctx, xpntransaction.ID, xpntransaction.Patient)
+	if err != nil {
+		return err
+	}
+
+	documentTypeKey, err := ctx.GetStub().CreateCompositeKey(xpnDocumentTypeIndex, []string{xpntransaction.DocumentType, xpntransaction.ID})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(documentTypeKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+
+	pathKey, err := ctx.GetStub().CreateCompositeKey(xpnPathIndex, []string{xpntransaction.Path, xpntransaction.ID})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(pathKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+
+	hashKey, err := ctx.GetStub().CreateCompositeKey(xpnHashIndex, []string{xpntransaction.Hash, xpntransaction.ID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(hashKey, []byte{0x00})
 }
 
 
-// ReadXpnTransaction returns the xpntransaction stored in the world state with given id.
+// ReadXpnTransaction returns the xpntransaction stored in the world state with given id. Patient
+// is only filled for members of the patient details collection with the xpn.reidentify attribute.
 func (s *SmartContract) ReadXpnTransaction(ctx contractapi.TransactionContextInterface, id string) (*XpnTransaction, error) {
-	xpntransactionJSON, err := ctx.GetStub().GetState(id)
+	key, err := xpnTransactionKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	xpntransactionJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read xpntransaction from world state: %v", err)
 	}
@@ -145,12 +526,17 @@ func (s *SmartContract) ReadXpnTransaction(ctx contractapi.TransactionContextInt
 		return nil, err
 	}
 
-	return &xpntransaction, nil
+	return withXpnPatient(ctx, &xpntransaction), nil
 }
 
 // XpnTransactionExists returns true when xpntransaction with given ID exists in world state.
 func (s *SmartContract) XpnTransactionExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
-	xpntransactionJSON, err := ctx.GetStub().GetState(id)
+	key, err := xpnTransactionKey(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	xpntransactionJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to read xpntransaction from world state: %v", err)
 	}
@@ -159,14 +545,6 @@ func (s *SmartContract) XpnTransactionExists(ctx contractapi.TransactionContextI
 }
 
 
-
-
-
-
-
-
-
-
 // ---------------------------------------------------- PATIENTS -------------------------------------------------------------- //
 // Validations for the patient parameters
 func (s *SmartContract) validatePatient(patient Patient) error {
@@ -200,8 +578,10 @@ func (s *SmartContract) validatePatient(patient Patient) error {
 }
 
 
-// CreatePatient creates a new patient with given details.
-func (s *SmartContract) CreatePatient(ctx contractapi.TransactionContextInterface, id string, firstName string, middleName string, 
+// createPatient creates a new patient with given details. The random salt of the details hash
+// is passed in the transient map under "salt". It is not a transaction, the identifiable fields
+// would be part of the proposal; use CreatePatientTransient.
+func (s *SmartContract) createPatient(ctx contractapi.TransactionContextInterface, id string, firstName string, middleName string, 
 	lastName string, birthDate string, birthPlace string, weight string, height string) error {
 	
 	weightFloat, err := strconv.ParseFloat(weight, 64)
@@ -240,17 +620,19 @@ func (s *SmartContract) CreatePatient(ctx contractapi.TransactionContextInterfac
 	if err != nil {
 		return err
 	}
-	
-	patientJSON, err := json.Marshal(patient)
+
+	err = s.assignPseudonym(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(id, patientJSON)
+	return s.putPatient(ctx, patient)
 }
 
 
-// ReadPatient returns the patient stored in the world state with given id.
+// ReadPatient returns the patient stored in the world state with given id. The identifiable
+// fields are only filled in for members of the patient details collection whose access to the
+// patient was recorded by AuditedReadPatient within the last auditedReadWindow.
 func (s *SmartContract) ReadPatient(ctx contractapi.TransactionContextInterface, id string) (*Patient, error) {
 	patientJSON, err := ctx.GetStub().GetState(id)
 	if err != nil {
@@ -266,11 +648,21 @@ func (s *SmartContract) ReadPatient(ctx contractapi.TransactionContextInterface,
 		return nil, err
 	}
 
-	return &patient, nil
+	recorded, err := s.accessRecorded(ctx, id, id)
+	if err != nil {
+		return nil, err
+	}
+	if !recorded {
+		return &patient, nil
+	}
+
+	return s.withPatientDetails(ctx, &patient), nil
 }
 
-// UpdatePatient updates an existing patient in the world state with provided parameters.
-func (s *SmartContract) UpdatePatient(ctx contractapi.TransactionContextInterface, id string, firstName string, middleName string, 
+// updatePatient updates an existing patient in the world state with provided parameters. A new
+// random salt is passed in the transient map under "salt". Like createPatient it is only reached
+// through UpdatePatientTransient.
+func (s *SmartContract) updatePatient(ctx contractapi.TransactionContextInterface, id string, firstName string, middleName string, 
 	lastName string, birthDate string, birthPlace string, weight string, height string) error {
 	
 	weightFloat, err := strconv.ParseFloat(weight, 64)
@@ -283,14 +675,24 @@ func (s *SmartContract) UpdatePatient(ctx contractapi.TransactionContextInterfac
         return fmt.Errorf("invalid height: %s", height)
     }
 
-	exists, err := s.PatientExists(ctx, id)
+	patientJSON, err := ctx.GetStub().GetState(id)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read patient from world state: %v", err)
 	}
-	if !exists {
+	if patientJSON == nil {
 		return fmt.Errorf("Cannot update patient. Patient with id %s does not exist", id)
 	}
 
+	// the details of encrypted patients are only kept encrypted, and never again once shredded
+	var existing Patient
+	err = json.Unmarshal(patientJSON, &existing)
+	if err != nil {
+		return err
+	}
+	if existing.EncryptedDetails != "" {
+		return fmt.Errorf("Cannot update patient. Patient with id %s is encrypted", id)
+	}
+
 	// overwriting original patient with new patient
 	patient := Patient{
 		ID:         id,
@@ -308,25 +710,36 @@ func (s *SmartContract) UpdatePatient(ctx contractapi.TransactionContextInterfac
 	if err != nil {
 		return err
 	}
-			
-	patientJSON, err := json.Marshal(patient)
-	if err != nil {
-		return err
-	}
 
-	return ctx.GetStub().PutState(id, patientJSON)
+	return s.putPatient(ctx, patient)
 }
 
-// DeletePatient deletes a patient from the world state.
+// DeletePatient deletes a patient from the world state. The data key of an encrypted patient is
+// destroyed, so that the details left in the ledger history cannot be decrypted.
 func (s *SmartContract) DeletePatient(ctx contractapi.TransactionContextInterface, id string) error {
-	exists, err := s.PatientExists(ctx, id)
+	patientJSON, err := ctx.GetStub().GetState(id)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read patient from world state: %v", err)
 	}
-	if !exists {
+	if patientJSON == nil {
 		return fmt.Errorf("Cannot delete patient. Patient with id %s does not exist", id)
 	}
 
+	var patient Patient
+	err = json.Unmarshal(patientJSON, &patient)
+	if err != nil {
+		return err
+	}
+	err = s.shredPatientKeys(ctx, &patient)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelPrivateData(patientDetailsCollection, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete patient details from private data collection: %v", err)
+	}
+
 	return ctx.GetStub().DelState(id)
 }
 
@@ -349,7 +762,8 @@ func startsWithDigit(s string) bool {
     return false
 }
 
-// GetAllPatients returns all patients found in world state
+// GetAllPatients returns all patients found in world state, without their identifiable fields,
+// which are only returned by ReadPatient after an audited read.
 func (s *SmartContract) GetAllPatients(ctx contractapi.TransactionContextInterface) ([]*Patient, error) {
 	// range query with empty string for startKey and endKey does an
 	// open-ended query of all assets in the chaincode namespace.
@@ -393,9 +807,9 @@ func (s *SmartContract) validateContract(contract Contract) error {
 		return fmt.Errorf("patient must be non-empty")
 	}
 
-	//check patient is a number
-	if _, err := strconv.Atoi(contract.Patient); err != nil {
-		return fmt.Errorf("patient must be numeric")
+	//check patient is a pseudonym
+	if !isPseudonym(contract.Patient) {
+		return fmt.Errorf("patient must be a pseudonym")
 	}
 
 	// Check if all other fields are positive numbers
@@ -457,8 +871,12 @@ func (s *SmartContract) CreateContract(ctx contractapi.TransactionContextInterfa
         return fmt.Errorf("invalid maxBloodPressureDiastolic: %s", maxBloodPressureDiastolic)
     }
 
-	//create id based on patient
-	id := "C" + patient
+	//create id based on the patient pseudonym
+	pseudonym, err := s.pseudonymFor(ctx, patient)
+	if err != nil {
+		return err
+	}
+	id := "C" + pseudonym
 
 	// check if a contract is already created for a patient
 	contractExists, err := s.ContractExists(ctx, id)
@@ -480,7 +898,7 @@ func (s *SmartContract) CreateContract(ctx contractapi.TransactionContextInterfa
 
 	contract := Contract{
 		ID:         		  		id,	
-		Patient:					patient,
+		Patient:					pseudonym,
 		MinOxygenSaturation:  		minOxygenSaturationFloat,
 		MaxOxygenSaturation:  		maxOxygenSaturationFloat,
 		MinPulseRate: 		  		minPulseRateFloat,
@@ -574,8 +992,11 @@ func (s *SmartContract) UpdateContract(ctx contractapi.TransactionContextInterfa
         return fmt.Errorf("invalid maxBloodPressureDiastolic: %s", maxBloodPressureDiastolic)
     }
 
-	
-	id := "C" + patient
+	pseudonym, err := s.pseudonymFor(ctx, patient)
+	if err != nil {
+		return err
+	}
+	id := "C" + pseudonym
 
 	// check if the contract exists
 	contractExists, err := s.ContractExists(ctx, id)
@@ -598,7 +1019,7 @@ func (s *SmartContract) UpdateContract(ctx contractapi.TransactionContextInterfa
 	// overwriting original contract with new contract
 	contract := Contract{
 		ID:         		  		id,	
-		Patient:					patient,
+		Patient:					pseudonym,
 		MinOxygenSaturation:  		minOxygenSaturationFloat,
 		MaxOxygenSaturation:  		maxOxygenSaturationFloat,
 		MinPulseRate: 		  		minPulseRateFloat,
@@ -694,9 +1115,20 @@ func (s *SmartContract) validateRTData(rtdata RTData) error {
 		return fmt.Errorf("patient must be non-empty")
 	}
 
-	//check patient is a number
-	if _, err := strconv.Atoi(rtdata.Patient); err != nil {
-		return fmt.Errorf("patient must be numeric")
+	//check patient is a pseudonym
+	if !isPseudonym(rtdata.Patient) {
+		return fmt.Errorf("patient must be a pseudonym")
+	}
+
+	// Pointer records keep the measurements in XPN
+	if rtdata.Pointer {
+		if strings.TrimSpace(rtdata.Path) == "" {
+			return fmt.Errorf("path must be non-empty")
+		}
+		if strings.TrimSpace(rtdata.Hash) == "" {
+			return fmt.Errorf("hash must be non-empty")
+		}
+		return nil
 	}
 
 	// Check if all other fields are positive numbers 
@@ -734,7 +1166,11 @@ func (s *SmartContract) CreateRTData(ctx contractapi.TransactionContextInterface
         return fmt.Errorf("invalid bloodPressureDiastolic: %s", bloodPressureDiastolic)
     }
 
-	id := "RTD" + patient
+	pseudonym, err := s.pseudonymFor(ctx, patient)
+	if err != nil {
+		return err
+	}
+	id := "RTD" + pseudonym
 
 	rtDataExists, err := s.RTDataExists(ctx, id)
 	if err != nil {
@@ -755,7 +1191,7 @@ func (s *SmartContract) CreateRTData(ctx contractapi.TransactionContextInterface
 
 	rtData:= RTData{
 		ID:         	  		 id,
-		Patient:			     patient,
+		Patient:			     pseudonym,
 		OxygenSaturation: 		 oxygenSaturationFloat,
 		PulseRate: 		  		 pulseRateFloat,
 		Temperature:   	  		 temperatureFloat,
@@ -794,7 +1230,7 @@ func (s *SmartContract) ReadRTData(ctx contractapi.TransactionContextInterface,
 		return nil, err
 	}
 
-	return &rtData, nil
+	return withRTDataPointer(ctx, &rtData), nil
 }
 
 
@@ -823,7 +1259,11 @@ func (s *SmartContract) UpdateRTData(ctx contractapi.TransactionContextInterface
         return fmt.Errorf("invalid bloodPressureDiastolic: %s", bloodPressureDiastolic)
     }
 
-	id := "RTD" + patient
+	pseudonym, err := s.pseudonymFor(ctx, patient)
+	if err != nil {
+		return err
+	}
+	id := "RTD" + pseudonym
 
 	rtDataexists, err := s.RTDataExists(ctx, id)
 	if err != nil {
@@ -845,7 +1285,7 @@ func (s *SmartContract) UpdateRTData(ctx contractapi.TransactionContextInterface
 	// overwriting original measurements with new measurements
 	rtData:= RTData{
 		ID:         	  		 id,
-		Patient:				 patient,
+		Patient:				 pseudonym,
 		OxygenSaturation: 		 oxygenSaturationFloat,
 		PulseRate: 		  		 pulseRateFloat,
 		Temperature:   	  		 temperatureFloat,
@@ -917,7 +1357,7 @@ func (s *SmartContract) GetAllRTData(ctx contractapi.TransactionContextInterface
 			if err != nil {
 				return nil, err
 			}
-			measurements = append(measurements, &rtData)
+			measurements = append(measurements, withRTDataPointer(ctx, &rtData))
 		}
 	}
 
@@ -937,9 +1377,9 @@ func (s *SmartContract) validateDiagnosis(diagnosis Diagnosis) error {
 		return fmt.Errorf("patient must be non-empty")
 	}
 
-	//check patient is a number
-	if _, err := strconv.Atoi(diagnosis.Patient); err != nil {
-		return fmt.Errorf("patient must be numeric")
+	//check patient is a pseudonym
+	if !isPseudonym(diagnosis.Patient) {
+		return fmt.Errorf("patient must be a pseudonym")
 	}
 
 	return nil
@@ -956,9 +1396,12 @@ func (s *SmartContract) CreateDiagnosis(ctx contractapi.TransactionContextInterf
 	temperatureDiagnosis = "None"
 	var bloodPressureDiagnosis string
 	bloodPressureDiagnosis = "None"
-	id := "D" + patient
+	pseudonym, err := s.pseudonymFor(ctx, patient)
+	if err != nil {
+		return err
+	}
+	id := "D" + pseudonym
 
-	
 	diagnosisExists, err := s.DiagnosisExists(ctx, id)
 	if err != nil {
 		return err
@@ -978,7 +1421,7 @@ func (s *SmartContract) CreateDiagnosis(ctx contractapi.TransactionContextInterf
 	
 	diagnosis:= Diagnosis{
 		ID:        					id,
-		Patient:					patient,
+		Patient:					pseudonym,
 		OxygenSaturationDiagnosis:  oxygenSaturationDiagnosis,	
 		PulseRateDiagnosis:     	pulseRateDiagnosis,
 		TemperatureDiagnosis:       temperatureDiagnosis,
@@ -1025,21 +1468,32 @@ func (s *SmartContract) ReadDiagnosis(ctx contractapi.TransactionContextInterfac
 // UpdateDiagnosis checks real time data for a specific patient and issue the diagnosis and 
 // an alert if any measurement is outside the contract limits.
 func (s *SmartContract) UpdateDiagnosis(ctx contractapi.TransactionContextInterface, patient string) error {
-	contractID := "C" + patient
+	pseudonym, err := s.pseudonymFor(ctx, patient)
+	if err != nil {
+		return err
+	}
+
+	contractID := "C" + pseudonym
 	contract, err := s.ReadContract(ctx, contractID)
 	if err != nil {
 		return fmt.Errorf("Could not read contract: %s", err.Error())
 	}
 
-	rtdID := "RTD" + patient
+	rtdID := "RTD" + pseudonym
 	rtData, err := s.ReadRTData(ctx, rtdID)
 	if err != nil {
 		return fmt.Errorf("Could not read RTData: %s", err.Error())
 	}
 
-	id := "D" + patient
+	// the measurements of pointer records are read from the document passed in the transient map
+	rtData, err = s.resolveRTData(ctx, patient, rtData)
+	if err != nil {
+		return err
+	}
+
+	id := "D" + pseudonym
 
-	diagnosis := Diagnosis{ID: id, Patient: patient}
+	diagnosis := Diagnosis{ID: id, Patient: pseudonym}
 
 	if rtData.OxygenSaturation < contract.MinOxygenSaturation {
 		diagnosis.OxygenSaturationDiagnosis = "Low oxygen saturation"
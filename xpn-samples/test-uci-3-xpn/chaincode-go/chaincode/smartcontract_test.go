@@ -1,16 +1,34 @@
 package chaincode_test
 
 import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hyperledger/fabric-chaincode-go/v2/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
 	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
 	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/queryresult"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/attestation"
 	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
 	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode/mocks"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/merkle"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //go:generate counterfeiter -o mocks/transaction.go -fake-name TransactionContext . transactionContext
@@ -28,157 +46,1275 @@ type stateQueryIterator interface {
 	shim.StateQueryIteratorInterface
 }
 
-func TestInitLedger(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+// clientIdentity is the identity of the caller of a transaction. cert is its certificate, nil for
+// identities that are not X.509 based.
+type clientIdentity struct {
+	msp        string
+	attributes map[string]string
+	cert       *x509.Certificate
+}
+
+func (c *clientIdentity) GetID() (string, error) {
+	return "x509::CN=user1::CN=ca." + c.msp, nil
+}
+
+func (c *clientIdentity) GetMSPID() (string, error) {
+	return c.msp, nil
+}
+
+func (c *clientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := c.attributes[attrName]
+	return value, found, nil
+}
+
+func (c *clientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	if c.attributes[attrName] != attrValue {
+		return fmt.Errorf("attribute '%s' equals '%s', not '%s'", attrName, c.attributes[attrName], attrValue)
+	}
+	return nil
+}
 
-	assetTransfer := chaincode.SmartContract{}
-	err := assetTransfer.InitLedger(transactionContext)
+func (c *clientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return c.cert, nil
+}
+
+// newCertificate returns a key and a self-signed certificate for it.
+func newCertificate(t *testing.T, commonName string) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
 	require.NoError(t, err)
 
-	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
-	err = assetTransfer.InitLedger(transactionContext)
-	require.EqualError(t, err, "failed to put to world state. failed inserting key")
+	return key, cert
+}
+
+// ledger is an in-memory world state and private data store behind a ChaincodeStub. Every call
+// to tx starts a new transaction.
+type ledger struct {
+	state     map[string][]byte
+	private   map[string]map[string][]byte
+	transient map[string][]byte
+	events    map[string][]byte
+	txID      string
+	txTime    time.Time
+	txCount   int
+	identity  *clientIdentity
+	stub      *mocks.ChaincodeStub
+	ctx       *mocks.TransactionContext
+}
+
+func newLedger() *ledger {
+	l := &ledger{
+		state:    map[string][]byte{},
+		private:  map[string]map[string][]byte{},
+		events:   map[string][]byte{},
+		txTime:   time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		identity: &clientIdentity{msp: "Org1MSP", attributes: map[string]string{"xpn.reidentify": "true", "xpn.dpo": "true"}},
+		stub:     &mocks.ChaincodeStub{},
+		ctx:      &mocks.TransactionContext{},
+	}
+
+	l.stub.GetStateStub = func(key string) ([]byte, error) {
+		return l.state[key], nil
+	}
+	l.stub.PutStateStub = func(key string, value []byte) error {
+		l.state[key] = value
+		return nil
+	}
+	l.stub.DelStateStub = func(key string) error {
+		delete(l.state, key)
+		return nil
+	}
+	l.stub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	l.stub.SplitCompositeKeyStub = (&shim.ChaincodeStub{}).SplitCompositeKey
+	l.stub.GetStateByPartialCompositeKeyStub = func(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		prefix, err := shim.CreateCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		return l.iterator(l.state, func(key string) bool { return strings.HasPrefix(key, prefix) }), nil
+	}
+	l.stub.GetStateByRangeStub = func(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+		// range queries do not return composite keys
+		return l.iterator(l.state, func(key string) bool {
+			return !strings.HasPrefix(key, "\x00") && key >= startKey && (endKey == "" || key < endKey)
+		}), nil
+	}
+	l.stub.GetPrivateDataStub = func(collection string, key string) ([]byte, error) {
+		return l.private[collection][key], nil
+	}
+	l.stub.GetPrivateDataHashStub = func(collection string, key string) ([]byte, error) {
+		value, ok := l.private[collection][key]
+		if !ok {
+			return nil, nil
+		}
+		sum := sha256.Sum256(value)
+		return sum[:], nil
+	}
+	l.stub.PutPrivateDataStub = func(collection string, key string, value []byte) error {
+		if l.private[collection] == nil {
+			l.private[collection] = map[string][]byte{}
+		}
+		l.private[collection][key] = value
+		return nil
+	}
+	l.stub.DelPrivateDataStub = func(collection string, key string) error {
+		delete(l.private[collection], key)
+		return nil
+	}
+	l.stub.PurgePrivateDataStub = l.stub.DelPrivateDataStub
+	l.stub.GetPrivateDataByPartialCompositeKeyStub = func(collection string, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		prefix, err := shim.CreateCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		return l.iterator(l.private[collection], func(key string) bool { return strings.HasPrefix(key, prefix) }), nil
+	}
+	l.stub.GetTransientStub = func() (map[string][]byte, error) {
+		return l.transient, nil
+	}
+	l.stub.GetTxIDStub = func() string {
+		return l.txID
+	}
+	l.stub.GetTxTimestampStub = func() (*timestamppb.Timestamp, error) {
+		return timestamppb.New(l.txTime), nil
+	}
+	l.stub.SetEventStub = func(name string, payload []byte) error {
+		l.events[name] = payload
+		return nil
+	}
+
+	l.ctx.GetStubReturns(l.stub)
+	l.ctx.GetClientIdentityStub = func() cid.ClientIdentity {
+		return l.identity
+	}
+
+	// the organizations have set their pseudonym keys
+	for _, msp := range []string{"Org1MSP", "Org2MSP"} {
+		key, _ := shim.CreateCompositeKey("PseudonymKey", []string{msp})
+		_ = l.stub.PutPrivateData("patientDetailsCollection", key, []byte("pseudonym key of "+msp+" 0123456789"))
+	}
+
+	return l
+}
+
+// iterator returns the entries of state whose key matches, in key order.
+func (l *ledger) iterator(state map[string][]byte, match func(key string) bool) *mocks.StateQueryIterator {
+	var keys []string
+	for key := range state {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextStub = func() bool {
+		return len(keys) > 0
+	}
+	iterator.NextStub = func() (*queryresult.KV, error) {
+		key := keys[0]
+		keys = keys[1:]
+		return &queryresult.KV{Key: key, Value: state[key]}, nil
+	}
+
+	return iterator
 }
 
-func TestCreateAsset(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+// tx starts a new transaction with the given transient map, one minute after the previous one.
+func (l *ledger) tx(transient map[string][]byte) *mocks.TransactionContext {
+	l.txCount++
+	l.txID = fmt.Sprintf("tx%d", l.txCount)
+	l.txTime = l.txTime.Add(time.Minute)
+	l.transient = transient
 
-	assetTransfer := chaincode.SmartContract{}
-	err := assetTransfer.CreateAsset(transactionContext, "", "", 0, "", 0)
+	return l.ctx
+}
+
+// patientTransient returns the transient map of CreatePatientTransient and UpdatePatientTransient.
+func patientTransient(id string, firstName string, weight string) map[string][]byte {
+	patient := fmt.Sprintf(`{"id": "%s", "firstName": "%s", "lastName": "Lovelace", "birthDate": "10-12-1915",
+		"birthPlace": "London", "weight": "%s", "height": "1.65"}`, id, firstName, weight)
+	return map[string][]byte{"patient": []byte(patient), "salt": []byte("0123456789abcdef" + id)}
+}
+
+// createPatient creates the patient with given id and returns its pseudonym.
+func createPatient(t *testing.T, l *ledger, s *chaincode.SmartContract, id string) string {
+	err := s.CreatePatientTransient(l.tx(patientTransient(id, "Ada", "60")))
 	require.NoError(t, err)
 
-	chaincodeStub.GetStateReturns([]byte{}, nil)
-	err = assetTransfer.CreateAsset(transactionContext, "asset1", "", 0, "", 0)
-	require.EqualError(t, err, "the asset asset1 already exists")
+	pseudonym, err := s.GetPatientPseudonym(l.tx(nil), id)
+	require.NoError(t, err)
 
-	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
-	err = assetTransfer.CreateAsset(transactionContext, "asset1", "", 0, "", 0)
-	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
+	return pseudonym
 }
 
-func TestReadAsset(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+func fileHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCreateXpnTransaction(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	pseudonym := createPatient(t, l, s, "1")
+
+	id, err := s.CreateXpnTransaction(l.tx(nil), fileHash("patient"), "/tmp/expand/xpn/1//patient.txt", "1", "patient", "7", "sha256", "xpn")
+	require.NoError(t, err)
+	require.Equal(t, l.txID, id)
+
+	xpntransaction, err := s.ReadXpnTransaction(l.tx(nil), id)
+	require.NoError(t, err)
+	require.Equal(t, pseudonym, xpntransaction.Patient)
+	require.Equal(t, "/tmp/expand/xpn/1/patient.txt", xpntransaction.Path)
+	require.Equal(t, "Org1MSP", xpntransaction.CreatorMSP)
+	require.Equal(t, "patient", xpntransaction.DocumentType)
+	require.Equal(t, int64(7), xpntransaction.Size)
+	require.Equal(t, 1, xpntransaction.Version)
+	require.Equal(t, "2024-03-01T12:03:00.000000000Z", xpntransaction.Timestamp)
+
+	// the anchor is found by patient id and by pseudonym
+	for _, patient := range []string{"1", pseudonym} {
+		xpntransactions, err := s.GetXpnTransactionsByPatient(l.tx(nil), patient)
+		require.NoError(t, err)
+		require.Len(t, xpntransactions, 1)
+		require.Equal(t, id, xpntransactions[0].ID)
+	}
 
-	expectedAsset := &chaincode.Asset{ID: "asset1"}
-	bytes, err := json.Marshal(expectedAsset)
+	// a pseudonym is accepted in place of the patient id
+	contractID, err := s.CreateXpnTransaction(l.tx(nil), fileHash("contract"), "/tmp/expand/xpn/1/contract.txt", pseudonym, "contract", "8", "sha256", "xpn")
 	require.NoError(t, err)
+	xpntransactions, err := s.GetXpnTransactionsByDocumentType(l.tx(nil), "contract")
+	require.NoError(t, err)
+	require.Len(t, xpntransactions, 1)
+	require.Equal(t, contractID, xpntransactions[0].ID)
 
-	chaincodeStub.GetStateReturns(bytes, nil)
-	assetTransfer := chaincode.SmartContract{}
-	asset, err := assetTransfer.ReadAsset(transactionContext, "")
+	// clients that allocate their own ids still can, but not twice
+	err = s.CreateXpnTransactionWithID(l.tx(nil), "7", fileHash("other"), "/tmp/expand/xpn/1/other.txt", "1", "patient", "5", "sha256", "xpn")
 	require.NoError(t, err)
-	require.Equal(t, expectedAsset, asset)
+	err = s.CreateXpnTransactionWithID(l.tx(nil), "7", fileHash("other"), "/tmp/expand/xpn/1/another.txt", "1", "patient", "5", "sha256", "xpn")
+	require.EqualError(t, err, "Cannot create xpntransaction. XpnTransaction with id 7 already exists")
+
+	_, err = s.CreateXpnTransaction(l.tx(nil), fileHash("other"), "/tmp/expand/xpn/1/patient.txt", "1", "patient", "5", "sha256", "xpn")
+	require.EqualError(t, err, fmt.Sprintf("Cannot create xpntransaction. Path /tmp/expand/xpn/1/patient.txt is already anchored by xpntransaction %s, use UpdateXpnTransaction", id))
+	err = s.CreateXpnTransactionWithID(l.tx(nil), "7/8", fileHash("other"), "/tmp/expand/xpn/1/another.txt", "1", "patient", "5", "sha256", "xpn")
+	require.EqualError(t, err, "ID must be at most 128 letters, digits, '.', '_' or '-'")
 
-	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
-	_, err = assetTransfer.ReadAsset(transactionContext, "")
-	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
+	_, err = s.CreateXpnTransaction(l.tx(nil), fileHash("patient"), "/tmp/expand/xpn/2/patient.txt", "2", "patient", "7", "sha256", "xpn")
+	require.EqualError(t, err, "Cannot resolve pseudonym. Patient with id 2 has no pseudonym")
 
-	chaincodeStub.GetStateReturns(nil, nil)
-	asset, err = assetTransfer.ReadAsset(transactionContext, "asset1")
-	require.EqualError(t, err, "the asset asset1 does not exist")
-	require.Nil(t, asset)
+	_, err = s.CreateXpnTransaction(l.tx(nil), fileHash("patient"), "/tmp/expand/xpn/2/patient.txt", strings.Repeat("0", 32), "patient", "7", "sha256", "xpn")
+	require.EqualError(t, err, "Cannot resolve pseudonym. Pseudonym 00000000000000000000000000000000 is unknown")
+
+	_, err = s.CreateXpnTransaction(l.tx(nil), fileHash("patient"), "tmp/patient.txt", "1", "patient", "7", "sha256", "xpn")
+	require.EqualError(t, err, "Path must be absolute")
+
+	_, err = s.CreateXpnTransaction(l.tx(nil), fileHash("patient"), "/tmp/expand/xpn/1/notes.txt", "1", "notes", "7", "sha256", "xpn")
+	require.EqualError(t, err, "DocumentType must be one of patient, contract, diagnosis or vital_signs")
+
+	l.identity = &clientIdentity{msp: "Org3MSP"}
+	_, err = s.CreateXpnTransaction(l.tx(nil), fileHash("patient"), "/tmp/expand/xpn/1/diagnosis.txt", "1", "diagnosis", "7", "sha256", "xpn")
+	require.EqualError(t, err, "Cannot resolve pseudonym. Caller is not a member of patientDetailsCollection")
 }
 
-func TestUpdateAsset(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+func TestUpdateXpnTransaction(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	pseudonym := createPatient(t, l, s, "1")
+
+	path := "/tmp/expand/xpn/1/contract.txt"
+	first, err := s.CreateXpnTransaction(l.tx(nil), fileHash("v1"), path, "1", "contract", "2", "sha256", "xpn")
+	require.NoError(t, err)
+
+	second, err := s.UpdateXpnTransaction(l.tx(nil), path, fileHash("v2"), "2")
+	require.NoError(t, err)
 
-	expectedAsset := &chaincode.Asset{ID: "asset1"}
-	bytes, err := json.Marshal(expectedAsset)
+	current, err := s.ReadXpnTransactionByPath(l.tx(nil), "/tmp/expand/xpn/1/./contract.txt")
 	require.NoError(t, err)
+	require.Equal(t, second, current.ID)
+	require.Equal(t, 2, current.Version)
+	require.Equal(t, first, current.PreviousID)
+	require.Equal(t, "1220"+fileHash("v1"), current.PreviousHash)
+	require.Equal(t, "contract", current.DocumentType)
+	require.Equal(t, pseudonym, current.Patient)
 
-	chaincodeStub.GetStateReturns(bytes, nil)
-	assetTransfer := chaincode.SmartContract{}
-	err = assetTransfer.UpdateAsset(transactionContext, "", "", 0, "", 0)
+	history, err := s.GetXpnTransactionHistory(l.tx(nil), path)
 	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, first, history[0].ID)
+	require.Equal(t, second, history[1].ID)
 
-	chaincodeStub.GetStateReturns(nil, nil)
-	err = assetTransfer.UpdateAsset(transactionContext, "asset1", "", 0, "", 0)
-	require.EqualError(t, err, "the asset asset1 does not exist")
+	_, err = s.UpdateXpnTransaction(l.tx(nil), path, fileHash("v2"), "2")
+	require.EqualError(t, err, fmt.Sprintf("Cannot update xpntransaction. Path %s is already anchored with hash %s", path, fileHash("v2")))
 
-	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
-	err = assetTransfer.UpdateAsset(transactionContext, "asset1", "", 0, "", 0)
-	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
+	_, err = s.UpdateXpnTransaction(l.tx(nil), "/tmp/expand/xpn/1/missing.txt", fileHash("v2"), "2")
+	require.EqualError(t, err, "Cannot update xpntransaction. Path /tmp/expand/xpn/1/missing.txt is not anchored")
 }
 
-func TestDeleteAsset(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+func TestReadXpnTransactionByHash(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	createPatient(t, l, s, "1")
 
-	asset := &chaincode.Asset{ID: "asset1"}
-	bytes, err := json.Marshal(asset)
+	id, err := s.CreateXpnTransaction(l.tx(nil), fileHash("vital signs"), "/tmp/expand/xpn/1/vital_signs_1.txt", "1", "vital_signs", "11", "sha256", "xpn")
 	require.NoError(t, err)
 
-	chaincodeStub.GetStateReturns(bytes, nil)
-	chaincodeStub.DelStateReturns(nil)
-	assetTransfer := chaincode.SmartContract{}
-	err = assetTransfer.DeleteAsset(transactionContext, "")
+	// a bare digest and the multihash find the same anchor
+	for _, hash := range []string{fileHash("vital signs"), "1220" + fileHash("vital signs")} {
+		xpntransactions, err := s.ReadXpnTransactionByHash(l.tx(nil), hash)
+		require.NoError(t, err)
+		require.Len(t, xpntransactions, 1)
+		require.Equal(t, id, xpntransactions[0].ID)
+	}
+
+	byType, err := s.GetXpnTransactionsByDocumentType(l.tx(nil), "vital_signs")
 	require.NoError(t, err)
+	require.Len(t, byType, 1)
 
-	chaincodeStub.GetStateReturns(nil, nil)
-	err = assetTransfer.DeleteAsset(transactionContext, "asset1")
-	require.EqualError(t, err, "the asset asset1 does not exist")
+	all, err := s.GetAllXpnTransactions(l.tx(nil))
+	require.NoError(t, err)
+	require.Len(t, all, 1)
 
-	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
-	err = assetTransfer.DeleteAsset(transactionContext, "")
-	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
+	_, err = s.ReadXpnTransactionByHash(l.tx(nil), fileHash("other"))
+	require.EqualError(t, err, fmt.Sprintf("Hash 1220%s was never anchored", fileHash("other")))
+
+	_, err = s.ReadXpnTransactionByPath(l.tx(nil), "/tmp/expand/xpn/1//contract.txt")
+	require.EqualError(t, err, "Path /tmp/expand/xpn/1/contract.txt is not anchored")
 }
 
-func TestTransferAsset(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+func TestVerifyXpnFile(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	createPatient(t, l, s, "1")
+
+	id, err := s.CreateXpnTransaction(l.tx(nil), fileHash("patient"), "/tmp/expand/xpn/1/patient.txt", "1", "patient", "7", "sha256", "xpn")
+	require.NoError(t, err)
+
+	// a bare digest of the anchored hash algorithm is accepted, no event is emitted for an intact file
+	verification, err := s.VerifyXpnFile(l.tx(nil), id, fileHash("patient"))
+	require.NoError(t, err)
+	require.True(t, verification.Intact)
+	require.Equal(t, "1220"+fileHash("patient"), verification.RecomputedHash)
+	require.Equal(t, "x509::CN=user1::CN=ca.Org1MSP", verification.Verifier)
+	require.Empty(t, l.events)
 
-	asset := &chaincode.Asset{ID: "asset1"}
-	bytes, err := json.Marshal(asset)
+	verification, err = s.VerifyXpnFileByPath(l.tx(nil), "/tmp/expand/xpn/1//patient.txt", fileHash("modified"))
 	require.NoError(t, err)
+	require.False(t, verification.Intact)
+	require.Equal(t, id, verification.XpnTransaction)
+	require.Equal(t, "1220"+fileHash("patient"), verification.AnchoredHash)
+
+	var event chaincode.XpnVerification
+	require.NoError(t, json.Unmarshal(l.events["XpnFileMismatch"], &event))
+	require.Equal(t, *verification, event)
 
-	chaincodeStub.GetStateReturns(bytes, nil)
-	assetTransfer := chaincode.SmartContract{}
-	_, err = assetTransfer.TransferAsset(transactionContext, "", "")
+	verifications, err := s.GetXpnVerifications(l.tx(nil), id)
 	require.NoError(t, err)
+	require.Len(t, verifications, 2)
+	require.True(t, verifications[0].Intact)
+	require.False(t, verifications[1].Intact)
 
-	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
-	_, err = assetTransfer.TransferAsset(transactionContext, "", "")
-	require.EqualError(t, err, "failed to read from world state: unable to retrieve asset")
+	_, err = s.VerifyXpnFileByPath(l.tx(nil), "/tmp/expand/xpn/1/contract.txt", fileHash("contract"))
+	require.EqualError(t, err, "Cannot verify file. No xpntransaction anchors path /tmp/expand/xpn/1/contract.txt")
+	_, err = s.VerifyXpnFile(l.tx(nil), id, " ")
+	require.EqualError(t, err, "recomputedHash must be non-empty")
 }
 
-func TestGetAllAssets(t *testing.T) {
-	asset := &chaincode.Asset{ID: "asset1"}
-	bytes, err := json.Marshal(asset)
+// xpnTransactionTransient returns the transient map of CreateXpnTransactionTransient anchoring the
+// patient file of patient 1. fields are appended to the members of the JSON payload.
+func xpnTransactionTransient(hash string, size string, fields string) map[string][]byte {
+	input := fmt.Sprintf(`{"hash": "%s", "path": "/tmp/expand/xpn/1/patient.txt", "patient": "1",
+		"documentType": "patient", "size": "%s", "hashAlgorithm": "sha256", "partition": "xpn"%s}`, hash, size, fields)
+	return map[string][]byte{"xpntransaction": []byte(input)}
+}
+
+func TestXpnTransactionChunks(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	createPatient(t, l, s, "1")
+
+	a, b, c, d := fileHash("a"), fileHash("b"), fileHash("c"), fileHash("d")
+	for _, invalid := range []struct {
+		fields string
+		err    string
+	}{
+		{fmt.Sprintf(`, "chunkSize": "4", "chunkHashes": ["%s", "%s"]`, a, b), "a file of 10 bytes has 3 chunks of 4 bytes, got 2 chunk hashes"},
+		{fmt.Sprintf(`, "chunkSize": "4", "chunkHashes": ["%s", "%s", "%s", "%s"]`, a, b, c, d), "a file of 10 bytes has 3 chunks of 4 bytes, got 4 chunk hashes"},
+		{fmt.Sprintf(`, "chunkHashes": ["%s"]`, a), "chunkSize must be set with chunkHashes"},
+		{`, "chunkSize": "0"`, "invalid chunkSize: 0, must be a positive number"},
+	} {
+		_, err := s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "10", invalid.fields)))
+		require.EqualError(t, err, invalid.err, invalid.fields)
+	}
+	_, err := s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "2", `, "chunkSize": "1", "chunkHashes": ["a", "b"]`)))
+	require.ErrorContains(t, err, "invalid hash of chunk 0: ")
+
+	fields := fmt.Sprintf(`, "chunkSize": "4", "chunkHashes": ["%s", "%s", "%s"]`, a, b, c)
+	id, err := s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "10", fields)))
+	require.NoError(t, err)
+	xpntransaction, err := s.ReadXpnTransaction(l.tx(nil), id)
 	require.NoError(t, err)
+	require.Equal(t, int64(4), xpntransaction.ChunkSize)
+	require.Equal(t, []string{"1220" + a, "1220" + b, "1220" + c}, xpntransaction.ChunkHashes)
 
-	iterator := &mocks.StateQueryIterator{}
-	iterator.HasNextReturnsOnCall(0, true)
-	iterator.HasNextReturnsOnCall(1, false)
-	iterator.NextReturns(&queryresult.KV{Value: bytes}, nil)
-
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
-
-	chaincodeStub.GetStateByRangeReturns(iterator, nil)
-	assetTransfer := &chaincode.SmartContract{}
-	assets, err := assetTransfer.GetAllAssets(transactionContext)
-	require.NoError(t, err)
-	require.Equal(t, []*chaincode.Asset{asset}, assets)
-
-	iterator.HasNextReturns(true)
-	iterator.NextReturns(nil, fmt.Errorf("failed retrieving next item"))
-	assets, err = assetTransfer.GetAllAssets(transactionContext)
-	require.EqualError(t, err, "failed retrieving next item")
-	require.Nil(t, assets)
-
-	chaincodeStub.GetStateByRangeReturns(nil, fmt.Errorf("failed retrieving all assets"))
-	assets, err = assetTransfer.GetAllAssets(transactionContext)
-	require.EqualError(t, err, "failed retrieving all assets")
-	require.Nil(t, assets)
+	// the chunk hashes of a new version cover its own size and are not inherited
+	input := fmt.Sprintf(`{"hash": "%s", "path": "/tmp/expand/xpn/1/patient.txt", "size": "12", "chunkSize": "4",
+		"chunkHashes": ["%s", "%s"]}`, fileHash("patient 1b"), a, b)
+	_, err = s.UpdateXpnTransactionTransient(l.tx(map[string][]byte{"xpntransaction": []byte(input)}))
+	require.EqualError(t, err, "a file of 12 bytes has 3 chunks of 4 bytes, got 2 chunk hashes")
+	updatedID, err := s.UpdateXpnTransaction(l.tx(nil), "/tmp/expand/xpn/1/patient.txt", fileHash("patient 1b"), "12")
+	require.NoError(t, err)
+	updated, err := s.ReadXpnTransaction(l.tx(nil), updatedID)
+	require.NoError(t, err)
+	require.Zero(t, updated.ChunkSize)
+	require.Empty(t, updated.ChunkHashes)
+}
+
+func TestXpnTransactionLayout(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	createPatient(t, l, s, "1")
+
+	for _, invalid := range []struct {
+		layout string
+		err    string
+	}{
+		{`{"BlockSize": 0, "ReplicationLevel": 0, "Servers": ["xpn://server1"]}`, "Layout BlockSize must be a positive number"},
+		{`{"BlockSize": 524288, "ReplicationLevel": 0, "Servers": []}`, "Layout Servers must be non-empty"},
+		{`{"BlockSize": 524288, "ReplicationLevel": 0, "Servers": ["xpn://server1", " "]}`, "Layout Servers must be non-empty URLs"},
+		{`{"BlockSize": 524288, "ReplicationLevel": 2, "Servers": ["xpn://server1", "xpn://server2"]}`, "Layout ReplicationLevel must be between 0 and the number of servers minus one"},
+		{`{"BlockSize": 524288, "ReplicationLevel": -1, "Servers": ["xpn://server1"]}`, "Layout ReplicationLevel must be between 0 and the number of servers minus one"},
+	} {
+		_, err := s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "9", `, "layout": `+invalid.layout)))
+		require.EqualError(t, err, invalid.err, invalid.layout)
+	}
+
+	layout := `{"BlockSize": 524288, "ReplicationLevel": 1, "Servers": ["xpn://server1", "xpn://server2"]}`
+	id, err := s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "9", `, "layout": `+layout)))
+	require.NoError(t, err)
+	xpntransaction, err := s.ReadXpnTransaction(l.tx(nil), id)
+	require.NoError(t, err)
+	expected := &chaincode.XpnLayout{BlockSize: 524288, ReplicationLevel: 1, Servers: []string{"xpn://server1", "xpn://server2"}}
+	require.Equal(t, expected, xpntransaction.Layout)
+
+	// a new version keeps the layout unless it passes its own
+	updatedID, err := s.UpdateXpnTransaction(l.tx(nil), "/tmp/expand/xpn/1/patient.txt", fileHash("patient 1b"), "10")
+	require.NoError(t, err)
+	updated, err := s.ReadXpnTransaction(l.tx(nil), updatedID)
+	require.NoError(t, err)
+	require.Equal(t, expected, updated.Layout)
+
+	input := fmt.Sprintf(`{"hash": "%s", "path": "/tmp/expand/xpn/1/patient.txt", "size": "11",
+		"layout": {"BlockSize": 1048576, "ReplicationLevel": 0, "Servers": ["xpn://server3"]}}`, fileHash("patient 1c"))
+	updatedID, err = s.UpdateXpnTransactionTransient(l.tx(map[string][]byte{"xpntransaction": []byte(input)}))
+	require.NoError(t, err)
+	updated, err = s.ReadXpnTransaction(l.tx(nil), updatedID)
+	require.NoError(t, err)
+	require.Equal(t, &chaincode.XpnLayout{BlockSize: 1048576, Servers: []string{"xpn://server3"}}, updated.Layout)
+}
+
+func TestXpnTransactionSignature(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	createPatient(t, l, s, "1")
+	key, cert := newCertificate(t, "user1")
+	hash := "1220" + fileHash("patient 1")
+	path := "/tmp/expand/xpn/1/patient.txt"
+
+	signedAt := l.txTime.Add(time.Minute).Format(time.RFC3339)
+	signature, err := attestation.Sign(key, path, hash, signedAt)
+	require.NoError(t, err)
+	fields := fmt.Sprintf(`, "signature": "%s", "signedAt": "%s"`, signature, signedAt)
+
+	// the submitter must have a certificate the signature verifies against
+	_, err = s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "9", fields)))
+	require.EqualError(t, err, "Cannot verify signature. The caller identity has no X.509 certificate")
+	_, other := newCertificate(t, "user2")
+	l.identity.cert = other
+	_, err = s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "9", fields)))
+	require.ErrorContains(t, err, "Cannot verify signature of "+path+": ")
+	l.identity.cert = cert
+
+	// a signature over another hash or a signing time later than the transaction are rejected
+	_, err = s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 2"), "9", fields)))
+	require.ErrorContains(t, err, "Cannot verify signature of "+path+": ")
+	late := l.txTime.Add(time.Hour).Format(time.RFC3339)
+	lateSignature, err := attestation.Sign(key, path, hash, late)
+	require.NoError(t, err)
+	_, err = s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "9",
+		fmt.Sprintf(`, "signature": "%s", "signedAt": "%s"`, lateSignature, late))))
+	require.EqualError(t, err, fmt.Sprintf("invalid signedAt: %s is later than the transaction timestamp", late))
+	_, err = s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "9", `, "signedAt": "`+signedAt+`"`)))
+	require.EqualError(t, err, "signature must be set with signedAt")
+
+	id, err := s.CreateXpnTransactionTransient(l.tx(xpnTransactionTransient(fileHash("patient 1"), "9", fields)))
+	require.NoError(t, err)
+	xpntransaction, err := s.ReadXpnTransaction(l.tx(nil), id)
+	require.NoError(t, err)
+	require.Equal(t, signature, xpntransaction.Signature)
+	require.Equal(t, attestation.Fingerprint(cert), xpntransaction.Signer)
+
+	a, err := s.GetXpnAttestation(l.tx(nil), id)
+	require.NoError(t, err)
+	require.True(t, a.Signed)
+	require.True(t, a.Valid)
+	require.Equal(t, string(attestation.Message(path, hash, signedAt)), a.Message)
+	require.Equal(t, "Org1MSP", a.Signer.MSP)
+	signerCert, err := attestation.ParseCertificate([]byte(a.Signer.Certificate))
+	require.NoError(t, err)
+	require.Equal(t, cert.Raw, signerCert.Raw)
+
+	// unsigned versions are reported as such, a stored signature that no longer verifies is not valid
+	_, err = s.UpdateXpnTransaction(l.tx(nil), path, fileHash("patient 1b"), "10")
+	require.NoError(t, err)
+	var stored chaincode.XpnTransaction
+	storedKey, _ := shim.CreateCompositeKey("XpnTransaction", []string{id})
+	require.NoError(t, json.Unmarshal(l.state[storedKey], &stored))
+	stored.Hash = "1220" + fileHash("tampered")
+	l.state[storedKey], _ = json.Marshal(stored)
+
+	attestations, err := s.GetXpnAttestationsByPath(l.tx(nil), path)
+	require.NoError(t, err)
+	require.Len(t, attestations, 2)
+	require.True(t, attestations[0].Signed)
+	require.False(t, attestations[0].Valid)
+	require.NotEmpty(t, attestations[0].Error)
+	require.False(t, attestations[1].Signed)
+	require.False(t, attestations[1].Valid)
+}
+
+func TestXpnTransactionPatientIsPrivate(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	pseudonym := createPatient(t, l, s, "1")
+
+	id, err := s.CreateXpnTransaction(l.tx(nil), fileHash("patient"), "/tmp/expand/xpn/1/patient.txt", "1", "patient", "7", "sha256", "xpn")
+	require.NoError(t, err)
+	updatedID, err := s.UpdateXpnTransaction(l.tx(nil), "/tmp/expand/xpn/1/patient.txt", fileHash("patient v2"), "10")
+	require.NoError(t, err)
+
+	// no public entry holds the pseudonym, so the paths naming the patient id cannot be linked to it
+	for key, value := range l.state {
+		require.NotContains(t, key, pseudonym)
+		require.NotContains(t, string(value), pseudonym)
+	}
+
+	// identities that may resolve pseudonyms see the patient of every version
+	xpntransactions, err := s.GetXpnTransactionsByPatient(l.tx(nil), pseudonym)
+	require.NoError(t, err)
+	require.Len(t, xpntransactions, 2)
+	updated, err := s.ReadXpnTransaction(l.tx(nil), updatedID)
+	require.NoError(t, err)
+	require.Equal(t, pseudonym, updated.Patient)
+
+	for _, identity := range []*clientIdentity{
+		{msp: "Org3MSP", attributes: map[string]string{"xpn.reidentify": "true"}},
+		{msp: "Org1MSP", attributes: map[string]string{}},
+	} {
+		l.identity = identity
+
+		for _, anchor := range []string{id, updatedID} {
+			xpntransaction, err := s.ReadXpnTransaction(l.tx(nil), anchor)
+			require.NoError(t, err)
+			require.Empty(t, xpntransaction.Patient)
+		}
+		all, err := s.GetAllXpnTransactions(l.tx(nil))
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		for _, xpntransaction := range all {
+			require.Empty(t, xpntransaction.Patient)
+		}
+		_, err = s.GetXpnTransactionsByPatient(l.tx(nil), pseudonym)
+		require.Error(t, err)
+	}
+}
+
+func TestAuditedReadPatient(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	createPatient(t, l, s, "1")
+
+	// without an access record the identifiable fields are withheld
+	patient, err := s.ReadPatient(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.Empty(t, patient.FirstName)
+	require.Equal(t, 60.0, patient.Weight)
+
+	txID, err := s.AuditedReadPatient(l.tx(nil), "1", "treatment")
+	require.NoError(t, err)
+	require.Equal(t, "tx4", txID)
+
+	_, err = s.AuditedReadPatient(l.tx(nil), "1", " ")
+	require.EqualError(t, err, "purpose must be non-empty")
+	_, err = s.AuditedReadPatient(l.tx(nil), "2", "treatment")
+	require.EqualError(t, err, "Cannot read patient. Patient with id 2 does not exist")
+
+	records, err := s.GetPatientAccessLog(l.tx(nil), "1", "", "")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, chaincode.AccessRecord{
+		TxID:      "tx4",
+		Patient:   "1",
+		Key:       "1",
+		Caller:    "x509::CN=user1::CN=ca.Org1MSP",
+		MSP:       "Org1MSP",
+		Purpose:   "treatment",
+		Timestamp: "2024-03-01T12:04:00.000000000Z",
+	}, *records[0])
+
+	patient, err = s.ReadPatient(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.Equal(t, "Ada", patient.FirstName)
+	require.Equal(t, "London", patient.BirthPlace)
+
+	all, err := s.GetAllPatients(l.tx(nil))
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.Empty(t, all[0].FirstName)
+
+	// the access record only covers its caller
+	l.identity = &clientIdentity{msp: "Org2MSP"}
+	patient, err = s.ReadPatient(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.Empty(t, patient.FirstName)
+
+	// and expires after the audited read window
+	l.identity = &clientIdentity{msp: "Org1MSP"}
+	l.txTime = l.txTime.Add(5 * time.Minute)
+	patient, err = s.ReadPatient(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.Empty(t, patient.FirstName)
+}
+
+func TestGetPatientAccessLog(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	createPatient(t, l, s, "1")
+	createPatient(t, l, s, "2")
+
+	for i := 0; i < 3; i++ {
+		_, err := s.AuditedReadPatient(l.tx(nil), "1", fmt.Sprintf("read %d", i))
+		require.NoError(t, err)
+	}
+	_, err := s.AuditedReadPatient(l.tx(nil), "2", "read")
+	require.NoError(t, err)
+
+	purposes := func(records []*chaincode.AccessRecord) []string {
+		var purposes []string
+		for _, record := range records {
+			purposes = append(purposes, record.Purpose)
+		}
+		return purposes
+	}
+
+	records, err := s.GetPatientAccessLog(l.tx(nil), "1", "", "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"read 0", "read 1", "read 2"}, purposes(records))
+
+	// the bounds are inclusive
+	records, err = s.GetPatientAccessLog(l.tx(nil), "1", "2024-03-01T12:06:00Z", "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"read 1", "read 2"}, purposes(records))
+	records, err = s.GetPatientAccessLog(l.tx(nil), "1", "", "2024-03-01T12:06:00Z")
+	require.NoError(t, err)
+	require.Equal(t, []string{"read 0", "read 1"}, purposes(records))
+	records, err = s.GetPatientAccessLog(l.tx(nil), "1", "2024-03-01T12:05:30Z", "2024-03-01T12:06:30Z")
+	require.NoError(t, err)
+	require.Equal(t, []string{"read 1"}, purposes(records))
+
+	_, err = s.GetPatientAccessLog(l.tx(nil), "1", "yesterday", "")
+	require.Error(t, err)
+}
+
+func TestCreatePatientTransient(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+
+	err := s.CreatePatientTransient(l.tx(nil))
+	require.EqualError(t, err, "patient must be a key in the transient map")
+	err = s.CreatePatientTransient(l.tx(map[string][]byte{"patient": []byte("{")}))
+	require.ErrorContains(t, err, "failed to unmarshal transient patient")
+
+	createPatient(t, l, s, "1")
+
+	// the identifiable fields only reach the private data collection
+	var public map[string]interface{}
+	require.NoError(t, json.Unmarshal(l.state["1"], &public))
+	require.NotContains(t, public, "FirstName")
+	require.NotContains(t, public, "BirthDate")
+	require.NotEmpty(t, public["DetailsHash"])
+	require.Contains(t, string(l.private["patientDetailsCollection"]["1"]), "Ada")
+
+	err = s.UpdatePatientTransient(l.tx(patientTransient("1", "Augusta", "61")))
+	require.NoError(t, err)
+	_, err = s.AuditedReadPatient(l.tx(nil), "1", "treatment")
+	require.NoError(t, err)
+	patient, err := s.ReadPatient(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.Equal(t, "Augusta", patient.FirstName)
+	require.Equal(t, 61.0, patient.Weight)
+
+	err = s.UpdatePatientTransient(l.tx(patientTransient("2", "Ada", "60")))
+	require.EqualError(t, err, "Cannot update patient. Patient with id 2 does not exist")
+}
+
+func TestXpnTransactionTransient(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	pseudonym := createPatient(t, l, s, "1")
+
+	_, err := s.CreateXpnTransactionTransient(l.tx(nil))
+	require.EqualError(t, err, "xpntransaction must be a key in the transient map")
+
+	input := fmt.Sprintf(`{"hash": "%s", "path": "/tmp/expand/xpn/1/patient.txt", "patient": "1",
+		"documentType": "patient", "size": "9", "hashAlgorithm": "sha256", "partition": "xpn"}`, fileHash("patient 1"))
+	id, err := s.CreateXpnTransactionTransient(l.tx(map[string][]byte{"xpntransaction": []byte(input)}))
+	require.NoError(t, err)
+	require.Equal(t, l.txID, id)
+
+	xpntransaction, err := s.ReadXpnTransaction(l.tx(nil), id)
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/expand/xpn/1/patient.txt", xpntransaction.Path)
+	require.Equal(t, "1220"+fileHash("patient 1"), xpntransaction.Hash)
+	require.Equal(t, pseudonym, xpntransaction.Patient)
+	require.Equal(t, int64(9), xpntransaction.Size)
+
+	// an id in the payload is used instead of the transaction ID
+	input = fmt.Sprintf(`{"id": "2", "hash": "%s", "path": "/tmp/expand/xpn/1/contract.txt", "patient": "1",
+		"documentType": "contract", "size": "9", "hashAlgorithm": "sha256", "partition": "xpn"}`, fileHash("contract 1"))
+	id, err = s.CreateXpnTransactionTransient(l.tx(map[string][]byte{"xpntransaction": []byte(input)}))
+	require.NoError(t, err)
+	require.Equal(t, "2", id)
+
+	input = fmt.Sprintf(`{"hash": "%s", "path": "/tmp/expand/xpn/1/patient.txt", "size": "10"}`, fileHash("patient 1b"))
+	updatedID, err := s.UpdateXpnTransactionTransient(l.tx(map[string][]byte{"xpntransaction": []byte(input)}))
+	require.NoError(t, err)
+
+	current, err := s.ReadXpnTransactionByPath(l.tx(nil), "/tmp/expand/xpn/1/patient.txt")
+	require.NoError(t, err)
+	require.Equal(t, updatedID, current.ID)
+	require.Equal(t, "patient", current.DocumentType)
+	require.Equal(t, int64(10), current.Size)
+}
+
+func TestErasePatient(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	pseudonym := createPatient(t, l, s, "1")
+	createPatient(t, l, s, "2")
+
+	erasedID, err := s.CreateXpnTransaction(l.tx(nil), fileHash("patient 1"), "/tmp/expand/xpn/1/patient.txt", "1", "patient", "9", "sha256", "xpn")
+	require.NoError(t, err)
+	keptID, err := s.CreateXpnTransaction(l.tx(nil), fileHash("patient 2"), "/tmp/expand/xpn/2/patient.txt", "2", "patient", "9", "sha256", "xpn")
+	require.NoError(t, err)
+
+	patientManifest, err := s.CreateXpnManifest(l.tx(nil), "/tmp/expand/xpn/1", fmt.Sprintf(`[
+		{"path": "notes.txt", "size": 5, "hash": "1220%s"},
+		{"path": "patient.txt", "size": 9, "hash": "1220%s"}
+	]`, fileHash("notes"), fileHash("patient 1")))
+	require.NoError(t, err)
+	partitionManifest, err := s.CreateXpnManifest(l.tx(nil), "/tmp/expand/xpn", fmt.Sprintf(`[
+		{"path": "1/patient.txt", "size": 9, "hash": "1220%s"},
+		{"path": "2/patient.txt", "size": 9, "hash": "1220%s"}
+	]`, fileHash("patient 1"), fileHash("patient 2")))
+	require.NoError(t, err)
+	otherManifest, err := s.CreateXpnManifest(l.tx(nil), "/tmp/expand/xpn/2", fmt.Sprintf(`[
+		{"path": "patient.txt", "size": 9, "hash": "1220%s"}
+	]`, fileHash("patient 2")))
+	require.NoError(t, err)
+
+	// only data protection officers erase patients
+	l.identity = &clientIdentity{msp: "Org1MSP", attributes: map[string]string{"xpn.reidentify": "true"}}
+	_, err = s.ErasePatient(l.tx(nil), "1")
+	require.EqualError(t, err, "Cannot erase patient. Caller does not have the xpn.dpo=true attribute")
+	exists, err := s.PatientExists(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.True(t, exists)
+	l.identity.attributes["xpn.dpo"] = "true"
+
+	certificate, err := s.ErasePatient(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, certificate.Keys)
+	require.Equal(t, []string{"/tmp/expand/xpn/1/patient.txt"}, certificate.XpnFiles)
+	require.Equal(t, []string{partitionManifest.ID, patientManifest.ID}, certificate.XpnManifests)
+
+	// the entries of the erased file are removed from the manifests of its directory and its parents
+	erasedManifest, err := s.ReadXpnManifest(l.tx(nil), patientManifest.ID)
+	require.NoError(t, err)
+	require.True(t, erasedManifest.Erased)
+	require.Len(t, erasedManifest.Entries, 1)
+	require.Equal(t, "notes.txt", erasedManifest.Entries[0].Path)
+	require.Equal(t, int64(5), erasedManifest.Size)
+	require.Equal(t, patientManifest.Hash, erasedManifest.Hash)
+	erasedManifest, err = s.ReadXpnManifest(l.tx(nil), partitionManifest.ID)
+	require.NoError(t, err)
+	require.True(t, erasedManifest.Erased)
+	require.Len(t, erasedManifest.Entries, 1)
+	require.Equal(t, "2/patient.txt", erasedManifest.Entries[0].Path)
+	keptManifest, err := s.ReadXpnManifest(l.tx(nil), otherManifest.ID)
+	require.NoError(t, err)
+	require.False(t, keptManifest.Erased)
+	require.Len(t, keptManifest.Entries, 1)
+
+	exists, err = s.PatientExists(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NotContains(t, l.private["patientDetailsCollection"], "1")
+	require.NotContains(t, l.private["cohortCollection"], "\x00CohortAttributes\x00"+pseudonym+"\x00")
+	require.Len(t, l.private["cohortCollection"], 1)
+
+	certificates, err := s.GetErasureCertificates(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.Equal(t, []*chaincode.ErasureCertificate{certificate}, certificates)
+
+	// the links of the erased files to the patient are purged with the pseudonym mapping
+	erased, err := s.ReadXpnTransaction(l.tx(nil), erasedID)
+	require.NoError(t, err)
+	require.True(t, erased.Erased)
+	require.Empty(t, erased.Patient)
+
+	kept, err := s.ReadXpnTransaction(l.tx(nil), keptID)
+	require.NoError(t, err)
+	require.False(t, kept.Erased)
+
+	pending, err := s.GetErasedXpnTransactions(l.tx(nil))
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, erasedID, pending[0].ID)
+
+	// the pseudonym mapping is gone, the erased files can no longer be linked to the patient
+	_, err = s.GetXpnTransactionsByPatient(l.tx(nil), "1")
+	require.Error(t, err)
+	_, err = s.ReadXpnTransactionByPath(l.tx(nil), "/tmp/expand/xpn/1/patient.txt")
+	require.EqualError(t, err, "Path /tmp/expand/xpn/1/patient.txt is not anchored")
+
+	require.NoError(t, s.MarkXpnFileDeleted(l.tx(nil), erasedID))
+	pending, err = s.GetErasedXpnTransactions(l.tx(nil))
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	err = s.MarkXpnFileDeleted(l.tx(nil), keptID)
+	require.EqualError(t, err, fmt.Sprintf("Cannot mark file as deleted. XpnTransaction with id %s is not erased", keptID))
+
+	_, err = s.ErasePatient(l.tx(nil), "1")
+	require.EqualError(t, err, "Cannot erase patient. Patient with id 1 does not exist")
+}
+
+func TestEncryptedPatient(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+
+	wrappingKeys := map[string]*ecdh.PrivateKey{}
+	for _, msp := range []string{"Org1MSP", "Org2MSP"} {
+		privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		wrappingKeys[msp] = privateKey
+		publicKey := base64.StdEncoding.EncodeToString(privateKey.PublicKey().Bytes())
+
+		l.identity = &clientIdentity{msp: msp}
+		err = s.SetOrgWrappingKey(l.tx(nil), publicKey)
+		require.EqualError(t, err, "Cannot set wrapping key. Caller does not have the xpn.admin=true attribute")
+		l.identity.attributes = map[string]string{"xpn.admin": "true"}
+		require.NoError(t, s.SetOrgWrappingKey(l.tx(nil), publicKey))
+	}
+	l.identity = &clientIdentity{msp: "Org1MSP", attributes: map[string]string{"xpn.dpo": "true"}}
+
+	transient := patientTransient("1", "Ada", "60")
+	transient["dataKey"] = []byte("0123456789abcdef0123456789abcdef")
+	require.NoError(t, s.CreateEncryptedPatient(l.tx(transient)))
+
+	// the details are only stored encrypted, the data key only wrapped
+	require.NotContains(t, string(l.state["1"]), "Ada")
+	require.NotContains(t, l.private["patientDetailsCollection"], "1")
+	for _, msp := range []string{"Org1MSP", "Org2MSP"} {
+		wrapped := l.private["_implicit_org_"+msp]["1"]
+		require.NotEmpty(t, wrapped)
+		require.NotContains(t, string(wrapped), string(transient["dataKey"]))
+	}
+
+	read := func(msp string, privateKey *ecdh.PrivateKey) (*chaincode.Patient, error) {
+		l.identity.msp = msp
+		return s.ReadPatientDecrypted(l.tx(map[string][]byte{"wrappingKey": privateKey.Bytes()}), "1")
+	}
+	_, err := read("Org1MSP", wrappingKeys["Org1MSP"])
+	require.EqualError(t, err, "Cannot decrypt patient. No access to patient 1 is recorded, submit AuditedReadPatient first")
+
+	for _, msp := range []string{"Org1MSP", "Org2MSP"} {
+		l.identity.msp = msp
+		_, err = s.AuditedReadPatient(l.tx(nil), "1", "treatment")
+		require.NoError(t, err)
+		patient, err := read(msp, wrappingKeys[msp])
+		require.NoError(t, err)
+		require.Equal(t, "Ada", patient.FirstName)
+		require.Equal(t, "London", patient.BirthPlace)
+	}
+	_, err = read("Org1MSP", wrappingKeys["Org2MSP"])
+	require.ErrorContains(t, err, "failed to unwrap data key")
+
+	l.identity.msp = "Org1MSP"
+	l.identity.attributes = nil
+	err = s.ShredPatientKey(l.tx(nil), "1")
+	require.EqualError(t, err, "Cannot shred patient key. Caller does not have the xpn.dpo=true attribute")
+	l.identity.attributes = map[string]string{"xpn.dpo": "true"}
+	require.NoError(t, s.ShredPatientKey(l.tx(nil), "1"))
+	require.NotContains(t, l.private["_implicit_org_Org1MSP"], "1")
+	require.NotContains(t, l.private["_implicit_org_Org2MSP"], "1")
+	_, err = read("Org1MSP", wrappingKeys["Org1MSP"])
+	require.EqualError(t, err, "Cannot decrypt patient. The data key of patient 1 has been destroyed")
+}
+
+func TestEncryptedPatientIsDeterministic(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	// every endorsing peer must write the same state for the same proposal
+	var ledgers []*ledger
+	for i := 0; i < 2; i++ {
+		l := newLedger()
+		s := &chaincode.SmartContract{}
+		l.identity.attributes["xpn.admin"] = "true"
+		require.NoError(t, s.SetOrgWrappingKey(l.tx(nil), base64.StdEncoding.EncodeToString(privateKey.PublicKey().Bytes())))
+
+		transient := patientTransient("1", "Ada", "60")
+		transient["dataKey"] = []byte("0123456789abcdef0123456789abcdef")
+		require.NoError(t, s.CreateEncryptedPatient(l.tx(transient)))
+		ledgers = append(ledgers, l)
+	}
+
+	require.Equal(t, ledgers[0].state, ledgers[1].state)
+	require.Equal(t, ledgers[0].private, ledgers[1].private)
+
+	// the details are encrypted with a nonce of the transaction, and never with the same one twice
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	l.identity.attributes["xpn.admin"] = "true"
+	require.NoError(t, s.SetOrgWrappingKey(l.tx(nil), base64.StdEncoding.EncodeToString(privateKey.PublicKey().Bytes())))
+	l.tx(nil)
+	transient := patientTransient("1", "Ada", "60")
+	transient["dataKey"] = []byte("0123456789abcdef0123456789abcdef")
+	require.NoError(t, s.CreateEncryptedPatient(l.tx(transient)))
+	require.NotEqual(t, ledgers[0].state["1"], l.state["1"])
+}
+
+func TestPseudonyms(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	delete(l.private["patientDetailsCollection"], "\x00PseudonymKey\x00Org2MSP\x00")
+
+	// pseudonyms are derived with the key of the organization creating the patient
+	l.identity = &clientIdentity{msp: "Org2MSP", attributes: map[string]string{"xpn.reidentify": "true"}}
+	err := s.CreatePatientTransient(l.tx(patientTransient("2", "Ada", "60")))
+	require.EqualError(t, err, "Cannot assign pseudonym. No pseudonym key is set for Org2MSP")
+
+	keyTransient := map[string][]byte{"pseudonymKey": []byte("0123456789abcdef0123456789abcdef")}
+	err = s.SetPseudonymKey(l.tx(keyTransient))
+	require.EqualError(t, err, "Cannot set pseudonym key. Caller does not have the xpn.admin=true attribute")
+	l.identity.attributes["xpn.admin"] = "true"
+	require.NoError(t, s.SetPseudonymKey(l.tx(keyTransient)))
+	err = s.SetPseudonymKey(l.tx(keyTransient))
+	require.EqualError(t, err, "Cannot set pseudonym key. A pseudonym key for Org2MSP already exists")
+	l.identity = &clientIdentity{msp: "Org3MSP", attributes: map[string]string{"xpn.admin": "true"}}
+	err = s.SetPseudonymKey(l.tx(keyTransient))
+	require.EqualError(t, err, "Cannot set pseudonym key. Caller is not a member of patientDetailsCollection")
+
+	l.identity = &clientIdentity{msp: "Org2MSP", attributes: map[string]string{"xpn.reidentify": "true"}}
+	second := createPatient(t, l, s, "2")
+	l.identity = &clientIdentity{msp: "Org1MSP", attributes: map[string]string{"xpn.reidentify": "true"}}
+	first := createPatient(t, l, s, "1")
+	require.Regexp(t, "^[0-9a-f]{32}$", first)
+	require.NotEqual(t, first, second)
+
+	// the same patient and salt give another pseudonym under the key of another organization
+	l.identity = &clientIdentity{msp: "Org2MSP", attributes: map[string]string{"xpn.reidentify": "true", "xpn.dpo": "true"}}
+	_, err = s.ErasePatient(l.tx(nil), "1")
+	require.NoError(t, err)
+	recreated := createPatient(t, l, s, "1")
+	require.NotEqual(t, first, recreated)
+
+	// every resolution of a patient id to its pseudonym requires the xpn.reidentify attribute
+	l.identity.attributes = nil
+	_, err = s.GetPatientPseudonym(l.tx(nil), "1")
+	require.EqualError(t, err, "Cannot resolve pseudonym. Caller does not have the xpn.reidentify=true attribute")
+	_, err = s.GetXpnTransactionsByPatient(l.tx(nil), "1")
+	require.EqualError(t, err, "Cannot resolve pseudonym. Caller does not have the xpn.reidentify=true attribute")
+	_, err = s.GetXpnTransactionsByPatient(l.tx(nil), recreated)
+	require.EqualError(t, err, "Cannot resolve pseudonym. Caller does not have the xpn.reidentify=true attribute")
+	err = s.CreateContract(l.tx(nil), "1", "95", "100", "60", "100", "35.5", "38", "120", "180", "80", "120")
+	require.EqualError(t, err, "Cannot resolve pseudonym. Caller does not have the xpn.reidentify=true attribute")
+
+	l.identity = &clientIdentity{msp: "Org3MSP", attributes: map[string]string{"xpn.reidentify": "true"}}
+	_, err = s.GetPatientPseudonym(l.tx(nil), "1")
+	require.EqualError(t, err, "Cannot resolve pseudonym. Caller is not a member of patientDetailsCollection")
+}
+
+// cohortPatient creates a patient born in birthPlace on birthDate with the given pulse rate.
+func cohortPatient(t *testing.T, l *ledger, s *chaincode.SmartContract, id string, birthPlace string, birthDate string, pulseRate string) {
+	transient := patientTransient(id, "Ada", "60")
+	transient["patient"] = []byte(fmt.Sprintf(`{"id": "%s", "firstName": "Ada", "lastName": "Lovelace", "birthDate": "%s",
+		"birthPlace": "%s", "weight": "60", "height": "1.65"}`, id, birthDate, birthPlace))
+	require.NoError(t, s.CreatePatientTransient(l.tx(transient)))
+	require.NoError(t, s.CreateRTData(l.tx(nil), id, "98", pulseRate, "36.5", "120", "80"))
+}
+
+func TestGetCohortStatistics(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+
+	err := s.SetCohortMinimumSize(l.tx(nil), "3")
+	require.EqualError(t, err, "Cannot set cohort minimum size. Caller does not have the xpn.admin=true attribute")
+	l.identity.attributes["xpn.admin"] = "true"
+	require.NoError(t, s.SetCohortMinimumSize(l.tx(nil), "3"))
+
+	// Spain 4, France 3, Italy 3, Peru 1 patients
+	id := 0
+	for place, count := range map[string]int{"Spain": 4, "France": 3, "Italy": 3, "Peru": 1} {
+		for i := 0; i < count; i++ {
+			id++
+			cohortPatient(t, l, s, strconv.Itoa(id), place, fmt.Sprintf("01-06-%d", 1950+10*i), strconv.Itoa(60+i))
+		}
+	}
+
+	groups := func(statistics []*chaincode.CohortStatistic) map[string]float64 {
+		counts := map[string]float64{}
+		for _, statistic := range statistics {
+			counts[statistic.Group] = statistic.Count
+		}
+		return counts
+	}
+
+	// Peru is below k and France is suppressed with it, Peru could be derived from the total otherwise
+	statistics, err := s.GetCohortStatistics(l.tx(nil), "PulseRate", "birthPlace", "")
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"Italy": 3, "Spain": 4}, groups(statistics))
+
+	require.NoError(t, s.SetCohortMinimumSize(l.tx(nil), "4"))
+	statistics, err = s.GetCohortStatistics(l.tx(nil), "PulseRate", "birthPlace", "")
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"Spain": 4}, groups(statistics))
+	require.NoError(t, s.SetCohortMinimumSize(l.tx(nil), "5"))
+	_, err = s.GetCohortStatistics(l.tx(nil), "PulseRate", "birthPlace", "")
+	require.EqualError(t, err, "Cannot return cohort statistics. No group has at least 5 patients")
+
+	// born in 1950, 1960, 1970 and 1980, in 2024 the patients are 70-79 (4), 60-69 (3), 50-59 (3) and
+	// 40-49 (1) years old
+	require.NoError(t, s.SetCohortMinimumSize(l.tx(nil), "2"))
+	statistics, err = s.GetCohortStatistics(l.tx(nil), "PulseRate", "ageBand", "")
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"70-79": 4, "60-69": 3}, groups(statistics))
+
+	// the cohort attributes are readable by the members of the cohort collection, Org3 included
+	l.identity = &clientIdentity{msp: "Org3MSP"}
+	statistics, err = s.GetCohortStatistics(l.tx(nil), "PulseRate", "birthPlace", "")
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"Italy": 3, "Spain": 4}, groups(statistics))
+}
+
+func TestGetCohortStatisticsNoise(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	for i := 1; i <= 5; i++ {
+		cohortPatient(t, l, s, strconv.Itoa(i), "Spain", "01-06-1950", strconv.Itoa(60+i))
+	}
+
+	_, err := s.GetCohortStatistics(l.tx(nil), "PulseRate", "", "1")
+	require.EqualError(t, err, "Cannot add noise. No cohort noise secret is set")
+
+	secret := map[string][]byte{"noiseSecret": []byte("0123456789abcdef0123456789abcdef")}
+	err = s.SetCohortNoiseSecret(l.tx(secret))
+	require.EqualError(t, err, "Cannot set cohort noise secret. Caller does not have the xpn.admin=true attribute")
+	l.identity.attributes["xpn.admin"] = "true"
+	require.NoError(t, s.SetCohortNoiseSecret(l.tx(secret)))
+	err = s.SetCohortNoiseSecret(l.tx(secret))
+	require.EqualError(t, err, "Cannot set cohort noise secret. The cohort noise secret already exists")
+
+	exact, err := s.GetCohortStatistics(l.tx(nil), "PulseRate", "", "")
+	require.NoError(t, err)
+	noisy, err := s.GetCohortStatistics(l.tx(nil), "PulseRate", "", "1")
+	require.NoError(t, err)
+	require.NotEqual(t, exact[0].Sum, noisy[0].Sum)
+	require.NotEqual(t, noisy[0].Sum-exact[0].Sum, noisy[0].Count-exact[0].Count)
+
+	// repeating the query returns the same noise, so averaging the answers does not remove it
+	for i := 0; i < 3; i++ {
+		again, err := s.GetCohortStatistics(l.tx(nil), "PulseRate", "", "1")
+		require.NoError(t, err)
+		require.Equal(t, noisy, again)
+	}
+
+	// the noise changes with the values of the group
+	require.NoError(t, s.UpdateRTData(l.tx(nil), "1", "98", "90", "36.5", "120", "80"))
+	changed, err := s.GetCohortStatistics(l.tx(nil), "PulseRate", "", "1")
+	require.NoError(t, err)
+	require.NotEqual(t, noisy[0].Count, changed[0].Count)
+}
+
+func TestCreateXpnTransactionAfterErasure(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	createPatient(t, l, s, "1")
+
+	erasedID, err := s.CreateXpnTransaction(l.tx(nil), fileHash("patient 1"), "/tmp/expand/xpn/1/patient.txt", "1", "patient", "9", "sha256", "xpn")
+	require.NoError(t, err)
+	_, err = s.ErasePatient(l.tx(nil), "1")
+	require.NoError(t, err)
+
+	// a new patient is stored under the same id and its file under the same path
+	pseudonym := createPatient(t, l, s, "1")
+	id, err := s.CreateXpnTransaction(l.tx(nil), fileHash("new patient 1"), "/tmp/expand/xpn/1/patient.txt", "1", "patient", "13", "sha256", "xpn")
+	require.NoError(t, err)
+
+	current, err := s.ReadXpnTransactionByPath(l.tx(nil), "/tmp/expand/xpn/1/patient.txt")
+	require.NoError(t, err)
+	require.Equal(t, id, current.ID)
+	require.Equal(t, pseudonym, current.Patient)
+	require.Equal(t, 2, current.Version)
+	require.Equal(t, erasedID, current.PreviousID)
+	require.False(t, current.Erased)
+
+	updatedID, err := s.UpdateXpnTransaction(l.tx(nil), "/tmp/expand/xpn/1/patient.txt", fileHash("new patient 1 v2"), "16")
+	require.NoError(t, err)
+	current, err = s.ReadXpnTransactionByPath(l.tx(nil), "/tmp/expand/xpn/1/patient.txt")
+	require.NoError(t, err)
+	require.Equal(t, updatedID, current.ID)
+	require.Equal(t, 3, current.Version)
+}
+
+func TestCreateXpnBatch(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	pseudonym := createPatient(t, l, s, "1")
+
+	_, err := s.CreateXpnTransaction(l.tx(nil), fileHash("contract v1"), "/tmp/expand/xpn/1/contract.txt", "1", "contract", "11", "sha256", "xpn")
+	require.NoError(t, err)
+
+	files := fmt.Sprintf(`[
+		{"hash": "%s", "path": "/tmp/expand/xpn/1/vital_signs_1.txt", "patient": "1", "documentType": "vital_signs", "size": "3", "hashAlgorithm": "sha256"},
+		{"hash": "%s", "path": "/tmp/expand/xpn/1/contract.txt", "patient": "1", "documentType": "contract", "size": "11", "hashAlgorithm": "sha256"}
+	]`, fileHash("vs1"), fileHash("contract v2"))
+	id, err := s.CreateXpnBatch(l.tx(nil), files, "xpn")
+	require.NoError(t, err)
+	require.Equal(t, l.txID, id)
+
+	batch, err := s.ReadXpnBatch(l.tx(nil), id)
+	require.NoError(t, err)
+	require.Equal(t, []string{id + ".0", id + ".1"}, batch.Files)
+	require.Equal(t, 2, batch.Leaves)
+
+	// every batched file has its own anchor, found through the per-file indexes
+	xpntransactions, err := s.ReadXpnTransactionByHash(l.tx(nil), fileHash("vs1"))
+	require.NoError(t, err)
+	require.Len(t, xpntransactions, 1)
+	require.Equal(t, id, xpntransactions[0].Batch)
+	require.Equal(t, pseudonym, xpntransactions[0].Patient)
+
+	contract, err := s.ReadXpnTransactionByPath(l.tx(nil), "/tmp/expand/xpn/1/contract.txt")
+	require.NoError(t, err)
+	require.Equal(t, id+".1", contract.ID)
+	require.Equal(t, 2, contract.Version)
+
+	byPatient, err := s.GetXpnTransactionsByPatient(l.tx(nil), "1")
+	require.NoError(t, err)
+	require.Len(t, byPatient, 3)
+
+	tree, err := merkle.New([]merkle.Entry{
+		{Path: "/tmp/expand/xpn/1/vital_signs_1.txt", Hash: xpntransactions[0].Hash},
+		{Path: "/tmp/expand/xpn/1/contract.txt", Hash: contract.Hash},
+	})
+	require.NoError(t, err)
+	require.Equal(t, tree.Root(), batch.Root)
+	proof, err := tree.Proof("/tmp/expand/xpn/1/contract.txt")
+	require.NoError(t, err)
+	proofJSON, err := json.Marshal(proof)
+	require.NoError(t, err)
+	ok, err := s.VerifyXpnBatchProof(l.tx(nil), id, string(proofJSON))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	duplicate := fmt.Sprintf(`[
+		{"hash": "%[1]s", "path": "/tmp/expand/xpn/1/vital_signs_2.txt", "patient": "1", "documentType": "vital_signs", "size": "3", "hashAlgorithm": "sha256"},
+		{"hash": "%[1]s", "path": "/tmp/expand/xpn/1/./vital_signs_2.txt", "patient": "1", "documentType": "vital_signs", "size": "3", "hashAlgorithm": "sha256"}
+	]`, fileHash("vs2"))
+	_, err = s.CreateXpnBatch(l.tx(nil), duplicate, "xpn")
+	require.EqualError(t, err, "Cannot create xpnbatch. Path /tmp/expand/xpn/1/vital_signs_2.txt is part of the batch more than once")
+}
+
+func TestGetCohortStatisticsExcludesPointerRecords(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	l.identity.attributes["xpn.admin"] = "true"
+	require.NoError(t, s.SetCohortMinimumSize(l.tx(nil), "2"))
+
+	for _, id := range []string{"1", "2", "3"} {
+		createPatient(t, l, s, id)
+		require.NoError(t, s.CreateRTData(l.tx(nil), id, "98", "60", "36.5", "120", "80"))
+	}
+	createPatient(t, l, s, "4")
+	_, err := s.CreateXpnTransaction(l.tx(nil), fileHash("vital signs 4"), "/tmp/expand/xpn/4/vital_signs_1.txt", "4", "vital_signs", "13", "sha256", "xpn")
+	require.NoError(t, err)
+	require.NoError(t, s.CreateRTDataPointer(l.tx(nil), "4", "/tmp/expand/xpn/4/vital_signs_1.txt", fileHash("vital signs 4")))
+
+	statistics, err := s.GetCohortStatistics(l.tx(nil), "PulseRate", "", "")
+	require.NoError(t, err)
+	require.Len(t, statistics, 1)
+	require.Equal(t, float64(3), statistics[0].Count)
+	require.Equal(t, float64(60), statistics[0].Mean)
+	require.Equal(t, float64(1), statistics[0].Excluded)
+}
+
+func TestRTDataPointer(t *testing.T) {
+	l := newLedger()
+	s := &chaincode.SmartContract{}
+	pseudonym := createPatient(t, l, s, "4")
+	require.NoError(t, s.CreateContract(l.tx(nil), "4", "90", "100", "50", "100", "35", "38", "90", "140", "60", "90"))
+	require.NoError(t, s.CreateDiagnosis(l.tx(nil), "4"))
+
+	document := `{"id": "1", "patientId": "4", "oxygenSaturation": "97", "pulseRate": "130", "temperature": "36.6",
+		"bloodPressureSystolic": "120", "bloodPressureDiastolic": "80"}`
+	path := "/tmp/expand/xpn/4/vital_signs_1.txt"
+	_, err := s.CreateXpnTransaction(l.tx(nil), fileHash(document), path, "4", "vital_signs", strconv.Itoa(len(document)), "sha256", "xpn")
+	require.NoError(t, err)
+	hash := "1220" + fileHash(document)
+
+	err = s.CreateRTDataPointer(l.tx(nil), "4", path, fileHash("other"))
+	require.EqualError(t, err, fmt.Sprintf("Cannot point to measurements. Path %s is anchored with hash %s, not %s", path, hash, fileHash("other")))
+	require.NoError(t, s.CreateRTDataPointer(l.tx(nil), "4", path, fileHash(document)))
+
+	// the public record only tells that the measurements are kept in XPN
+	require.NotContains(t, string(l.state["RTD"+pseudonym]), path)
+	require.NotContains(t, string(l.state["RTD"+pseudonym]), fileHash(document))
+	rtData, err := s.ReadRTData(l.tx(nil), "RTD"+pseudonym)
+	require.NoError(t, err)
+	require.True(t, rtData.Pointer)
+	require.Equal(t, path, rtData.Path)
+	require.Equal(t, hash, rtData.Hash)
+
+	l.identity = &clientIdentity{msp: "Org3MSP", attributes: map[string]string{"xpn.reidentify": "true"}}
+	rtData, err = s.ReadRTData(l.tx(nil), "RTD"+pseudonym)
+	require.NoError(t, err)
+	require.True(t, rtData.Pointer)
+	require.Empty(t, rtData.Path)
+	require.Empty(t, rtData.Hash)
+	l.identity = &clientIdentity{msp: "Org1MSP", attributes: map[string]string{"xpn.reidentify": "true"}}
+
+	// the diagnosis is issued from the document passed in the transient map, once checked against the pointer
+	err = s.UpdateDiagnosis(l.tx(nil), "4")
+	require.EqualError(t, err, fmt.Sprintf("Cannot read measurements. Measurements with id RTD%s are stored in %s, pass the document under the rtdata transient key", pseudonym, path))
+	tampered := strings.Replace(document, `"130"`, `"70"`, 1)
+	err = s.UpdateDiagnosis(l.tx(map[string][]byte{"rtdata": []byte(tampered)}), "4")
+	require.EqualError(t, err, fmt.Sprintf("Cannot read measurements. The document passed has hash 1220%s, %s is anchored with hash %s", fileHash(tampered), path, hash))
+
+	require.NoError(t, s.UpdateDiagnosis(l.tx(map[string][]byte{"rtdata": []byte(document)}), "4"))
+	diagnosis, err := s.ReadDiagnosis(l.tx(nil), "D"+pseudonym)
+	require.NoError(t, err)
+	require.Equal(t, "Alert. Tachycardia", diagnosis.PulseRateDiagnosis)
+	require.Equal(t, "Oxygen Saturation in correct range", diagnosis.OxygenSaturationDiagnosis)
 }
@@ -0,0 +1,139 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// Keys of the transient map read by the *Transient transaction variants. The transient map is not
+// stored in the block, but what a transaction writes to the world state is: the variants keep their
+// arguments out of the proposal, and only values written to a private data collection stay out of
+// the block store.
+const (
+	patientTransientKey        = "patient"
+	xpnTransactionTransientKey = "xpntransaction"
+	// the raw vital signs document behind an RTData pointer, read by UpdateDiagnosis
+	rtDataTransientKey = "rtdata"
+)
+
+// patientInput is the transient payload of CreatePatientTransient and UpdatePatientTransient.
+// Numbers are strings, as in the other transaction arguments.
+type patientInput struct {
+	ID         string `json:"id"`
+	FirstName  string `json:"firstName"`
+	MiddleName string `json:"middleName"`
+	LastName   string `json:"lastName"`
+	BirthDate  string `json:"birthDate"`
+	BirthPlace string `json:"birthPlace"`
+	Weight     string `json:"weight"`
+	Height     string `json:"height"`
+}
+
+// xpnTransactionInput is the transient payload of CreateXpnTransactionTransient.
+type xpnTransactionInput struct {
+	ID            string `json:"id"`
+	Hash          string `json:"hash"`
+	Path          string `json:"path"`
+	Patient       string `json:"patient"`
+	DocumentType  string `json:"documentType"`
+	Size          string `json:"size"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+	Partition     string `json:"partition"`
+	// optional chunk hashes, see XpnTransaction.ChunkHashes
+	xpnChunks
+	// optional partition layout, see XpnTransaction.Layout
+	Layout *XpnLayout `json:"layout"`
+	// optional signature of the submitter, see package attestation
+	xpnSignature
+}
+
+// ---------------------------------------------------- TRANSIENT INPUT -------------------------------------------------------------- //
+// readTransient unmarshals the JSON value stored under key in the transient map into v.
+func readTransient(ctx contractapi.TransactionContextInterface, key string, v interface{}) error {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient map: %v", err)
+	}
+
+	value, ok := transientMap[key]
+	if !ok {
+		return fmt.Errorf("%s must be a key in the transient map", key)
+	}
+
+	err = json.Unmarshal(value, v)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal transient %s: %v", key, err)
+	}
+
+	return nil
+}
+
+// CreatePatientTransient creates a new patient with the details passed in the transient map
+// under the "patient" key, so the identifiable fields are not part of the signed proposal.
+func (s *SmartContract) CreatePatientTransient(ctx contractapi.TransactionContextInterface) error {
+	var input patientInput
+	err := readTransient(ctx, patientTransientKey, &input)
+	if err != nil {
+		return err
+	}
+
+	return s.createPatient(ctx, input.ID, input.FirstName, input.MiddleName, input.LastName,
+		input.BirthDate, input.BirthPlace, input.Weight, input.Height)
+}
+
+// UpdatePatientTransient updates an existing patient with the details passed in the transient map
+// under the "patient" key.
+func (s *SmartContract) UpdatePatientTransient(ctx contractapi.TransactionContextInterface) error {
+	var input patientInput
+	err := readTransient(ctx, patientTransientKey, &input)
+	if err != nil {
+		return err
+	}
+
+	return s.updatePatient(ctx, input.ID, input.FirstName, input.MiddleName, input.LastName,
+		input.BirthDate, input.BirthPlace, input.Weight, input.Height)
+}
+
+// CreateXpnTransactionTransient creates a new xpntransaction with the details passed in the transient
+// map under the "xpntransaction" key and returns its id. When the payload carries an id, it is used
+// instead of the transaction ID. The payload may also carry chunkSize and chunkHashes, the partition
+// layout, and a signature with its signedAt time.
+//
+// Only the proposal is kept free of the details, the path is not protected: the anchor is public by
+// design and the stored xpntransaction, path and hash included, is part of the write set recorded in
+// the block. XPN paths hold the patient id, so the anchors show which files exist for a patient.
+func (s *SmartContract) CreateXpnTransactionTransient(ctx contractapi.TransactionContextInterface) (string, error) {
+	var input xpnTransactionInput
+	err := readTransient(ctx, xpnTransactionTransientKey, &input)
+	if err != nil {
+		return "", err
+	}
+
+	id := input.ID
+	if id == "" {
+		id = ctx.GetStub().GetTxID()
+	}
+
+	err = s.createXpnTransaction(ctx, id, input.Hash, input.Path, input.Patient, input.DocumentType,
+		input.Size, input.HashAlgorithm, input.Partition, input.xpnChunks, input.Layout, input.xpnSignature, "")
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// UpdateXpnTransactionTransient anchors a new version of a file with the path, hash, size and optional
+// chunkSize, chunkHashes, layout, signature and signedAt passed in the transient map under the
+// "xpntransaction" key, and returns its id.
+func (s *SmartContract) UpdateXpnTransactionTransient(ctx contractapi.TransactionContextInterface) (string, error) {
+	var input xpnTransactionInput
+	err := readTransient(ctx, xpnTransactionTransientKey, &input)
+	if err != nil {
+		return "", err
+	}
+
+	return s.updateXpnTransaction(ctx, ctx.GetStub().GetTxID(), input.Path, input.Hash, input.Size, input.xpnChunks, input.Layout, input.xpnSignature, "")
+}
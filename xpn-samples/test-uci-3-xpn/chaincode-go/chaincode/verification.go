@@ -0,0 +1,146 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// xpnVerificationType is the composite key object type of xpn file verification records.
+const xpnVerificationType = "XpnVerification"
+
+// xpnFileMismatchEvent is the name of the event emitted when a verified file does not match its anchor.
+const xpnFileMismatchEvent = "XpnFileMismatch"
+
+// XpnVerification records that the file of an xpntransaction was hashed again and compared with
+// the anchored hash.
+type XpnVerification struct {
+	TxID           string `json:"TxID"`
+	XpnTransaction string `json:"XpnTransaction"`
+	Path           string `json:"Path"`
+	AnchoredHash   string `json:"AnchoredHash"`
+	RecomputedHash string `json:"RecomputedHash"`
+	Intact         bool   `json:"Intact"`
+	Verifier       string `json:"Verifier"`
+	MSP            string `json:"MSP"`
+	Timestamp      string `json:"Timestamp"`
+}
+
+// ---------------------------------------------------- XPN FILE VERIFICATION -------------------------------------------------------------- //
+// VerifyXpnFile compares a freshly computed hash of the file of the xpntransaction with given id against
+// the anchored hash and appends a verification record to the ledger. An XpnFileMismatch event is emitted
+// when they differ. It must be submitted, not evaluated, for the record to be committed.
+func (s *SmartContract) VerifyXpnFile(ctx contractapi.TransactionContextInterface, id string, recomputedHash string) (*XpnVerification, error) {
+	xpntransaction, err := s.ReadXpnTransaction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.verifyXpnTransaction(ctx, xpntransaction, recomputedHash)
+}
+
+// VerifyXpnFileByPath works like VerifyXpnFile for the current version of the file at path.
+func (s *SmartContract) VerifyXpnFileByPath(ctx contractapi.TransactionContextInterface, path string, recomputedHash string) (*XpnVerification, error) {
+	current, err := s.currentXpnTransaction(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("Cannot verify file. No xpntransaction anchors path %s", path)
+	}
+
+	return s.verifyXpnTransaction(ctx, current, recomputedHash)
+}
+
+// verifyXpnTransaction compares recomputedHash with the hash of xpntransaction and stores the result.
+func (s *SmartContract) verifyXpnTransaction(ctx contractapi.TransactionContextInterface, xpntransaction *XpnTransaction, recomputedHash string) (*XpnVerification, error) {
+	if strings.TrimSpace(recomputedHash) == "" {
+		return nil, fmt.Errorf("recomputedHash must be non-empty")
+	}
+	if xpntransaction.Erased {
+		return nil, fmt.Errorf("Cannot verify file. XpnTransaction with id %s is erased", xpntransaction.ID)
+	}
+
+	verifier, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read caller identity: %v", err)
+	}
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read caller MSP: %v", err)
+	}
+	ts, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	verification := XpnVerification{
+		TxID:           ctx.GetStub().GetTxID(),
+		XpnTransaction: xpntransaction.ID,
+		Path:           xpntransaction.Path,
+		AnchoredHash:   xpntransaction.Hash,
+		RecomputedHash: recomputedHash,
+		Verifier:       verifier,
+		MSP:            msp,
+		Timestamp:      ts.Format(timestampLayout),
+	}
+
+	// the recomputed hash is a multihash or a bare digest of the anchored hash algorithm
+	m, err := multihash.Parse(recomputedHash, xpntransaction.HashAlgorithm)
+	if err == nil {
+		verification.RecomputedHash = m.String()
+	}
+	verification.Intact = multihash.Equal(verification.RecomputedHash, verification.AnchoredHash, xpntransaction.HashAlgorithm)
+
+	verificationJSON, err := json.Marshal(verification)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(xpnVerificationType, []string{xpntransaction.ID, verification.Timestamp, verification.TxID})
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(key, verificationJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	if !verification.Intact {
+		err = ctx.GetStub().SetEvent(xpnFileMismatchEvent, verificationJSON)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &verification, nil
+}
+
+// GetXpnVerifications returns the verification records of the xpntransaction with given id, oldest first.
+func (s *SmartContract) GetXpnVerifications(ctx contractapi.TransactionContextInterface, id string) ([]*XpnVerification, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(xpnVerificationType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var verifications []*XpnVerification
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var verification XpnVerification
+		err = json.Unmarshal(queryResponse.Value, &verification)
+		if err != nil {
+			return nil, err
+		}
+		verifications = append(verifications, &verification)
+	}
+
+	return verifications, nil
+}
@@ -0,0 +1,124 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// Composite key object types linking xpntransactions to patients. The paths of XPN files name the
+// patient id, so the links are kept in the patient details collection and never in the world state
+// next to the path.
+const (
+	xpnPatientIndex = "XpnTransactionPatient"
+	xpnOwnerType    = "XpnTransactionOwner"
+)
+
+// ---------------------------------------------------- PATIENT LINKS -------------------------------------------------------------- //
+// canReidentify reports whether the caller may see which patient an anchor belongs to.
+func canReidentify(ctx contractapi.TransactionContextInterface) bool {
+	return callerIsDetailsMember(ctx) && checkReidentify(ctx) == nil
+}
+
+// putXpnPatient links the xpntransaction with given id to the pseudonym of its patient.
+func putXpnPatient(ctx contractapi.TransactionContextInterface, id string, pseudonym string) error {
+	ownerKey, err := privateCompositeKey(ctx, xpnOwnerType, id)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(patientDetailsCollection, ownerKey, []byte(pseudonym))
+	if err != nil {
+		return fmt.Errorf("failed to put patient of xpntransaction %s in private data collection: %v", id, err)
+	}
+
+	patientKey, err := ctx.GetStub().CreateCompositeKey(xpnPatientIndex, []string{pseudonym, id})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(patientDetailsCollection, patientKey, []byte{0x00})
+}
+
+// xpnPatientOf returns the pseudonym of the patient of the xpntransaction with given id without
+// checking the caller. It must only be used for results that do not disclose it.
+func xpnPatientOf(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	ownerKey, err := privateCompositeKey(ctx, xpnOwnerType, id)
+	if err != nil {
+		return "", err
+	}
+
+	pseudonym, err := ctx.GetStub().GetPrivateData(patientDetailsCollection, ownerKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read patient of xpntransaction %s: %v", id, err)
+	}
+	if pseudonym == nil {
+		return "", fmt.Errorf("Cannot read patient. XpnTransaction with id %s has no patient", id)
+	}
+
+	return string(pseudonym), nil
+}
+
+// withXpnPatient fills the patient of an xpntransaction when the caller is allowed to see it.
+func withXpnPatient(ctx contractapi.TransactionContextInterface, xpntransaction *XpnTransaction) *XpnTransaction {
+	if !canReidentify(ctx) {
+		return xpntransaction
+	}
+
+	pseudonym, err := xpnPatientOf(ctx, xpntransaction.ID)
+	if err == nil {
+		xpntransaction.Patient = pseudonym
+	}
+
+	return xpntransaction
+}
+
+// xpnTransactionsOfPatient returns the xpntransactions linked to a pseudonym without checking the
+// caller.
+func (s *SmartContract) xpnTransactionsOfPatient(ctx contractapi.TransactionContextInterface, pseudonym string) ([]*XpnTransaction, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(patientDetailsCollection, xpnPatientIndex, []string{pseudonym})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xpntransactions of patient from private data collection: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var xpntransactions []*XpnTransaction
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		xpntransaction, err := s.ReadXpnTransaction(ctx, keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		xpntransaction.Patient = pseudonym
+		xpntransactions = append(xpntransactions, xpntransaction)
+	}
+
+	return xpntransactions, nil
+}
+
+// purgeXpnPatient removes the link between the xpntransaction with given id and its patient.
+func purgeXpnPatient(ctx contractapi.TransactionContextInterface, id string, pseudonym string) error {
+	ownerKey, err := privateCompositeKey(ctx, xpnOwnerType, id)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PurgePrivateData(patientDetailsCollection, ownerKey)
+	if err != nil {
+		return err
+	}
+
+	patientKey, err := ctx.GetStub().CreateCompositeKey(xpnPatientIndex, []string{pseudonym, id})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PurgePrivateData(patientDetailsCollection, patientKey)
+}
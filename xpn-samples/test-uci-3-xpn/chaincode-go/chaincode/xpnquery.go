@@ -0,0 +1,218 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// ---------------------------------------------------- XpnTransaction QUERIES -------------------------------------------------------------- //
+// xpnTransactionsByIndex returns the xpntransactions referenced by the index entries matching
+// the given attributes.
+func (s *SmartContract) xpnTransactionsByIndex(ctx contractapi.TransactionContextInterface, index string, attributes []string) ([]*XpnTransaction, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var xpntransactions []*XpnTransaction
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		// the xpntransaction ID is the last attribute of every index key
+		xpntransaction, err := s.ReadXpnTransaction(ctx, keyParts[len(keyParts)-1])
+		if err != nil {
+			return nil, err
+		}
+		xpntransactions = append(xpntransactions, xpntransaction)
+	}
+
+	return xpntransactions, nil
+}
+
+// GetAllXpnTransactions returns all xpntransactions found in world state
+func (s *SmartContract) GetAllXpnTransactions(ctx contractapi.TransactionContextInterface) ([]*XpnTransaction, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(xpnTransactionType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var xpntransactions []*XpnTransaction
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var xpntransaction XpnTransaction
+		err = json.Unmarshal(queryResponse.Value, &xpntransaction)
+		if err != nil {
+			return nil, err
+		}
+		xpntransactions = append(xpntransactions, withXpnPatient(ctx, &xpntransaction))
+	}
+
+	return xpntransactions, nil
+}
+
+// GetXpnTransactionsByPatient returns the xpntransactions of the files of a patient, given by its id
+// or its pseudonym. Only members of the patient details collection can resolve either.
+func (s *SmartContract) GetXpnTransactionsByPatient(ctx contractapi.TransactionContextInterface, patient string) ([]*XpnTransaction, error) {
+	pseudonym, err := s.anchorPseudonym(ctx, patient)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.xpnTransactionsOfPatient(ctx, pseudonym)
+}
+
+// GetXpnTransactionsByDocumentType returns the xpntransactions of the files of a document type
+// (patient, contract, diagnosis or vital_signs).
+func (s *SmartContract) GetXpnTransactionsByDocumentType(ctx contractapi.TransactionContextInterface, documentType string) ([]*XpnTransaction, error) {
+	return s.xpnTransactionsByIndex(ctx, xpnDocumentTypeIndex, []string{documentType})
+}
+
+// GetXpnTransactionsByTimeRange returns the xpntransactions anchored between from and to
+// (RFC3339, both optional).
+func (s *SmartContract) GetXpnTransactionsByTimeRange(ctx contractapi.TransactionContextInterface, from string, to string) ([]*XpnTransaction, error) {
+	fromTime, err := parseTimeBound("from", from)
+	if err != nil {
+		return nil, err
+	}
+	toTime, err := parseTimeBound("to", to)
+	if err != nil {
+		return nil, err
+	}
+
+	xpntransactions, err := s.GetAllXpnTransactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var inRange []*XpnTransaction
+	for _, xpntransaction := range xpntransactions {
+		anchored, err := time.Parse(timestampLayout, xpntransaction.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if !fromTime.IsZero() && anchored.Before(fromTime) {
+			continue
+		}
+		if !toTime.IsZero() && anchored.After(toTime) {
+			continue
+		}
+		inRange = append(inRange, xpntransaction)
+	}
+
+	return inRange, nil
+}
+
+// GetXpnTransactionHistory returns every version anchored for path, oldest first.
+func (s *SmartContract) GetXpnTransactionHistory(ctx contractapi.TransactionContextInterface, path string) ([]*XpnTransaction, error) {
+	xpntransactions, err := s.xpnTransactionsByIndex(ctx, xpnPathIndex, []string{canonicalXpnPath(path)})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(xpntransactions, func(i, j int) bool {
+		return xpntransactions[i].Version < xpntransactions[j].Version
+	})
+
+	return xpntransactions, nil
+}
+
+// currentXpnTransaction returns the latest version anchored for path, or nil when the path is not
+// anchored or its anchors were erased.
+func (s *SmartContract) currentXpnTransaction(ctx contractapi.TransactionContextInterface, path string) (*XpnTransaction, error) {
+	history, err := s.GetXpnTransactionHistory(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 || history[len(history)-1].Erased {
+		return nil, nil
+	}
+
+	return history[len(history)-1], nil
+}
+
+// GetXpnTransactionAt returns the version of path that was current at the given time (RFC3339).
+func (s *SmartContract) GetXpnTransactionAt(ctx contractapi.TransactionContextInterface, path string, at string) (*XpnTransaction, error) {
+	atTime, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return nil, fmt.Errorf("invalid at: %s, required: RFC3339", at)
+	}
+
+	history, err := s.GetXpnTransactionHistory(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var current *XpnTransaction
+	for _, xpntransaction := range history {
+		anchored, err := time.Parse(timestampLayout, xpntransaction.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if anchored.After(atTime) {
+			break
+		}
+		current = xpntransaction
+	}
+	if current == nil {
+		return nil, fmt.Errorf("Path %s was not anchored at %s", path, at)
+	}
+
+	return current, nil
+}
+
+// ReadXpnTransactionByPath returns the current anchor of path. The path is canonicalized first,
+// so /tmp/expand//xpn/1/./patient.txt finds the anchor of /tmp/expand/xpn/1/patient.txt.
+func (s *SmartContract) ReadXpnTransactionByPath(ctx contractapi.TransactionContextInterface, path string) (*XpnTransaction, error) {
+	current, err := s.currentXpnTransaction(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("Path %s is not anchored", canonicalXpnPath(path))
+	}
+
+	return current, nil
+}
+
+// ReadXpnTransactionByHash returns every anchor of the given content, oldest first, including erased
+// ones. hash is a multihash or a bare SHA-256 digest. Each anchor tells when the content was anchored
+// and by whom.
+func (s *SmartContract) ReadXpnTransactionByHash(ctx contractapi.TransactionContextInterface, hash string) ([]*XpnTransaction, error) {
+	m, err := multihash.Parse(hash, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid hash: %v", err)
+	}
+
+	xpntransactions, err := s.xpnTransactionsByIndex(ctx, xpnHashIndex, []string{m.String()})
+	if err != nil {
+		return nil, err
+	}
+	if len(xpntransactions) == 0 {
+		return nil, fmt.Errorf("Hash %s was never anchored", m.String())
+	}
+
+	sort.Slice(xpntransactions, func(i, j int) bool {
+		return xpntransactions[i].Timestamp < xpntransactions[j].Timestamp
+	})
+
+	return xpntransactions, nil
+}
@@ -7,6 +7,7 @@ require (
 	github.com/hyperledger/fabric-contract-api-go/v2 v2.2.0
 	github.com/hyperledger/fabric-protos-go-apiv2 v0.3.4
 	github.com/stretchr/testify v1.10.0
+	golang.org/x/crypto v0.36.0
 	google.golang.org/protobuf v1.36.4
 )
 
@@ -0,0 +1,130 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package manifest builds the commitment of an XPN directory manifest, the sorted list of (relative
+// path, size, hash) of its files, and compares manifests. It is shared by the chaincode and the
+// off-chain tools.
+//
+// The committed encoding has one line per file, sorted by path:
+//
+//	contract.txt\t296\t1220<sha-256 digest>\n
+//	patient.txt\t183\t1220<sha-256 digest>\n
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+)
+
+// Entry is a file of a directory. Path is relative to the directory and Hash is a multihash.
+type Entry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// Change is a file whose size or hash differs between two manifests.
+type Change struct {
+	Path string `json:"path"`
+	Old  Entry  `json:"old"`
+	New  Entry  `json:"new"`
+}
+
+// Changes are the differences between two manifests.
+type Changes struct {
+	Added   []Entry  `json:"added"`
+	Removed []Entry  `json:"removed"`
+	Changed []Change `json:"changed"`
+}
+
+// Empty reports whether there are no differences.
+func (c *Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// Sort sorts entries by path.
+func Sort(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+}
+
+// Validate checks that entries are sorted by path without duplicates, that every path is a clean
+// relative path without control characters and that every hash is a multihash.
+func Validate(entries []Entry) error {
+	for i, entry := range entries {
+		if entry.Path == "" || path.IsAbs(entry.Path) || path.Clean(entry.Path) != entry.Path ||
+			entry.Path == ".." || strings.HasPrefix(entry.Path, "../") {
+			return fmt.Errorf("invalid manifest path %q, must be a clean relative path", entry.Path)
+		}
+		if strings.IndexFunc(entry.Path, unicode.IsControl) >= 0 {
+			return fmt.Errorf("invalid manifest path %q, must not hold control characters", entry.Path)
+		}
+		if i > 0 && entries[i-1].Path >= entry.Path {
+			return fmt.Errorf("manifest paths must be sorted without duplicates, %q follows %q", entry.Path, entries[i-1].Path)
+		}
+		if entry.Size < 0 {
+			return fmt.Errorf("invalid size of %s: %d", entry.Path, entry.Size)
+		}
+		if _, err := multihash.Decode(entry.Hash); err != nil {
+			return fmt.Errorf("invalid hash of %s: %v", entry.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// Encode returns the committed encoding of entries, which must be valid.
+func Encode(entries []Entry) []byte {
+	var b bytes.Buffer
+	for _, entry := range entries {
+		b.WriteString(entry.Path)
+		b.WriteByte('\t')
+		b.WriteString(strconv.FormatInt(entry.Size, 10))
+		b.WriteByte('\t')
+		b.WriteString(entry.Hash)
+		b.WriteByte('\n')
+	}
+
+	return b.Bytes()
+}
+
+// Hash returns the multihash of the encoding of entries computed with the named hash function.
+func Hash(entries []Entry, name string) (string, error) {
+	if err := Validate(entries); err != nil {
+		return "", err
+	}
+
+	return multihash.Sum(name, bytes.NewReader(Encode(entries)))
+}
+
+// Diff returns the files added, removed and changed in next compared with previous. Both must be
+// sorted by path.
+func Diff(previous []Entry, next []Entry) Changes {
+	var changes Changes
+	i, j := 0, 0
+	for i < len(previous) || j < len(next) {
+		switch {
+		case j == len(next) || (i < len(previous) && previous[i].Path < next[j].Path):
+			changes.Removed = append(changes.Removed, previous[i])
+			i++
+		case i == len(previous) || next[j].Path < previous[i].Path:
+			changes.Added = append(changes.Added, next[j])
+			j++
+		default:
+			if previous[i].Size != next[j].Size || previous[i].Hash != next[j].Hash {
+				changes.Changed = append(changes.Changed, Change{Path: next[j].Path, Old: previous[i], New: next[j]})
+			}
+			i++
+			j++
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,62 @@
+package manifest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/manifest"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func entry(t *testing.T, path string, content string) manifest.Entry {
+	hash, err := multihash.Sum(multihash.Default, strings.NewReader(content))
+	require.NoError(t, err)
+	return manifest.Entry{Path: path, Size: int64(len(content)), Hash: hash}
+}
+
+func TestHash(t *testing.T) {
+	entries := []manifest.Entry{entry(t, "patient.txt", "p"), entry(t, "contract.txt", "c")}
+	_, err := manifest.Hash(entries, multihash.Default)
+	require.Error(t, err, "unsorted")
+
+	manifest.Sort(entries)
+	hash, err := manifest.Hash(entries, multihash.Default)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "1220"))
+	require.Equal(t, "contract.txt\t1\t"+entries[0].Hash+"\npatient.txt\t1\t"+entries[1].Hash+"\n", string(manifest.Encode(entries)))
+
+	entries[1].Size = 2
+	changed, err := manifest.Hash(entries, multihash.Default)
+	require.NoError(t, err)
+	require.NotEqual(t, hash, changed)
+}
+
+func TestValidate(t *testing.T) {
+	valid := entry(t, "patient.txt", "p")
+	for _, path := range []string{"", "/tmp/expand/xpn/1/patient.txt", "../2/patient.txt", "./patient.txt", "a//b", "new\nline"} {
+		e := valid
+		e.Path = path
+		require.Error(t, manifest.Validate([]manifest.Entry{e}), path)
+	}
+
+	require.Error(t, manifest.Validate([]manifest.Entry{valid, valid}))
+	e := valid
+	e.Hash = "not a multihash"
+	require.Error(t, manifest.Validate([]manifest.Entry{e}))
+	require.NoError(t, manifest.Validate([]manifest.Entry{valid, entry(t, "vital_signs_1.txt", "v")}))
+}
+
+func TestDiff(t *testing.T) {
+	previous := []manifest.Entry{entry(t, "contract.txt", "c"), entry(t, "diagnosis.txt", "d"), entry(t, "patient.txt", "p")}
+	next := []manifest.Entry{entry(t, "contract.txt", "c"), entry(t, "patient.txt", "p2"), entry(t, "vital_signs_1.txt", "v")}
+
+	changes := manifest.Diff(previous, next)
+	require.Equal(t, []manifest.Entry{next[2]}, changes.Added)
+	require.Equal(t, []manifest.Entry{previous[1]}, changes.Removed)
+	require.Equal(t, []manifest.Change{{Path: "patient.txt", Old: previous[2], New: next[1]}}, changes.Changed)
+	require.False(t, changes.Empty())
+
+	changes = manifest.Diff(next, next)
+	require.True(t, changes.Empty())
+}
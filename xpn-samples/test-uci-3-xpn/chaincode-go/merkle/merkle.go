@@ -0,0 +1,160 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package merkle builds Merkle trees over (path, hash) pairs of XPN files and checks inclusion
+// proofs against an anchored root. It is shared by the chaincode and the off-chain tools.
+//
+// Leaves are sorted by path. A leaf is SHA-256(0x00 || len(path) || path || hash) and an inner
+// node is SHA-256(0x01 || left || right), so that a leaf can never be passed off as a node.
+// A node without a sibling is promoted to the next level unchanged.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Entry is a file covered by a tree.
+type Entry struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+}
+
+// Step is one sibling on the way from a leaf to the root.
+type Step struct {
+	Hash string `json:"hash"`
+	// Left is true when the sibling is the left child.
+	Left bool `json:"left"`
+}
+
+// Proof shows that an entry is covered by a root.
+type Proof struct {
+	Entry Entry  `json:"entry"`
+	Steps []Step `json:"steps"`
+}
+
+// Tree is a Merkle tree over a set of entries.
+type Tree struct {
+	entries []Entry
+	// levels[0] are the leaves, the last level holds the root
+	levels [][][]byte
+}
+
+// LeafHash returns the leaf hash of an entry.
+func LeafHash(entry Entry) []byte {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(entry.Path)))
+
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(length[:])
+	h.Write([]byte(entry.Path))
+	h.Write([]byte(entry.Hash))
+
+	return h.Sum(nil)
+}
+
+func nodeHash(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+
+	return h.Sum(nil)
+}
+
+// New builds the tree of entries. Every path must appear once.
+func New(entries []Entry) (*Tree, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("a tree needs at least one entry")
+	}
+
+	sorted := append([]Entry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	leaves := make([][]byte, len(sorted))
+	for i, entry := range sorted {
+		if i > 0 && sorted[i-1].Path == entry.Path {
+			return nil, fmt.Errorf("path %s appears more than once", entry.Path)
+		}
+		leaves[i] = LeafHash(entry)
+	}
+
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, nodeHash(level[i], level[i+1]))
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+
+	return &Tree{entries: sorted, levels: levels}, nil
+}
+
+// Root returns the hex encoded root of the tree.
+func (t *Tree) Root() string {
+	return hex.EncodeToString(t.levels[len(t.levels)-1][0])
+}
+
+// Len returns the number of entries of the tree.
+func (t *Tree) Len() int {
+	return len(t.entries)
+}
+
+// Proof returns the inclusion proof of the entry with given path.
+func (t *Tree) Proof(path string) (*Proof, error) {
+	index := sort.Search(len(t.entries), func(i int) bool { return t.entries[i].Path >= path })
+	if index == len(t.entries) || t.entries[index].Path != path {
+		return nil, fmt.Errorf("path %s is not in the tree", path)
+	}
+
+	proof := &Proof{Entry: t.entries[index]}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Steps = append(proof.Steps, Step{Hash: hex.EncodeToString(level[sibling]), Left: sibling < index})
+		}
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// Verify reports whether proof shows that its entry is covered by the hex encoded root.
+func Verify(root string, proof Proof) (bool, error) {
+	rootBytes, err := hex.DecodeString(root)
+	if err != nil {
+		return false, fmt.Errorf("invalid root: %v", err)
+	}
+
+	current := LeafHash(proof.Entry)
+	for _, step := range proof.Steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return false, fmt.Errorf("invalid proof step: %v", err)
+		}
+		if step.Left {
+			current = nodeHash(sibling, current)
+		} else {
+			current = nodeHash(current, sibling)
+		}
+	}
+
+	return bytes.Equal(current, rootBytes), nil
+}
@@ -0,0 +1,63 @@
+package merkle_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/merkle"
+	"github.com/stretchr/testify/require"
+)
+
+func entries(n int) []merkle.Entry {
+	var e []merkle.Entry
+	for i := 0; i < n; i++ {
+		e = append(e, merkle.Entry{Path: fmt.Sprintf("/tmp/expand/xpn/%d/patient.txt", i), Hash: fmt.Sprintf("%064x", i)})
+	}
+	return e
+}
+
+func TestProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		tree, err := merkle.New(entries(n))
+		require.NoError(t, err)
+
+		for _, entry := range entries(n) {
+			proof, err := tree.Proof(entry.Path)
+			require.NoError(t, err)
+
+			ok, err := merkle.Verify(tree.Root(), *proof)
+			require.NoError(t, err)
+			require.True(t, ok, "n=%d path=%s", n, entry.Path)
+
+			proof.Entry.Hash = fmt.Sprintf("%064x", 999)
+			ok, err = merkle.Verify(tree.Root(), *proof)
+			require.NoError(t, err)
+			require.False(t, ok)
+		}
+	}
+}
+
+func TestRootIgnoresOrder(t *testing.T) {
+	e := entries(5)
+	tree, err := merkle.New(e)
+	require.NoError(t, err)
+
+	e[0], e[4] = e[4], e[0]
+	reordered, err := merkle.New(e)
+	require.NoError(t, err)
+	require.Equal(t, tree.Root(), reordered.Root())
+}
+
+func TestDuplicatePath(t *testing.T) {
+	e := append(entries(2), entries(1)...)
+	_, err := merkle.New(e)
+	require.Error(t, err)
+}
+
+func TestUnknownPath(t *testing.T) {
+	tree, err := merkle.New(entries(3))
+	require.NoError(t, err)
+
+	_, err = tree.Proof("/tmp/expand/xpn/9/patient.txt")
+	require.Error(t, err)
+}
@@ -0,0 +1,205 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package multihash encodes content hashes in the self-describing multihash format: the varint code
+// of the hash function, the varint digest length and the digest, written as lowercase hex. It is
+// shared by the chaincode and the off-chain tools.
+//
+// Bare 64 character hex digests, as printed by write_data_xpn.py, are read as SHA-256.
+package multihash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// Names of the supported hash functions.
+const (
+	SHA2_256    = "sha2-256"
+	SHA3_256    = "sha3-256"
+	BLAKE2b_256 = "blake2b-256"
+)
+
+// Default is the hash function of bare hex digests.
+const Default = SHA2_256
+
+type function struct {
+	code   uint64
+	length int
+	new    func() hash.Hash
+}
+
+// functions are the supported hash functions with their codes in the multicodec table.
+var functions = map[string]function{
+	SHA2_256:    {0x12, sha256.Size, sha256.New},
+	SHA3_256:    {0x16, 32, sha3.New256},
+	BLAKE2b_256: {0xb220, blake2b.Size256, newBlake2b256},
+}
+
+// aliases are other names accepted for the hash functions.
+var aliases = map[string]string{
+	"sha256":  SHA2_256,
+	"sha-256": SHA2_256,
+}
+
+func newBlake2b256() hash.Hash {
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+// Multihash is a decoded multihash.
+type Multihash struct {
+	Name   string
+	Digest []byte
+}
+
+// String returns the hex encoded multihash.
+func (m Multihash) String() string {
+	return Encode(m.Name, m.Digest)
+}
+
+// Canonical returns the name of a supported hash function, resolving aliases. It fails for
+// unsupported functions.
+func Canonical(name string) (string, error) {
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	if _, ok := functions[name]; !ok {
+		return "", fmt.Errorf("hash algorithm %s is not supported, required: %s, %s or %s", name, SHA2_256, SHA3_256, BLAKE2b_256)
+	}
+
+	return name, nil
+}
+
+// New returns a new hash.Hash computing the named hash function.
+func New(name string) (hash.Hash, error) {
+	name, err := Canonical(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return functions[name].new(), nil
+}
+
+// Encode returns the hex encoded multihash of digest computed with the named hash function.
+// name must be canonical.
+func Encode(name string, digest []byte) string {
+	f := functions[name]
+
+	buf := binary.AppendUvarint(nil, f.code)
+	buf = binary.AppendUvarint(buf, uint64(len(digest)))
+	buf = append(buf, digest...)
+
+	return hex.EncodeToString(buf)
+}
+
+// Decode parses a hex encoded multihash. The digest length must match the hash function.
+func Decode(s string) (*Multihash, error) {
+	buf, err := decodeHex(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multihash %q: %v", s, err)
+	}
+
+	code, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid multihash %q: bad function code", s)
+	}
+	buf = buf[n:]
+	length, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid multihash %q: bad digest length", s)
+	}
+	buf = buf[n:]
+
+	for name, f := range functions {
+		if f.code != code {
+			continue
+		}
+		if length != uint64(f.length) || len(buf) != f.length {
+			return nil, fmt.Errorf("invalid multihash %q: %s digests are %d bytes", s, name, f.length)
+		}
+		return &Multihash{Name: name, Digest: buf}, nil
+	}
+
+	return nil, fmt.Errorf("invalid multihash %q: unsupported function code 0x%x", s, code)
+}
+
+// decodeHex decodes lowercase hex only, so that every hash has a single encoding.
+func decodeHex(s string) ([]byte, error) {
+	buf, err := hex.DecodeString(s)
+	if err != nil || hex.EncodeToString(buf) != s {
+		return nil, fmt.Errorf("must be lowercase hex")
+	}
+
+	return buf, nil
+}
+
+// Parse reads a multihash or a bare hex digest. A bare digest is taken to be computed with the
+// named hash function, or with Default when name is empty. When name is given, a multihash must
+// use that function.
+func Parse(s string, name string) (*Multihash, error) {
+	bareName := Default
+	if name != "" {
+		var err error
+		bareName, err = Canonical(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s) == 2*functions[bareName].length {
+		digest, err := decodeHex(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s digest %q: %v", bareName, s, err)
+		}
+		return &Multihash{Name: bareName, Digest: digest}, nil
+	}
+
+	m, err := Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && m.Name != bareName {
+		return nil, fmt.Errorf("hash %q is a %s multihash, not %s", s, m.Name, bareName)
+	}
+
+	return m, nil
+}
+
+// Equal reports whether two hashes, multihashes or bare digests of the named hash function,
+// denote the same digest.
+func Equal(a string, b string, name string) bool {
+	ma, err := Parse(a, name)
+	if err != nil {
+		return false
+	}
+	mb, err := Parse(b, name)
+	if err != nil {
+		return false
+	}
+
+	return ma.String() == mb.String()
+}
+
+// Sum returns the hex encoded multihash of the content of r computed with the named hash function.
+func Sum(name string, r io.Reader) (string, error) {
+	name, err := Canonical(name)
+	if err != nil {
+		return "", err
+	}
+
+	h := functions[name].new()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return Encode(name, h.Sum(nil)), nil
+}
@@ -0,0 +1,60 @@
+package multihash_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSum(t *testing.T) {
+	for name, prefix := range map[string]string{
+		multihash.SHA2_256:    "1220",
+		multihash.SHA3_256:    "1620",
+		multihash.BLAKE2b_256: "a0e40220",
+	} {
+		sum, err := multihash.Sum(name, strings.NewReader("patient"))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(sum, prefix), "%s: %s", name, sum)
+
+		m, err := multihash.Decode(sum)
+		require.NoError(t, err)
+		require.Equal(t, name, m.Name)
+		require.Len(t, m.Digest, 32)
+	}
+}
+
+func TestParseBareDigest(t *testing.T) {
+	digest := sha256.Sum256([]byte("patient"))
+	bare := hex.EncodeToString(digest[:])
+
+	m, err := multihash.Parse(bare, "sha256")
+	require.NoError(t, err)
+	require.Equal(t, multihash.SHA2_256, m.Name)
+
+	sum, err := multihash.Sum(multihash.SHA2_256, strings.NewReader("patient"))
+	require.NoError(t, err)
+	require.Equal(t, sum, m.String())
+	require.True(t, multihash.Equal(bare, sum, ""))
+}
+
+func TestParseRejects(t *testing.T) {
+	sum, err := multihash.Sum(multihash.SHA3_256, strings.NewReader("patient"))
+	require.NoError(t, err)
+
+	_, err = multihash.Parse(sum, multihash.SHA2_256)
+	require.Error(t, err, "algorithm mismatch")
+	_, err = multihash.Parse(strings.ToUpper(sum), "")
+	require.Error(t, err, "uppercase hex")
+	_, err = multihash.Parse(sum[:len(sum)-2], "")
+	require.Error(t, err, "truncated digest")
+	_, err = multihash.Parse("1120"+sum[4:], "")
+	require.Error(t, err, "unsupported function")
+	_, err = multihash.Parse("not a hash", "")
+	require.Error(t, err)
+	_, err = multihash.Sum("md5", strings.NewReader(""))
+	require.Error(t, err)
+}
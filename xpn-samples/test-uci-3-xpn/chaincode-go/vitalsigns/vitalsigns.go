@@ -0,0 +1,79 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package vitalsigns decodes the vital signs documents that generate_data.sh writes to XPN, such as
+// /tmp/expand/xpn/1/vital_signs_1.txt:
+//
+//	{
+//	    "id": "1",
+//	    "patientId": "1",
+//	    "oxygenSaturation": "95",
+//	    "pulseRate": "72",
+//	    "temperature": "36.6",
+//	    "bloodPressureSystolic": "120",
+//	    "bloodPressureDiastolic": "80"
+//	}
+//
+// It is shared by the chaincode, which checks the documents behind RTData pointers, and the off-chain
+// resolver.
+package vitalsigns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Document is a decoded vital signs document. The measurements are written as strings by the
+// scripts, plain JSON numbers are accepted too.
+type Document struct {
+	ID                     string `json:"id"`
+	PatientID              string `json:"patientId"`
+	OxygenSaturation       Value  `json:"oxygenSaturation"`
+	PulseRate              Value  `json:"pulseRate"`
+	Temperature            Value  `json:"temperature"`
+	BloodPressureSystolic  Value  `json:"bloodPressureSystolic"`
+	BloodPressureDiastolic Value  `json:"bloodPressureDiastolic"`
+}
+
+// Value is a measurement encoded as a JSON number or a string holding one.
+type Value float64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var f float64
+		if err := json.Unmarshal(data, &f); err != nil {
+			return fmt.Errorf("invalid measurement: %s", data)
+		}
+		*v = Value(f)
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid measurement: %s", s)
+	}
+	*v = Value(f)
+
+	return nil
+}
+
+// Decode decodes a vital signs document and checks that every measurement is set.
+func Decode(content []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode vital signs: %v", err)
+	}
+	if doc.PatientID == "" {
+		return nil, fmt.Errorf("vital signs document has no patientId")
+	}
+	if doc.OxygenSaturation <= 0 || doc.PulseRate <= 0 || doc.Temperature <= 0 ||
+		doc.BloodPressureSystolic <= 0 || doc.BloodPressureDiastolic <= 0 {
+		return nil, fmt.Errorf("vital signs measurements must be positive numbers")
+	}
+
+	return &doc, nil
+}
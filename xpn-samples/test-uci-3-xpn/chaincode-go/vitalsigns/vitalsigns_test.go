@@ -0,0 +1,37 @@
+package vitalsigns_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/vitalsigns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	doc, err := vitalsigns.Decode([]byte(`{
+    "id": "1",
+    "patientId": "7",
+    "oxygenSaturation": "95",
+    "pulseRate": 72,
+    "temperature": "36.6",
+    "bloodPressureSystolic": "120",
+    "bloodPressureDiastolic": "80"
+}`))
+	require.NoError(t, err)
+	require.Equal(t, "7", doc.PatientID)
+	require.Equal(t, vitalsigns.Value(95), doc.OxygenSaturation)
+	require.Equal(t, vitalsigns.Value(72), doc.PulseRate)
+	require.Equal(t, vitalsigns.Value(36.6), doc.Temperature)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, content := range []string{
+		`not json`,
+		`{"patientId": "7", "oxygenSaturation": "high", "pulseRate": "72", "temperature": "36.6", "bloodPressureSystolic": "120", "bloodPressureDiastolic": "80"}`,
+		`{"patientId": "7", "pulseRate": "72", "temperature": "36.6", "bloodPressureSystolic": "120", "bloodPressureDiastolic": "80"}`,
+		`{"oxygenSaturation": "95", "pulseRate": "72", "temperature": "36.6", "bloodPressureSystolic": "120", "bloodPressureDiastolic": "80"}`,
+	} {
+		_, err := vitalsigns.Decode([]byte(content))
+		require.Error(t, err, content)
+	}
+}
@@ -4,6 +4,8 @@
 # Bogdan Gabriel Hortea
 # Diego Camarmas Alonso
 
+. scripts/xpnUtils.sh
+
 # Expand configuration 
 export XPN_LOCALITY=0
 export XPN_CONF=$HOME/xpn-blockchain/xpn-samples/test-uci-3-xpn/xpn/config.xml
@@ -22,16 +24,14 @@ if ! [[ "$numPatients" =~ ^[0-9]+$ ]]; then
     exit 1
 fi
 
-# Transaction ID
-export TRANSACTION_ID="/tmp/transaction_id.var"
-export LOCK_FILE="/tmp/transaction_id.lock"
-echo 1 > "$TRANSACTION_ID"
-
+# the pseudonyms of the patients are derived with the pseudonym key of the organization, set once by
+# an identity enrolled with the xpn.admin=true attribute; it fails harmlessly when already set
+peer chaincode invoke -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" -C mychannel -n basic --peerAddresses localhost:7051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt" --peerAddresses localhost:9051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt" -c '{"function":"SetPseudonymKey","Args":[]}' --transient "{\"pseudonymKey\":\"$(head -c 32 /dev/urandom | base64 | tr -d '\n')\"}"
+sleep 5
 
 # Create patients
 for ((i=1; i<=numPatients; i++)); do
 
-    transaction_id=0
     patient_id="$i"
     firstName=$(shuf -n 1 patient_data/first-names.txt)
     lastName=$(shuf -n 1 patient_data/last-names.txt)
@@ -75,24 +75,22 @@ for ((i=1; i<=numPatients; i++)); do
 
 
 
-    # Create patient
+    # Create patient, its files are anchored with a single batch
+    files=()
     echo -e "GENERATING PATIENT WITH ID $patient_id ... \n"
 
-    {
-        flock -x 200
-        transaction_id=$(cat "$TRANSACTION_ID")
-        echo $((transaction_id+1)) > "$TRANSACTION_ID"
-    } 200>"$LOCK_FILE"
+    # register the patient first, the files of the patient are anchored under its pseudonym
+    peer chaincode invoke -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" -C mychannel -n basic --peerAddresses localhost:7051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt" --peerAddresses localhost:9051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt" -c '{"function":"CreatePatientTransient","Args":[]}' --transient "$(patientTransient "$patient_id" "$firstName" "$lastName" "$birthDate" "$birthPlace" "$weight" "$height")"
+
+    sleep 5
 
     LD_PRELOAD=$HOME/bin/xpn/lib/xpn_bypass.so mkdir /tmp/expand/xpn/$patient_id
-    file_hash=$(LD_PRELOAD=$HOME/bin/xpn/lib/xpn_bypass.so python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/patient.txt "{\"id\":\"1\", \"patientId\":\"$patient_id\", \"firstName\":\"$firstName\", \"lastName\":\"$lastName\", \"birthDate\":\"$birthDate\", \"birthPlace\":\"$birthPlace\", \"weight\":\"$weight\", \"height\":\"$height\"}")
+    read -r file_hash file_size <<< "$(LD_PRELOAD=$HOME/bin/xpn/lib/xpn_bypass.so python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/patient.txt "{\"id\":\"1\", \"patientId\":\"$patient_id\", \"firstName\":\"$firstName\", \"lastName\":\"$lastName\", \"birthDate\":\"$birthDate\", \"birthPlace\":\"$birthPlace\", \"weight\":\"$weight\", \"height\":\"$height\"}")"
     
     #mkdir /tmp/expand/xpn/$patient_id
-    #file_hash=$(python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/patient.txt "{\"id\":\"$transaction_id\", \"patientId\":\"$patient_id\", \"firstName\":\"$firstName\", \"lastName\":\"$lastName\", \"birthDate\":\"$birthDate\", \"birthPlace\":\"$birthPlace\", \"weight\":\"$weight\", \"height\":\"$height\"}")
+    #read -r file_hash file_size <<< "$(python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/patient.txt "{\"id\":\"1\", \"patientId\":\"$patient_id\", \"firstName\":\"$firstName\", \"lastName\":\"$lastName\", \"birthDate\":\"$birthDate\", \"birthPlace\":\"$birthPlace\", \"weight\":\"$weight\", \"height\":\"$height\"}")"
 
-    peer chaincode invoke -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" -C mychannel -n basic --peerAddresses localhost:7051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt" --peerAddresses localhost:9051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt" -c "{\"function\":\"CreateXpnTransaction\",\"Args\":[\"$transaction_id\", \"$file_hash\", \"/tmp/expand/xpn/$patient_id/patient.txt\"]}"
-
-    sleep 5
+    files+=("$(xpnBatchFile "$file_hash" "/tmp/expand/xpn/$patient_id/patient.txt" "$patient_id" "patient" "$file_size")")
 
 
 
@@ -101,19 +99,11 @@ for ((i=1; i<=numPatients; i++)); do
     # Create contract
     echo -e "GENERATING CONTRACT FOR PATIENT WITH ID $patient_id ... \n "
 
-    {
-        flock -x 200
-        transaction_id=$(cat "$TRANSACTION_ID")
-        echo $((transaction_id+1)) > "$TRANSACTION_ID"
-    } 200>"$LOCK_FILE"
-
-    file_hash=$(LD_PRELOAD=$HOME/bin/xpn/lib/xpn_bypass.so python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/contract.txt "{\"id\":\"1\", \"patientId\":\"$patient_id\", \"minOxygenSaturation\":\"95\", \"maxOxygenSaturation\":\"100\", \"minPulseRate\":\"60\", \"maxPulseRate\":\"100\", \"minTemperature\":\"35.5\", \"maxTemperature\":\"38\", \"minBloodPressureSystolic\":\"120\", \"maxBloodPressureSystolic\":\"180\", \"minBloodPressureDiastolic\":\"80\", \"maxBloodPressureDiastolic\":\"120\"}")
+    read -r file_hash file_size <<< "$(LD_PRELOAD=$HOME/bin/xpn/lib/xpn_bypass.so python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/contract.txt "{\"id\":\"1\", \"patientId\":\"$patient_id\", \"minOxygenSaturation\":\"95\", \"maxOxygenSaturation\":\"100\", \"minPulseRate\":\"60\", \"maxPulseRate\":\"100\", \"minTemperature\":\"35.5\", \"maxTemperature\":\"38\", \"minBloodPressureSystolic\":\"120\", \"maxBloodPressureSystolic\":\"180\", \"minBloodPressureDiastolic\":\"80\", \"maxBloodPressureDiastolic\":\"120\"}")"
     
-    #file_hash=$(python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/contract.txt "{\"id\":\"$transaction_id\", \"patientId\":\"$patient_id\", \"minOxygenSaturation\":\"95\", \"maxOxygenSaturation\":\"100\", \"minPulseRate\":\"60\", \"maxPulseRate\":\"100\", \"minTemperature\":\"35.5\", \"maxTemperature\":\"38\", \"minBloodPressureSystolic\":\"120\", \"maxBloodPressureSystolic\":\"180\", \"minBloodPressureDiastolic\":\"80\", \"maxBloodPressureDiastolic\":\"120\"}")
+    #read -r file_hash file_size <<< "$(python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/contract.txt "{\"id\":\"1\", \"patientId\":\"$patient_id\", \"minOxygenSaturation\":\"95\", \"maxOxygenSaturation\":\"100\", \"minPulseRate\":\"60\", \"maxPulseRate\":\"100\", \"minTemperature\":\"35.5\", \"maxTemperature\":\"38\", \"minBloodPressureSystolic\":\"120\", \"maxBloodPressureSystolic\":\"180\", \"minBloodPressureDiastolic\":\"80\", \"maxBloodPressureDiastolic\":\"120\"}")"
 
-    peer chaincode invoke -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" -C mychannel -n basic --peerAddresses localhost:7051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt" --peerAddresses localhost:9051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt" -c "{\"function\":\"CreateXpnTransaction\",\"Args\":[\"$transaction_id\", \"$file_hash\", \"/tmp/expand/xpn/$patient_id/contract.txt\"]}"
-
-    sleep 5
+    files+=("$(xpnBatchFile "$file_hash" "/tmp/expand/xpn/$patient_id/contract.txt" "$patient_id" "contract" "$file_size")")
 
 
 
@@ -122,18 +112,18 @@ for ((i=1; i<=numPatients; i++)); do
     # Create diagnosis
     echo -e "GENERATING DIAGNOSIS FOR PATIENT WITH ID $patient_id ... \n "
 
-    {
-        flock -x 200
-        transaction_id=$(cat "$TRANSACTION_ID")
-        echo $((transaction_id+1)) > "$TRANSACTION_ID"
-    } 200>"$LOCK_FILE"
-
-    file_hash=$(LD_PRELOAD=$HOME/bin/xpn/lib/xpn_bypass.so python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/diagnosis.txt "{\"id\":\"1\", \"patientId\":\"$patient_id\", \"oxygenSaturationDiagnosis\":\"None\", \"pulseRateDiagnosis\":\"None\", \"temperatureDiagnosis\":\"None\", \"bloodPressureDiagnosis\":\"None\"}")
+    read -r file_hash file_size <<< "$(LD_PRELOAD=$HOME/bin/xpn/lib/xpn_bypass.so python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/diagnosis.txt "{\"id\":\"1\", \"patientId\":\"$patient_id\", \"oxygenSaturationDiagnosis\":\"None\", \"pulseRateDiagnosis\":\"None\", \"temperatureDiagnosis\":\"None\", \"bloodPressureDiagnosis\":\"None\"}")"
     
-    #file_hash=$(python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/diagnosis.txt "{\"id\":\"$transaction_id\", \"patientId\":\"$patient_id\", \"oxygenSaturationDiagnosis\":\"None\", \"pulseRateDiagnosis\":\"None\", \"temperatureDiagnosis\":\"None\", \"bloodPressureDiagnosis\":\"None\"}")
+    #read -r file_hash file_size <<< "$(python3 write_data_xpn.py /tmp/expand/xpn/$patient_id/diagnosis.txt "{\"id\":\"1\", \"patientId\":\"$patient_id\", \"oxygenSaturationDiagnosis\":\"None\", \"pulseRateDiagnosis\":\"None\", \"temperatureDiagnosis\":\"None\", \"bloodPressureDiagnosis\":\"None\"}")"
+
+    files+=("$(xpnBatchFile "$file_hash" "/tmp/expand/xpn/$patient_id/diagnosis.txt" "$patient_id" "diagnosis" "$file_size")")
+
+
+    # Anchor the patient, contract and diagnosis files
+    echo -e "ANCHORING THE FILES OF PATIENT WITH ID $patient_id ... \n "
+
+    peer chaincode invoke -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" -C mychannel -n basic --peerAddresses localhost:7051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt" --peerAddresses localhost:9051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt" -c '{"function":"CreateXpnBatchTransient","Args":[]}' --transient "{\"xpnbatch\":\"$(xpnBatchTransient "" "${files[@]}")\"}"
 
-    peer chaincode invoke -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" -C mychannel -n basic --peerAddresses localhost:7051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt" --peerAddresses localhost:9051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt" -c "{\"function\":\"CreateXpnTransaction\",\"Args\":[\"$transaction_id\", \"$file_hash\", \"/tmp/expand/xpn/$patient_id/diagnosis.txt\"]}"
-    
     sleep 5
 
 
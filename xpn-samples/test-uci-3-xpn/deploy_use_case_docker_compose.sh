@@ -37,7 +37,7 @@ done
 
 # STEP 3: DEPLOY CHAINCODE --------------------------------------------------------------------------------------------------------
 ./network.sh createChannel
-./network.sh deployCC -ccn basic -ccp ./chaincode-go -ccl go
+./network.sh deployCC -ccn basic -ccp ./chaincode-go -ccl go -cccg ./chaincode-go/collections_config.json
 
 # Exporting paths
 export PATH=${PWD}/../bin:$PATH
@@ -48,7 +48,7 @@ docker stack deploy -c swarm/swarm-test-net.yaml fabric-network
 
 # STEP 3: DEPLOY CHAINCODE --------------------------------------------------------------------------------------------------------
 ./network.sh createChannel
-./network.sh deployCC -ccn basic -ccp ./chaincode-go -ccl go
+./network.sh deployCC -ccn basic -ccp ./chaincode-go -ccl go -cccg ./chaincode-go/collections_config.json
 
 # Exporting paths
 export PATH=${PWD}/../bin:$PATH
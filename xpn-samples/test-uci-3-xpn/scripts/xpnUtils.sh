@@ -0,0 +1,54 @@
+#!/bin/bash
+
+# xpnConfigValue prints the value of a key of the XPN configuration file $XPN_CONF
+# (xpn/config.xml), or the given default when the file or the key is missing.
+# usage: xpnConfigValue <key> <default>
+function xpnConfigValue() {
+    local value=""
+    if [ -f "$XPN_CONF" ]; then
+        value=$(sed -n "s/^[[:space:]]*$1[[:space:]]*=[[:space:]]*//p" "$XPN_CONF" | head -n 1 | tr -d '[:space:]')
+    fi
+    echo -n "${value:-$2}"
+}
+
+# xpnTransient prints the base64 encoded "xpntransaction" transient value
+# expected by CreateXpnTransactionTransient.
+# The chaincode derives the id of the xpntransaction from the transaction ID and stores it
+# under the pseudonym of the patient, which must have been created with patientTransient.
+# The hash algorithm defaults to $XPN_HASH_ALGORITHM or sha256, the one used by
+# write_data_xpn.py, and the partition to the partition_name of $XPN_CONF.
+# usage: xpnTransient <hash> <path> <patient> <documentType> <size> [hashAlgorithm] [partition]
+function xpnTransient() {
+    local hashAlgorithm="${6:-${XPN_HASH_ALGORITHM:-sha256}}"
+    local partition="${7:-$(xpnConfigValue partition_name xpn)}"
+    echo -n "{\"hash\":\"$1\", \"path\":\"$2\", \"patient\":\"$3\", \"documentType\":\"$4\", \"size\":\"$5\", \"hashAlgorithm\":\"$hashAlgorithm\", \"partition\":\"$partition\"}" | base64 | tr -d '\n'
+}
+
+# patientTransient prints the --transient argument of CreatePatientTransient: the base64 encoded
+# "patient" details and a fresh random "salt", which the details hash and, with the pseudonym key of
+# the organization, the pseudonym of the patient are derived from.
+# usage: patientTransient <id> <firstName> <lastName> <birthDate> <birthPlace> <weight> <height>
+function patientTransient() {
+    local patient
+    patient=$(echo -n "{\"id\":\"$1\", \"firstName\":\"$2\", \"middleName\":\"\", \"lastName\":\"$3\", \"birthDate\":\"$4\", \"birthPlace\":\"$5\", \"weight\":\"$6\", \"height\":\"$7\"}" | base64 | tr -d '\n')
+    echo -n "{\"patient\":\"$patient\", \"salt\":\"$(head -c 16 /dev/urandom | base64 | tr -d '\n')\"}"
+}
+
+# xpnBatchFile prints one file of a batch, to be passed to xpnBatchTransient.
+# The hash algorithm defaults like in xpnTransient.
+# usage: xpnBatchFile <hash> <path> <patient> <documentType> <size> [hashAlgorithm]
+function xpnBatchFile() {
+    local hashAlgorithm="${6:-${XPN_HASH_ALGORITHM:-sha256}}"
+    echo -n "{\"hash\":\"$1\", \"path\":\"$2\", \"patient\":\"$3\", \"documentType\":\"$4\", \"size\":\"$5\", \"hashAlgorithm\":\"$hashAlgorithm\"}"
+}
+
+# xpnBatchTransient prints the base64 encoded "xpnbatch" transient value expected by
+# CreateXpnBatchTransient, which anchors every file with its own xpntransaction in a single
+# transaction. An empty partition defaults to the partition_name of $XPN_CONF.
+# usage: xpnBatchTransient <partition> <file printed by xpnBatchFile>...
+function xpnBatchTransient() {
+    local partition="${1:-$(xpnConfigValue partition_name xpn)}"
+    shift
+    local IFS=,
+    echo -n "{\"files\":[$*], \"partition\":\"$partition\"}" | base64 | tr -d '\n'
+}
@@ -4,6 +4,9 @@
 # Diego Camarmas Alonso
 
 id="$1"
+# contracts, measurements and diagnoses are stored under the patient pseudonym, which only
+# identities enrolled with the xpn.reidentify=true attribute can look up
+pseudonym=$(peer chaincode query -C mychannel -n basic -c "{\"function\":\"GetPatientPseudonym\",\"Args\":[\"$id\"]}")
 endTime=$(($(date +%s) + 120)) #120s, same value as in generate_data
 
 while [ $(date +%s) -lt $endTime ]; do
@@ -16,7 +19,7 @@ while [ $(date +%s) -lt $endTime ]; do
     sleep 1
 
     #get the data
-    diagnosis=$(peer chaincode query -C mychannel -n basic -c "{\"function\":\"ReadDiagnosis\",\"Args\":[\"D$id\"]}")
+    diagnosis=$(peer chaincode query -C mychannel -n basic -c "{\"function\":\"ReadDiagnosis\",\"Args\":[\"D$pseudonym\"]}")
     oxygenSaturationDiagnosis=$(echo $diagnosis | jq -r '.OxygenSaturationDiagnosis')
     pulseRateDiagnosis=$(echo $diagnosis | jq -r '.PulseRateDiagnosis')
     temperatureDiagnosis=$(echo $diagnosis | jq -r '.TemperatureDiagnosis')
@@ -1,3 +1,4 @@
+import os
 import sys
 import json
 import hashlib
@@ -23,4 +24,4 @@ def get_file_hash (file_name):
 
 #Main
 write_json_file (sys.argv[1], sys.argv[2])
-print(get_file_hash (sys.argv[1]))
\ No newline at end of file
+print(get_file_hash (sys.argv[1]), os.path.getsize (sys.argv[1]))
\ No newline at end of file